@@ -0,0 +1,148 @@
+// Package analysis scores chat messages for toxicity and sentiment through
+// an external model.
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+)
+
+// Scores rates a text. Toxicity lies between 0 and 1, sentiment between -1
+// (negative) and 1 (positive) and is nil when the model doesn't rate it.
+type Scores struct {
+	Toxicity  float64  `json:"toxicity"`
+	Sentiment *float64 `json:"sentiment,omitempty"`
+	// Toxic is set when the toxicity reached the configured threshold
+	Toxic bool `json:"toxic,omitempty"`
+}
+
+// Scorer rates texts
+type Scorer interface {
+	Score(ctx context.Context, text string) (*Scores, error)
+}
+
+// NewScorer returns the model selected by the configuration guarded by a
+// circuit breaker, or nil when scoring is disabled
+func NewScorer(cfg config.AnalysisConfig, b *breaker.Breaker) Scorer {
+	client := &http.Client{Timeout: cfg.Timeout}
+	switch cfg.Provider {
+	case "perspective":
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze"
+		}
+		return &breakerScorer{Scorer: &PerspectiveScorer{URL: endpoint, APIKey: cfg.APIKey, Client: client}, breaker: b}
+	case "http":
+		return &breakerScorer{Scorer: &HTTPScorer{URL: cfg.URL, APIKey: cfg.APIKey, Client: client}, breaker: b}
+	default:
+		return nil
+	}
+}
+
+// breakerScorer fails fast with breaker.ErrOpen while the model keeps
+// failing
+type breakerScorer struct {
+	Scorer
+	breaker *breaker.Breaker
+}
+
+func (s *breakerScorer) Score(ctx context.Context, text string) (*Scores, error) {
+	var scores *Scores
+	err := s.breaker.Do(func() error {
+		var err error
+		scores, err = s.Scorer.Score(ctx, text)
+		return err
+	})
+	return scores, err
+}
+
+// PerspectiveScorer rates toxicity with Google's Perspective API, which
+// doesn't rate sentiment
+type PerspectiveScorer struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (s *PerspectiveScorer) Score(ctx context.Context, text string) (*Scores, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"comment":             map[string]string{"text": text},
+		"requestedAttributes": map[string]interface{}{"TOXICITY": struct{}{}},
+		"doNotStore":          true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"?key="+url.QueryEscape(s.APIKey), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		AttributeScores struct {
+			Toxicity struct {
+				SummaryScore struct {
+					Value float64 `json:"value"`
+				} `json:"summaryScore"`
+			} `json:"TOXICITY"`
+		} `json:"attributeScores"`
+	}
+	if err := do(s.Client, req, &result); err != nil {
+		return nil, err
+	}
+	return &Scores{Toxicity: result.AttributeScores.Toxicity.SummaryScore.Value}, nil
+}
+
+// HTTPScorer posts {"text": ...} to a self-hosted model answering with
+// {"toxicity": 0.02, "sentiment": 0.6}
+type HTTPScorer struct {
+	URL string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+	Client *http.Client
+}
+
+func (s *HTTPScorer) Score(ctx context.Context, text string) (*Scores, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+
+	var scores Scores
+	if err := do(s.Client, req, &scores); err != nil {
+		return nil, err
+	}
+	return &scores, nil
+}
+
+// do sends a request and decodes the JSON response into result
+func do(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,64 @@
+// Package apiauth protects the admin, analytics and privacy endpoints with
+// bearer tokens issued by the backend: admins carry the admin role claim,
+// and users may reach their own data.
+package apiauth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Authenticator verifies the bearer tokens of API requests
+type Authenticator struct {
+	secret []byte
+}
+
+// New creates an Authenticator for tokens signed with secret. Without a
+// secret no token verifies and the protected endpoints are unavailable.
+func New(secret string) *Authenticator {
+	return &Authenticator{secret: []byte(secret)}
+}
+
+// Admin allows requests carrying a token with the admin role
+func (a *Authenticator) Admin() echo.MiddlewareFunc {
+	return a.AdminOr(nil)
+}
+
+// AdminOr allows requests carrying a token with the admin role, or whose
+// subject is the user owner returns for the request
+func (a *Authenticator) AdminOr(owner func(c echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, errResp := a.verify(c)
+			if errResp != nil {
+				return c.JSON(errResp.StatusCode, errResp)
+			}
+			if claims.Role != utils.RoleAdmin && (owner == nil || claims.Subject != owner(c)) {
+				errResp := utils.NewErrorResponse(http.StatusForbidden, "not allowed for this token")
+				return c.JSON(errResp.StatusCode, errResp)
+			}
+			return next(c)
+		}
+	}
+}
+
+// verify returns the claims of the request's bearer token
+func (a *Authenticator) verify(c echo.Context) (*utils.Claims, *utils.ErrorResponse) {
+	if len(a.secret) == 0 {
+		return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "API authentication is not configured")
+	}
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return nil, utils.NewErrorResponse(http.StatusUnauthorized, "bearer token is required")
+	}
+	claims, err := utils.ParseToken(a.secret, token, time.Now())
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusUnauthorized, err.Error())
+	}
+	return claims, nil
+}
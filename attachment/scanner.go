@@ -0,0 +1,170 @@
+package attachment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+)
+
+// ScanStatus is the outcome of scanning an attachment
+type ScanStatus string
+
+const (
+	ScanClean    ScanStatus = "clean"
+	ScanInfected ScanStatus = "infected"
+	ScanSkipped  ScanStatus = "skipped"
+)
+
+// ScanResult describes the verdict of a scanner
+type ScanResult struct {
+	Status    ScanStatus `json:"status"`
+	Signature string     `json:"signature,omitempty"`
+}
+
+// Scanner inspects file content for malware
+type Scanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+// NewScanner returns the scanner selected by the configuration, external
+// scanners are guarded by a circuit breaker
+func NewScanner(cfg config.AttachmentConfig, b *breaker.Breaker) Scanner {
+	switch cfg.Scanner {
+	case "clamav":
+		return &breakerScanner{Scanner: &ClamAVScanner{Addr: cfg.ClamAVAddr, Timeout: cfg.ScanTimeout}, breaker: b}
+	case "http":
+		return &breakerScanner{Scanner: &HTTPScanner{URL: cfg.ScannerURL, Client: &http.Client{Timeout: cfg.ScanTimeout}}, breaker: b}
+	default:
+		return NoopScanner{}
+	}
+}
+
+// breakerScanner fails fast with breaker.ErrOpen while the scanner keeps
+// failing
+type breakerScanner struct {
+	Scanner
+	breaker *breaker.Breaker
+}
+
+func (s *breakerScanner) Scan(r io.Reader) (*ScanResult, error) {
+	var result *ScanResult
+	err := s.breaker.Do(func() error {
+		var err error
+		result, err = s.Scanner.Scan(r)
+		return err
+	})
+	return result, err
+}
+
+// NoopScanner accepts every file without inspecting it
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(io.Reader) (*ScanResult, error) {
+	return &ScanResult{Status: ScanSkipped}, nil
+}
+
+// ClamAVScanner streams files to a clamd daemon using the INSTREAM command
+type ClamAVScanner struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (s *ClamAVScanner) Scan(r io.Reader) (*ScanResult, error) {
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 32*1024)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	// A zero length chunk terminates the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+
+	// Replies look like "stream: OK" or "stream: Eicar-Test-Signature FOUND"
+	switch {
+	case strings.HasSuffix(reply, "OK"):
+		return &ScanResult{Status: ScanClean}, nil
+	case strings.HasSuffix(reply, "FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return &ScanResult{Status: ScanInfected, Signature: signature}, nil
+	default:
+		return nil, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
+
+// HTTPScanner posts files to an external scanning API which answers with
+// {"infected": bool, "signature": string}
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPScanner) Scan(r io.Reader) (*ScanResult, error) {
+	resp, err := s.Client.Post(s.URL, "application/octet-stream", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("scanner returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var verdict struct {
+		Infected  bool   `json:"infected"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return nil, err
+	}
+
+	if verdict.Infected {
+		return &ScanResult{Status: ScanInfected, Signature: verdict.Signature}, nil
+	}
+	return &ScanResult{Status: ScanClean}, nil
+}
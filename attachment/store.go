@@ -0,0 +1,214 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/utils"
+	"pion-webrtc-microservice/webhook"
+)
+
+// InfectedEvent is the webhook event sent when an infected file is rejected
+const InfectedEvent = "attachment.infected"
+
+// StoredFile describes an uploaded file that passed scanning
+type StoredFile struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Path        string      `json:"-"`
+	URL         string      `json:"url"`
+	Size        int64       `json:"size"`
+	ContentType string      `json:"contentType"`
+	Scan        *ScanResult `json:"scan"`
+	Image       *ImageInfo  `json:"image,omitempty"`
+}
+
+// Store saves uploaded attachments after passing them through the scanner
+type Store struct {
+	dir string
+	// quarantine holds uploads until they are scanned, they are only moved
+	// to the served dir once clean
+	quarantine     string
+	urlPath        string
+	scanner        Scanner
+	webhooks       *webhook.Notifier
+	thumbnailSizes []int
+}
+
+// NewStore creates a Store writing below dir, with files served at urlPath.
+// Uploads are scanned in quarantineDir, which must be on the filesystem of
+// dir. Images get a thumbnail for each of thumbnailSizes.
+func NewStore(dir, quarantineDir, urlPath string, scanner Scanner, webhooks *webhook.Notifier, thumbnailSizes []int) *Store {
+	return &Store{
+		dir:            dir,
+		quarantine:     quarantineDir,
+		urlPath:        urlPath,
+		scanner:        scanner,
+		webhooks:       webhooks,
+		thumbnailSizes: thumbnailSizes,
+	}
+}
+
+// Save writes an upload to quarantine and scans it. Clean files are moved
+// to the served directory, infected ones are removed and rejected, so no
+// unscanned file is ever served.
+func (s *Store) Save(name, contentType string, r io.Reader) (*StoredFile, *utils.ErrorResponse) {
+	id := utils.NewID(utils.PrefixAttachment)
+	name = filepath.Base(name)
+	dir := filepath.Join(s.quarantine, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to store attachment")
+	}
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to store attachment")
+	}
+
+	// Sniff the content type from the first bytes when the client didn't send one
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(head[:n])
+	}
+
+	size, err := io.Copy(file, io.MultiReader(bytes.NewReader(head[:n]), r))
+	file.Close()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to store attachment")
+	}
+
+	stored := &StoredFile{
+		ID:          id,
+		Name:        name,
+		Path:        path,
+		URL:         s.urlPath + "/" + url.PathEscape(id) + "/" + url.PathEscape(name),
+		Size:        size,
+		ContentType: contentType,
+	}
+
+	result, errResp := s.ScanFile(path)
+	if errResp != nil {
+		return nil, errResp
+	}
+	stored.Scan = result
+
+	served := filepath.Join(s.dir, id)
+	if err := os.MkdirAll(s.dir, 0755); err != nil || os.Rename(dir, served) != nil {
+		log.Printf("Error moving scanned attachment %s out of quarantine\n", path)
+		os.RemoveAll(dir)
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to store attachment")
+	}
+	stored.Path = filepath.Join(served, name)
+	path = stored.Path
+
+	if strings.HasPrefix(contentType, "image/") {
+		info, err := s.processImage(stored)
+		if err != nil {
+			// The original is still usable, it just won't have a preview
+			log.Printf("Error processing image %s: %v\n", path, err)
+		}
+		stored.Image = info
+	}
+
+	return stored, nil
+}
+
+// ScanFile scans a file on disk. Infected files are deleted, logged and
+// reported through the webhook.
+func (s *Store) ScanFile(path string) (*ScanResult, *utils.ErrorResponse) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read attachment")
+	}
+	result, err := s.scanner.Scan(file)
+	file.Close()
+
+	if err != nil {
+		log.Printf("Error scanning attachment %s: %v\n", path, err)
+		os.RemoveAll(filepath.Dir(path))
+		if errors.Is(err, breaker.ErrOpen) {
+			return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "attachment scanning is unavailable")
+		}
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "attachment scan failed")
+	}
+
+	if result.Status == ScanInfected {
+		log.Printf("Rejected infected attachment %s: %s\n", path, result.Signature)
+		os.RemoveAll(filepath.Dir(path))
+		s.webhooks.Send(InfectedEvent, map[string]interface{}{
+			"name":      filepath.Base(path),
+			"signature": result.Signature,
+		})
+		return nil, utils.NewErrorResponse(http.StatusUnprocessableEntity, "attachment is infected")
+	}
+
+	return result, nil
+}
+
+// DeleteURL removes the stored file served at the given URL. It reports
+// whether the URL belongs to this store.
+func (s *Store) DeleteURL(fileURL string) bool {
+	return DeleteServed(s.dir, s.urlPath, fileURL)
+}
+
+// DeleteServed removes the directory of a file stored as dir/<id>/<name> and
+// served at urlPath/<id>/<name>. It reports whether the URL matched.
+func DeleteServed(dir, urlPath, fileURL string) bool {
+	rest, ok := strings.CutPrefix(fileURL, urlPath+"/")
+	if !ok {
+		return false
+	}
+	id, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return false
+	}
+	id, err := url.PathUnescape(id)
+	if err != nil || id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return false
+	}
+
+	os.RemoveAll(filepath.Join(dir, id))
+	return true
+}
+
+// Replace swaps a stored file for one derived from it, such as a transcoded
+// copy, which is moved into its place under name. The derived file isn't
+// scanned again.
+func (s *Store) Replace(stored *StoredFile, path, name, contentType string) error {
+	name = filepath.Base(name)
+	target := filepath.Join(filepath.Dir(stored.Path), name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+	if target != stored.Path {
+		os.Remove(stored.Path)
+	}
+
+	stored.Name = name
+	stored.Path = target
+	stored.URL = s.urlPath + "/" + url.PathEscape(stored.ID) + "/" + url.PathEscape(name)
+	stored.Size = info.Size()
+	stored.ContentType = contentType
+	stored.Image = nil
+	return nil
+}
+
+// Delete removes a stored file
+func (s *Store) Delete(stored *StoredFile) {
+	os.RemoveAll(filepath.Dir(stored.Path))
+}
@@ -0,0 +1,150 @@
+package attachment
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// maxImagePixels bounds decoding so a small file can't expand into a huge bitmap
+const maxImagePixels = 50_000_000
+
+// Thumbnail is a downscaled copy of an image attachment
+type Thumbnail struct {
+	MaxSize int    `json:"maxSize"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	URL     string `json:"url"`
+}
+
+// ImageInfo holds the metadata extracted from an image attachment. EXIF data
+// is never exposed and thumbnails are re-encoded without it.
+type ImageInfo struct {
+	Format     string      `json:"format"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// processImage extracts the dimensions of a stored image and writes a
+// thumbnail beside it for each configured size smaller than the image.
+// Thumbnails live in a thumbnails directory next to the original.
+func (s *Store) processImage(stored *StoredFile) (*ImageInfo, error) {
+	file, err := os.Open(stored.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large to process")
+	}
+
+	info := &ImageInfo{Format: format, Width: cfg.Width, Height: cfg.Height}
+	if len(s.thumbnailSizes) == 0 {
+		return info, nil
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbDir := filepath.Join(filepath.Dir(stored.Path), "thumbnails")
+	if err := os.MkdirAll(thumbDir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, size := range s.thumbnailSizes {
+		if size <= 0 || (cfg.Width <= size && cfg.Height <= size) {
+			continue
+		}
+
+		thumb := downscale(img, size)
+		// Keep transparency for formats that support it
+		name := fmt.Sprintf("%d.jpg", size)
+		if format == "png" || format == "gif" {
+			name = fmt.Sprintf("%d.png", size)
+		}
+		if err := writeThumbnail(filepath.Join(thumbDir, name), thumb); err != nil {
+			return nil, err
+		}
+
+		bounds := thumb.Bounds()
+		info.Thumbnails = append(info.Thumbnails, Thumbnail{
+			MaxSize: size,
+			Width:   bounds.Dx(),
+			Height:  bounds.Dy(),
+			URL:     s.urlPath + "/" + url.PathEscape(stored.ID) + "/thumbnails/" + name,
+		})
+	}
+
+	return info, nil
+}
+
+func writeThumbnail(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".png" {
+		return png.Encode(file, img)
+	}
+	return jpeg.Encode(file, img, &jpeg.Options{Quality: 85})
+}
+
+// downscale resizes img so its longest side is maxSize, averaging the source
+// pixels covered by each destination pixel
+func downscale(img image.Image, maxSize int) *image.RGBA {
+	src := img.Bounds()
+	width, height := src.Dx(), src.Dy()
+	if width >= height {
+		height = max(1, height*maxSize/width)
+		width = maxSize
+	} else {
+		width = max(1, width*maxSize/height)
+		height = maxSize
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// Audited actions
+const (
+	ChatRoleChange        = "chat.role.change"
+	ChatModeration        = "chat.moderate"
+	ChatSlowMode          = "chat.slow_mode"
+	ChatAnnouncement      = "chat.announcement"
+	ChatSessionTerminate  = "chat.session.terminate"
+	ChatLock              = "chat.lock"
+	ChatParticipantAdd    = "chat.participant.add"
+	ChatParticipantRemove = "chat.participant.remove"
+	ChatBotRegister       = "chat.bot.register"
+	ChatBotRemove         = "chat.bot.remove"
+
+	CallRecordingStart    = "call.recording.start"
+	CallRecordingStop     = "call.recording.stop"
+	CallRecordingToggle   = "call.recording.toggle"
+	CallLobbyAdmit        = "call.lobby.admit"
+	CallPasscodeRotate    = "call.passcode.rotate"
+	CallJoinCodeRotate    = "call.join_code.rotate"
+	CallSessionTerminate  = "call.session.terminate"
+	CallMuteAll           = "call.mute_all"
+	CallLiftHardMute      = "call.hard_mute.lift"
+	CallRoleChange        = "call.role.change"
+	CallMute              = "call.mute"
+	CallEnd               = "call.end"
+	CallKnockAnswer       = "call.knock.answer"
+	CallLock              = "call.lock"
+	CallParticipantAdd    = "call.participant.add"
+	CallParticipantRemove = "call.participant.remove"
+	CallGain              = "call.gain"
+	CallRecordingMix      = "call.recording.mix"
+	CallLayout            = "call.layout"
+	CallTransfer          = "call.transfer"
+	CallTransferAnswer    = "call.transfer.answer"
+	CallHandOver          = "call.handover"
+	CallAudioOnly         = "call.audio_only"
+	CallPin               = "call.pin"
+
+	PrivacyErase = "privacy.erase"
+)
+
+// SystemActor is recorded for actions the service takes on its own
+const SystemActor = "system"
+
+// Entry is a single audited action. Before and after are encoded when the
+// action is recorded, so later changes to the state don't show in it.
+type Entry struct {
+	ID        string          `json:"id"`
+	Timestamp utils.Time      `json:"timestamp"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// Filter selects audit entries, empty fields match everything
+type Filter struct {
+	Actor     string
+	Action    string
+	SessionID string
+	Target    string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+func (f Filter) matches(entry Entry) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.SessionID != "" && entry.SessionID != f.SessionID:
+		return false
+	case f.Target != "" && entry.Target != f.Target:
+		return false
+	case !f.Since.IsZero() && entry.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Timestamp.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Log is an append-only audit log persisted as JSON lines
+type Log struct {
+	file    *os.File
+	entries []Entry
+	mu      sync.Mutex
+}
+
+// Open loads the entries already in the file at path and appends new ones to it
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		l.entries = append(l.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Record appends an entry. Before and after describe the changed state and
+// may be nil.
+func (l *Log) Record(actor, action, sessionID, target string, before, after interface{}) {
+	if l == nil {
+		return
+	}
+	if actor == "" {
+		actor = SystemActor
+	}
+
+	entry := Entry{
+		ID:        utils.NewID(utils.PrefixAudit),
+		Timestamp: utils.Now(),
+		Actor:     actor,
+		Action:    action,
+		SessionID: sessionID,
+		Target:    target,
+	}
+	var err error
+	if entry.Before, err = encodeState(before); err != nil {
+		log.Printf("Error encoding audit entry %s: %v\n", action, err)
+		return
+	}
+	if entry.After, err = encodeState(after); err != nil {
+		log.Printf("Error encoding audit entry %s: %v\n", action, err)
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding audit entry %s: %v\n", action, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit entry %s: %v\n", action, err)
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// encodeState encodes the state before or after an action, nil is left out
+func encodeState(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	return json.Marshal(state)
+}
+
+// Query returns the matching entries, newest first
+func (l *Log) Query(filter Filter) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if !filter.matches(l.entries[i]) {
+			continue
+		}
+		entries = append(entries, l.entries[i])
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+	}
+	return entries
+}
@@ -0,0 +1,114 @@
+// Package breaker guards calls to external services so a failing or slow
+// dependency is skipped for a while instead of stalling every caller.
+package breaker
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/config"
+)
+
+// ErrOpen is returned without calling the service while the breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker
+type State string
+
+const (
+	// Closed lets every call through
+	Closed State = "closed"
+	// Open rejects calls until the cooldown has passed
+	Open State = "open"
+	// HalfOpen lets a single trial call through after the cooldown
+	HalfOpen State = "half-open"
+)
+
+// Breaker opens after a number of consecutive failures and lets a trial
+// call through once the cooldown has passed. A successful trial closes it
+// again, a failed one restarts the cooldown.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     State
+	failures  int
+	openedAt  time.Time
+	mu        sync.Mutex
+}
+
+// New creates a closed Breaker named after the service it guards
+func New(name string, cfg config.BreakerConfig) *Breaker {
+	return &Breaker{
+		name:      name,
+		threshold: cfg.FailureThreshold,
+		cooldown:  cfg.Cooldown,
+		state:     Closed,
+	}
+}
+
+// Do calls fn unless the breaker is open, and records its outcome
+func (b *Breaker) Do(fn func() error) error {
+	if !b.allow() {
+		return ErrOpen
+	}
+
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = HalfOpen
+		return true
+	case HalfOpen:
+		// The trial call is still in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.state != Closed {
+			log.Printf("Circuit breaker %s closed\n", b.name)
+		}
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		if b.state != Open {
+			log.Printf("Circuit breaker %s opened after %d failures: %v\n", b.name, b.failures, err)
+		}
+		b.state = Open
+		b.openedAt = time.Now()
+	}
+}
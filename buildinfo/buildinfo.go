@@ -0,0 +1,63 @@
+// Package buildinfo describes the running build. Release builds set the
+// variables with ldflags, e.g.
+//
+//	go build -ldflags "-X pion-webrtc-microservice/buildinfo.Version=1.4.0 \
+//	  -X pion-webrtc-microservice/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X pion-webrtc-microservice/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the semantic version of the build
+	Version = "0.0.0-dev"
+	// Commit is the git commit the build was made from
+	Commit = ""
+	// BuildTime is when the build was made, in RFC 3339
+	BuildTime = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+	// Modified marks a build from a working tree with uncommitted changes
+	Modified bool `json:"modified"`
+}
+
+// Get returns the build information. Commit and build time that weren't set
+// with ldflags are taken from the version control information Go stamps into
+// builds made inside a git checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,158 @@
+package call
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// talkTimeBuckets are the upper bounds of the talk-time distribution, the
+// last bucket is open-ended
+var talkTimeBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+}
+
+// DailyCallMetrics aggregates the calls started on one UTC day
+type DailyCallMetrics struct {
+	Date                   string  `json:"date"`
+	Calls                  int     `json:"calls"`
+	AverageDurationSeconds float64 `json:"averageDurationSeconds"`
+	PeakConcurrency        int     `json:"peakConcurrency"`
+}
+
+// TalkTimeBucket counts the participations that lasted up to MaxSeconds,
+// MaxSeconds is zero for the open-ended last bucket
+type TalkTimeBucket struct {
+	MaxSeconds     float64 `json:"maxSeconds,omitempty"`
+	Participations int     `json:"participations"`
+}
+
+// CallAnalytics aggregates the archived calls started within a window
+type CallAnalytics struct {
+	Since                  utils.Time         `json:"since"`
+	Until                  utils.Time         `json:"until"`
+	Calls                  int                `json:"calls"`
+	AverageDurationSeconds float64            `json:"averageDurationSeconds"`
+	PeakConcurrency        int                `json:"peakConcurrency"`
+	PeakAt                 utils.Time         `json:"peakAt,omitempty"`
+	Daily                  []DailyCallMetrics `json:"daily"`
+	TalkTime               []TalkTimeBucket   `json:"talkTime"`
+}
+
+// Analytics aggregates the archived calls started between since and until, per
+// UTC day. Calls offloaded to cold storage have no participants and are
+// left out of the talk-time distribution.
+func (cm *CallManager) Analytics(since, until time.Time) (*CallAnalytics, *utils.ErrorResponse) {
+	files, err := os.ReadDir(cm.cfg.ArchiveDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read archived call sessions")
+	}
+
+	// Concurrency is measured over every call overlapping the window, not
+	// only those that started within it
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	var events []event
+
+	analytics := &CallAnalytics{
+		Since:    utils.NewTime(since),
+		Until:    utils.NewTime(until),
+		Daily:    []DailyCallMetrics{},
+		TalkTime: make([]TalkTimeBucket, len(talkTimeBuckets)+1),
+	}
+	for i, bound := range talkTimeBuckets {
+		analytics.TalkTime[i].MaxSeconds = bound.Seconds()
+	}
+
+	for day := since.UTC().Truncate(24 * time.Hour); day.Before(until); day = day.Add(24 * time.Hour) {
+		analytics.Daily = append(analytics.Daily, DailyCallMetrics{Date: day.Format(time.DateOnly)})
+	}
+	days := make(map[string]*DailyCallMetrics, len(analytics.Daily))
+	for i := range analytics.Daily {
+		days[analytics.Daily[i].Date] = &analytics.Daily[i]
+	}
+
+	var totalSeconds float64
+
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		archived, err := cm.loadArchive(sessionID)
+		if err != nil {
+			continue
+		}
+		if archived.StartTime.Before(until) && archived.EndedAt.After(since) {
+			events = append(events, event{archived.StartTime.Time, 1}, event{archived.EndedAt.Time, -1})
+		}
+		if archived.StartTime.Before(since) || !archived.StartTime.Before(until) {
+			continue
+		}
+
+		seconds := archived.EndedAt.Sub(archived.StartTime.Time).Seconds()
+		analytics.Calls++
+		totalSeconds += seconds
+
+		day := days[archived.StartTime.UTC().Format(time.DateOnly)]
+		day.Calls++
+		day.AverageDurationSeconds += seconds
+
+		for _, participant := range archived.Participants {
+			i := sort.Search(len(talkTimeBuckets), func(i int) bool {
+				return participant.DurationSeconds <= talkTimeBuckets[i].Seconds()
+			})
+			analytics.TalkTime[i].Participations++
+		}
+	}
+
+	if analytics.Calls > 0 {
+		analytics.AverageDurationSeconds = totalSeconds / float64(analytics.Calls)
+	}
+	for i := range analytics.Daily {
+		if day := &analytics.Daily[i]; day.Calls > 0 {
+			day.AverageDurationSeconds /= float64(day.Calls)
+		}
+	}
+
+	// Calls ending at the instant another starts don't overlap
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+
+	concurrent, next := 0, 0
+	for i := range analytics.Daily {
+		day := &analytics.Daily[i]
+		start, _ := time.Parse(time.DateOnly, day.Date)
+		end := start.Add(24 * time.Hour)
+
+		// Calls carried over from the previous day count towards its peak
+		day.PeakConcurrency = concurrent
+		for ; next < len(events) && events[next].at.Before(end); next++ {
+			concurrent += events[next].delta
+			if concurrent > day.PeakConcurrency {
+				day.PeakConcurrency = concurrent
+			}
+			if concurrent > analytics.PeakConcurrency {
+				analytics.PeakConcurrency = concurrent
+				analytics.PeakAt = utils.NewTime(events[next].at)
+			}
+		}
+	}
+
+	return analytics, nil
+}
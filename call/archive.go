@@ -0,0 +1,161 @@
+package call
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// ArchivedParticipant is a participant of an archived call
+type ArchivedParticipant struct {
+	ID              string            `json:"id"`
+	Role            CallRole          `json:"role"`
+	DisplayName     string            `json:"displayName,omitempty"`
+	Status          ParticipantStatus `json:"status"`
+	JoinTime        utils.Time        `json:"joinTime"`
+	DurationSeconds float64           `json:"durationSeconds"`
+}
+
+// ArchivedCall is the snapshot of a call kept after it ended
+type ArchivedCall struct {
+	ID            string                 `json:"id"`
+	Type          CallType               `json:"type"`
+	Quality       CallQuality            `json:"quality"`
+	CreatorID     string                 `json:"creatorId"`
+	ChatSessionID string                 `json:"chatSessionId,omitempty"`
+	E2EE          bool                   `json:"e2ee"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+	StartTime     utils.Time             `json:"startTime"`
+	EndedAt       utils.Time             `json:"endedAt"`
+	ArchivedAt    utils.Time             `json:"archivedAt"`
+	Participants  []ArchivedParticipant  `json:"participants"`
+	Report        *QualityReport         `json:"report,omitempty"`
+	// OffloadedAt is set while the participants and report are in cold
+	// storage and only a stub is kept
+	OffloadedAt  utils.Time `json:"offloadedAt,omitempty"`
+	RehydratedAt utils.Time `json:"rehydratedAt,omitempty"`
+}
+
+// HasTag reports whether the archived call is labelled with the given tag
+func (a *ArchivedCall) HasTag(tag string) bool {
+	return utils.ContainsTag(a.Tags, tag)
+}
+
+// archive persists a snapshot of a session that is ending, with its
+// participants and quality report. The session lock must be held.
+func (cm *CallManager) archive(session *CallSession, endedAt time.Time, report *QualityReport) error {
+	archived := ArchivedCall{
+		ID:            session.ID,
+		Type:          session.Type,
+		Quality:       session.Quality,
+		CreatorID:     session.CreatorID,
+		ChatSessionID: session.ChatSessionID,
+		E2EE:          session.E2EE,
+		Metadata:      session.Metadata,
+		Tags:          session.Tags,
+		StartTime:     session.StartTime,
+		EndedAt:       utils.NewTime(endedAt),
+		ArchivedAt:    utils.Now(),
+		Participants:  make([]ArchivedParticipant, 0, len(session.Participants)),
+		Report:        report,
+	}
+	for _, participant := range session.Participants {
+		participant.mu.Lock()
+		archived.Participants = append(archived.Participants, ArchivedParticipant{
+			ID:              participant.ID,
+			Role:            participant.Role,
+			DisplayName:     participant.DisplayName,
+			Status:          participant.Status,
+			JoinTime:        participant.JoinTime,
+			DurationSeconds: newParticipation(session, participant, endedAt).DurationSeconds,
+		})
+		participant.mu.Unlock()
+	}
+	sort.Slice(archived.Participants, func(i, j int) bool {
+		return archived.Participants[i].JoinTime.Before(archived.Participants[j].JoinTime.Time)
+	})
+
+	return cm.saveArchive(&archived)
+}
+
+// saveArchive writes an archived call to the archive directory
+func (cm *CallManager) saveArchive(archived *ArchivedCall) error {
+	data, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cm.cfg.ArchiveDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cm.cfg.ArchiveDir, archived.ID+".json"), data, 0644)
+}
+
+// loadArchive reads an archived call
+func (cm *CallManager) loadArchive(sessionID string) (*ArchivedCall, error) {
+	data, err := os.ReadFile(filepath.Join(cm.cfg.ArchiveDir, filepath.Base(sessionID)+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var archived ArchivedCall
+	if err := json.Unmarshal(data, &archived); err != nil {
+		return nil, err
+	}
+	return &archived, nil
+}
+
+// GetArchivedCall returns the snapshot of a call that ended. Of an
+// offloaded call only the stub is returned, RehydrateArchive fetches the
+// participants and report.
+func (cm *CallManager) GetArchivedCall(sessionID string) (*ArchivedCall, *utils.ErrorResponse) {
+	archived, err := cm.loadArchive(sessionID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "archived call session not found")
+		}
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to load archived call session")
+	}
+	return archived, nil
+}
+
+// ListArchivedCalls lists the archived calls, most recently ended first,
+// optionally only those labelled with a tag. Participants and reports are
+// left out.
+func (cm *CallManager) ListArchivedCalls(tag string) ([]ArchivedCall, *utils.ErrorResponse) {
+	files, err := os.ReadDir(cm.cfg.ArchiveDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to list archived call sessions")
+	}
+
+	calls := []ArchivedCall{}
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		archived, err := cm.loadArchive(sessionID)
+		if err != nil {
+			continue
+		}
+		if tag != "" && !archived.HasTag(tag) {
+			continue
+		}
+
+		archived.Participants = nil
+		archived.Report = nil
+		calls = append(calls, *archived)
+	}
+
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].EndedAt.After(calls[j].EndedAt.Time)
+	})
+	return calls, nil
+}
@@ -0,0 +1,120 @@
+package call
+
+import (
+	"log"
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// audioOnlyBitrate is announced to audio-only publishers for their video, so
+// their encoders fall back to the lowest layer instead of sending video
+// nobody receives
+const audioOnlyBitrate = 30_000
+
+// audioOnly reports whether the participant is in audio-only mode
+func (p *CallParticipant) audioOnly() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.AudioOnly
+}
+
+// SetAudioOnly switches a participant of a video call to audio-only or back,
+// on their own behalf or a host's or co-host's. A manual switch overrides
+// the one made for poor network quality.
+func (cm *CallManager) SetAudioOnly(sessionID, actorID, participantID string, enabled bool) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	self := actorID == participantID
+	if !self && !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can switch other participants to audio-only")
+	}
+	if session.Type != VideoCall {
+		return utils.NewErrorResponse(http.StatusBadRequest, "audio calls have no video")
+	}
+
+	participant, exists := session.Participants[participantID]
+	if !exists || participant.Status == StatusLeft {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	previous := participant.audioOnly()
+	cm.applyAudioOnly(session, participant, enabled, false)
+	if !self {
+		cm.Audit.Record(actorID, audit.CallAudioOnly, sessionID, participantID, previous, enabled)
+	}
+	cm.persist(session)
+
+	return nil
+}
+
+// adaptAudioOnly switches a participant to audio-only when their network
+// quality drops to the configured level, and back once it recovers unless
+// they chose audio-only themselves. The session lock must be held.
+func (cm *CallManager) adaptAudioOnly(session *CallSession, participant *CallParticipant, quality int) {
+	if cm.cfg.AudioOnlyQuality <= 0 || session.Type != VideoCall {
+		return
+	}
+
+	participant.mu.Lock()
+	audioOnly, auto := participant.AudioOnly, participant.audioOnlyAuto
+	participant.mu.Unlock()
+
+	switch {
+	case quality <= cm.cfg.AudioOnlyQuality && !audioOnly:
+		log.Printf("Switching participant %s of call %s to audio-only, network quality is %d\n", participant.ID, session.ID, quality)
+		cm.applyAudioOnly(session, participant, true, true)
+	case quality > cm.cfg.AudioOnlyQuality && auto:
+		log.Printf("Restoring video of participant %s of call %s, network quality is %d\n", participant.ID, session.ID, quality)
+		cm.applyAudioOnly(session, participant, false, true)
+	}
+}
+
+// applyAudioOnly sets the audio-only mode of a participant. Their published
+// video stops being forwarded and the video of the others is removed from
+// their peer connection, or added back, and the server offers them the
+// change. The session lock must be held.
+func (cm *CallManager) applyAudioOnly(session *CallSession, participant *CallParticipant, enabled, auto bool) {
+	participant.mu.Lock()
+	changed := participant.AudioOnly != enabled
+	participant.AudioOnly = enabled
+	participant.audioOnlyAuto = enabled && auto
+	participant.mu.Unlock()
+
+	pc := participant.PeerConnection
+	if !changed || pc == nil {
+		return
+	}
+
+	subscriptions := false
+	for key, track := range session.tracks {
+		if track.publisherID == participant.ID || track.remote.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if enabled {
+			subscriptions = track.unsubscribe(participant.ID, pc) || subscriptions
+			continue
+		}
+		if err := track.subscribe(participant, pc); err != nil {
+			log.Printf("Error subscribing %s to %s: %v\n", participant.ID, key, err)
+			continue
+		}
+		subscriptions = true
+	}
+	if subscriptions {
+		cm.renegotiate(participant, pc)
+	}
+	// Their video resumes from a keyframe rather than the next periodic one
+	if !enabled {
+		session.requestKeyframesFrom(participant.ID)
+	}
+}
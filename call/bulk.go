@@ -0,0 +1,122 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// isProvisioned reports whether a host added the participant ahead of
+// joining. The session lock must be held.
+func (s *CallSession) isProvisioned(participantID string) bool {
+	participant, exists := s.Participants[participantID]
+	return exists && participant.Status == StatusWaiting
+}
+
+// provision gives a participant a seat they join without the lobby, with
+// the passcode if the call has one. The session lock must be held.
+func (s *CallSession) provision(participantID string) *CallParticipant {
+	participant := &CallParticipant{
+		ID:             participantID,
+		Role:           s.roleFor(participantID),
+		Status:         StatusWaiting,
+		NetworkQuality: 5,
+	}
+	participant.Preset, _ = PresetFor(s.Quality)
+	s.Participants[participantID] = participant
+	return participant
+}
+
+// AddParticipants provisions participants on behalf of a host or co-host,
+// reporting the outcome for each of them. Provisioned participants hold a
+// seat and join without the lobby. A locked call takes no new participants.
+func (cm *CallManager) AddParticipants(sessionID, actorID string, participantIDs []string) ([]utils.BulkResult, *utils.ErrorResponse) {
+	if errResp := utils.ValidateBulkSize(len(participantIDs)); errResp != nil {
+		return nil, errResp
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can add participants")
+	}
+	if session.IsLocked {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "call session is locked")
+	}
+
+	results := make([]utils.BulkResult, 0, len(participantIDs))
+	for _, participantID := range participantIDs {
+		if participantID == "" {
+			results = append(results, utils.BulkFailure(participantID, "participant ID is required"))
+			continue
+		}
+		if existing, exists := session.Participants[participantID]; exists && existing.Status != StatusLeft {
+			results = append(results, utils.BulkFailure(participantID, "already a participant"))
+			continue
+		}
+		if session.isFull(participantID) {
+			results = append(results, utils.BulkFailure(participantID, "call is full"))
+			continue
+		}
+
+		participant := session.provision(participantID)
+		cm.Audit.Record(actorID, audit.CallParticipantAdd, sessionID, participantID, nil, participant.Role)
+		results = append(results, utils.BulkSuccess(participantID))
+	}
+
+	return results, nil
+}
+
+// RemoveParticipants removes participants on behalf of a host or co-host,
+// reporting the outcome for each of them. Provisioned participants lose
+// their seat and joined ones are disconnected. The host can't be removed.
+func (cm *CallManager) RemoveParticipants(sessionID, actorID string, participantIDs []string) ([]utils.BulkResult, *utils.ErrorResponse) {
+	if errResp := utils.ValidateBulkSize(len(participantIDs)); errResp != nil {
+		return nil, errResp
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can remove participants")
+	}
+
+	results := make([]utils.BulkResult, 0, len(participantIDs))
+	for _, participantID := range participantIDs {
+		if participantID == session.CreatorID {
+			results = append(results, utils.BulkFailure(participantID, "the host can't be removed"))
+			continue
+		}
+		participant, exists := session.Participants[participantID]
+		if !exists || participant.Status == StatusLeft {
+			results = append(results, utils.BulkFailure(participantID, "participant not found"))
+			continue
+		}
+
+		// Participants that never joined leave no trace in the call history
+		if participant.Status == StatusWaiting {
+			delete(session.Participants, participantID)
+		} else {
+			session.release(participant)
+			cm.publishLeft(sessionID, participantID, "removed")
+		}
+		cm.Audit.Record(actorID, audit.CallParticipantRemove, sessionID, participantID, participant.Role, nil)
+		results = append(results, utils.BulkSuccess(participantID))
+	}
+	cm.watchIdle(session)
+
+	return results, nil
+}
@@ -1,11 +1,26 @@
 package call
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/coldstorage"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/denoise"
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/mixer"
+	"pion-webrtc-microservice/peer"
+	"pion-webrtc-microservice/shardmap"
+	"pion-webrtc-microservice/statestore"
 	"pion-webrtc-microservice/utils"
+	"pion-webrtc-microservice/watermark"
 
 	"github.com/pion/webrtc/v3"
 )
@@ -24,131 +39,576 @@ const (
 
 	StatusWaiting   ParticipantStatus = "waiting"
 	StatusConnected ParticipantStatus = "connected"
-	StatusLeft      ParticipantStatus = "left"
+	// StatusReconnecting marks a participant whose connection failed and
+	// whose slot is kept for the reconnect grace period
+	StatusReconnecting ParticipantStatus = "reconnecting"
+	StatusLeft         ParticipantStatus = "left"
 )
 
 type CallParticipant struct {
 	ID             string
+	Role           CallRole
+	DisplayName    string
+	AvatarURL      string
+	Metadata       map[string]interface{}
 	PeerConnection *webrtc.PeerConnection
 	Status         ParticipantStatus
 	IsMuted        bool
+	// IsAudience marks a view-only participant that joined a full call
+	IsAudience bool
+	// IsHardMuted is set by the host; the participant can't unmute and their
+	// audio isn't forwarded until the host lifts it
+	IsHardMuted    bool
 	IsVideoEnabled bool
+	// AudioOnly stops the video the participant publishes and receives, on
+	// request or while their network quality is poor
+	AudioOnly bool
+	// audioOnlyAuto is set when AudioOnly was switched on because of the
+	// network quality, so it is lifted once the quality recovers
+	audioOnlyAuto  bool
 	IsSpeaking     bool
 	NetworkQuality int // 1-5 scale
-	JoinTime       time.Time
-	AudioDetector  *AudioLevelDetector
-	MediaRecorder  *MediaRecorder
-	mu             sync.Mutex
+	Preset         QualityPreset
+	JoinTime       utils.Time
+	// LeftAt is when the participant left, zero while they are in the call
+	LeftAt utils.Time
+	// ReconnectDeadline is when a reconnecting participant loses their slot
+	ReconnectDeadline utils.Time
+	AudioDetector     *AudioLevelDetector
+	MediaRecorder     *MediaRecorder
+	// resumeRecording is set for a participant recorded before a restart,
+	// whose recording starts again when they rejoin
+	resumeRecording bool
+	quality         mosAccumulator
+	sampledBytes    uint64 // bytes published as of the last timeline sample
+	// session is the call the participant's peer connection feeds, which
+	// changes when they are transferred
+	session *CallSession
+	// negotiation serializes the offers of the participant
+	negotiation sync.Mutex
+	// needsOffer is set when the tracks of the peer connection changed
+	// during a negotiation, guarded by negotiation
+	needsOffer bool
+	mu         sync.Mutex
+}
+
+// currentSession returns the call the participant is in
+func (p *CallParticipant) currentSession() *CallSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.session
+}
+
+// ParticipantProfile holds the display information of a call participant
+type ParticipantProfile struct {
+	DisplayName string                 `json:"displayName"`
+	AvatarURL   string                 `json:"avatarUrl"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// applyProfile merges a profile into the participant. Empty fields are left
+// untouched and metadata keys with a nil value are removed.
+func (p *CallParticipant) applyProfile(profile ParticipantProfile) {
+	if profile.DisplayName != "" {
+		p.DisplayName = profile.DisplayName
+	}
+	if profile.AvatarURL != "" {
+		p.AvatarURL = profile.AvatarURL
+	}
+	for key, value := range profile.Metadata {
+		if value == nil {
+			delete(p.Metadata, key)
+			continue
+		}
+		if p.Metadata == nil {
+			p.Metadata = make(map[string]interface{})
+		}
+		p.Metadata[key] = utils.CloneJSON(value)
+	}
 }
 
 type CallSession struct {
-	ID              string
-	Type            CallType
-	Quality         CallQuality
-	URL             string
+	ID             string
+	Type           CallType
+	Quality        CallQuality
+	URL            string
+	JoinCode       string
+	JoinCodeExpiry utils.Time
+	HasPasscode    bool
+	// E2EE marks a call whose media is frame-encrypted by the clients. The
+	// server only forwards it, so features that need decoded media are disabled.
+	E2EE          bool
+	ChatSessionID string
+	// MaxParticipants caps the publishing participants, zero is unlimited
+	MaxParticipants int
+	// Overflow lets joiners beyond the limit watch as a view-only audience
+	Overflow bool
+	// NoiseSuppression denoises the audio of the call's recordings
+	NoiseSuppression bool
+	// Watermark burns the tenant logo, session ID and time into the video of
+	// the call's recordings
+	Watermark bool
+	// SkipSilence leaves long stretches without speech out of the call's
+	// recordings
+	SkipSilence bool
+	// CalleeID is the participant called in a 1:1 call, Voicemail the
+	// message the caller leaves when they don't join
+	CalleeID  string
+	Voicemail *Voicemail
+	// Gains holds the levels participants are mixed at when not 1
+	Gains map[string]float64
+	// Layout is the current composition layout, layouts every change of it
+	Layout  LayoutChange
+	layouts []LayoutChange
+	// PinnedID is the participant every client spotlights, whose video gets
+	// the bandwidth of the call. Empty when nobody is pinned.
+	PinnedID string
+	// talk follows who speaks in the call
+	talk *talkTracker
+	// terminated is set once TerminateSession ran
+	terminated bool
+	// idleTimer terminates the call while nobody is connected to it
+	idleTimer *time.Timer
+	// IsLocked freezes membership, only current participants may (re)join
+	IsLocked        bool
 	Participants    map[string]*CallParticipant
 	CreatorID       string
-	StartTime       time.Time
-	EndTime         time.Time
+	StartTime       utils.Time
+	EndTime         utils.Time
 	IsRecording     bool
 	IsLivestreaming bool
-	InLobby         []string 
-	mu              sync.Mutex
+	InLobby         []string
+	Metadata        map[string]interface{}
+	Tags            []string
+	Codecs          peer.CodecPreferences
+	Audio           peer.OpusOptions
+	api             *webrtc.API
+	tracks          map[string]*publishedTrack
+	timeline        *statsRing
+
+	passcodeHash     []byte
+	passcodeFailures map[string]*passcodeAttempts
+	admitted         map[string]bool // lobby participants allowed to join
+	knocks           map[string]*Knock
+	transfers        map[string]*Transfer   // attended transfers into the call
+	invitations      map[string]*Invitation // keyed by invitee ID
+
+	mu sync.RWMutex
+}
+
+// SessionOptions holds optional settings applied when a call is created
+type SessionOptions struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Tags     []string               `json:"tags"`
+	Codecs   peer.CodecPreferences  `json:"codecs"`
+	Audio    peer.OpusOptions       `json:"audio"`
+	Passcode string                 `json:"passcode"`
+	E2EE     bool                   `json:"e2ee"`
+	// ChatSessionID links the call to a chat session that receives its
+	// system messages
+	ChatSessionID string `json:"chatSessionId"`
+	// MaxParticipants overrides the configured limit when positive
+	MaxParticipants int  `json:"maxParticipants"`
+	Overflow        bool `json:"overflow"`
+	// NoiseSuppression denoises the audio of the call's recordings
+	NoiseSuppression bool `json:"noiseSuppression"`
+	// Watermark burns a watermark into the video of the call's recordings
+	Watermark bool `json:"watermark"`
+	// SkipSilence pauses the call's recordings while nobody speaks
+	SkipSilence bool `json:"skipSilence"`
+	// CalleeID makes a 1:1 call that turns into voicemail when the callee
+	// doesn't join in time
+	CalleeID string `json:"calleeId"`
+}
+
+// HasTag reports whether the session is labelled with the given tag
+func (s *CallSession) HasTag(tag string) bool {
+	return utils.ContainsTag(s.Tags, tag)
 }
 
 type CallManager struct {
-	sessions map[string]*CallSession
-	mu       sync.Mutex
+	sessions     *shardmap.Map[*CallSession]
+	joinCodes    map[string]string // join code -> session ID
+	factory      *peer.Factory
+	recordingDir string
+	// cfg holds the participant limit, reconnect grace period and stats
+	// sampling applied to new calls
+	cfg config.CallConfig
+	// Audit records privileged actions, nil disables auditing
+	Audit *audit.Log
+	// ColdStore receives old archived calls, nil keeps them in the archive
+	// directory
+	ColdStore coldstorage.Store
+	// State keeps the state of active calls so they survive a restart, nil
+	// disables it
+	State statestore.Store
+	// History keeps the participations of ended calls so they survive a
+	// restart, nil keeps them in memory only
+	History statestore.Store
+	// Events publishes call and participant events, nil disables them
+	Events *events.Bus
+	// Denoiser suppresses the noise in recordings of calls that ask for it,
+	// nil disables noise suppression
+	Denoiser *denoise.Denoiser
+	// Mixer mixes the audio recordings of a call, nil disables mixing
+	Mixer *mixer.Mixer
+	// Watermark burns watermarks into recorded video of calls that ask for
+	// it, nil disables watermarking
+	Watermark *watermark.Burner
+	// Snapshots keeps thumbnails of the video publishers, nil disables them
+	Snapshots *Snapshots
+	// OnSpeaking is called when a participant starts or stops speaking, nil
+	// disables speaking events
+	OnSpeaking func(sessionID string, event SpeakingEvent)
+	// OnVoicemail is called when a caller starts leaving a voicemail and
+	// when it is delivered or cancelled
+	OnVoicemail func(voicemail *Voicemail)
+	// OnInvitation is called when an invitation rings out as a missed call
+	OnInvitation func(invitation *Invitation)
+	// OnRenegotiate is called with a server offer for a participant whose
+	// subscriptions changed, nil leaves renegotiating to the clients
+	OnRenegotiate func(participantID string, renegotiation Renegotiation)
+	// OnCallDetailRecord is called with the record of every terminated call
+	OnCallDetailRecord func(record *CallDetailRecord)
+	// ClaimID reports whether a new call may take an ID, which places calls
+	// on the cluster node owning their ID. Nil accepts every ID.
+	ClaimID func(sessionID string) bool
+	// mu guards the join code and load test maps only, the sessions are
+	// sharded and each session has its own lock
+	mu sync.RWMutex
+
+	history   []historyEntry
+	reports   map[string]keptReport // keyed by session ID
+	missed    []Invitation
+	historyMu sync.Mutex
+
+	loadTests map[string]*LoadTest // guarded by mu
+
+	states    stateWriter
+	histories stateWriter
 }
 
-func NewCallManager() *CallManager {
+// NewCallManager creates a CallManager building participant peer connections
+// with the given factory and storing recordings below recordingDir
+func NewCallManager(factory *peer.Factory, recordingDir string, cfg config.CallConfig) *CallManager {
 	return &CallManager{
-		sessions: make(map[string]*CallSession),
+		sessions:     shardmap.New[*CallSession](),
+		joinCodes:    make(map[string]string),
+		factory:      factory,
+		recordingDir: recordingDir,
+		cfg:          cfg,
+		reports:      make(map[string]keptReport),
+		loadTests:    make(map[string]*LoadTest),
+		states:       stateWriter{what: "state of call"},
+		histories:    stateWriter{what: "history entry"},
 	}
 }
 
-func (cm *CallManager) CreateCallSession(creatorID string, callType CallType, quality CallQuality, duration time.Duration) (*CallSession, *utils.ErrorResponse) {
+func (cm *CallManager) CreateCallSession(creatorID string, callType CallType, quality CallQuality, duration time.Duration, opts SessionOptions) (*CallSession, *utils.ErrorResponse) {
+	if quality == "" {
+		quality = QualityHD
+	}
+	preset, ok := PresetFor(quality)
+	if !ok {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid call quality")
+	}
+
+	if opts.E2EE && opts.NoiseSuppression {
+		return nil, errUnavailableEncrypted("noise suppression")
+	}
+	if opts.E2EE && opts.Watermark {
+		return nil, errUnavailableEncrypted("watermarking")
+	}
+
+	api, err := cm.factory.NewAPI(opts.Codecs, opts.Audio)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, err.Error())
+	}
+
 	session := &CallSession{
-		ID:           utils.GenerateSessionID(),
+		ID:           cm.newSessionID(),
 		Type:         callType,
 		Quality:      quality,
-		URL:          "/call/" + utils.GenerateSessionID(),
 		Participants: make(map[string]*CallParticipant),
 		CreatorID:    creatorID,
-		StartTime:    utils.GetTimestamp(),
-		EndTime:      utils.GetTimestamp().Add(duration),
+		StartTime:    utils.Now(),
+		EndTime:      utils.NewTime(utils.GetTimestamp().Add(duration)),
+		Metadata:     opts.Metadata,
+		Tags:         utils.NormalizeTags(opts.Tags),
+		Codecs:       opts.Codecs,
+		Audio:        opts.Audio,
+		api:          api,
+		tracks:       make(map[string]*publishedTrack),
+		timeline:     newStatsRing(cm.cfg.StatsRetention),
+		E2EE:         opts.E2EE,
+		talk:         newTalkTracker(),
+
+		ChatSessionID:   opts.ChatSessionID,
+		MaxParticipants: cm.cfg.MaxParticipants,
+		Overflow:        opts.Overflow,
+
+		NoiseSuppression: opts.NoiseSuppression,
+		Watermark:        opts.Watermark,
+		SkipSilence:      opts.SkipSilence,
+		CalleeID:         opts.CalleeID,
+	}
+	if opts.MaxParticipants > 0 {
+		session.MaxParticipants = opts.MaxParticipants
+	}
+	session.Layout = LayoutChange{Layout: LayoutGrid, At: session.StartTime}
+	session.layouts = []LayoutChange{session.Layout}
+
+	if err := session.setPasscode(opts.Passcode); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set passcode")
 	}
 
 	// Add creator as first participant
 	session.Participants[creatorID] = &CallParticipant{
 		ID:       creatorID,
+		Role:     RoleHost,
 		Status:   StatusConnected,
-		JoinTime: utils.GetTimestamp(),
+		JoinTime: utils.Now(),
+		Preset:   preset,
 	}
 
+	cm.Events.Publish(events.CallStarted, session.ID, map[string]interface{}{
+		"sessionId": session.ID,
+		"creatorId": creatorID,
+		"type":      callType,
+		"quality":   quality,
+		"endTime":   session.EndTime,
+	})
+
 	cm.mu.Lock()
-	cm.sessions[session.ID] = session
+	cm.assignJoinCode(session, session.EndTime.Time)
 	cm.mu.Unlock()
+	cm.sessions.Store(session.ID, session)
+
+	if cm.cfg.StatsInterval > 0 {
+		go cm.sampleStats(session)
+	}
+	if cm.OnSpeaking != nil {
+		go cm.watchSpeaking(session)
+	}
+	if cm.cfg.StateInterval > 0 {
+		go cm.checkpoint(session)
+	}
+	session.mu.Lock()
+	cm.persist(session)
+	session.mu.Unlock()
+	// Encrypted audio can't be recorded as voicemail
+	if session.CalleeID != "" && cm.cfg.VoicemailTimeout > 0 && !session.E2EE {
+		time.AfterFunc(cm.cfg.VoicemailTimeout, func() { cm.awaitCallee(session) })
+	}
 
 	// Auto terminate
 	go func() {
 		time.Sleep(duration)
-		cm.TerminateSession(session.ID)
+		cm.TerminateSession(session.ID, TerminationExpired)
 	}()
 
 	return session, nil
 }
 
-func (cm *CallManager) JoinCall(sessionID, participantID string, pc *webrtc.PeerConnection) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+// JoinCall adds a participant to a call. When the call is full the join is
+// rejected, or turned into a view-only audience seat if the call overflows.
+// A participant whose connection failed within the reconnect grace period
+// gets their previous slot back.
+func (cm *CallManager) JoinCall(sessionID, participantID, passcode string, profile ParticipantProfile) (*JoinInfo, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
-		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if errResp := session.checkLocked(participantID); errResp != nil {
+		return nil, errResp
+	}
+
+	if existing, exists := session.Participants[participantID]; exists && existing.Status == StatusReconnecting {
+		return cm.reattach(session, existing, profile)
+	}
+
+	switch {
+	case session.isProvisioned(participantID):
+		// Added by a host, the lobby doesn't apply but the passcode does
+		if errResp := session.verifyPasscode(participantID, passcode); errResp != nil {
+			return nil, errResp
+		}
+	case session.isInLobby(participantID):
+		// Participants waiting in the lobby already entered the passcode but
+		// need to be admitted by a host or co-host
+		if !session.admitted[participantID] {
+			return nil, utils.NewErrorResponse(http.StatusForbidden, "waiting to be admitted from the lobby")
+		}
+	default:
+		if errResp := session.verifyPasscode(participantID, passcode); errResp != nil {
+			return nil, errResp
+		}
+	}
+
+	audience := false
+	if session.isFull(participantID) {
+		if !session.Overflow {
+			return nil, utils.NewErrorResponse(http.StatusConflict, fmt.Sprintf("call is full, the limit is %d participants", session.MaxParticipants))
+		}
+		audience = true
+	}
+
 	// Check if participant is in lobby
 	for i, id := range session.InLobby {
 		if id == participantID {
 			// Remove from lobby
 			session.InLobby = append(session.InLobby[:i], session.InLobby[i+1:]...)
+			delete(session.admitted, participantID)
 			break
 		}
 	}
 
-	session.Participants[participantID] = &CallParticipant{
+	participant := &CallParticipant{
 		ID:             participantID,
-		PeerConnection: pc,
+		Role:           session.roleFor(participantID),
 		Status:         StatusConnected,
-		JoinTime:       utils.GetTimestamp(),
+		JoinTime:       utils.Now(),
 		NetworkQuality: 5, // Start with best quality
+		IsAudience:     audience,
+	}
+	participant.Preset, _ = PresetFor(session.Quality)
+	participant.applyProfile(profile)
+	if errResp := cm.connect(session, participant); errResp != nil {
+		return nil, errResp
+	}
+	// The callee picked up after all
+	if participantID == session.CalleeID {
+		cm.stopVoicemail(session, false)
+	}
+	session.Participants[participantID] = participant
+	cm.watchIdle(session)
+	cm.persist(session)
+	cm.Events.Publish(events.CallJoined, sessionID, map[string]interface{}{
+		"sessionId":     sessionID,
+		"participantId": participantID,
+		"role":          participant.Role,
+		"audience":      audience,
+	})
+
+	return &JoinInfo{ParticipantID: participantID, Role: participant.Role, Audience: audience, Layout: session.Layout}, nil
+}
+
+// connect builds a peer connection for the participant with the codecs
+// negotiated for the call and subscribes it to the published tracks. The
+// session lock must be held.
+func (cm *CallManager) connect(session *CallSession, participant *CallParticipant) *utils.ErrorResponse {
+	pc, err := session.api.NewPeerConnection(cm.factory.Configuration())
+	if err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to create peer connection")
 	}
 
-	// Setup media tracks
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if participant.IsAudience {
+			return
+		}
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			go enforceBitrate(participant, pc, track)
+		}
+		cm.publishTrack(participant.currentSession(), participant, pc, track, receiver)
+	})
+
+	cm.watchConnection(participant, pc)
+
+	// Setup media tracks, the audience only receives
+	direction := webrtc.RTPTransceiverDirectionSendrecv
+	if participant.IsAudience {
+		direction = webrtc.RTPTransceiverDirectionRecvonly
+	}
 	if session.Type == VideoCall {
 		if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
-			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendrecv}); err != nil {
+			webrtc.RTPTransceiverInit{Direction: direction}); err != nil {
+			pc.Close()
 			return utils.NewErrorResponse(http.StatusInternalServerError, "failed to add video transceiver")
 		}
 	}
 
 	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
-		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendrecv}); err != nil {
+		webrtc.RTPTransceiverInit{Direction: direction}); err != nil {
+		pc.Close()
 		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to add audio transceiver")
 	}
 
+	participant.PeerConnection = pc
+	participant.mu.Lock()
+	participant.session = session
+	participant.mu.Unlock()
+	session.subscribeToSession(participant, pc)
 	return nil
 }
 
-func (cm *CallManager) AddToLobby(sessionID, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// HandleOffer applies a participant's SDP offer to their call peer connection and returns the answer
+func (cm *CallManager) HandleOffer(sessionID, participantID string, offer webrtc.SessionDescription) (*webrtc.SessionDescription, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	// The peer connection is read under the session lock, a reattach or a
+	// transfer may replace it meanwhile
+	session.mu.RLock()
+	participant, exists := session.Participants[participantID]
+	var pc *webrtc.PeerConnection
+	if exists {
+		pc = participant.PeerConnection
+	}
+	session.mu.RUnlock()
+
+	if pc == nil {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "participant has not joined the call")
+	}
+
+	participant.negotiation.Lock()
+	defer participant.negotiation.Unlock()
+
+	// The server is the polite peer: a colliding offer rolls back the pending
+	// negotiation, and a rollback only does that. A server offer rolled back
+	// is sent again once this negotiation completes.
+	if pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		participant.needsOffer = true
+	}
+	if err := peer.ApplyOffer(pc, offer); err != nil {
+		if errors.Is(err, peer.ErrNegotiationPending) {
+			return nil, utils.NewErrorResponse(http.StatusConflict, "negotiation already in progress")
+		}
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "failed to set remote description")
+	}
+	if offer.Type == webrtc.SDPTypeRollback {
+		return pc.CurrentLocalDescription(), nil
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create answer")
+	}
+
+	// Wait for candidate gathering so the answer is usable without trickle ICE
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set local description")
+	}
+	<-gatherComplete
+
+	// Tracks the offer had no room for, e.g. those subscribed to on joining,
+	// are offered by the server
+	if unnegotiated(pc) {
+		participant.needsOffer = true
+	}
+	cm.negotiated(participant, pc)
+
+	return pc.LocalDescription(), nil
+}
 
+func (cm *CallManager) AddToLobby(sessionID, participantID, passcode string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
@@ -156,15 +616,25 @@ func (cm *CallManager) AddToLobby(sessionID, participantID string) *utils.ErrorR
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if errResp := session.checkLocked(participantID); errResp != nil {
+		return errResp
+	}
+	if errResp := session.verifyPasscode(participantID, passcode); errResp != nil {
+		return errResp
+	}
+	// Joining removes a single entry, a repeated request must not add another
+	if session.isInLobby(participantID) {
+		return nil
+	}
+
 	session.InLobby = append(session.InLobby, participantID)
 	return nil
 }
 
-func (cm *CallManager) ToggleMute(sessionID, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+// ToggleMute toggles a participant's mute state. Participants may toggle
+// themselves unless hard-muted, while hosts and co-hosts may toggle anyone.
+func (cm *CallManager) ToggleMute(sessionID, actorID, participantID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
@@ -177,18 +647,55 @@ func (cm *CallManager) ToggleMute(sessionID, participantID string) *utils.ErrorR
 		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
 	}
 
+	self := actorID == "" || actorID == participantID
+	if !self && !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can mute other participants")
+	}
+
 	participant.mu.Lock()
+	defer participant.mu.Unlock()
+
+	if self && participant.IsMuted && participant.IsHardMuted {
+		return utils.NewErrorResponse(http.StatusForbidden, "muted by the host")
+	}
 	participant.IsMuted = !participant.IsMuted
-	participant.mu.Unlock()
+
+	if !self {
+		cm.Audit.Record(actorID, audit.CallMute, sessionID, participantID, !participant.IsMuted, participant.IsMuted)
+	}
 
 	return nil
 }
 
-func (cm *CallManager) UpdateNetworkQuality(sessionID, participantID string, quality int) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// UpdateParticipantProfile updates the display name, avatar and metadata of a
+// participant and returns their encoded state, taken under the lock
+func (cm *CallManager) UpdateParticipantProfile(sessionID, participantID string, profile ParticipantProfile) (json.RawMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
 
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	participant, exists := session.Participants[participantID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	participant.mu.Lock()
+	defer participant.mu.Unlock()
+
+	participant.applyProfile(profile)
+	data, err := json.Marshal(participant)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to encode participant")
+	}
+	return data, nil
+}
+
+func (cm *CallManager) UpdateNetworkQuality(sessionID, participantID string, quality int) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
@@ -204,56 +711,181 @@ func (cm *CallManager) UpdateNetworkQuality(sessionID, participantID string, qua
 	participant.mu.Lock()
 	participant.NetworkQuality = quality
 	participant.mu.Unlock()
+	cm.adaptAudioOnly(session, participant, quality)
 
 	return nil
 }
 
-func (cm *CallManager) ToggleRecording(sessionID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// SetParticipantQuality overrides the session quality preset for a single participant
+func (cm *CallManager) SetParticipantQuality(sessionID, participantID string, quality CallQuality) (*QualityPreset, *utils.ErrorResponse) {
+	preset, ok := PresetFor(quality)
+	if !ok {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid call quality")
+	}
 
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
-		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	participant, exists := session.Participants[participantID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	participant.mu.Lock()
+	participant.Preset = preset
+	participant.mu.Unlock()
+
+	return &preset, nil
+}
+
+// ToggleRecording flips the recording flag of a session and returns the new state
+func (cm *CallManager) ToggleRecording(sessionID, actorID string) (bool, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return false, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
 
 	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return false, errRecordingForbidden()
+	}
+	if session.E2EE && !session.IsRecording {
+		return false, errRecordingUnavailable()
+	}
 	session.IsRecording = !session.IsRecording
-	session.mu.Unlock()
+	cm.Audit.Record(actorID, audit.CallRecordingToggle, sessionID, "", !session.IsRecording, session.IsRecording)
 
-	return nil
+	return session.IsRecording, nil
 }
 
-func (cm *CallManager) TerminateSession(sessionID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// ListSessions returns the active call sessions, optionally filtered by
+// tag. Each is encoded while its lock is held, as it keeps changing once
+// listed.
+func (cm *CallManager) ListSessions(tag string) []json.RawMessage {
+	sessions := []json.RawMessage{}
+	cm.sessions.Range(func(_ string, session *CallSession) bool {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+
+		if tag != "" && !session.HasTag(tag) {
+			return true
+		}
+		data, err := json.Marshal(session)
+		if err != nil {
+			log.Printf("Error encoding call %s: %v\n", session.ID, err)
+			return true
+		}
+		sessions = append(sessions, data)
+		return true
+	})
+
+	return sessions
+}
+
+// SessionIDs returns the IDs of the active call sessions
+func (cm *CallManager) SessionIDs() []string {
+	ids := []string{}
+	cm.sessions.Range(func(id string, _ *CallSession) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
 
+// SessionCount returns the number of active call sessions
+func (cm *CallManager) SessionCount() int {
+	return cm.sessions.Len()
+}
+
+// PeerConnectionCount returns the number of participant peer connections
+// across all active calls
+func (cm *CallManager) PeerConnectionCount() int {
+	count := 0
+	for _, session := range cm.sessions.Values() {
+		session.mu.RLock()
+		for _, participant := range session.Participants {
+			if participant.PeerConnection != nil {
+				count++
+			}
+		}
+		session.mu.RUnlock()
+	}
+	return count
+}
+
+func (cm *CallManager) TerminateSession(sessionID, reason string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
 
 	session.mu.Lock()
-	// Close all peer connections
+	// Terminated concurrently, e.g. by a host as the call expired
+	if session.terminated {
+		session.mu.Unlock()
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+	session.terminated = true
+	if session.idleTimer != nil {
+		session.idleTimer.Stop()
+		session.idleTimer = nil
+	}
+	cm.stopVoicemail(session, true)
+	// Take a last quality sample while media still flows
+	session.collectStats()
+	// Close all peer connections, finishing the recordings so the call
+	// detail record can refer to them
 	for _, participant := range session.Participants {
+		participant.mu.Lock()
+		if participant.MediaRecorder != nil && participant.MediaRecorder.IsRecording() {
+			if _, err := participant.MediaRecorder.Stop(session.layouts); err != nil {
+				log.Printf("Error finalizing recording of %s: %v\n", participant.ID, err)
+			}
+		}
+		participant.mu.Unlock()
 		if participant.PeerConnection != nil {
 			participant.PeerConnection.Close()
 		}
 	}
+	endedAt := time.Now()
+	report := session.qualityReport(endedAt)
+	// Built first, the history then holds only the participants transferred
+	// out of the call
+	record := cm.callDetailRecord(session, endedAt, reason, report)
+	cm.recordHistory(session, endedAt, report)
 	session.mu.Unlock()
 
+	if err := cm.saveCallDetailRecord(record); err != nil {
+		log.Printf("Error storing call detail record of %s: %v\n", sessionID, err)
+	}
+	if cm.OnCallDetailRecord != nil {
+		go cm.OnCallDetailRecord(record)
+	}
+
+	cm.sessions.Delete(sessionID)
 	cm.mu.Lock()
-	delete(cm.sessions, sessionID)
+	delete(cm.joinCodes, session.JoinCode)
 	cm.mu.Unlock()
+	cm.Snapshots.remove(sessionID)
+	cm.forgetState(sessionID)
+
+	cm.Audit.Record(audit.SystemActor, audit.CallSessionTerminate, sessionID, "", nil, nil)
+	cm.Events.Publish(events.CallEnded, sessionID, map[string]interface{}{"sessionId": sessionID})
 
 	return nil
 }
 
-func (cm *CallManager) StartRecording(sessionID, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+// StartRecording records the tracks published by a participant on behalf of a
+// host or co-host
+func (cm *CallManager) StartRecording(sessionID, actorID, participantID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
@@ -261,6 +893,13 @@ func (cm *CallManager) StartRecording(sessionID, participantID string) *utils.Er
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if !session.canModerate(actorID) {
+		return errRecordingForbidden()
+	}
+	if session.E2EE {
+		return errRecordingUnavailable()
+	}
+
 	participant, exists := session.Participants[participantID]
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
@@ -269,23 +908,46 @@ func (cm *CallManager) StartRecording(sessionID, participantID string) *utils.Er
 	participant.mu.Lock()
 	defer participant.mu.Unlock()
 
-	if participant.MediaRecorder == nil {
-		participant.MediaRecorder = NewMediaRecorder()
+	if participant.MediaRecorder != nil && participant.MediaRecorder.IsRecording() {
+		return utils.NewErrorResponse(http.StatusConflict, "recording already in progress")
 	}
-
-	err := participant.MediaRecorder.Start(participant.PeerConnection)
-	if err != nil {
+	if err := cm.startRecorder(session, participant); err != nil {
 		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to start recording")
 	}
 
+	// Keyframes let the video files start decodable
+	session.requestKeyframesFrom(participantID)
+
+	cm.Audit.Record(actorID, audit.CallRecordingStart, sessionID, participantID, nil, nil)
+
 	return nil
 }
 
-func (cm *CallManager) ProcessAudioLevel(sessionID, participantID string, sample []byte) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// startRecorder starts recording a participant with the post-processing the
+// call asks for. The session and participant locks must be held.
+func (cm *CallManager) startRecorder(session *CallSession, participant *CallParticipant) error {
+	if participant.MediaRecorder == nil {
+		participant.MediaRecorder = NewMediaRecorder(filepath.Join(cm.recordingDir, session.ID), session.ID, participant.ID)
+	}
+
+	var post PostProcessing
+	if session.NoiseSuppression {
+		post.Denoiser = cm.Denoiser
+	}
+	if session.Watermark && cm.Watermark != nil {
+		tenant, _ := session.Metadata[TenantMetadataKey].(string)
+		post.Watermark = cm.Watermark
+		post.Mark = watermark.Mark{Text: session.ID, Logo: cm.Watermark.LogoFor(tenant)}
+	}
+	var silence *silenceGate
+	if session.SkipSilence && cm.cfg.SilenceThreshold > 0 {
+		silence = &silenceGate{talk: session.talk, after: cm.cfg.SilenceThreshold}
+	}
+	return participant.MediaRecorder.Start(post, silence)
+}
 
+func (cm *CallManager) ProcessAudioLevel(sessionID, participantID string, sample []byte) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
@@ -311,41 +973,85 @@ func (cm *CallManager) ProcessAudioLevel(sessionID, participantID string, sample
 	return nil
 }
 
-func (cm *CallManager) StopRecording(sessionID, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
+// errRecordingUnavailable is returned when recording is requested for an end-to-end encrypted call
+func errRecordingUnavailable() *utils.ErrorResponse {
+	return errUnavailableEncrypted("recording")
+}
+
+// errUnavailableEncrypted is returned when a feature that needs decoded
+// media is requested for an end-to-end encrypted call
+func errUnavailableEncrypted(feature string) *utils.ErrorResponse {
+	return utils.NewErrorResponse(http.StatusConflict, fmt.Sprintf("%s is unavailable for end-to-end encrypted calls", feature))
+}
+
+// errRecordingForbidden is returned when someone other than a host or co-host controls recording
+func errRecordingForbidden() *utils.ErrorResponse {
+	return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can control recording")
+}
 
+// StopRecording stops a participant's recording on behalf of a host or
+// co-host and returns the written manifest
+func (cm *CallManager) StopRecording(sessionID, actorID, participantID string) (*RecordingManifest, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
-		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if !session.canModerate(actorID) {
+		return nil, errRecordingForbidden()
+	}
+
 	participant, exists := session.Participants[participantID]
 	if !exists {
-		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
 	}
 
 	participant.mu.Lock()
 	defer participant.mu.Unlock()
 
-	if participant.MediaRecorder != nil {
-		participant.MediaRecorder.Stop()
+	if participant.MediaRecorder == nil || !participant.MediaRecorder.IsRecording() {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "recording not in progress")
 	}
 
-	return nil
-}
+	manifest, err := participant.MediaRecorder.Stop(session.layouts)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to finalize recording")
+	}
+	cm.Audit.Record(actorID, audit.CallRecordingStop, sessionID, participantID, nil, manifest)
 
-func (cm *CallManager) GetCallSession(sessionID string) (*CallSession, *utils.ErrorResponse) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	return manifest, nil
+}
 
-	session, exists := cm.sessions[sessionID]
+// GetCallSession returns a call session, encoded while its lock is held
+// like the ones of ListSessions
+func (cm *CallManager) GetCallSession(sessionID string) (json.RawMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
 	}
 
-	return session, nil
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to encode call session")
+	}
+	return data, nil
+}
+
+// ChatSessionOf returns the ID of the chat session linked to a call, empty
+// when there is none
+func (cm *CallManager) ChatSessionOf(sessionID string) (string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return "", utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.ChatSessionID, nil
 }
@@ -0,0 +1,275 @@
+package call
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// Reasons a call is terminated for
+const (
+	TerminationEndedByHost = "ended-by-host"
+	TerminationExpired     = "expired"
+	// TerminationVoicemail ends a call whose voicemail reached its maximum
+	// length
+	TerminationVoicemail = "voicemail-limit"
+	// TerminationShutdown ends the calls still live when the service
+	// finished draining
+	TerminationShutdown = "shutdown"
+	// TerminationIdle ends a call nobody was connected to for the idle
+	// timeout
+	TerminationIdle = "idle"
+)
+
+// CDRParticipant is the time a participant spent in a call
+type CDRParticipant struct {
+	ID              string     `json:"id"`
+	Role            CallRole   `json:"role"`
+	JoinTime        utils.Time `json:"joinTime"`
+	LeftAt          utils.Time `json:"leftAt"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	// AverageMOS is zero when the participant published no media
+	AverageMOS float64 `json:"averageMos,omitempty"`
+}
+
+// RecordingReference points to a finished recording of a call
+type RecordingReference struct {
+	ParticipantID string     `json:"participantId"`
+	StartedAt     utils.Time `json:"startedAt"`
+	StoppedAt     utils.Time `json:"stoppedAt"`
+	Files         []string   `json:"files"`
+}
+
+// CallDetailRecord describes a terminated call for billing and compliance
+type CallDetailRecord struct {
+	ID                string                 `json:"id"`
+	SessionID         string                 `json:"sessionId"`
+	Type              CallType               `json:"type"`
+	CreatorID         string                 `json:"creatorId"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	StartTime         utils.Time             `json:"startTime"`
+	EndTime           utils.Time             `json:"endTime"`
+	DurationSeconds   float64                `json:"durationSeconds"`
+	TerminationReason string                 `json:"terminationReason"`
+	Participants      []CDRParticipant       `json:"participants"`
+	AverageMOS        float64                `json:"averageMos"`
+	MinMOS            float64                `json:"minMos"`
+	Recordings        []RecordingReference   `json:"recordings"`
+}
+
+// callDetailRecord describes a session that is ending. Participants that
+// were transferred out of the call are taken from the history, provisioned
+// ones that never joined are left out. The session lock must be held.
+func (cm *CallManager) callDetailRecord(session *CallSession, endedAt time.Time, reason string, report *QualityReport) *CallDetailRecord {
+	record := &CallDetailRecord{
+		ID:                utils.NewID(utils.PrefixCDR),
+		SessionID:         session.ID,
+		Type:              session.Type,
+		CreatorID:         session.CreatorID,
+		Metadata:          session.Metadata,
+		StartTime:         session.StartTime,
+		EndTime:           utils.NewTime(endedAt),
+		DurationSeconds:   endedAt.Sub(session.StartTime.Time).Seconds(),
+		TerminationReason: reason,
+		Participants:      []CDRParticipant{},
+		AverageMOS:        report.AverageMOS,
+		MinMOS:            report.MinMOS,
+		Recordings:        cm.sessionRecordings(session.ID),
+	}
+
+	mos := make(map[string]float64, len(report.Participants))
+	for _, quality := range report.Participants {
+		mos[quality.ParticipantID] = quality.AverageMOS
+	}
+
+	cm.historyMu.Lock()
+	for _, entry := range cm.history {
+		if entry.SessionID != session.ID {
+			continue
+		}
+		record.Participants = append(record.Participants, CDRParticipant{
+			ID:              entry.participantID,
+			JoinTime:        entry.JoinTime,
+			LeftAt:          entry.LeftAt,
+			DurationSeconds: entry.DurationSeconds,
+		})
+	}
+	cm.historyMu.Unlock()
+
+	for _, participant := range session.Participants {
+		if participant.Status == StatusWaiting {
+			continue
+		}
+		leftAt := endedAt
+		if participant.Status == StatusLeft && !participant.LeftAt.IsZero() {
+			leftAt = participant.LeftAt.Time
+		}
+		record.Participants = append(record.Participants, CDRParticipant{
+			ID:              participant.ID,
+			Role:            participant.Role,
+			JoinTime:        participant.JoinTime,
+			LeftAt:          utils.NewTime(leftAt),
+			DurationSeconds: leftAt.Sub(participant.JoinTime.Time).Seconds(),
+			AverageMOS:      mos[participant.ID],
+		})
+	}
+	sort.Slice(record.Participants, func(i, j int) bool {
+		return record.Participants[i].JoinTime.Before(record.Participants[j].JoinTime.Time)
+	})
+
+	return record
+}
+
+// sessionRecordings returns the finished recordings of a call
+func (cm *CallManager) sessionRecordings(sessionID string) []RecordingReference {
+	references := []RecordingReference{}
+
+	paths, _ := filepath.Glob(filepath.Join(cm.recordingDir, filepath.Base(sessionID), "*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var manifest RecordingManifest
+		if err := json.Unmarshal(data, &manifest); err != nil || manifest.ParticipantID == "" {
+			continue
+		}
+
+		reference := RecordingReference{
+			ParticipantID: manifest.ParticipantID,
+			StartedAt:     manifest.StartedAt,
+			StoppedAt:     manifest.StoppedAt,
+			Files:         make([]string, 0, len(manifest.Files)),
+		}
+		for _, file := range manifest.Files {
+			reference.Files = append(reference.Files, file.Path)
+		}
+		references = append(references, reference)
+	}
+
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].StartedAt.Before(references[j].StartedAt.Time)
+	})
+	return references
+}
+
+// saveCallDetailRecord writes a record to the CDR directory
+func (cm *CallManager) saveCallDetailRecord(record *CallDetailRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cm.cfg.CDRDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cm.cfg.CDRDir, record.SessionID+".json"), data, 0644)
+}
+
+// loadCallDetailRecord reads the record of a call
+func (cm *CallManager) loadCallDetailRecord(sessionID string) (*CallDetailRecord, error) {
+	data, err := os.ReadFile(filepath.Join(cm.cfg.CDRDir, filepath.Base(sessionID)+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var record CallDetailRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetCallDetailRecord returns the record of a terminated call
+func (cm *CallManager) GetCallDetailRecord(sessionID string) (*CallDetailRecord, *utils.ErrorResponse) {
+	record, err := cm.loadCallDetailRecord(sessionID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "call detail record not found")
+		}
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to load call detail record")
+	}
+	return record, nil
+}
+
+// ListCallDetailRecords returns the records of the calls that ended within
+// [since, until), oldest first
+func (cm *CallManager) ListCallDetailRecords(since, until time.Time) ([]CallDetailRecord, *utils.ErrorResponse) {
+	files, err := os.ReadDir(cm.cfg.CDRDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to list call detail records")
+	}
+
+	records := []CallDetailRecord{}
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		record, err := cm.loadCallDetailRecord(sessionID)
+		if err != nil {
+			continue
+		}
+		if record.EndTime.Before(since) || !record.EndTime.Before(until) {
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].EndTime.Before(records[j].EndTime.Time)
+	})
+	return records, nil
+}
+
+// WriteCallDetailRecordsCSV writes records as CSV, one row per participant
+// so billing can rate every leg of a call
+func WriteCallDetailRecordsCSV(w io.Writer, records []CallDetailRecord) error {
+	out := csv.NewWriter(w)
+	header := []string{
+		"cdr_id", "session_id", "type", "creator_id", "start_time", "end_time", "duration_seconds",
+		"termination_reason", "participant_id", "role", "join_time", "left_at", "participant_duration_seconds",
+		"average_mos", "recordings",
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	formatSeconds := func(seconds float64) string { return strconv.FormatFloat(seconds, 'f', 3, 64) }
+	for _, record := range records {
+		call := []string{
+			record.ID, record.SessionID, string(record.Type), record.CreatorID,
+			record.StartTime.UTC().Format(time.RFC3339), record.EndTime.UTC().Format(time.RFC3339),
+			formatSeconds(record.DurationSeconds), record.TerminationReason,
+		}
+		for _, participant := range record.Participants {
+			recordings := 0
+			for _, recording := range record.Recordings {
+				if recording.ParticipantID == participant.ID {
+					recordings++
+				}
+			}
+			row := append(append([]string{}, call...),
+				participant.ID, string(participant.Role),
+				participant.JoinTime.UTC().Format(time.RFC3339), participant.LeftAt.UTC().Format(time.RFC3339),
+				formatSeconds(participant.DurationSeconds),
+				strconv.FormatFloat(participant.AverageMOS, 'f', 2, 64), strconv.Itoa(recordings),
+			)
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,109 @@
+package call
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/coldstorage"
+	"pion-webrtc-microservice/utils"
+)
+
+// coldKey is the key of an archived call in cold storage
+func coldKey(sessionID string) string {
+	return "call/" + sessionID + ".json"
+}
+
+// OffloadArchives moves the participants and reports of calls archived
+// before a time to cold storage, leaving stubs in the archive directory. It
+// returns how many were moved.
+func (cm *CallManager) OffloadArchives(before time.Time) (int, error) {
+	if cm.ColdStore == nil {
+		return 0, nil
+	}
+
+	files, err := os.ReadDir(cm.cfg.ArchiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	offloaded := 0
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		archived, err := cm.loadArchive(sessionID)
+		if err != nil || !archived.OffloadedAt.IsZero() {
+			continue
+		}
+		if !coldstorage.Due(archived.ArchivedAt.Time, archived.RehydratedAt.Time, before) {
+			continue
+		}
+
+		data, err := json.Marshal(archived)
+		if err != nil {
+			return offloaded, err
+		}
+		if err := cm.ColdStore.Put(coldKey(sessionID), data); err != nil {
+			return offloaded, err
+		}
+
+		archived.Participants = nil
+		archived.Report = nil
+		archived.OffloadedAt = utils.Now()
+		if err := cm.saveArchive(archived); err != nil {
+			return offloaded, err
+		}
+		offloaded++
+	}
+
+	return offloaded, nil
+}
+
+// RehydrateArchive fetches an offloaded call back into the archive
+// directory. It stays there until it ages out again.
+func (cm *CallManager) RehydrateArchive(sessionID string) (*ArchivedCall, *utils.ErrorResponse) {
+	stub, errResp := cm.GetArchivedCall(sessionID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if stub.OffloadedAt.IsZero() {
+		return stub, nil
+	}
+	if cm.ColdStore == nil {
+		return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "cold storage is disabled")
+	}
+
+	data, err := cm.ColdStore.Get(coldKey(stub.ID))
+	if err != nil {
+		if errors.Is(err, coldstorage.ErrNotFound) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "archived call not found in cold storage")
+		}
+		if errors.Is(err, breaker.ErrOpen) {
+			return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "cold storage is unavailable")
+		}
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to fetch archived call from cold storage")
+	}
+
+	var archived ArchivedCall
+	if err := json.Unmarshal(data, &archived); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to fetch archived call from cold storage")
+	}
+
+	archived.OffloadedAt = utils.Time{}
+	archived.RehydratedAt = utils.Now()
+	if err := cm.saveArchive(&archived); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to restore archived call")
+	}
+
+	return &archived, nil
+}
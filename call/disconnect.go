@@ -0,0 +1,71 @@
+package call
+
+import (
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// watchConnection follows the state of a participant's peer connection so a
+// participant that vanished doesn't stay connected. A failed connection
+// keeps their slot for the reconnect grace period, one closed underneath
+// them releases it right away.
+func (cm *CallManager) watchConnection(participant *CallParticipant, pc *webrtc.PeerConnection) {
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		switch state {
+		case webrtc.ICEConnectionStateDisconnected:
+			// Usually transient, ICE fails on its own if connectivity doesn't return
+			log.Printf("Participant %s of call %s lost connectivity\n", participant.ID, participant.currentSession().ID)
+		case webrtc.ICEConnectionStateFailed:
+			cm.startReconnectGrace(participant.currentSession(), participant, pc)
+		}
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// Ask publishers for a keyframe once media can flow to the new subscriber
+			participant.currentSession().requestKeyframes(participant.ID)
+		case webrtc.PeerConnectionStateFailed:
+			cm.startReconnectGrace(participant.currentSession(), participant, pc)
+		case webrtc.PeerConnectionStateClosed:
+			cm.dropConnection(participant.currentSession(), participant, pc)
+		}
+	})
+
+	if cm.cfg.ConnectTimeout <= 0 {
+		return
+	}
+	// A participant that joined but never negotiated, or whose ICE checks
+	// never got anywhere, would otherwise hold their slot forever
+	time.AfterFunc(cm.cfg.ConnectTimeout, func() {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			return
+		}
+		log.Printf("Participant %s of call %s didn't connect within %s\n", participant.ID, participant.currentSession().ID, cm.cfg.ConnectTimeout)
+		cm.startReconnectGrace(participant.currentSession(), participant, pc)
+	})
+}
+
+// dropConnection releases the slot of a participant whose peer connection
+// was closed while they were still in the call. Connections closed by the
+// call itself, as it ends or a participant leaves or reattaches, are no
+// longer the participant's by then and are ignored.
+func (cm *CallManager) dropConnection(session *CallSession, participant *CallParticipant, pc *webrtc.PeerConnection) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.terminated || participant.PeerConnection != pc || participant.Status == StatusLeft {
+		return
+	}
+
+	session.release(participant)
+	if participant.ID == session.CreatorID {
+		cm.stopVoicemail(session, true)
+	}
+	cm.publishLeft(session.ID, participant.ID, "connection-closed")
+	log.Printf("Connection of participant %s of call %s was closed\n", participant.ID, session.ID)
+	cm.watchIdle(session)
+	cm.persist(session)
+}
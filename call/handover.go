@@ -0,0 +1,108 @@
+package call
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// maxClaimTries bounds the IDs drawn for a new call until ClaimID accepts
+// one, after which the call keeps the last ID it drew
+const maxClaimTries = 64
+
+// newSessionID draws the ID of a new call, preferring one ClaimID accepts
+func (cm *CallManager) newSessionID() string {
+	id := utils.NewID(utils.PrefixCall)
+	for tries := 1; cm.ClaimID != nil && !cm.ClaimID(id) && tries < maxClaimTries; tries++ {
+		id = utils.NewID(utils.PrefixCall)
+	}
+	return id
+}
+
+// HandOver passes a call to another node with adopt, which receives its
+// state. Once adopted, the call is dropped here without ending it: the
+// recordings are finished, to resume on the adopting node, the peer
+// connections are closed and the IDs of the participants that have to rejoin
+// there are returned.
+func (cm *CallManager) HandOver(sessionID string, adopt func(state []byte) error) ([]string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	// The lock is held while adopting, so the handed over state stays current
+	session.mu.Lock()
+	if session.terminated {
+		session.mu.Unlock()
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+	state := session.state()
+	data, err := json.Marshal(state)
+	if err != nil {
+		session.mu.Unlock()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to encode call session")
+	}
+	if err := adopt(data); err != nil {
+		session.mu.Unlock()
+		log.Printf("Error handing over call %s: %v\n", sessionID, err)
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to hand over call session")
+	}
+
+	session.terminated = true
+	cm.stopVoicemail(session, true)
+	rejoin := []string{}
+	for _, participant := range session.Participants {
+		participant.mu.Lock()
+		if participant.MediaRecorder != nil && participant.MediaRecorder.IsRecording() {
+			if _, err := participant.MediaRecorder.Stop(session.layouts); err != nil {
+				log.Printf("Error finalizing recording of %s: %v\n", participant.ID, err)
+			}
+		}
+		participant.mu.Unlock()
+		if participant.PeerConnection != nil {
+			participant.PeerConnection.Close()
+		}
+	}
+	for _, saved := range state.Participants {
+		if saved.Connected && saved.Status != StatusLeft {
+			rejoin = append(rejoin, saved.ID)
+		}
+	}
+	session.mu.Unlock()
+
+	cm.sessions.Delete(sessionID)
+	cm.mu.Lock()
+	delete(cm.joinCodes, session.JoinCode)
+	cm.mu.Unlock()
+	cm.Snapshots.remove(sessionID)
+	cm.forgetState(sessionID)
+
+	cm.Audit.Record(audit.SystemActor, audit.CallHandOver, sessionID, "", nil, nil)
+	return rejoin, nil
+}
+
+// AdoptSession takes over a call handed over by another node. Its connected
+// participants get the reconnect grace period to rejoin here.
+func (cm *CallManager) AdoptSession(data []byte) *utils.ErrorResponse {
+	var state persistedCall
+	if err := json.Unmarshal(data, &state); err != nil || state.ID == "" {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid call session state")
+	}
+
+	if _, exists := cm.sessions.Load(state.ID); exists {
+		return utils.NewErrorResponse(http.StatusConflict, "call session already exists")
+	}
+
+	if err := cm.restore(&state); err != nil {
+		return utils.NewErrorResponse(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	session, _ := cm.sessions.Load(state.ID)
+	session.mu.Lock()
+	cm.persist(session)
+	session.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,244 @@
+package call
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pion-webrtc-microservice/statestore"
+	"pion-webrtc-microservice/utils"
+)
+
+// Participation describes the time a participant spent in a call
+type Participation struct {
+	SessionID string     `json:"sessionId"`
+	Type      CallType   `json:"type"`
+	JoinTime  utils.Time `json:"joinTime"`
+	// LeftAt is zero while the participant is still in the call
+	LeftAt          utils.Time `json:"leftAt"`
+	DurationSeconds float64    `json:"durationSeconds"`
+}
+
+// historyEntry is a finished participation kept by the CallManager
+type historyEntry struct {
+	participantID string
+	Participation
+}
+
+// persistedParticipation is a history entry as kept in the history store
+type persistedParticipation struct {
+	ParticipantID string `json:"participantId"`
+	Participation
+}
+
+func newParticipation(session *CallSession, participant *CallParticipant, leftAt time.Time) Participation {
+	end := leftAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return Participation{
+		SessionID:       session.ID,
+		Type:            session.Type,
+		JoinTime:        participant.JoinTime,
+		LeftAt:          utils.NewTime(leftAt),
+		DurationSeconds: end.Sub(participant.JoinTime.Time).Seconds(),
+	}
+}
+
+// keptReport is the quality report of an ended call, kept in memory until
+// it expires and then read from the archive
+type keptReport struct {
+	report    *QualityReport
+	expiresAt time.Time
+}
+
+// recordHistory keeps the participations and the quality report of a
+// session that is ending and archives it. The session lock must be held.
+func (cm *CallManager) recordHistory(session *CallSession, endedAt time.Time, report *QualityReport) {
+	cm.historyMu.Lock()
+	defer cm.historyMu.Unlock()
+
+	cm.pruneReports(endedAt)
+	cm.reports[session.ID] = keptReport{report: report, expiresAt: endedAt.Add(cm.cfg.ReportRetention)}
+	if err := cm.archive(session, endedAt, report); err != nil {
+		log.Printf("Error archiving call session %s: %v\n", session.ID, err)
+	}
+
+	for _, participant := range session.Participants {
+		cm.addHistory(historyEntry{
+			participantID: participant.ID,
+			Participation: newParticipation(session, participant, endedAt),
+		})
+	}
+}
+
+// pruneReports drops the quality reports that expired. The history lock
+// must be held.
+func (cm *CallManager) pruneReports(now time.Time) {
+	for sessionID, kept := range cm.reports {
+		if !now.Before(kept.expiresAt) {
+			delete(cm.reports, sessionID)
+		}
+	}
+}
+
+// addHistory keeps a finished participation and queues it to be written to
+// the history store. The history lock must be held.
+func (cm *CallManager) addHistory(entry historyEntry) {
+	cm.history = append(cm.history, entry)
+	if cm.History == nil {
+		return
+	}
+
+	data, err := json.Marshal(persistedParticipation{ParticipantID: entry.participantID, Participation: entry.Participation})
+	if err != nil {
+		log.Printf("Error encoding history of call %s: %v\n", entry.SessionID, err)
+		return
+	}
+	// A participant transferred out of a call and back has two entries
+	cm.histories.queue(cm.History, entry.SessionID+"."+utils.NewID(""), data)
+}
+
+// visitHistory calls fn with the key of every entry of the history store
+// belonging to a user, once the entries still queued are written
+func (cm *CallManager) visitHistory(userID string, fn func(key string, entry persistedParticipation) error) error {
+	cm.histories.flush()
+
+	keys, err := cm.History.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := cm.History.Get(key)
+		if errors.Is(err, statestore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry persistedParticipation
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Error decoding history entry %s: %v\n", key, err)
+			continue
+		}
+		if entry.ParticipantID != userID {
+			continue
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedHistory reads the participations of a user from the history store
+func (cm *CallManager) storedHistory(userID string) ([]Participation, error) {
+	participations := []Participation{}
+	err := cm.visitHistory(userID, func(_ string, entry persistedParticipation) error {
+		participations = append(participations, entry.Participation)
+		return nil
+	})
+	return participations, err
+}
+
+// EraseHistory deletes the finished participations of a user, in memory
+// and in the history store, and returns how many it deleted. Their calls
+// then no longer appear in the user's exports, nor do their recordings.
+func (cm *CallManager) EraseHistory(userID string) (int, error) {
+	cm.historyMu.Lock()
+	kept := cm.history[:0]
+	erased := 0
+	for _, entry := range cm.history {
+		if entry.participantID == userID {
+			erased++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	cm.history = kept
+	cm.historyMu.Unlock()
+
+	if cm.History == nil {
+		return erased, nil
+	}
+
+	// The store holds the entries of earlier runs and other nodes as well
+	erased = 0
+	err := cm.visitHistory(userID, func(key string, _ persistedParticipation) error {
+		if err := cm.History.Delete(key); err != nil {
+			return err
+		}
+		erased++
+		return nil
+	})
+	return erased, err
+}
+
+// ParticipationsOf returns the calls a user took part in, including the ones
+// still in progress. With a history store they include the calls that
+// ended before a restart or on another node sharing the store.
+func (cm *CallManager) ParticipationsOf(userID string) ([]Participation, error) {
+	participations := []Participation{}
+	if cm.History != nil {
+		stored, err := cm.storedHistory(userID)
+		if err != nil {
+			return nil, err
+		}
+		participations = append(participations, stored...)
+	} else {
+		cm.historyMu.Lock()
+		for _, participation := range cm.history {
+			if participation.participantID == userID {
+				participations = append(participations, participation.Participation)
+			}
+		}
+		cm.historyMu.Unlock()
+	}
+
+	for _, session := range cm.sessions.Values() {
+		session.mu.RLock()
+		if participant, exists := session.Participants[userID]; exists {
+			participations = append(participations, newParticipation(session, participant, time.Time{}))
+		}
+		session.mu.RUnlock()
+	}
+
+	return participations, nil
+}
+
+// RecordingsOf returns the manifests of the finished recordings of the
+// calls taken part in, of every participant recorded in them
+func (cm *CallManager) RecordingsOf(calls []Participation) ([]RecordingManifest, error) {
+	sessionIDs := make(map[string]bool, len(calls))
+	for _, participation := range calls {
+		sessionIDs[participation.SessionID] = true
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cm.recordingDir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []RecordingManifest{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest RecordingManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		if sessionIDs[manifest.SessionID] {
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	return manifests, nil
+}
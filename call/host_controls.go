@@ -0,0 +1,82 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// MuteAll mutes every participant except the host and co-hosts. A hard mute also keeps
+// participants from unmuting themselves and stops forwarding their audio
+// until the host lifts it. It returns the IDs of the muted participants.
+func (cm *CallManager) MuteAll(sessionID, hostID string, hard bool) ([]string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(hostID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can mute all participants")
+	}
+
+	muted := []string{}
+	for id, participant := range session.Participants {
+		if session.canModerate(id) {
+			continue
+		}
+		participant.mu.Lock()
+		participant.IsMuted = true
+		if hard {
+			participant.IsHardMuted = true
+		}
+		participant.mu.Unlock()
+		muted = append(muted, id)
+	}
+
+	cm.Audit.Record(hostID, audit.CallMuteAll, sessionID, "", nil, map[string]interface{}{
+		"participants": muted,
+		"hard":         hard,
+	})
+
+	return muted, nil
+}
+
+// LiftHardMute allows a hard-muted participant to unmute again, or every
+// participant when participantID is empty. Participants stay muted until
+// they unmute themselves.
+func (cm *CallManager) LiftHardMute(sessionID, hostID, participantID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(hostID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can lift a hard mute")
+	}
+
+	if participantID != "" {
+		if _, exists := session.Participants[participantID]; !exists {
+			return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+		}
+	}
+
+	for id, participant := range session.Participants {
+		if participantID != "" && id != participantID {
+			continue
+		}
+		participant.mu.Lock()
+		participant.IsHardMuted = false
+		participant.mu.Unlock()
+	}
+
+	cm.Audit.Record(hostID, audit.CallLiftHardMute, sessionID, participantID, nil, nil)
+
+	return nil
+}
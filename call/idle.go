@@ -0,0 +1,55 @@
+package call
+
+import (
+	"log"
+	"time"
+)
+
+// hasConnected reports whether a participant is connected to the call with
+// a peer connection. The session lock must be held.
+func (s *CallSession) hasConnected() bool {
+	for _, participant := range s.Participants {
+		if participant.Status == StatusConnected && participant.PeerConnection != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// watchIdle starts the idle timer of a call nobody is connected to anymore
+// and stops it once someone is again. Calls nobody joined yet aren't idle.
+// The session lock must be held.
+func (cm *CallManager) watchIdle(session *CallSession) {
+	if cm.cfg.IdleTimeout <= 0 || session.terminated {
+		return
+	}
+	if session.hasConnected() {
+		if session.idleTimer != nil {
+			session.idleTimer.Stop()
+			session.idleTimer = nil
+		}
+		return
+	}
+	if session.idleTimer != nil {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(cm.cfg.IdleTimeout, func() {
+		session.mu.Lock()
+		// Stopped or replaced while this fired
+		if session.idleTimer != timer {
+			session.mu.Unlock()
+			return
+		}
+		session.idleTimer = nil
+		idle := !session.terminated && !session.hasConnected()
+		session.mu.Unlock()
+
+		if idle {
+			log.Printf("Terminating call %s, nobody was connected for %s\n", session.ID, cm.cfg.IdleTimeout)
+			cm.TerminateSession(session.ID, TerminationIdle)
+		}
+	})
+	session.idleTimer = timer
+}
@@ -0,0 +1,154 @@
+package call
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// Invitation states
+const (
+	InvitationRinging  = "ringing"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	// InvitationMissed marks an invitation that rang out unanswered
+	InvitationMissed = "missed"
+)
+
+// Invitation rings a user to join a call
+type Invitation struct {
+	SessionID string     `json:"sessionId"`
+	CallerID  string     `json:"callerId"`
+	InviteeID string     `json:"inviteeId"`
+	Type      CallType   `json:"type"`
+	Status    string     `json:"status"`
+	CreatedAt utils.Time `json:"createdAt"`
+	ExpiresAt utils.Time `json:"expiresAt"`
+}
+
+// Invite rings a user on behalf of a participant of the call until they
+// answer or the ring timeout passes
+func (cm *CallManager) Invite(sessionID, callerID, inviteeID string) (*Invitation, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if caller, exists := session.Participants[callerID]; !exists || caller.Status == StatusLeft {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only participants can invite to the call")
+	}
+	if inviteeID == callerID {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "participants can't invite themselves")
+	}
+	if existing, exists := session.Participants[inviteeID]; exists && existing.Status != StatusLeft {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "already a participant")
+	}
+	if ringing, exists := session.invitations[inviteeID]; exists && ringing.Status == InvitationRinging {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "invitee is already being rung")
+	}
+	if errResp := session.checkLocked(inviteeID); errResp != nil {
+		return nil, errResp
+	}
+
+	now := utils.GetTimestamp()
+	invitation := &Invitation{
+		SessionID: sessionID,
+		CallerID:  callerID,
+		InviteeID: inviteeID,
+		Type:      session.Type,
+		Status:    InvitationRinging,
+		CreatedAt: utils.NewTime(now),
+		ExpiresAt: utils.NewTime(now.Add(cm.cfg.RingTimeout)),
+	}
+	if session.invitations == nil {
+		session.invitations = make(map[string]*Invitation)
+	}
+	session.invitations[inviteeID] = invitation
+	time.AfterFunc(cm.cfg.RingTimeout, func() { cm.ringOut(session, invitation) })
+
+	ringing := *invitation
+	return &ringing, nil
+}
+
+// AnswerInvitation accepts or declines the invitation ringing a user. An
+// accepted invitation provisions the invitee, who then joins without the
+// lobby.
+func (cm *CallManager) AnswerInvitation(sessionID, inviteeID string, accepted bool) (*Invitation, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	invitation, exists := session.invitations[inviteeID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "invitation not found")
+	}
+	if invitation.Status != InvitationRinging {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "invitation is no longer ringing")
+	}
+
+	status := InvitationDeclined
+	if accepted {
+		if errResp := session.checkLocked(inviteeID); errResp != nil {
+			return nil, errResp
+		}
+		if session.isFull(inviteeID) {
+			return nil, utils.NewErrorResponse(http.StatusConflict, "call is full")
+		}
+		if existing, exists := session.Participants[inviteeID]; !exists || existing.Status == StatusLeft {
+			session.provision(inviteeID)
+		}
+		status = InvitationAccepted
+	}
+	invitation.Status = status
+
+	answered := *invitation
+	return &answered, nil
+}
+
+// ringOut records an invitation that is still ringing when the ring timeout
+// passes as a missed call
+func (cm *CallManager) ringOut(session *CallSession, invitation *Invitation) {
+	session.mu.Lock()
+	if invitation.Status != InvitationRinging {
+		session.mu.Unlock()
+		return
+	}
+	invitation.Status = InvitationMissed
+	missed := *invitation
+	session.mu.Unlock()
+
+	cm.historyMu.Lock()
+	cm.missed = append(cm.missed, missed)
+	cm.historyMu.Unlock()
+
+	if cm.OnInvitation != nil {
+		cm.OnInvitation(&missed)
+	}
+}
+
+// MissedCallsOf returns the invitations a user didn't answer, newest first
+func (cm *CallManager) MissedCallsOf(userID string) []Invitation {
+	missed := []Invitation{}
+
+	cm.historyMu.Lock()
+	for _, invitation := range cm.missed {
+		if invitation.InviteeID == userID {
+			missed = append(missed, invitation)
+		}
+	}
+	cm.historyMu.Unlock()
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].CreatedAt.After(missed[j].CreatedAt.Time)
+	})
+	return missed
+}
@@ -0,0 +1,94 @@
+package call
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// JoinCodeInfo is returned when a join code is resolved
+type JoinCodeInfo struct {
+	SessionID string     `json:"sessionId"`
+	Type      CallType   `json:"type"`
+	URL       string     `json:"url"`
+	ExpiresAt utils.Time `json:"expiresAt"`
+}
+
+// assignJoinCode gives the session a fresh join code, replacing any previous
+// one. The manager lock must be held.
+func (cm *CallManager) assignJoinCode(session *CallSession, expiresAt time.Time) {
+	delete(cm.joinCodes, session.JoinCode)
+
+	code := utils.GenerateJoinCode()
+	for _, taken := cm.joinCodes[code]; taken; _, taken = cm.joinCodes[code] {
+		code = utils.GenerateJoinCode()
+	}
+
+	cm.joinCodes[code] = session.ID
+	session.JoinCode = code
+	session.JoinCodeExpiry = utils.NewTime(expiresAt)
+	session.URL = "/call/resolve/" + code
+}
+
+// ResolveJoinCode maps a join code to its call session
+func (cm *CallManager) ResolveJoinCode(code string) (*JoinCodeInfo, *utils.ErrorResponse) {
+	cm.mu.RLock()
+	sessionID, exists := cm.joinCodes[code]
+	cm.mu.RUnlock()
+	session, active := cm.sessions.Load(sessionID)
+
+	if !exists || !active {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "join code not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	if time.Now().After(session.JoinCodeExpiry.Time) {
+		return nil, utils.NewErrorResponse(http.StatusGone, "join code expired")
+	}
+
+	return &JoinCodeInfo{
+		SessionID: session.ID,
+		Type:      session.Type,
+		URL:       session.URL,
+		ExpiresAt: session.JoinCodeExpiry,
+	}, nil
+}
+
+// RegenerateJoinCode replaces the session's join code. Only the host may do
+// this. A zero ttl keeps the code valid until the session ends.
+func (cm *CallManager) RegenerateJoinCode(sessionID, hostID string, ttl time.Duration) (*JoinCodeInfo, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if session.CreatorID != hostID {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only the host can regenerate the join code")
+	}
+
+	expiresAt := session.EndTime
+	if ttl > 0 && time.Now().Add(ttl).Before(expiresAt.Time) {
+		expiresAt = utils.NewTime(time.Now().Add(ttl))
+	}
+	previousExpiry := session.JoinCodeExpiry
+	cm.assignJoinCode(session, expiresAt.Time)
+	cm.Audit.Record(hostID, audit.CallJoinCodeRotate, sessionID, "",
+		map[string]utils.Time{"expiresAt": previousExpiry},
+		map[string]utils.Time{"expiresAt": session.JoinCodeExpiry})
+
+	return &JoinCodeInfo{
+		SessionID: session.ID,
+		Type:      session.Type,
+		URL:       session.URL,
+		ExpiresAt: session.JoinCodeExpiry,
+	}, nil
+}
@@ -0,0 +1,112 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// How an approved knock lets the requester in
+const (
+	KnockAdmitLobby = "lobby"
+	KnockAdmitJoin  = "join"
+)
+
+// Knock is a request from an uninvited user to join a call
+type Knock struct {
+	SessionID   string             `json:"sessionId"`
+	RequesterID string             `json:"requesterId"`
+	Profile     ParticipantProfile `json:"profile"`
+	KnockedAt   utils.Time         `json:"knockedAt"`
+}
+
+// Knock records a join request and returns it along with the hosts and
+// co-hosts that should be prompted
+func (cm *CallManager) Knock(sessionID, requesterID string, profile ParticipantProfile) (*Knock, []string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if requesterID == "" {
+		return nil, nil, utils.NewErrorResponse(http.StatusBadRequest, "requester is required")
+	}
+	if _, exists := session.Participants[requesterID]; exists {
+		return nil, nil, utils.NewErrorResponse(http.StatusConflict, "already a participant")
+	}
+	if session.isInLobby(requesterID) {
+		return nil, nil, utils.NewErrorResponse(http.StatusConflict, "already waiting in the lobby")
+	}
+	if errResp := session.checkLocked(requesterID); errResp != nil {
+		return nil, nil, errResp
+	}
+
+	knock := &Knock{
+		SessionID:   sessionID,
+		RequesterID: requesterID,
+		Profile:     profile,
+		KnockedAt:   utils.Now(),
+	}
+	if session.knocks == nil {
+		session.knocks = make(map[string]*Knock)
+	}
+	session.knocks[requesterID] = knock
+
+	var moderators []string
+	for id := range session.Participants {
+		if session.canModerate(id) {
+			moderators = append(moderators, id)
+		}
+	}
+
+	return knock, moderators, nil
+}
+
+// AnswerKnock approves or denies a knock. An approved knock either places
+// the requester in the lobby or admits them so they can join directly.
+func (cm *CallManager) AnswerKnock(sessionID, actorID, requesterID string, approved bool, mode string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can answer knocks")
+	}
+	if _, exists := session.knocks[requesterID]; !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "knock not found")
+	}
+	if approved && mode != KnockAdmitLobby && mode != KnockAdmitJoin {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid admission mode")
+	}
+	if approved {
+		if errResp := session.checkLocked(requesterID); errResp != nil {
+			return errResp
+		}
+	}
+
+	delete(session.knocks, requesterID)
+	if approved {
+		session.InLobby = append(session.InLobby, requesterID)
+		if mode == KnockAdmitJoin {
+			if session.admitted == nil {
+				session.admitted = make(map[string]bool)
+			}
+			session.admitted[requesterID] = true
+		}
+	}
+
+	cm.Audit.Record(actorID, audit.CallKnockAnswer, sessionID, requesterID, nil, map[string]interface{}{
+		"approved": approved,
+		"mode":     mode,
+	})
+
+	return nil
+}
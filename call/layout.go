@@ -0,0 +1,94 @@
+package call
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// Layout arranges the participants of a composite recording or livestream
+type Layout string
+
+const (
+	// LayoutGrid tiles every video publisher equally
+	LayoutGrid Layout = "grid"
+	// LayoutSpotlight shows the active speaker full size
+	LayoutSpotlight Layout = "spotlight"
+	// LayoutPresenter shows the presenter large above a filmstrip of the
+	// other publishers
+	LayoutPresenter Layout = "presenter"
+)
+
+// LayoutChange is a layout taking effect at a point in time
+type LayoutChange struct {
+	Layout Layout `json:"layout"`
+	// PresenterID is the featured participant of the presenter layout
+	PresenterID string     `json:"presenterId,omitempty"`
+	At          utils.Time `json:"at"`
+}
+
+// SetLayout switches the layout of a call on behalf of a host or co-host
+// and returns the participants in the call, who should be told. The
+// presenter layout needs the participant to feature.
+func (cm *CallManager) SetLayout(sessionID, actorID string, layout Layout, presenterID string) (*LayoutChange, []string, *utils.ErrorResponse) {
+	switch layout {
+	case LayoutGrid, LayoutSpotlight, LayoutPresenter:
+	default:
+		return nil, nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid layout")
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return nil, nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can change the layout")
+	}
+
+	if layout != LayoutPresenter {
+		presenterID = ""
+	} else if presenterID == "" {
+		return nil, nil, utils.NewErrorResponse(http.StatusBadRequest, "the presenter layout needs a presenter")
+	} else if _, exists := session.Participants[presenterID]; !exists {
+		return nil, nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	previous := session.Layout
+	change := LayoutChange{Layout: layout, PresenterID: presenterID, At: utils.Now()}
+	session.Layout = change
+	session.layouts = append(session.layouts, change)
+	cm.Audit.Record(actorID, audit.CallLayout, sessionID, presenterID, previous, change)
+	cm.persist(session)
+
+	var participants []string
+	for id, participant := range session.Participants {
+		if participant.Status == StatusConnected || participant.Status == StatusReconnecting {
+			participants = append(participants, id)
+		}
+	}
+
+	return &change, participants, nil
+}
+
+// layoutsBetween returns the layout in effect at from followed by the
+// changes up to to
+func layoutsBetween(layouts []LayoutChange, from, to time.Time) []LayoutChange {
+	var window []LayoutChange
+	for _, change := range layouts {
+		if change.At.After(to) {
+			break
+		}
+		if !change.At.After(from) {
+			window = append(window[:0], change)
+			continue
+		}
+		window = append(window, change)
+	}
+	return window
+}
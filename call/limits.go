@@ -0,0 +1,32 @@
+package call
+
+// JoinInfo tells a participant how they joined a call
+type JoinInfo struct {
+	ParticipantID string   `json:"participantId"`
+	Role          CallRole `json:"role"`
+	// Audience is set when the call was full and the participant joined
+	// view-only: they receive media but can't publish
+	Audience bool `json:"audience"`
+	// Layout is the layout in effect, later changes are sent over signaling
+	Layout LayoutChange `json:"layout"`
+}
+
+// isFull reports whether a participant can't take a publishing slot. A
+// participant that rejoins keeps their slot unless they already left. The
+// session lock must be held.
+func (s *CallSession) isFull(participantID string) bool {
+	if s.MaxParticipants <= 0 {
+		return false
+	}
+	if existing, exists := s.Participants[participantID]; exists && !existing.IsAudience && existing.Status != StatusLeft {
+		return false
+	}
+
+	count := 0
+	for _, participant := range s.Participants {
+		if !participant.IsAudience && participant.Status != StatusLeft {
+			count++
+		}
+	}
+	return count >= s.MaxParticipants
+}
@@ -0,0 +1,238 @@
+package call
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// MaxLoadTestParticipants caps the synthetic participants of a load test
+const MaxLoadTestParticipants = 100
+
+// LoadTest is a set of synthetic participants publishing generated media
+// into a call, used to measure the capacity of the SFU and signaling paths
+type LoadTest struct {
+	ID             string     `json:"id"`
+	SessionID      string     `json:"sessionId"`
+	ParticipantIDs []string   `json:"participantIds"`
+	Video          bool       `json:"video"`
+	StartedAt      utils.Time `json:"startedAt"`
+	ExpiresAt      utils.Time `json:"expiresAt"`
+
+	connections []*webrtc.PeerConnection // client side of each synthetic participant
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// StartLoadTest joins count synthetic participants to a call. Each publishes
+// a G.711 tone and, when video is set, an H.264 test pattern, and consumes
+// the media forwarded to it. The participants leave after duration.
+func (cm *CallManager) StartLoadTest(sessionID, passcode string, count int, video bool, duration time.Duration) (*LoadTest, *utils.ErrorResponse) {
+	if count < 1 || count > MaxLoadTestParticipants {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("participants must be between 1 and %d", MaxLoadTestParticipants))
+	}
+	if duration <= 0 {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "duration must be positive")
+	}
+
+	now := time.Now()
+	test := &LoadTest{
+		ID:        utils.NewID(utils.PrefixLoadTest),
+		SessionID: sessionID,
+		Video:     video,
+		StartedAt: utils.NewTime(now),
+		ExpiresAt: utils.NewTime(now.Add(duration)),
+		stop:      make(chan struct{}),
+	}
+
+	for i := 1; i <= count; i++ {
+		participantID := fmt.Sprintf("synthetic-%s-%d", test.ID[:8], i)
+		profile := ParticipantProfile{
+			DisplayName: fmt.Sprintf("Synthetic %d", i),
+			Metadata:    map[string]interface{}{"loadTestId": test.ID},
+		}
+		if _, errResp := cm.JoinCall(sessionID, participantID, passcode, profile); errResp != nil {
+			cm.endLoadTest(test)
+			return nil, errResp
+		}
+		test.ParticipantIDs = append(test.ParticipantIDs, participantID)
+
+		pc, errResp := cm.connectSynthetic(sessionID, participantID, video, test.stop)
+		if errResp != nil {
+			cm.endLoadTest(test)
+			return nil, errResp
+		}
+		test.connections = append(test.connections, pc)
+	}
+
+	cm.mu.Lock()
+	cm.loadTests[test.ID] = test
+	cm.mu.Unlock()
+
+	time.AfterFunc(duration, func() {
+		cm.StopLoadTest(test.ID)
+	})
+
+	return test, nil
+}
+
+// StopLoadTest makes the synthetic participants of a load test leave
+func (cm *CallManager) StopLoadTest(testID string) *utils.ErrorResponse {
+	cm.mu.Lock()
+	test, exists := cm.loadTests[testID]
+	delete(cm.loadTests, testID)
+	cm.mu.Unlock()
+
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "load test not found")
+	}
+
+	cm.endLoadTest(test)
+	return nil
+}
+
+// endLoadTest stops the generated media and releases the slots of the
+// synthetic participants
+func (cm *CallManager) endLoadTest(test *LoadTest) {
+	test.stopOnce.Do(func() {
+		close(test.stop)
+	})
+	for _, pc := range test.connections {
+		pc.Close()
+	}
+
+	session, exists := cm.sessions.Load(test.SessionID)
+	if !exists {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, participantID := range test.ParticipantIDs {
+		if participant, exists := session.Participants[participantID]; exists {
+			session.release(participant)
+			cm.publishLeft(session.ID, participantID, "removed")
+		}
+	}
+	cm.watchIdle(session)
+}
+
+// connectSynthetic negotiates the client side of a synthetic participant
+// that has joined the call and starts publishing generated media
+func (cm *CallManager) connectSynthetic(sessionID, participantID string, video bool, stop <-chan struct{}) (*webrtc.PeerConnection, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	pc, err := session.api.NewPeerConnection(cm.factory.Configuration())
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create peer connection")
+	}
+
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU}, "audio", participantID)
+	if err == nil {
+		err = addSyntheticTrack(pc, audio)
+	}
+	var pattern *webrtc.TrackLocalStaticSample
+	if err == nil && video {
+		pattern, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", participantID)
+		if err == nil {
+			err = addSyntheticTrack(pc, pattern)
+		}
+	}
+	if err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to add synthetic track")
+	}
+
+	// Forwarded media is read and discarded, as a real subscriber would
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		bufPtr := bufferPool.Get().(*[]byte)
+		defer bufferPool.Put(bufPtr)
+		for {
+			if _, _, err := track.Read(*bufPtr); err != nil {
+				return
+			}
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create offer")
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set local description")
+	}
+	<-gatherComplete
+
+	answer, errResp := cm.HandleOffer(sessionID, participantID, *pc.LocalDescription())
+	if errResp != nil {
+		pc.Close()
+		return nil, errResp
+	}
+	if err := pc.SetRemoteDescription(*answer); err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set remote description")
+	}
+
+	tone := &toneGenerator{}
+	go publishSynthetic(audio, toneFrameLength, tone.next, stop)
+	if pattern != nil {
+		frames := &testPattern{}
+		go publishSynthetic(pattern, time.Second/patternFrameRate, frames.next, stop)
+	}
+
+	return pc, nil
+}
+
+// addSyntheticTrack adds a track and reads its RTCP so interceptors such as
+// the NACK responder see the feedback
+func addSyntheticTrack(pc *webrtc.PeerConnection, track webrtc.TrackLocal) error {
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		bufPtr := bufferPool.Get().(*[]byte)
+		defer bufferPool.Put(bufPtr)
+		for {
+			if _, _, err := sender.Read(*bufPtr); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// publishSynthetic writes a generated sample every interval until stopped
+func publishSynthetic(track *webrtc.TrackLocalStaticSample, interval time.Duration, next func() []byte, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := track.WriteSample(media.Sample{Data: next(), Duration: interval})
+			if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				log.Println("Error writing synthetic sample:", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// checkLocked rejects participants that aren't already part of a locked
+// call. The session lock must be held.
+func (s *CallSession) checkLocked(participantID string) *utils.ErrorResponse {
+	if !s.IsLocked {
+		return nil
+	}
+	// Provisioned participants haven't joined yet
+	if existing, exists := s.Participants[participantID]; exists && existing.Status != StatusWaiting {
+		return nil
+	}
+	return utils.NewErrorResponse(http.StatusLocked, "call session is locked")
+}
+
+// SetLocked locks or unlocks the membership of a call on behalf of a host
+// or co-host. Participants already in the call are unaffected.
+func (cm *CallManager) SetLocked(sessionID, actorID string, locked bool) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can lock the call")
+	}
+
+	previous := session.IsLocked
+	session.IsLocked = locked
+	cm.Audit.Record(actorID, audit.CallLock, sessionID, "", previous, locked)
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package call
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/mixer"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// mixDir is the subdirectory of a call's recordings holding its mixes
+const mixDir = "mix"
+
+// Mix states
+const (
+	MixPending  = "pending"
+	MixComplete = "complete"
+	MixFailed   = "failed"
+)
+
+// MixInput is a participant's audio recording placed on the mix timeline
+type MixInput struct {
+	ParticipantID string  `json:"participantId"`
+	Path          string  `json:"path"`
+	OffsetMs      int64   `json:"offsetMs"`
+	Gain          float64 `json:"gain"`
+}
+
+// MixManifest describes the mix of a call's audio recordings. It is written
+// beside the mixed file and updated once mixing is done.
+type MixManifest struct {
+	SessionID string     `json:"sessionId"`
+	Path      string     `json:"path"`
+	Status    string     `json:"status"`
+	CreatedAt utils.Time `json:"createdAt"`
+	Inputs    []MixInput `json:"inputs"`
+	// Layouts is the composition layout timeline of the mixed recordings
+	Layouts []LayoutChange `json:"layouts,omitempty"`
+}
+
+// SetGain sets the level a participant's audio is mixed at on behalf of a
+// host or co-host. 1 keeps the recorded level and 0 silences the participant.
+func (cm *CallManager) SetGain(sessionID, actorID, participantID string, gain float64) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can set the gain")
+	}
+	if session.E2EE {
+		return errUnavailableEncrypted("mixing")
+	}
+	if _, exists := session.Participants[participantID]; !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	previous := session.gain(participantID)
+	if session.Gains == nil {
+		session.Gains = make(map[string]float64)
+	}
+	session.Gains[participantID] = gain
+	cm.Audit.Record(actorID, audit.CallGain, sessionID, participantID, previous, gain)
+
+	return nil
+}
+
+// gain returns the level a participant is mixed at. The session lock must
+// be held.
+func (s *CallSession) gain(participantID string) float64 {
+	if gain, ok := s.Gains[participantID]; ok {
+		return gain
+	}
+	return 1
+}
+
+// MixRecordings mixes the finished audio recordings of a call into a single
+// Ogg/Opus file on behalf of a host or co-host, aligning them by their start
+// time. Mixing runs in the background, the returned manifest is pending.
+func (cm *CallManager) MixRecordings(sessionID, actorID string) (*MixManifest, *utils.ErrorResponse) {
+	if cm.Mixer == nil {
+		return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "audio mixing is disabled")
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	if !session.canModerate(actorID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can mix recordings")
+	}
+	if session.E2EE {
+		return nil, errUnavailableEncrypted("mixing")
+	}
+
+	dir := filepath.Join(cm.recordingDir, sessionID)
+	recordings, err := readManifests(dir)
+	if err != nil {
+		log.Printf("Error reading recordings of %s: %v\n", sessionID, err)
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read recordings")
+	}
+
+	manifest := &MixManifest{SessionID: sessionID, Status: MixPending, CreatedAt: utils.Now()}
+	var start time.Time
+	for _, recording := range recordings {
+		if start.IsZero() || recording.StartedAt.Before(start) {
+			start = recording.StartedAt.Time
+		}
+	}
+	for _, recording := range recordings {
+		for _, file := range recording.Files {
+			if !strings.EqualFold(file.Codec, webrtc.MimeTypeOpus) {
+				continue
+			}
+			manifest.Inputs = append(manifest.Inputs, MixInput{
+				ParticipantID: recording.ParticipantID,
+				Path:          file.Path,
+				OffsetMs:      recording.StartedAt.Sub(start).Milliseconds(),
+				Gain:          session.gain(recording.ParticipantID),
+			})
+		}
+	}
+	if len(manifest.Inputs) == 0 {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "no finished audio recordings to mix")
+	}
+	manifest.Layouts = layoutsBetween(session.layouts, start, manifest.CreatedAt.Time)
+
+	if err := os.MkdirAll(filepath.Join(dir, mixDir), 0755); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create mix")
+	}
+	base := filepath.Join(dir, mixDir, fmt.Sprintf("%d", manifest.CreatedAt.UnixNano()))
+	manifest.Path = base + ".ogg"
+	if err := writeManifest(base+".json", manifest); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create mix")
+	}
+
+	background := *manifest
+	background.Inputs = slices.Clone(manifest.Inputs)
+	go cm.mix(base+".json", &background)
+
+	cm.Audit.Record(actorID, audit.CallRecordingMix, sessionID, "", nil, manifest)
+
+	return manifest, nil
+}
+
+// mix runs the mixer and records the outcome in the manifest
+func (cm *CallManager) mix(manifestPath string, manifest *MixManifest) {
+	inputs := make([]mixer.Input, len(manifest.Inputs))
+	for i, input := range manifest.Inputs {
+		inputs[i] = mixer.Input{
+			Path:   input.Path,
+			Offset: time.Duration(input.OffsetMs) * time.Millisecond,
+			Gain:   input.Gain,
+		}
+	}
+
+	manifest.Status = MixComplete
+	if err := cm.Mixer.Mix(context.Background(), inputs, manifest.Path); err != nil {
+		log.Printf("Error mixing recordings of %s: %v\n", manifest.SessionID, err)
+		manifest.Status = MixFailed
+	}
+	if err := writeManifest(manifestPath, manifest); err != nil {
+		log.Printf("Error updating mix manifest %s: %v\n", manifestPath, err)
+	}
+}
+
+// readManifests returns the manifests of the stopped recordings in dir
+func readManifests(dir string) ([]RecordingManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []RecordingManifest
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var manifest RecordingManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
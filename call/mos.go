@@ -0,0 +1,48 @@
+package call
+
+import "math"
+
+// EstimateMOS approximates the Mean Opinion Score of a stream from its
+// packet loss, jitter and round trip time with a simplified ITU-T G.107
+// E-model. The result ranges from 1 (bad) to 4.5 (excellent).
+func EstimateMOS(lossPercent, jitterMs, rttMs float64) float64 {
+	// Jitter buffers turn jitter into delay, weigh it double
+	effectiveLatency := rttMs/2 + 2*jitterMs + 10
+
+	r := 93.2
+	if effectiveLatency < 160 {
+		r -= effectiveLatency / 40
+	} else {
+		r -= (effectiveLatency - 120) / 10
+	}
+	r -= 2.5 * lossPercent
+	if r < 0 {
+		r = 0
+	}
+
+	mos := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+	mos = math.Max(1, math.Min(4.5, mos))
+	return math.Round(mos*100) / 100
+}
+
+// mosAccumulator aggregates the MOS samples taken over a call
+type mosAccumulator struct {
+	sum     float64
+	min     float64
+	samples int
+}
+
+func (a *mosAccumulator) add(mos float64) {
+	if a.samples == 0 || mos < a.min {
+		a.min = mos
+	}
+	a.sum += mos
+	a.samples++
+}
+
+func (a *mosAccumulator) average() float64 {
+	if a.samples == 0 {
+		return 0
+	}
+	return math.Round(a.sum/float64(a.samples)*100) / 100
+}
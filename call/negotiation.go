@@ -0,0 +1,119 @@
+package call
+
+import (
+	"log"
+	"net/http"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Renegotiation carries an offer of the server to a participant whose
+// subscriptions changed, e.g. because another participant started or
+// stopped publishing. The participant answers it with HandleAnswer.
+type Renegotiation struct {
+	SessionID string                    `json:"sessionId"`
+	Offer     webrtc.SessionDescription `json:"offer"`
+}
+
+// renegotiate offers the current tracks of a participant's peer connection
+// to them in the background, once any negotiation in progress completed.
+// The session lock may be held.
+func (cm *CallManager) renegotiate(participant *CallParticipant, pc *webrtc.PeerConnection) {
+	if cm.OnRenegotiate == nil || pc == nil {
+		return
+	}
+	go cm.offer(participant, pc)
+}
+
+// offer sends a server offer to a participant. While the participant's
+// first offer or a server offer is still unanswered, it's deferred until
+// that negotiation completes.
+func (cm *CallManager) offer(participant *CallParticipant, pc *webrtc.PeerConnection) {
+	participant.negotiation.Lock()
+	defer participant.negotiation.Unlock()
+
+	if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+	if pc.CurrentRemoteDescription() == nil || pc.SignalingState() != webrtc.SignalingStateStable {
+		participant.needsOffer = true
+		return
+	}
+	participant.needsOffer = false
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		log.Printf("Error creating offer for %s: %v\n", participant.ID, err)
+		return
+	}
+	// Wait for candidate gathering so the offer is usable without trickle ICE
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		log.Printf("Error setting offer for %s: %v\n", participant.ID, err)
+		return
+	}
+	<-gatherComplete
+
+	cm.OnRenegotiate(participant.ID, Renegotiation{
+		SessionID: participant.currentSession().ID,
+		Offer:     *pc.LocalDescription(),
+	})
+}
+
+// negotiated offers again what changed while a negotiation was in progress.
+// The negotiation lock of the participant must be held.
+func (cm *CallManager) negotiated(participant *CallParticipant, pc *webrtc.PeerConnection) {
+	if participant.needsOffer {
+		cm.renegotiate(participant, pc)
+	}
+}
+
+// unnegotiated reports whether a peer connection sends a track that no
+// negotiation covered yet
+func unnegotiated(pc *webrtc.PeerConnection) bool {
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Mid() == "" && transceiver.Sender() != nil && transceiver.Sender().Track() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleAnswer applies a participant's answer to the server offer sent
+// with a Renegotiation
+func (cm *CallManager) HandleAnswer(sessionID, participantID string, answer webrtc.SessionDescription) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.RLock()
+	participant, exists := session.Participants[participantID]
+	var pc *webrtc.PeerConnection
+	if exists {
+		pc = participant.PeerConnection
+	}
+	session.mu.RUnlock()
+
+	if pc == nil {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant has not joined the call")
+	}
+
+	participant.negotiation.Lock()
+	defer participant.negotiation.Unlock()
+
+	if answer.Type != webrtc.SDPTypeAnswer {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid answer")
+	}
+	if pc.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		return utils.NewErrorResponse(http.StatusConflict, "no offer awaiting an answer")
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		return utils.NewErrorResponse(http.StatusBadRequest, "failed to set remote description")
+	}
+	cm.negotiated(participant, pc)
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package call
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxPasscodeAttempts = 5
+	passcodeLockout     = 5 * time.Minute
+)
+
+// passcodeAttempts tracks failed passcode entries of a single participant
+type passcodeAttempts struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// setPasscode stores the bcrypt hash of the passcode, an empty passcode
+// removes the protection. The session lock must be held.
+func (s *CallSession) setPasscode(passcode string) error {
+	if passcode == "" {
+		s.passcodeHash = nil
+		s.HasPasscode = false
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.passcodeHash = hash
+	s.HasPasscode = true
+	s.passcodeFailures = make(map[string]*passcodeAttempts)
+	return nil
+}
+
+// verifyPasscode checks a participant's passcode, locking them out after
+// repeated failures. The session lock must be held.
+func (s *CallSession) verifyPasscode(participantID, passcode string) *utils.ErrorResponse {
+	if !s.HasPasscode || participantID == s.CreatorID {
+		return nil
+	}
+
+	attempts, exists := s.passcodeFailures[participantID]
+	if !exists {
+		attempts = &passcodeAttempts{}
+		s.passcodeFailures[participantID] = attempts
+	}
+
+	if time.Now().Before(attempts.lockedUntil) {
+		return utils.NewErrorResponse(http.StatusTooManyRequests, "too many failed passcode attempts, try again later")
+	}
+
+	if bcrypt.CompareHashAndPassword(s.passcodeHash, []byte(passcode)) != nil {
+		attempts.failures++
+		if attempts.failures >= maxPasscodeAttempts {
+			attempts.failures = 0
+			attempts.lockedUntil = time.Now().Add(passcodeLockout)
+		}
+		return utils.NewErrorResponse(http.StatusForbidden, "invalid passcode")
+	}
+
+	delete(s.passcodeFailures, participantID)
+	return nil
+}
+
+// RotatePasscode replaces the passcode of a call. Only the host may do this
+// and an empty passcode removes the protection.
+func (cm *CallManager) RotatePasscode(sessionID, hostID, passcode string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.CreatorID != hostID {
+		return utils.NewErrorResponse(http.StatusForbidden, "only the host can change the passcode")
+	}
+
+	hadPasscode := session.HasPasscode
+	if err := session.setPasscode(passcode); err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to set passcode")
+	}
+
+	// Only record whether a passcode is set, never the passcode itself
+	cm.Audit.Record(hostID, audit.CallPasscodeRotate, sessionID, "",
+		map[string]bool{"hasPasscode": hadPasscode},
+		map[string]bool{"hasPasscode": session.HasPasscode})
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package call
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// rtpBufferSize fits any RTP packet received on a standard MTU
+const rtpBufferSize = 1500
+
+// bufferPool recycles the read buffers of forwarded and consumed tracks, so
+// participants joining and leaving don't churn 1500 byte allocations
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, rtpBufferSize)
+		return &buf
+	},
+}
+
+// packetPool recycles the packets parsed for recording
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		return &rtp.Packet{}
+	},
+}
+
+// getPacket returns an empty packet from the pool
+func getPacket() *rtp.Packet {
+	return packetPool.Get().(*rtp.Packet)
+}
+
+// putPacket returns a packet to the pool. The CSRC and extension slices are
+// kept so the next unmarshal reuses their capacity, the payload is dropped
+// as it points into the read buffer.
+func putPacket(packet *rtp.Packet) {
+	*packet = rtp.Packet{Header: rtp.Header{
+		CSRC:       packet.CSRC[:0],
+		Extensions: packet.Extensions[:0],
+	}}
+	packetPool.Put(packet)
+}
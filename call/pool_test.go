@@ -0,0 +1,120 @@
+package call
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// benchmarkPacket returns a marshalled video packet of a typical size, with
+// a header extension like the ones browsers send
+func benchmarkPacket(b *testing.B) []byte {
+	b.Helper()
+
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 1,
+			Timestamp:      90000,
+			SSRC:           1234,
+		},
+		Payload: make([]byte, 1100),
+	}
+	if err := packet.SetExtension(1, []byte{0x30}); err != nil {
+		b.Fatal(err)
+	}
+	raw, err := packet.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return raw
+}
+
+// BenchmarkForward measures the work forward does per packet once it has
+// been read: parsing the header for the stats and writing it to the local
+// track the subscribers are bound to
+func BenchmarkForward(b *testing.B) {
+	raw := benchmarkPacket(b)
+	local, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "publisher")
+	if err != nil {
+		b.Fatal(err)
+	}
+	track := &publishedTrack{local: local, stats: &streamStats{clockRate: 90000}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	bufPtr := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufPtr)
+	buf := *bufPtr
+	var header rtp.Header
+	for i := 0; i < b.N; i++ {
+		n := copy(buf, raw)
+		if _, err := header.Unmarshal(buf[:n]); err == nil {
+			track.stats.update(&header, n, time.Now())
+		}
+		if _, err := track.local.Write(buf[:n]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadBuffer compares the read buffers taken from bufferPool with
+// allocating one per track, as done before pooling
+func BenchmarkReadBuffer(b *testing.B) {
+	raw := benchmarkPacket(b)
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bufPtr := bufferPool.Get().(*[]byte)
+			copy(*bufPtr, raw)
+			bufferPool.Put(bufPtr)
+		}
+	})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := make([]byte, rtpBufferSize)
+			copy(buf, raw)
+			bufferSink = buf
+		}
+	})
+}
+
+// BenchmarkPacket compares parsing the packets written to recordings and
+// snapshots into pooled packets with allocating one per packet
+func BenchmarkPacket(b *testing.B) {
+	raw := benchmarkPacket(b)
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			packet := getPacket()
+			if err := packet.Unmarshal(raw); err != nil {
+				b.Fatal(err)
+			}
+			putPacket(packet)
+		}
+	})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			packet := &rtp.Packet{}
+			if err := packet.Unmarshal(raw); err != nil {
+				b.Fatal(err)
+			}
+			packetSink = packet
+		}
+	})
+}
+
+// The sinks keep the allocations of the unpooled benchmarks from being
+// optimized away
+var (
+	bufferSink []byte
+	packetSink *rtp.Packet
+)
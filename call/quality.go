@@ -0,0 +1,65 @@
+package call
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// rembInterval is how often the bitrate cap is re-announced to publishers
+const rembInterval = time.Second
+
+// QualityPreset describes the media constraints applied for a CallQuality.
+// Resolution and framerate are hints for clients to apply to their senders,
+// the bitrate is enforced by the server through REMB feedback.
+type QualityPreset struct {
+	Quality      CallQuality `json:"quality"`
+	MaxBitrate   uint64      `json:"maxBitrate"` // bits per second
+	Width        int         `json:"width"`
+	Height       int         `json:"height"`
+	MaxFramerate int         `json:"maxFramerate"`
+}
+
+var qualityPresets = map[CallQuality]QualityPreset{
+	QualitySD: {Quality: QualitySD, MaxBitrate: 800_000, Width: 640, Height: 480, MaxFramerate: 24},
+	QualityHD: {Quality: QualityHD, MaxBitrate: 2_500_000, Width: 1280, Height: 720, MaxFramerate: 30},
+	Quality4K: {Quality: Quality4K, MaxBitrate: 15_000_000, Width: 3840, Height: 2160, MaxFramerate: 30},
+}
+
+// PresetFor returns the preset for a quality level
+func PresetFor(quality CallQuality) (QualityPreset, bool) {
+	preset, ok := qualityPresets[quality]
+	return preset, ok
+}
+
+// enforceBitrate periodically sends REMB feedback for an incoming video
+// track so the publisher stays under the participant's bitrate cap. It
+// returns once the peer connection can no longer be written to.
+func enforceBitrate(participant *CallParticipant, pc *webrtc.PeerConnection, track *webrtc.TrackRemote) {
+	ticker := time.NewTicker(rembInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		participant.mu.Lock()
+		bitrate := participant.Preset.MaxBitrate
+		audioOnly := participant.AudioOnly
+		session := participant.session
+		participant.mu.Unlock()
+
+		switch {
+		case audioOnly:
+			bitrate = audioOnlyBitrate
+		case session.yieldsToPinned(participant.ID):
+			bitrate = bitrate * unpinnedSharePercent / 100
+		}
+
+		err := pc.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{
+			Bitrate: float32(bitrate),
+			SSRCs:   []uint32{uint32(track.SSRC())},
+		}})
+		if err != nil {
+			return
+		}
+	}
+}
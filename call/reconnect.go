@@ -0,0 +1,109 @@
+package call
+
+import (
+	"log"
+	"time"
+
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// startReconnectGrace keeps the slot of a participant whose peer connection
+// failed, releasing it if they don't reattach within the grace period
+func (cm *CallManager) startReconnectGrace(session *CallSession, participant *CallParticipant, pc *webrtc.PeerConnection) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if participant.PeerConnection != pc || participant.Status != StatusConnected {
+		return
+	}
+
+	participant.Status = StatusReconnecting
+	participant.ReconnectDeadline = utils.NewTime(time.Now().Add(cm.cfg.ReconnectGrace))
+	log.Printf("Participant %s of call %s is reconnecting\n", participant.ID, session.ID)
+	cm.watchIdle(session)
+
+	time.AfterFunc(cm.cfg.ReconnectGrace, func() {
+		cm.expireReconnect(session, participant, pc)
+	})
+}
+
+// expireReconnect releases the slot of a participant that didn't reattach
+// in time
+func (cm *CallManager) expireReconnect(session *CallSession, participant *CallParticipant, pc *webrtc.PeerConnection) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if participant.PeerConnection != pc || participant.Status != StatusReconnecting {
+		return
+	}
+
+	session.release(participant)
+	if participant.ID == session.CreatorID {
+		cm.stopVoicemail(session, true)
+	}
+	cm.publishLeft(session.ID, participant.ID, "connection-lost")
+	log.Printf("Participant %s of call %s didn't reconnect in time\n", participant.ID, session.ID)
+	cm.watchIdle(session)
+}
+
+// publishLeft publishes that a participant left a call for the given reason
+func (cm *CallManager) publishLeft(sessionID, participantID, reason string) {
+	cm.Events.Publish(events.CallLeft, sessionID, map[string]interface{}{
+		"sessionId":     sessionID,
+		"participantId": participantID,
+		"reason":        reason,
+	})
+}
+
+// release marks a participant as having left and closes their peer
+// connection. The session lock must be held.
+func (s *CallSession) release(participant *CallParticipant) {
+	pc := participant.PeerConnection
+
+	participant.Status = StatusLeft
+	participant.LeftAt = utils.Now()
+	participant.PeerConnection = nil
+	participant.ReconnectDeadline = utils.Time{}
+	if s.PinnedID == participant.ID {
+		s.PinnedID = ""
+	}
+	if pc == nil {
+		return
+	}
+	for _, track := range s.tracks {
+		track.unsubscribe(participant.ID, pc)
+	}
+	pc.Close()
+}
+
+// reattach gives a reconnecting participant a new peer connection in their
+// existing slot, keeping their role, mute state and audience seat. The
+// session lock must be held.
+func (cm *CallManager) reattach(session *CallSession, participant *CallParticipant, profile ParticipantProfile) (*JoinInfo, *utils.ErrorResponse) {
+	// The subscriptions of the previous connection are dropped first, so the
+	// new one takes their place
+	previous := participant.PeerConnection
+	if previous != nil {
+		for _, track := range session.tracks {
+			track.unsubscribe(participant.ID, previous)
+		}
+	}
+	if errResp := cm.connect(session, participant); errResp != nil {
+		return nil, errResp
+	}
+
+	participant.Status = StatusConnected
+	participant.ReconnectDeadline = utils.Time{}
+	participant.applyProfile(profile)
+	if previous != nil {
+		previous.Close()
+	}
+	cm.resumeRecorder(session, participant)
+	cm.watchIdle(session)
+	cm.persist(session)
+
+	return &JoinInfo{ParticipantID: participant.ID, Role: participant.Role, Audience: participant.IsAudience, Layout: session.Layout}, nil
+}
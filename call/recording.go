@@ -1,65 +1,321 @@
 package call
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/denoise"
+	"pion-webrtc-microservice/utils"
+	"pion-webrtc-microservice/watermark"
 
 	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// RecordingFile describes a single track written by a MediaRecorder
+type RecordingFile struct {
+	Kind  string `json:"kind"`
+	Codec string `json:"codec"`
+	Path  string `json:"path"`
+	// NoiseSuppression is "pending", "applied" or "failed" for audio
+	// recorded with noise suppression
+	NoiseSuppression string `json:"noiseSuppression,omitempty"`
+	// Watermark is the same for video recorded with a watermark
+	Watermark string `json:"watermark,omitempty"`
+}
+
+// Post-processing states of a recorded file
+const (
+	ProcessingPending = "pending"
+	ProcessingApplied = "applied"
+	ProcessingFailed  = "failed"
 )
 
+// TenantMetadataKey is the call metadata entry naming the tenant whose logo
+// watermarks the call's recordings
+const TenantMetadataKey = "tenant"
+
+// PostProcessing holds the steps applied to a recording once it stops
+type PostProcessing struct {
+	// Denoiser suppresses the noise in the audio, nil leaves it as recorded
+	Denoiser *denoise.Denoiser
+	// Watermark burns Mark into the video, nil leaves it as recorded
+	Watermark *watermark.Burner
+	Mark      watermark.Mark
+}
+
+// RecordingManifest is written beside the media files when a recording stops
+type RecordingManifest struct {
+	SessionID     string          `json:"sessionId"`
+	ParticipantID string          `json:"participantId"`
+	StartedAt     utils.Time      `json:"startedAt"`
+	StoppedAt     utils.Time      `json:"stoppedAt"`
+	Files         []RecordingFile `json:"files"`
+	// Layouts is the composition layout at the start of the recording
+	// followed by its changes while recording
+	Layouts []LayoutChange `json:"layouts,omitempty"`
+	// Gaps are the stretches of silence left out of the files
+	Gaps []RecordingGap `json:"gaps,omitempty"`
+}
+
+// MediaRecorder writes the tracks published by a participant to disk, one
+// file per track
 type MediaRecorder struct {
-	audioWriter   io.Writer
-	videoWriter   io.Writer
-	audioTrack    *webrtc.TrackLocalStaticSample
-	videoTrack    *webrtc.TrackLocalStaticSample
+	dir           string
+	sessionID     string
+	participantID string
+	writers       map[string]media.Writer // keyed by track ID
+	files         []RecordingFile
+	startedAt     time.Time
 	isRecording   bool
-	stopRecording chan struct{}
+	// post is applied to the files once the recording stops
+	post PostProcessing
+	// audioOnly leaves video tracks out, for voicemail
+	audioOnly bool
+	// silence pauses writing while the call is silent, nil records
+	// throughout. pausedAt is zero while writing.
+	silence   *silenceGate
+	pausedAt  time.Time
+	resumedAt time.Time
+	gaps      []RecordingGap
+	shifts    map[string]*timestampShift
+	mu        sync.Mutex
 }
 
-func NewMediaRecorder() *MediaRecorder {
+func NewMediaRecorder(dir, sessionID, participantID string) *MediaRecorder {
 	return &MediaRecorder{
-		audioWriter:   &bytes.Buffer{},
-		videoWriter:   &bytes.Buffer{},
-		stopRecording: make(chan struct{}),
+		dir:           dir,
+		sessionID:     sessionID,
+		participantID: participantID,
 	}
 }
 
-func (mr *MediaRecorder) Start(pc *webrtc.PeerConnection) error {
-	// Create audio track
-	audioTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "audio/opus"},
-		"audio", "recording",
-	)
-	if err != nil {
+// Start begins a new recording. Writers are created lazily when the first
+// packet of each track arrives. The post-processing steps are applied
+// once the recording stops, the watermark clock starts now. Writing pauses
+// while the silence gate reports the call silent, when one is given.
+func (mr *MediaRecorder) Start(post PostProcessing, silence *silenceGate) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.isRecording {
+		return fmt.Errorf("recording already in progress")
+	}
+
+	if err := os.MkdirAll(mr.dir, 0755); err != nil {
 		return err
 	}
-	mr.audioTrack = audioTrack
 
-	// Create video track
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "video/vp8"},
-		"video", "recording",
-	)
+	mr.writers = make(map[string]media.Writer)
+	mr.files = nil
+	mr.startedAt = time.Now()
+	mr.isRecording = true
+	mr.post = post
+	mr.post.Mark.Start = mr.startedAt
+	mr.silence = silence
+	mr.pausedAt = time.Time{}
+	mr.resumedAt = mr.startedAt
+	mr.gaps = nil
+	mr.shifts = make(map[string]*timestampShift)
+	return nil
+}
+
+// IsRecording reports whether the recorder accepts packets
+func (mr *MediaRecorder) IsRecording() bool {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.isRecording
+}
+
+// WriteRTP writes a raw RTP packet received on the given track. It returns
+// true for the first packet of the track written after skipped silence.
+func (mr *MediaRecorder) WriteRTP(track *webrtc.TrackRemote, raw []byte) bool {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if !mr.isRecording || (mr.audioOnly && track.Kind() != webrtc.RTPCodecTypeAudio) || !mr.skipSilence(time.Now()) {
+		return false
+	}
+
+	writer, exists := mr.writers[track.ID()]
+	if !exists {
+		var err error
+		writer, err = mr.newWriter(track)
+		if err != nil {
+			log.Printf("Error creating recording writer for %s: %v\n", mr.participantID, err)
+		}
+		// A nil writer marks an unsupported codec so it isn't retried per packet
+		mr.writers[track.ID()] = writer
+		mr.shifts[track.ID()] = &timestampShift{frame: track.Codec().ClockRate / 50}
+	}
+	if writer == nil {
+		return false
+	}
+
+	packet := getPacket()
+	defer putPacket(packet)
+	if err := packet.Unmarshal(raw); err != nil {
+		return false
+	}
+	resumed := mr.shifts[track.ID()].apply(packet)
+	if err := writer.WriteRTP(packet); err != nil {
+		log.Printf("Error writing recording for %s: %v\n", mr.participantID, err)
+	}
+	return resumed
+}
+
+// Stop closes all writers and writes the manifest, time-stamping the layout
+// changes of the call made while recording. Post-processing runs in the
+// background, the manifest is rewritten once it is done.
+func (mr *MediaRecorder) Stop(layouts []LayoutChange) (*RecordingManifest, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if !mr.isRecording {
+		return nil, nil
+	}
+	mr.isRecording = false
+
+	for _, writer := range mr.writers {
+		if writer != nil {
+			_ = writer.Close()
+		}
+	}
+
+	manifest := &RecordingManifest{
+		SessionID:     mr.sessionID,
+		ParticipantID: mr.participantID,
+		StartedAt:     utils.NewTime(mr.startedAt),
+		StoppedAt:     utils.Now(),
+		Files:         mr.files,
+	}
+	manifest.Layouts = layoutsBetween(layouts, manifest.StartedAt.Time, manifest.StoppedAt.Time)
+	if !mr.pausedAt.IsZero() {
+		mr.closeGap(manifest.StoppedAt.Time)
+	}
+	manifest.Gaps = mr.gaps
+	processing := false
+	for i, file := range manifest.Files {
+		if mr.post.Denoiser != nil && strings.EqualFold(file.Codec, webrtc.MimeTypeOpus) {
+			manifest.Files[i].NoiseSuppression = ProcessingPending
+			processing = true
+		}
+		if mr.post.Watermark != nil && file.Kind == webrtc.RTPCodecTypeVideo.String() && watermark.Supports(file.Path) {
+			manifest.Files[i].Watermark = ProcessingPending
+			processing = true
+		}
+	}
+
+	path := filepath.Join(mr.dir, fmt.Sprintf("%s-%d.json", mr.participantID, mr.startedAt.Unix()))
+	if err := writeManifest(path, manifest); err != nil {
+		return nil, err
+	}
+
+	if processing {
+		// The returned manifest is handed to the caller, the copy is updated
+		background := *manifest
+		background.Files = slices.Clone(manifest.Files)
+		go postProcess(mr.post, path, &background)
+	}
+
+	return manifest, nil
+}
+
+// postProcess applies the pending steps to the files of a stopped recording
+// and rewrites its manifest with the outcome
+func postProcess(post PostProcessing, manifestPath string, manifest *RecordingManifest) {
+	for i, file := range manifest.Files {
+		if file.NoiseSuppression == ProcessingPending {
+			manifest.Files[i].NoiseSuppression = ProcessingApplied
+			if err := post.Denoiser.Denoise(context.Background(), file.Path); err != nil {
+				log.Printf("Error denoising recording %s: %v\n", file.Path, err)
+				manifest.Files[i].NoiseSuppression = ProcessingFailed
+			}
+		}
+		if file.Watermark == ProcessingPending {
+			manifest.Files[i].Watermark = ProcessingApplied
+			if err := post.Watermark.Burn(context.Background(), file.Path, post.Mark); err != nil {
+				log.Printf("Error watermarking recording %s: %v\n", file.Path, err)
+				manifest.Files[i].Watermark = ProcessingFailed
+			}
+		}
+	}
+
+	if err := writeManifest(manifestPath, manifest); err != nil {
+		log.Printf("Error updating recording manifest %s: %v\n", manifestPath, err)
+	}
+}
+
+// writeManifest writes a recording or mix manifest as indented JSON
+func writeManifest(path string, manifest interface{}) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return err
 	}
-	mr.videoTrack = videoTrack
+	return os.WriteFile(path, data, 0644)
+}
 
-	// Add tracks to peer connection
-	if _, err = pc.AddTrack(audioTrack); err != nil {
-		return err
+func (mr *MediaRecorder) newWriter(track *webrtc.TrackRemote) (media.Writer, error) {
+	codec := track.Codec()
+	name := fmt.Sprintf("%s-%s", mr.participantID, track.Kind())
+	// Further tracks of a kind, e.g. a screen share beside the camera, are
+	// numbered from 2
+	if n := mr.tracksOf(track.Kind()); n > 0 {
+		name += fmt.Sprintf("-%d", n+1)
 	}
-	if _, err = pc.AddTrack(videoTrack); err != nil {
-		return err
+	base := filepath.Join(mr.dir, fmt.Sprintf("%s-%d", name, mr.startedAt.Unix()))
+
+	var (
+		writer media.Writer
+		path   string
+		err    error
+	)
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus):
+		path = base + ".ogg"
+		writer, err = oggwriter.New(path, codec.ClockRate, codec.Channels)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		path = base + ".ivf"
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(webrtc.MimeTypeVP8))
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeAV1):
+		path = base + ".ivf"
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(webrtc.MimeTypeAV1))
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		path = base + ".h264"
+		writer, err = h264writer.New(path)
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", codec.MimeType)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	mr.isRecording = true
-	return nil
+	mr.files = append(mr.files, RecordingFile{
+		Kind:  track.Kind().String(),
+		Codec: codec.MimeType,
+		Path:  path,
+	})
+	return writer, nil
 }
 
-func (mr *MediaRecorder) Stop() {
-	if mr.isRecording {
-		close(mr.stopRecording)
-		mr.isRecording = false
+// tracksOf returns the number of files of a track kind being recorded
+func (mr *MediaRecorder) tracksOf(kind webrtc.RTPCodecType) int {
+	n := 0
+	for _, file := range mr.files {
+		if file.Kind == kind.String() {
+			n++
+		}
 	}
+	return n
 }
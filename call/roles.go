@@ -0,0 +1,148 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// CallRole is the role of a participant within a call
+type CallRole string
+
+const (
+	RoleHost        CallRole = "host"
+	RoleCoHost      CallRole = "cohost"
+	RoleParticipant CallRole = "participant"
+)
+
+// canModerate reports whether the participant may admit from the lobby,
+// mute others, record and end the call. The session lock must be held.
+func (s *CallSession) canModerate(participantID string) bool {
+	if participantID == s.CreatorID {
+		return true
+	}
+	participant, exists := s.Participants[participantID]
+	return exists && participant.Role == RoleCoHost
+}
+
+// roleFor returns the role a joining participant gets, keeping the role of
+// a participant that rejoins. The session lock must be held.
+func (s *CallSession) roleFor(participantID string) CallRole {
+	if participantID == s.CreatorID {
+		return RoleHost
+	}
+	if participant, exists := s.Participants[participantID]; exists && participant.Role != "" {
+		return participant.Role
+	}
+	return RoleParticipant
+}
+
+// SetCoHost grants or revokes the co-host role. Only the host may do this.
+func (cm *CallManager) SetCoHost(sessionID, hostID, participantID string, enabled bool) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.CreatorID != hostID {
+		return utils.NewErrorResponse(http.StatusForbidden, "only the host can assign co-hosts")
+	}
+	if participantID == hostID {
+		return utils.NewErrorResponse(http.StatusBadRequest, "the host can't be a co-host")
+	}
+
+	participant, exists := session.Participants[participantID]
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	participant.mu.Lock()
+	previous := participant.Role
+	participant.Role = RoleParticipant
+	if enabled {
+		participant.Role = RoleCoHost
+	}
+	participant.mu.Unlock()
+
+	cm.Audit.Record(hostID, audit.CallRoleChange, sessionID, participantID, previous, participant.Role)
+
+	return nil
+}
+
+// AdmitFromLobby lets a participant waiting in the lobby join the call
+func (cm *CallManager) AdmitFromLobby(sessionID, actorID, participantID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can admit participants")
+	}
+	if !session.isInLobby(participantID) {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant is not in the lobby")
+	}
+	if errResp := session.checkLocked(participantID); errResp != nil {
+		return errResp
+	}
+
+	if session.admitted == nil {
+		session.admitted = make(map[string]bool)
+	}
+	session.admitted[participantID] = true
+
+	cm.Audit.Record(actorID, audit.CallLobbyAdmit, sessionID, participantID, nil, nil)
+
+	return nil
+}
+
+// isInLobby reports whether a participant is waiting in the lobby. The
+// session lock must be held.
+func (s *CallSession) isInLobby(participantID string) bool {
+	for _, id := range s.InLobby {
+		if id == participantID {
+			return true
+		}
+	}
+	return false
+}
+
+// EndCall terminates the call for everyone on behalf of a host or co-host
+func (cm *CallManager) EndCall(sessionID, actorID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	allowed := session.canModerate(actorID)
+	session.mu.Unlock()
+
+	if !allowed {
+		return utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can end the call")
+	}
+
+	cm.Audit.Record(actorID, audit.CallEnd, sessionID, "", nil, nil)
+	return cm.TerminateSession(sessionID, TerminationEndedByHost)
+}
+
+// IsParticipant reports whether a user is a current participant of a call
+func (cm *CallManager) IsParticipant(sessionID, participantID string) bool {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return false
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	participant, exists := session.Participants[participantID]
+	return exists && participant.Status != StatusLeft
+}
@@ -0,0 +1,262 @@
+package call
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// keyframeThrottle is the minimum interval between keyframe requests sent to a publisher
+const keyframeThrottle = 500 * time.Millisecond
+
+// publishedTrack is a participant's remote track forwarded to the other
+// participants of the call
+type publishedTrack struct {
+	publisherID string
+	publisher   *CallParticipant
+	publisherPC *webrtc.PeerConnection
+	remote      *webrtc.TrackRemote
+	local       *webrtc.TrackLocalStaticRTP
+	stats       *streamStats
+	senders     map[string]*webrtc.RTPSender // keyed by subscriber ID
+	// snapshot captures thumbnails of the video, nil when disabled
+	snapshot *snapshotCapture
+	// levelID is the ID of the audio level header extension, zero when the
+	// publisher doesn't send it
+	levelID uint8
+	lastPLI time.Time
+	mu      sync.Mutex
+}
+
+// requestKeyframe asks the publisher for a new keyframe, throttled so a burst
+// of subscribers or loss reports results in a single PLI
+func (t *publishedTrack) requestKeyframe() {
+	if t.remote.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	t.mu.Lock()
+	if time.Since(t.lastPLI) < keyframeThrottle {
+		t.mu.Unlock()
+		return
+	}
+	t.lastPLI = time.Now()
+	t.mu.Unlock()
+
+	err := t.publisherPC.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(t.remote.SSRC())}})
+	if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		log.Printf("Error requesting keyframe from %s: %v\n", t.publisherID, err)
+	}
+}
+
+// subscribe adds the track to a subscriber's peer connection and requests a
+// keyframe so the subscriber doesn't wait for the next periodic one. Video
+// is left out for audio-only subscribers.
+func (t *publishedTrack) subscribe(subscriber *CallParticipant, pc *webrtc.PeerConnection) error {
+	if t.remote.Kind() == webrtc.RTPCodecTypeVideo && subscriber.audioOnly() {
+		return nil
+	}
+	sender, err := pc.AddTrack(t.local)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.senders[subscriber.ID] = sender
+	t.mu.Unlock()
+
+	go t.readFeedback(sender)
+	t.requestKeyframe()
+	return nil
+}
+
+// unsubscribe removes the track from a subscriber's peer connection and
+// reports whether it was subscribed
+func (t *publishedTrack) unsubscribe(subscriberID string, pc *webrtc.PeerConnection) bool {
+	t.mu.Lock()
+	sender, exists := t.senders[subscriberID]
+	delete(t.senders, subscriberID)
+	t.mu.Unlock()
+
+	if exists {
+		_ = pc.RemoveTrack(sender)
+	}
+	return exists
+}
+
+// readFeedback drains RTCP from a subscriber, relaying keyframe requests to
+// the publisher. NACKs are answered by the responder interceptor while the
+// packets are read here.
+func (t *publishedTrack) readFeedback(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				t.requestKeyframe()
+			}
+		}
+	}
+}
+
+// forward copies RTP from the publisher to every subscriber, and to the
+// publisher's recorder and the thumbnail capture when they exist, until the
+// remote track ends
+func (t *publishedTrack) forward() {
+	bufPtr := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufPtr)
+	buf := *bufPtr
+
+	// The header is reused so its extension slice doesn't grow per packet
+	var header rtp.Header
+	for {
+		n, _, err := t.remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		_, err = header.Unmarshal(buf[:n])
+		if err == nil {
+			t.stats.update(&header, n, time.Now())
+		}
+
+		t.publisher.mu.Lock()
+		recorder := t.publisher.MediaRecorder
+		hardMuted := t.publisher.IsHardMuted
+		audioOnly := t.publisher.AudioOnly
+		// The publisher may have been transferred to another call
+		session := t.publisher.session
+		t.publisher.mu.Unlock()
+
+		// Enforce the host's hard mute by dropping the publisher's audio
+		if hardMuted && t.remote.Kind() == webrtc.RTPCodecTypeAudio {
+			continue
+		}
+		// Nobody sees the video of an audio-only publisher
+		if audioOnly && t.remote.Kind() == webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if err == nil && t.levelID != 0 {
+			t.trackSpeech(session.talk, &header, time.Now())
+		}
+
+		// Video resumes from a keyframe after silence left out of a recording
+		if recorder != nil && recorder.WriteRTP(t.remote, buf[:n]) {
+			t.requestKeyframe()
+		}
+		// A keyframe is requested rather than waiting for the next periodic one
+		if t.snapshot != nil {
+			t.snapshot.sessionID = session.ID
+			if t.snapshot.write(buf[:n], time.Now()) {
+				t.requestKeyframe()
+			}
+		}
+
+		if _, err := t.local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// publishTrack registers a participant's remote track on the session and
+// forwards it to all other connected participants
+func (cm *CallManager) publishTrack(session *CallSession, publisher *CallParticipant, pc *webrtc.PeerConnection, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	publisherID := publisher.ID
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), publisherID)
+	if err != nil {
+		log.Printf("Error creating forwarding track for %s: %v\n", publisherID, err)
+		return
+	}
+
+	track := &publishedTrack{
+		publisherID: publisherID,
+		publisher:   publisher,
+		publisherPC: pc,
+		remote:      remote,
+		local:       local,
+		stats:       &streamStats{clockRate: remote.Codec().ClockRate},
+		senders:     make(map[string]*webrtc.RTPSender),
+	}
+	// Frames of end-to-end encrypted calls can't be decoded
+	if !session.E2EE {
+		track.snapshot = newSnapshotCapture(cm.Snapshots, session.ID, publisherID, remote.Codec())
+	}
+	if remote.Kind() == webrtc.RTPCodecTypeAudio {
+		track.levelID = audioLevelExtensionID(receiver)
+	}
+	key := publisherID + "/" + remote.ID()
+
+	session.mu.Lock()
+	session.tracks[key] = track
+	for id, participant := range session.Participants {
+		if id == publisherID || participant.PeerConnection == nil {
+			continue
+		}
+		if err := track.subscribe(participant, participant.PeerConnection); err != nil {
+			log.Printf("Error subscribing %s to %s: %v\n", id, key, err)
+			continue
+		}
+		cm.renegotiate(participant, participant.PeerConnection)
+	}
+	session.mu.Unlock()
+
+	track.forward()
+
+	// The publisher stopped sending, detach the track from all subscribers of
+	// the call the publisher is in by now
+	session = publisher.currentSession()
+	session.mu.Lock()
+	delete(session.tracks, key)
+	for id, participant := range session.Participants {
+		if participant.PeerConnection != nil && track.unsubscribe(id, participant.PeerConnection) {
+			cm.renegotiate(participant, participant.PeerConnection)
+		}
+	}
+	session.mu.Unlock()
+}
+
+// subscribeToSession adds every published track of the session to a newly
+// joined participant. The session lock must be held.
+func (s *CallSession) subscribeToSession(participant *CallParticipant, pc *webrtc.PeerConnection) {
+	for key, track := range s.tracks {
+		if track.publisherID == participant.ID {
+			continue
+		}
+		if err := track.subscribe(participant, pc); err != nil {
+			log.Printf("Error subscribing %s to %s: %v\n", participant.ID, key, err)
+		}
+	}
+}
+
+// requestKeyframes asks the publishers of every track a participant is
+// subscribed to for a keyframe
+func (s *CallSession) requestKeyframes(participantID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, track := range s.tracks {
+		if track.publisherID != participantID {
+			track.requestKeyframe()
+		}
+	}
+}
+
+// requestKeyframesFrom asks a publisher for a keyframe on each of its video
+// tracks. The session lock must be held.
+func (s *CallSession) requestKeyframesFrom(publisherID string) {
+	for _, track := range s.tracks {
+		if track.publisherID == publisherID {
+			track.requestKeyframe()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package call
+
+import (
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/rtp"
+)
+
+// silenceGate pauses recordings once nobody in the call spoke for a while
+type silenceGate struct {
+	talk  *talkTracker
+	after time.Duration
+}
+
+// silent reports whether the call was silent for long enough, counting from
+// since at the earliest
+func (g *silenceGate) silent(since, now time.Time) bool {
+	lastVoice, ok := g.talk.silentSince(now)
+	if !ok {
+		return false
+	}
+	if lastVoice.Before(since) {
+		lastVoice = since
+	}
+	return now.Sub(lastVoice) >= g.after
+}
+
+// RecordingGap is a stretch of silence left out of a recording
+type RecordingGap struct {
+	// OffsetSeconds is where the gap sits in the recorded files
+	OffsetSeconds float64    `json:"offsetSeconds"`
+	From          utils.Time `json:"from"`
+	To            utils.Time `json:"to"`
+}
+
+// timestampShift moves the RTP timestamps of a recorded track back by the
+// skipped silence, so the files don't play it back
+type timestampShift struct {
+	frame   uint32 // a 20ms frame in clock ticks
+	last    uint32
+	offset  uint32
+	started bool
+	// resync is set when writing resumes after a gap
+	resync bool
+}
+
+// apply shifts the timestamp of a packet and reports whether it is the first
+// one written after a gap
+func (s *timestampShift) apply(packet *rtp.Packet) bool {
+	resumed := s.resync && s.started
+	if resumed {
+		s.offset += packet.Timestamp - s.last - s.frame
+	}
+	s.resync = false
+	s.started = true
+	s.last = packet.Timestamp
+	packet.Timestamp -= s.offset
+	return resumed
+}
+
+// skipSilence pauses and resumes the recording with the silence of the call
+// and reports whether packets are written. The recorder lock must be held.
+func (mr *MediaRecorder) skipSilence(now time.Time) bool {
+	if mr.silence == nil {
+		return true
+	}
+
+	if mr.silence.silent(mr.resumedAt, now) {
+		if mr.pausedAt.IsZero() {
+			mr.pausedAt = now
+		}
+		return false
+	}
+	if !mr.pausedAt.IsZero() {
+		mr.closeGap(now)
+		mr.resumedAt = now
+		for _, shift := range mr.shifts {
+			shift.resync = true
+		}
+	}
+	return true
+}
+
+// closeGap records the gap of a paused recording ending at the given time
+func (mr *MediaRecorder) closeGap(end time.Time) {
+	var skipped time.Duration
+	for _, gap := range mr.gaps {
+		skipped += gap.To.Sub(gap.From.Time)
+	}
+	mr.gaps = append(mr.gaps, RecordingGap{
+		OffsetSeconds: (mr.pausedAt.Sub(mr.startedAt) - skipped).Seconds(),
+		From:          utils.NewTime(mr.pausedAt),
+		To:            utils.NewTime(end),
+	})
+	mr.pausedAt = time.Time{}
+}
@@ -0,0 +1,282 @@
+package call
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+)
+
+const (
+	// snapshotKeyframeWait is how long a capture waits for a keyframe before
+	// it is retried at the next interval
+	snapshotKeyframeWait = 5 * time.Second
+	// snapshotEncodeTimeout bounds converting a keyframe to JPEG
+	snapshotEncodeTimeout = 30 * time.Second
+)
+
+// Snapshots captures a keyframe of every video publisher at an interval and
+// keeps the latest one per publisher as JPEG, for previews that don't
+// subscribe to the video
+type Snapshots struct {
+	dir      string
+	ffmpeg   string
+	interval time.Duration
+}
+
+// NewSnapshots returns the thumbnail store converting keyframes with the
+// ffmpeg binary, or nil when thumbnails are disabled
+func NewSnapshots(cfg config.CallConfig, ffmpeg string) *Snapshots {
+	if cfg.SnapshotInterval <= 0 {
+		return nil
+	}
+	return &Snapshots{dir: cfg.SnapshotDir, ffmpeg: ffmpeg, interval: cfg.SnapshotInterval}
+}
+
+// path returns where the latest thumbnail of a publisher is kept
+func (s *Snapshots) path(sessionID, participantID string) string {
+	return filepath.Join(s.dir, sessionID, participantID+".jpg")
+}
+
+// remove deletes the thumbnails of an ended call
+func (s *Snapshots) remove(sessionID string) {
+	if s == nil {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, sessionID)); err != nil {
+		log.Printf("Error removing snapshots of %s: %v\n", sessionID, err)
+	}
+}
+
+// encode converts a single keyframe in the given ffmpeg input format to the
+// publisher's JPEG thumbnail
+func (s *Snapshots) encode(sessionID, participantID, format string, frame []byte) {
+	path := s.path(sessionID, participantID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Error creating snapshot directory: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotEncodeTimeout)
+	defer cancel()
+
+	tmp := strings.TrimSuffix(path, ".jpg") + ".partial.jpg"
+	cmd := exec.CommandContext(ctx, s.ffmpeg,
+		"-nostdin", "-y", "-loglevel", "error",
+		"-f", format, "-i", "pipe:0",
+		"-frames:v", "1", "-q:v", "4",
+		tmp,
+	)
+	cmd.Stdin = bytes.NewReader(frame)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("Error encoding snapshot of %s: %v: %s\n", participantID, err, strings.TrimSpace(string(output)))
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Error storing snapshot of %s: %v\n", participantID, err)
+	}
+}
+
+// snapshotCapture collects the next keyframe of a video track once the
+// interval elapsed. It is only used by the track's forwarding loop.
+type snapshotCapture struct {
+	snapshots     *Snapshots
+	sessionID     string
+	participantID string
+	format        string
+	due           time.Time
+	started       time.Time // zero while idle
+	inFrame       bool
+	buf           bytes.Buffer
+	writer        media.Writer
+}
+
+// newSnapshotCapture returns a capture for a video track, or nil when
+// thumbnails are disabled or the codec can't be captured
+func newSnapshotCapture(snapshots *Snapshots, sessionID, participantID string, codec webrtc.RTPCodecParameters) *snapshotCapture {
+	if snapshots == nil {
+		return nil
+	}
+
+	capture := &snapshotCapture{
+		snapshots:     snapshots,
+		sessionID:     sessionID,
+		participantID: participantID,
+	}
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		capture.format = "ivf"
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		capture.format = "h264"
+	default:
+		return nil
+	}
+	return capture
+}
+
+// write feeds a packet of the track. It returns true when a capture begins,
+// so the caller can request a keyframe.
+func (c *snapshotCapture) write(raw []byte, now time.Time) bool {
+	began := false
+	if c.started.IsZero() {
+		if now.Before(c.due) {
+			return false
+		}
+		if err := c.begin(); err != nil {
+			log.Printf("Error starting snapshot of %s: %v\n", c.participantID, err)
+			c.reset(now)
+			return false
+		}
+		c.started = now
+		began = true
+	}
+	if now.Sub(c.started) > snapshotKeyframeWait {
+		c.reset(now)
+		return began
+	}
+
+	packet := getPacket()
+	defer putPacket(packet)
+	if err := packet.Unmarshal(raw); err != nil || len(packet.Payload) == 0 {
+		return began
+	}
+
+	if !c.inFrame {
+		if !c.keyframeStart(packet) {
+			return began
+		}
+		c.inFrame = true
+	}
+	if err := c.writer.WriteRTP(packet); err != nil {
+		c.reset(now)
+		return began
+	}
+
+	if packet.Marker {
+		frame := bytes.Clone(c.buf.Bytes())
+		go c.snapshots.encode(c.sessionID, c.participantID, c.format, frame)
+		c.reset(now)
+	}
+	return began
+}
+
+// begin prepares the writer a keyframe is collected with
+func (c *snapshotCapture) begin() error {
+	c.buf.Reset()
+	if c.format == "h264" {
+		c.writer = h264writer.NewWith(&c.buf)
+		return nil
+	}
+
+	writer, err := ivfwriter.NewWith(&c.buf, ivfwriter.WithCodec(webrtc.MimeTypeVP8))
+	if err != nil {
+		return err
+	}
+	c.writer = writer
+	return nil
+}
+
+// reset idles the capture until the next interval
+func (c *snapshotCapture) reset(now time.Time) {
+	c.started = time.Time{}
+	c.inFrame = false
+	c.writer = nil
+	c.buf.Reset()
+	c.due = now.Add(c.snapshots.interval)
+}
+
+// keyframeStart reports whether the packet begins a keyframe
+func (c *snapshotCapture) keyframeStart(packet *rtp.Packet) bool {
+	if c.format == "h264" {
+		return h264KeyframeStart(packet.Payload)
+	}
+
+	var vp8 codecs.VP8Packet
+	if _, err := vp8.Unmarshal(packet.Payload); err != nil || len(vp8.Payload) == 0 {
+		return false
+	}
+	// The inverse key frame flag of the VP8 frame tag is clear on keyframes
+	return vp8.S == 1 && vp8.PID == 0 && vp8.Payload[0]&0x01 == 0
+}
+
+// h264KeyframeStart reports whether an H264 payload starts with the
+// parameter sets or the IDR slice of a keyframe
+func h264KeyframeStart(payload []byte) bool {
+	const (
+		naluIDR  = 5
+		naluSPS  = 7
+		naluSTAP = 24
+		naluFUA  = 28
+	)
+
+	switch payload[0] & 0x1F {
+	case naluIDR, naluSPS:
+		return true
+	case naluSTAP:
+		return len(payload) > 3 && payload[3]&0x1F == naluSPS
+	case naluFUA:
+		// The start bit marks the first fragment
+		return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1F == naluIDR
+	}
+	return false
+}
+
+// Snapshot returns the path of the latest thumbnail of a publisher, or of
+// the most recently captured publisher when participantID is empty
+func (cm *CallManager) Snapshot(sessionID, participantID string) (string, *utils.ErrorResponse) {
+	if cm.Snapshots == nil {
+		return "", utils.NewErrorResponse(http.StatusServiceUnavailable, "snapshots are disabled")
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return "", utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	if participantID != "" {
+		if _, exists := session.Participants[participantID]; !exists {
+			return "", utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+		}
+		path := cm.Snapshots.path(sessionID, participantID)
+		if _, err := os.Stat(path); err != nil {
+			return "", utils.NewErrorResponse(http.StatusNotFound, "snapshot not found")
+		}
+		return path, nil
+	}
+
+	var latest string
+	var latestTime time.Time
+	for id := range session.Participants {
+		path := cm.Snapshots.path(sessionID, id)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latestTime) {
+			latest, latestTime = path, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", utils.NewErrorResponse(http.StatusNotFound, "snapshot not found")
+	}
+	return latest, nil
+}
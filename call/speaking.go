@@ -0,0 +1,66 @@
+package call
+
+import (
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+const (
+	// speakingPoll is how often the speakers of a call are checked for changes
+	speakingPoll = 200 * time.Millisecond
+	// speakingMinTurn is how long a turn lasts before it is reported, so
+	// coughs and clicks don't flash speaking indicators
+	speakingMinTurn = 200 * time.Millisecond
+)
+
+// SpeakingEvent tells that a participant started or stopped speaking
+type SpeakingEvent struct {
+	ParticipantID string     `json:"participantId"`
+	Speaking      bool       `json:"speaking"`
+	At            utils.Time `json:"at"`
+}
+
+// speakingNow returns the participants speaking as of now, leaving out turns
+// shorter than minTurn
+func (t *talkTracker) speakingNow(now time.Time, minTurn time.Duration) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	speaking := make(map[string]bool)
+	for id, stats := range t.speakers {
+		if stats.speaking && now.Sub(stats.lastVoice) <= speechHangover && stats.lastVoice.Sub(stats.turnStart) >= minTurn {
+			speaking[id] = true
+		}
+	}
+	return speaking
+}
+
+// watchSpeaking reports participants starting and stopping to speak to
+// OnSpeaking until the call ends
+func (cm *CallManager) watchSpeaking(session *CallSession) {
+	ticker := time.NewTicker(speakingPoll)
+	defer ticker.Stop()
+
+	reported := make(map[string]bool)
+	for now := range ticker.C {
+		stored, exists := cm.sessions.Load(session.ID)
+		active := exists && stored == session
+		if !active {
+			return
+		}
+
+		current := session.talk.speakingNow(now, speakingMinTurn)
+		for id := range current {
+			if !reported[id] {
+				cm.OnSpeaking(session.ID, SpeakingEvent{ParticipantID: id, Speaking: true, At: utils.NewTime(now)})
+			}
+		}
+		for id := range reported {
+			if !current[id] {
+				cm.OnSpeaking(session.ID, SpeakingEvent{ParticipantID: id, Speaking: false, At: utils.NewTime(now)})
+			}
+		}
+		reported = current
+	}
+}
@@ -0,0 +1,69 @@
+package call
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// unpinnedSharePercent is the part of their bitrate cap the other video
+// publishers keep while a participant is pinned, leaving the bandwidth of
+// the subscribers to the pinned video
+const unpinnedSharePercent = 25
+
+// PinChange tells which participant every client spotlights
+type PinChange struct {
+	// ParticipantID is empty when the pin was lifted
+	ParticipantID string     `json:"participantId"`
+	ActorID       string     `json:"actorId"`
+	At            utils.Time `json:"at"`
+}
+
+// PinParticipant pins a participant on behalf of a host or co-host, so every
+// client spotlights them, or lifts the pin when participantID is empty. The
+// pin is also lifted when the pinned participant leaves.
+func (cm *CallManager) PinParticipant(sessionID, actorID, participantID string) (*PinChange, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.canModerate(actorID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can pin participants")
+	}
+	if participantID != "" {
+		participant, exists := session.Participants[participantID]
+		if !exists || participant.Status == StatusLeft {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+		}
+	}
+
+	previous := session.PinnedID
+	session.PinnedID = participantID
+	// Subscribers switch to the pinned video without waiting for the next
+	// periodic keyframe
+	if participantID != "" {
+		session.requestKeyframesFrom(participantID)
+	}
+	cm.Audit.Record(actorID, audit.CallPin, sessionID, participantID, previous, participantID)
+	cm.persist(session)
+
+	return &PinChange{ParticipantID: participantID, ActorID: actorID, At: utils.Now()}, nil
+}
+
+// yieldsToPinned reports whether the video of a publisher is capped because
+// another participant of the call is pinned and connected
+func (s *CallSession) yieldsToPinned(participantID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.PinnedID == "" || s.PinnedID == participantID {
+		return false
+	}
+	pinned, exists := s.Participants[s.PinnedID]
+	return exists && pinned.Status == StatusConnected
+}
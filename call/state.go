@@ -0,0 +1,456 @@
+package call
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/peer"
+	"pion-webrtc-microservice/statestore"
+	"pion-webrtc-microservice/utils"
+)
+
+// persistedParticipant is the state of a participant kept across restarts
+type persistedParticipant struct {
+	ID             string                 `json:"id"`
+	Role           CallRole               `json:"role"`
+	DisplayName    string                 `json:"displayName,omitempty"`
+	AvatarURL      string                 `json:"avatarUrl,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	Status         ParticipantStatus      `json:"status"`
+	IsMuted        bool                   `json:"isMuted"`
+	IsAudience     bool                   `json:"isAudience"`
+	IsHardMuted    bool                   `json:"isHardMuted"`
+	IsVideoEnabled bool                   `json:"isVideoEnabled"`
+	AudioOnly      bool                   `json:"audioOnly,omitempty"`
+	Preset         QualityPreset          `json:"preset"`
+	JoinTime       utils.Time             `json:"joinTime"`
+	LeftAt         utils.Time             `json:"leftAt,omitempty"`
+	// Connected is set when the participant had a peer connection, which
+	// they re-establish after a restart
+	Connected bool `json:"connected"`
+	Recording bool `json:"recording"`
+}
+
+// persistedCall is the state of a call kept across restarts. Media, knocks,
+// transfers, invitations and voicemail are not kept.
+type persistedCall struct {
+	ID               string                 `json:"id"`
+	Type             CallType               `json:"type"`
+	Quality          CallQuality            `json:"quality"`
+	JoinCode         string                 `json:"joinCode"`
+	JoinCodeExpiry   utils.Time             `json:"joinCodeExpiry"`
+	PasscodeHash     []byte                 `json:"passcodeHash,omitempty"`
+	E2EE             bool                   `json:"e2ee"`
+	ChatSessionID    string                 `json:"chatSessionId,omitempty"`
+	MaxParticipants  int                    `json:"maxParticipants"`
+	Overflow         bool                   `json:"overflow"`
+	NoiseSuppression bool                   `json:"noiseSuppression"`
+	Watermark        bool                   `json:"watermark"`
+	SkipSilence      bool                   `json:"skipSilence"`
+	CalleeID         string                 `json:"calleeId,omitempty"`
+	Gains            map[string]float64     `json:"gains,omitempty"`
+	Layouts          []LayoutChange         `json:"layouts"`
+	PinnedID         string                 `json:"pinnedId,omitempty"`
+	IsLocked         bool                   `json:"isLocked"`
+	CreatorID        string                 `json:"creatorId"`
+	StartTime        utils.Time             `json:"startTime"`
+	EndTime          utils.Time             `json:"endTime"`
+	IsRecording      bool                   `json:"isRecording"`
+	IsLivestreaming  bool                   `json:"isLivestreaming"`
+	InLobby          []string               `json:"inLobby,omitempty"`
+	Admitted         []string               `json:"admitted,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	Tags             []string               `json:"tags,omitempty"`
+	Codecs           peer.CodecPreferences  `json:"codecs"`
+	Audio            peer.OpusOptions       `json:"audio"`
+	Participants     []persistedParticipant `json:"participants"`
+}
+
+// state captures what is kept of a call across restarts and handovers. The
+// session lock must be held.
+func (s *CallSession) state() *persistedCall {
+	session := s
+	state := &persistedCall{
+		ID:               session.ID,
+		Type:             session.Type,
+		Quality:          session.Quality,
+		JoinCode:         session.JoinCode,
+		JoinCodeExpiry:   session.JoinCodeExpiry,
+		PasscodeHash:     session.passcodeHash,
+		E2EE:             session.E2EE,
+		ChatSessionID:    session.ChatSessionID,
+		MaxParticipants:  session.MaxParticipants,
+		Overflow:         session.Overflow,
+		NoiseSuppression: session.NoiseSuppression,
+		Watermark:        session.Watermark,
+		SkipSilence:      session.SkipSilence,
+		CalleeID:         session.CalleeID,
+		Gains:            session.Gains,
+		Layouts:          session.layouts,
+		PinnedID:         session.PinnedID,
+		IsLocked:         session.IsLocked,
+		CreatorID:        session.CreatorID,
+		StartTime:        session.StartTime,
+		EndTime:          session.EndTime,
+		IsRecording:      session.IsRecording,
+		IsLivestreaming:  session.IsLivestreaming,
+		InLobby:          session.InLobby,
+		Metadata:         session.Metadata,
+		Tags:             session.Tags,
+		Codecs:           session.Codecs,
+		Audio:            session.Audio,
+		Participants:     make([]persistedParticipant, 0, len(session.Participants)),
+	}
+	for id, admitted := range s.admitted {
+		if admitted {
+			state.Admitted = append(state.Admitted, id)
+		}
+	}
+	for _, participant := range session.Participants {
+		participant.mu.Lock()
+		recording := participant.MediaRecorder != nil && participant.MediaRecorder.IsRecording() && !participant.MediaRecorder.audioOnly
+		// Audio-only for poor network quality isn't kept, the quality is
+		// measured anew
+		audioOnly := participant.AudioOnly && !participant.audioOnlyAuto
+		participant.mu.Unlock()
+
+		state.Participants = append(state.Participants, persistedParticipant{
+			ID:             participant.ID,
+			Role:           participant.Role,
+			DisplayName:    participant.DisplayName,
+			AvatarURL:      participant.AvatarURL,
+			Metadata:       participant.Metadata,
+			Status:         participant.Status,
+			IsMuted:        participant.IsMuted,
+			IsAudience:     participant.IsAudience,
+			IsHardMuted:    participant.IsHardMuted,
+			IsVideoEnabled: participant.IsVideoEnabled,
+			AudioOnly:      audioOnly,
+			Preset:         participant.Preset,
+			JoinTime:       participant.JoinTime,
+			LeftAt:         participant.LeftAt,
+			Connected:      participant.PeerConnection != nil || participant.Status == StatusReconnecting,
+			Recording:      recording || participant.resumeRecording,
+		})
+	}
+	return state
+}
+
+// stateWriter writes values to a store in the background, so the store is
+// never written while a session lock is held. Only the latest value queued
+// for a key is written, a nil value removes it.
+type stateWriter struct {
+	// what names the values in logs, e.g. "state of call"
+	what    string
+	start   sync.Once
+	wake    chan struct{}
+	pending map[string][]byte
+	writing bool
+	// idle is signalled when nothing is pending or being written
+	idle *sync.Cond
+	mu   sync.Mutex
+}
+
+// persist snapshots the state of a call and queues it to be written, so it
+// survives a restart. The session lock must be held.
+func (cm *CallManager) persist(session *CallSession) {
+	if cm.State == nil || session.terminated {
+		return
+	}
+
+	// Encoded under the lock, the state shares maps and slices with the
+	// session
+	data, err := json.Marshal(session.state())
+	if err != nil {
+		log.Printf("Error encoding state of call %s: %v\n", session.ID, err)
+		return
+	}
+	cm.states.queue(cm.State, session.ID, data)
+}
+
+// forgetState queues the removal of the persisted state of a call that ended
+func (cm *CallManager) forgetState(sessionID string) {
+	if cm.State == nil {
+		return
+	}
+	cm.states.queue(cm.State, sessionID, nil)
+}
+
+// queue replaces the value waiting to be written to store for a key
+func (w *stateWriter) queue(store statestore.Store, key string, data []byte) {
+	w.start.Do(func() {
+		w.wake = make(chan struct{}, 1)
+		w.pending = make(map[string][]byte)
+		w.idle = sync.NewCond(&w.mu)
+		go w.run(store)
+	})
+
+	w.mu.Lock()
+	w.pending[key] = data
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queued values until the process exits
+func (w *stateWriter) run(store statestore.Store) {
+	for range w.wake {
+		for {
+			w.mu.Lock()
+			batch := w.pending
+			w.pending = make(map[string][]byte)
+			w.writing = len(batch) > 0
+			if !w.writing {
+				w.idle.Broadcast()
+				w.mu.Unlock()
+				break
+			}
+			w.mu.Unlock()
+
+			for key, data := range batch {
+				if data == nil {
+					if err := store.Delete(key); err != nil {
+						log.Printf("Error removing %s %s: %v\n", w.what, key, err)
+					}
+					continue
+				}
+				if err := store.Put(key, data); err != nil {
+					log.Printf("Error persisting %s %s: %v\n", w.what, key, err)
+				}
+			}
+		}
+	}
+}
+
+// flush waits until the queued values are written
+func (w *stateWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.idle != nil && (len(w.pending) > 0 || w.writing) {
+		w.idle.Wait()
+	}
+}
+
+// FlushState waits until the queued states and history of calls are
+// written, e.g. before the process exits
+func (cm *CallManager) FlushState() {
+	cm.states.flush()
+	cm.histories.flush()
+}
+
+// checkpoint persists the state of a call at the configured interval until
+// it ends, catching the changes not persisted as they happen
+func (cm *CallManager) checkpoint(session *CallSession) {
+	ticker := time.NewTicker(cm.cfg.StateInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stored, exists := cm.sessions.Load(session.ID)
+		active := exists && stored == session
+		if !active {
+			return
+		}
+
+		session.mu.Lock()
+		cm.persist(session)
+		session.mu.Unlock()
+	}
+}
+
+// RestoreSessions brings back the calls persisted before a restart and
+// returns how many were restored. Participants that were connected get the
+// reconnect grace period to rejoin with a new peer connection; recordings
+// resume when they do. Calls that expired meanwhile are dropped.
+func (cm *CallManager) RestoreSessions() int {
+	if cm.State == nil {
+		return 0
+	}
+	sessionIDs, err := cm.State.Keys()
+	if err != nil {
+		log.Printf("Error reading call state: %v\n", err)
+		return 0
+	}
+
+	restored := 0
+	for _, sessionID := range sessionIDs {
+		data, err := cm.State.Get(sessionID)
+		if err != nil {
+			log.Printf("Error reading state of call %s: %v\n", sessionID, err)
+			continue
+		}
+		var state persistedCall
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("Error decoding state of call %s: %v\n", sessionID, err)
+			continue
+		}
+		if err := cm.restore(&state); err != nil {
+			log.Printf("Dropping state of call %s: %v\n", sessionID, err)
+			cm.forgetState(sessionID)
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+// errCallExpired is returned when restoring a call whose end time passed
+var errCallExpired = errors.New("call expired while the service was down")
+
+// restore rebuilds a persisted call and resumes its background work
+func (cm *CallManager) restore(state *persistedCall) error {
+	remaining := time.Until(state.EndTime.Time)
+	if remaining <= 0 {
+		return errCallExpired
+	}
+	api, err := cm.factory.NewAPI(state.Codecs, state.Audio)
+	if err != nil {
+		return err
+	}
+
+	session := &CallSession{
+		ID:               state.ID,
+		Type:             state.Type,
+		Quality:          state.Quality,
+		JoinCode:         state.JoinCode,
+		JoinCodeExpiry:   state.JoinCodeExpiry,
+		URL:              "/call/resolve/" + state.JoinCode,
+		HasPasscode:      len(state.PasscodeHash) > 0,
+		passcodeHash:     state.PasscodeHash,
+		passcodeFailures: make(map[string]*passcodeAttempts),
+		E2EE:             state.E2EE,
+		ChatSessionID:    state.ChatSessionID,
+		MaxParticipants:  state.MaxParticipants,
+		Overflow:         state.Overflow,
+		NoiseSuppression: state.NoiseSuppression,
+		Watermark:        state.Watermark,
+		SkipSilence:      state.SkipSilence,
+		CalleeID:         state.CalleeID,
+		Gains:            state.Gains,
+		layouts:          state.Layouts,
+		PinnedID:         state.PinnedID,
+		IsLocked:         state.IsLocked,
+		CreatorID:        state.CreatorID,
+		StartTime:        state.StartTime,
+		EndTime:          state.EndTime,
+		IsRecording:      state.IsRecording,
+		IsLivestreaming:  state.IsLivestreaming,
+		InLobby:          state.InLobby,
+		admitted:         make(map[string]bool, len(state.Admitted)),
+		Metadata:         state.Metadata,
+		Tags:             state.Tags,
+		Codecs:           state.Codecs,
+		Audio:            state.Audio,
+		Participants:     make(map[string]*CallParticipant, len(state.Participants)),
+		api:              api,
+		tracks:           make(map[string]*publishedTrack),
+		timeline:         newStatsRing(cm.cfg.StatsRetention),
+		talk:             newTalkTracker(),
+	}
+	if len(session.layouts) > 0 {
+		session.Layout = session.layouts[len(session.layouts)-1]
+	}
+	for _, id := range state.Admitted {
+		session.admitted[id] = true
+	}
+
+	deadline := time.Now().Add(cm.cfg.ReconnectGrace)
+	for _, saved := range state.Participants {
+		participant := &CallParticipant{
+			ID:              saved.ID,
+			Role:            saved.Role,
+			DisplayName:     saved.DisplayName,
+			AvatarURL:       saved.AvatarURL,
+			Metadata:        saved.Metadata,
+			Status:          saved.Status,
+			IsMuted:         saved.IsMuted,
+			IsAudience:      saved.IsAudience,
+			IsHardMuted:     saved.IsHardMuted,
+			IsVideoEnabled:  saved.IsVideoEnabled,
+			AudioOnly:       saved.AudioOnly,
+			NetworkQuality:  5,
+			Preset:          saved.Preset,
+			JoinTime:        saved.JoinTime,
+			LeftAt:          saved.LeftAt,
+			resumeRecording: saved.Recording,
+			session:         session,
+		}
+		if saved.Connected && saved.Status != StatusLeft {
+			participant.Status = StatusReconnecting
+			participant.ReconnectDeadline = utils.NewTime(deadline)
+			time.AfterFunc(cm.cfg.ReconnectGrace, func() {
+				cm.expireReconnect(session, participant, nil)
+			})
+		}
+		session.Participants[participant.ID] = participant
+	}
+
+	cm.mu.Lock()
+	if _, taken := cm.joinCodes[session.JoinCode]; taken || session.JoinCode == "" {
+		cm.assignJoinCode(session, session.JoinCodeExpiry.Time)
+	} else {
+		cm.joinCodes[session.JoinCode] = session.ID
+	}
+	cm.mu.Unlock()
+	cm.sessions.Store(session.ID, session)
+
+	if cm.cfg.StatsInterval > 0 {
+		go cm.sampleStats(session)
+	}
+	if cm.OnSpeaking != nil {
+		go cm.watchSpeaking(session)
+	}
+	if cm.cfg.StateInterval > 0 {
+		go cm.checkpoint(session)
+	}
+	go func() {
+		time.Sleep(remaining)
+		cm.TerminateSession(session.ID, TerminationExpired)
+	}()
+
+	log.Printf("Restored call %s, waiting for its participants to rejoin\n", session.ID)
+	return nil
+}
+
+// Rejoin is a call a participant should rejoin with a new peer connection
+type Rejoin struct {
+	SessionID string     `json:"sessionId"`
+	Deadline  utils.Time `json:"deadline"`
+	// URL is the node serving the call when it was handed over to another
+	// node of the cluster
+	URL string `json:"url,omitempty"`
+}
+
+// PendingRejoins returns the calls in which a participant is reconnecting,
+// such as those restored after a restart
+func (cm *CallManager) PendingRejoins(participantID string) []Rejoin {
+	rejoins := []Rejoin{}
+	for _, session := range cm.sessions.Values() {
+		session.mu.RLock()
+		if participant, exists := session.Participants[participantID]; exists && participant.Status == StatusReconnecting {
+			rejoins = append(rejoins, Rejoin{SessionID: session.ID, Deadline: participant.ReconnectDeadline})
+		}
+		session.mu.RUnlock()
+	}
+	return rejoins
+}
+
+// resumeRecorder restarts the recording of a participant that was recorded
+// before a restart. The session lock must be held.
+func (cm *CallManager) resumeRecorder(session *CallSession, participant *CallParticipant) {
+	participant.mu.Lock()
+	defer participant.mu.Unlock()
+
+	if !participant.resumeRecording {
+		return
+	}
+	participant.resumeRecording = false
+	if err := cm.startRecorder(session, participant); err != nil {
+		log.Printf("Error resuming recording of %s: %v\n", participant.ID, err)
+	}
+}
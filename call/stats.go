@@ -0,0 +1,252 @@
+package call
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// streamStats measures the loss and interarrival jitter of an inbound RTP
+// stream as described in RFC 3550
+type streamStats struct {
+	clockRate   uint32
+	started     bool
+	baseSeq     uint32
+	highestSeq  uint32 // extended with the wrap-around count
+	received    uint64
+	bytes       uint64
+	lastTransit int64
+	jitter      float64 // in RTP timestamp units
+	mu          sync.Mutex
+}
+
+// update accounts for a packet of the given size that arrived at the given time
+func (s *streamStats) update(header *rtp.Header, size int, arrival time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bytes += uint64(size)
+
+	seq := uint32(header.SequenceNumber)
+	transit := arrival.UnixNano()*int64(s.clockRate)/int64(time.Second) - int64(header.Timestamp)
+	if !s.started {
+		s.started = true
+		s.baseSeq = seq
+		s.highestSeq = seq
+		s.lastTransit = transit
+		s.received = 1
+		return
+	}
+	s.received++
+
+	// Ignore reordered packets, only move forward on newer sequence numbers
+	if delta := header.SequenceNumber - uint16(s.highestSeq); delta != 0 && delta < 0x8000 {
+		s.highestSeq += uint32(delta)
+	}
+
+	if s.clockRate > 0 {
+		// The wrapping difference keeps timestamp roll-overs from spiking jitter
+		d := math.Abs(float64(int32(transit - s.lastTransit)))
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.lastTransit = transit
+}
+
+// bytesReceived returns the bytes received so far
+func (s *streamStats) bytesReceived() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// snapshot returns the packets received and lost so far and the jitter
+func (s *streamStats) snapshot() (received, lost uint64, jitterMs float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return 0, 0, 0
+	}
+	expected := uint64(s.highestSeq-s.baseSeq) + 1
+	if expected > s.received {
+		lost = expected - s.received
+	}
+	if s.clockRate > 0 {
+		jitterMs = s.jitter / float64(s.clockRate) * 1000
+	}
+	return s.received, lost, jitterMs
+}
+
+// ParticipantStats are the measured network conditions of a participant's
+// uplink and the MOS estimated from them
+type ParticipantStats struct {
+	ParticipantID   string            `json:"participantId"`
+	Status          ParticipantStatus `json:"status"`
+	NetworkQuality  int               `json:"networkQuality"`
+	PacketsReceived uint64            `json:"packetsReceived"`
+	PacketsLost     uint64            `json:"packetsLost"`
+	LossPercent     float64           `json:"lossPercent"`
+	JitterMs        float64           `json:"jitterMs"`
+	RTTMs           float64           `json:"rttMs"`
+	// MOS is zero until the participant published media
+	MOS float64 `json:"mos"`
+}
+
+// SessionStats are the stats of every participant of a call along with the
+// MOS aggregated over the participants that publish
+type SessionStats struct {
+	SessionID    string             `json:"sessionId"`
+	Participants []ParticipantStats `json:"participants"`
+	AverageMOS   float64            `json:"averageMos"`
+	MinMOS       float64            `json:"minMos"`
+}
+
+// ParticipantQuality summarizes the MOS samples of a participant
+type ParticipantQuality struct {
+	ParticipantID string  `json:"participantId"`
+	AverageMOS    float64 `json:"averageMos"`
+	MinMOS        float64 `json:"minMos"`
+	Samples       int     `json:"samples"`
+}
+
+// QualityReport is the post-call quality summary of a session
+type QualityReport struct {
+	SessionID    string               `json:"sessionId"`
+	EndedAt      utils.Time           `json:"endedAt"`
+	AverageMOS   float64              `json:"averageMos"`
+	MinMOS       float64              `json:"minMos"`
+	Participants []ParticipantQuality `json:"participants"`
+	// Conversation is how the speaking time was shared, for coaching and
+	// meeting quality reviews
+	Conversation *TalkTimeReport `json:"conversation,omitempty"`
+}
+
+// roundTripTime returns the current round trip time of the selected
+// candidate pair of a peer connection in milliseconds
+func roundTripTime(pc *webrtc.PeerConnection) float64 {
+	for _, stat := range pc.GetStats() {
+		pair, ok := stat.(webrtc.ICECandidatePairStats)
+		if ok && pair.Nominated && pair.CurrentRoundTripTime > 0 {
+			return pair.CurrentRoundTripTime * 1000
+		}
+	}
+	return 0
+}
+
+// collectStats measures every participant and adds a MOS sample to the
+// participants that publish media. The session lock must be held.
+func (s *CallSession) collectStats() *SessionStats {
+	stats := &SessionStats{SessionID: s.ID, Participants: []ParticipantStats{}}
+
+	var total mosAccumulator
+	for id, participant := range s.Participants {
+		entry := ParticipantStats{
+			ParticipantID:  id,
+			Status:         participant.Status,
+			NetworkQuality: participant.NetworkQuality,
+		}
+
+		// Rate the participant by their audio, falling back to video
+		var measured *publishedTrack
+		for _, track := range s.tracks {
+			if track.publisherID != id {
+				continue
+			}
+			if measured == nil || track.remote.Kind() == webrtc.RTPCodecTypeAudio {
+				measured = track
+			}
+		}
+
+		if measured != nil {
+			entry.PacketsReceived, entry.PacketsLost, entry.JitterMs = measured.stats.snapshot()
+			if expected := entry.PacketsReceived + entry.PacketsLost; expected > 0 {
+				entry.LossPercent = float64(entry.PacketsLost) / float64(expected) * 100
+			}
+			if participant.PeerConnection != nil {
+				entry.RTTMs = roundTripTime(participant.PeerConnection)
+			}
+			if entry.PacketsReceived > 0 {
+				entry.MOS = EstimateMOS(entry.LossPercent, entry.JitterMs, entry.RTTMs)
+				participant.quality.add(entry.MOS)
+				total.add(entry.MOS)
+			}
+		}
+
+		stats.Participants = append(stats.Participants, entry)
+	}
+
+	stats.AverageMOS = total.average()
+	stats.MinMOS = total.min
+	return stats
+}
+
+// qualityReport builds the quality summary of a session from the MOS samples
+// taken during the call. The session lock must be held.
+func (s *CallSession) qualityReport(endedAt time.Time) *QualityReport {
+	report := &QualityReport{SessionID: s.ID, EndedAt: utils.NewTime(endedAt), Participants: []ParticipantQuality{}}
+
+	var total mosAccumulator
+	for id, participant := range s.Participants {
+		if participant.quality.samples == 0 {
+			continue
+		}
+		quality := ParticipantQuality{
+			ParticipantID: id,
+			AverageMOS:    participant.quality.average(),
+			MinMOS:        participant.quality.min,
+			Samples:       participant.quality.samples,
+		}
+		report.Participants = append(report.Participants, quality)
+
+		total.add(quality.AverageMOS)
+		if report.MinMOS == 0 || quality.MinMOS < report.MinMOS {
+			report.MinMOS = quality.MinMOS
+		}
+	}
+	report.AverageMOS = total.average()
+	if conversation := s.talk.report(s.ID, endedAt); len(conversation.Participants) > 0 {
+		report.Conversation = conversation
+	}
+
+	return report
+}
+
+// GetCallStats returns the live stats of a call
+func (cm *CallManager) GetCallStats(sessionID string) (*SessionStats, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return session.collectStats(), nil
+}
+
+// GetQualityReport returns the quality report of a call that ended. Once
+// the report expired from memory it's read from the archived call.
+func (cm *CallManager) GetQualityReport(sessionID string) (*QualityReport, *utils.ErrorResponse) {
+	cm.historyMu.Lock()
+	kept, exists := cm.reports[sessionID]
+	cm.historyMu.Unlock()
+	if exists && time.Now().Before(kept.expiresAt) {
+		return kept.report, nil
+	}
+
+	archived, err := cm.loadArchive(sessionID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to load archived call session")
+	}
+	if err != nil || archived.Report == nil {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "quality report not found")
+	}
+	return archived.Report, nil
+}
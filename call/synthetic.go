@@ -0,0 +1,222 @@
+package call
+
+import (
+	"math"
+	"time"
+)
+
+// Synthetic media published by load test participants: a G.711 tone and an
+// H.264 color bar test pattern, both produced without an encoder
+
+const (
+	toneFrequency   = 440
+	toneSampleRate  = 8000
+	toneFrameLength = 20 * time.Millisecond
+
+	// The test pattern is 8x6 macroblocks, one color bar per column
+	patternWidthMBs  = 8
+	patternHeightMBs = 6
+	patternFrameRate = 10
+)
+
+// toneGenerator produces consecutive frames of a sine tone as µ-law samples
+type toneGenerator struct {
+	sample int
+}
+
+// next returns the following 20ms of the tone
+func (g *toneGenerator) next() []byte {
+	frame := make([]byte, toneSampleRate*toneFrameLength/time.Second)
+	for i := range frame {
+		value := math.Sin(2 * math.Pi * toneFrequency * float64(g.sample) / toneSampleRate)
+		frame[i] = linearToULaw(int16(value * 8000))
+		g.sample++
+	}
+	return frame
+}
+
+// linearToULaw encodes a 16-bit PCM sample as G.711 µ-law
+func linearToULaw(sample int16) byte {
+	const bias, clip = 0x84, 32635
+
+	value := int32(sample)
+	sign := byte(0)
+	if value < 0 {
+		sign = 0x80
+		value = -value
+	}
+	if value > clip {
+		value = clip
+	}
+	value += bias
+
+	exponent := byte(7)
+	for mask := int32(0x4000); value&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := byte(value>>(exponent+3)) & 0x0f
+	return ^(sign | exponent<<4 | mantissa)
+}
+
+// colorBars are the 75% color bars as Y, Cb and Cr
+var colorBars = [patternWidthMBs][3]byte{
+	{180, 128, 128}, // white
+	{168, 44, 136},  // yellow
+	{145, 147, 44},  // cyan
+	{133, 63, 52},   // green
+	{63, 193, 204},  // magenta
+	{51, 109, 212},  // red
+	{28, 212, 120},  // blue
+	{16, 128, 128},  // black
+}
+
+// testPattern encodes scrolling color bars as a constrained baseline H.264
+// stream. Every frame is an IDR picture of uncompressed I_PCM macroblocks,
+// preceded by its parameter sets.
+type testPattern struct {
+	frame int
+}
+
+// next returns the following frame as an Annex B access unit
+func (p *testPattern) next() []byte {
+	sps := &bitWriter{}
+	sps.bits(66, 8)   // profile_idc, baseline
+	sps.bits(0xe0, 8) // constraint_set0-2 flags
+	sps.bits(31, 8)   // level_idc 3.1
+	sps.ue(0)         // seq_parameter_set_id
+	sps.ue(0)         // log2_max_frame_num_minus4
+	sps.ue(2)         // pic_order_cnt_type
+	sps.ue(1)         // max_num_ref_frames
+	sps.bits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	sps.ue(patternWidthMBs - 1)
+	sps.ue(patternHeightMBs - 1)
+	sps.bits(1, 1) // frame_mbs_only_flag
+	sps.bits(1, 1) // direct_8x8_inference_flag
+	sps.bits(0, 1) // frame_cropping_flag
+	sps.bits(0, 1) // vui_parameters_present_flag
+	sps.trailing()
+
+	pps := &bitWriter{}
+	pps.ue(0)      // pic_parameter_set_id
+	pps.ue(0)      // seq_parameter_set_id
+	pps.bits(0, 1) // entropy_coding_mode_flag, CAVLC
+	pps.bits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	pps.ue(0)      // num_slice_groups_minus1
+	pps.ue(0)      // num_ref_idx_l0_default_active_minus1
+	pps.ue(0)      // num_ref_idx_l1_default_active_minus1
+	pps.bits(0, 1) // weighted_pred_flag
+	pps.bits(0, 2) // weighted_bipred_idc
+	pps.se(0)      // pic_init_qp_minus26
+	pps.se(0)      // pic_init_qs_minus26
+	pps.se(0)      // chroma_qp_index_offset
+	pps.bits(0, 1) // deblocking_filter_control_present_flag
+	pps.bits(0, 1) // constrained_intra_pred_flag
+	pps.bits(0, 1) // redundant_pic_cnt_present_flag
+	pps.trailing()
+
+	slice := &bitWriter{}
+	slice.ue(0)                   // first_mb_in_slice
+	slice.ue(7)                   // slice_type, I
+	slice.ue(0)                   // pic_parameter_set_id
+	slice.bits(0, 4)              // frame_num
+	slice.ue(uint32(p.frame % 2)) // idr_pic_id differs between consecutive IDRs
+	slice.bits(0, 1)              // no_output_of_prior_pics_flag
+	slice.bits(0, 1)              // long_term_reference_flag
+	slice.se(0)                   // slice_qp_delta
+	for y := 0; y < patternHeightMBs; y++ {
+		for x := 0; x < patternWidthMBs; x++ {
+			color := colorBars[(x+p.frame)%patternWidthMBs]
+			slice.ue(25) // mb_type, I_PCM
+			slice.align()
+			slice.repeat(color[0], 256)
+			slice.repeat(color[1], 64)
+			slice.repeat(color[2], 64)
+		}
+	}
+	slice.trailing()
+	p.frame++
+
+	var unit []byte
+	unit = appendNAL(unit, 0x67, sps.data)
+	unit = appendNAL(unit, 0x68, pps.data)
+	return appendNAL(unit, 0x65, slice.data)
+}
+
+// appendNAL appends a NAL unit with a start code, escaping the payload so it
+// can't contain one
+func appendNAL(unit []byte, header byte, payload []byte) []byte {
+	unit = append(unit, 0, 0, 0, 1, header)
+	zeros := 0
+	for _, b := range payload {
+		if zeros == 2 && b <= 3 {
+			unit = append(unit, 3)
+			zeros = 0
+		}
+		unit = append(unit, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return unit
+}
+
+// bitWriter writes the bit fields of an H.264 RBSP
+type bitWriter struct {
+	data  []byte
+	nbits int
+}
+
+func (w *bitWriter) bits(value uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.nbits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			w.data[len(w.data)-1] |= 0x80 >> uint(w.nbits%8)
+		}
+		w.nbits++
+	}
+}
+
+// ue writes an unsigned Exp-Golomb code
+func (w *bitWriter) ue(value uint32) {
+	value++
+	length := 0
+	for v := value; v > 1; v >>= 1 {
+		length++
+	}
+	w.bits(0, length)
+	w.bits(value, length+1)
+}
+
+// se writes a signed Exp-Golomb code
+func (w *bitWriter) se(value int32) {
+	if value > 0 {
+		w.ue(uint32(2*value - 1))
+	} else {
+		w.ue(uint32(-2 * value))
+	}
+}
+
+// align pads with zero bits to the next byte boundary
+func (w *bitWriter) align() {
+	if rem := w.nbits % 8; rem != 0 {
+		w.bits(0, 8-rem)
+	}
+}
+
+func (w *bitWriter) repeat(b byte, n int) {
+	w.align()
+	for i := 0; i < n; i++ {
+		w.data = append(w.data, b)
+	}
+	w.nbits += 8 * n
+}
+
+// trailing writes the RBSP stop bit and alignment
+func (w *bitWriter) trailing() {
+	w.bits(1, 1)
+	w.align()
+}
@@ -0,0 +1,282 @@
+package call
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/peer"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// speechLevel is the loudest audio level, in -dBov, still counted as
+	// silence when the sender doesn't flag voice activity
+	speechLevel = 50
+	// speechHangover is how long a pause may last without ending a turn
+	speechHangover = 800 * time.Millisecond
+	// voicedWindow is how recently a participant must have sent voice to be
+	// counted as talking over someone, a few packets of 20ms
+	voicedWindow = 60 * time.Millisecond
+	// levelTimeout is how long the call's silence is trusted after the last
+	// reported audio level
+	levelTimeout = 2 * time.Second
+)
+
+// speakerStats accumulates the speaking turns of one participant
+type speakerStats struct {
+	speaking      bool
+	turnStart     time.Time
+	lastVoice     time.Time
+	total         time.Duration
+	longest       time.Duration
+	turns         int
+	interruptions int
+	// overlap is the time spent speaking while someone else spoke too
+	overlap time.Duration
+}
+
+// endTurn closes the current turn at the last voiced packet
+func (s *speakerStats) endTurn() {
+	turn := s.lastVoice.Sub(s.turnStart)
+	s.total += turn
+	s.longest = max(s.longest, turn)
+	s.speaking = false
+}
+
+// talkTracker follows who speaks in a call from the audio levels of the
+// forwarded packets. It has its own lock so the forwarding loops don't take
+// the session lock.
+type talkTracker struct {
+	speakers map[string]*speakerStats
+	// lastLevel is when any audio level was last reported, lastVoice when
+	// anyone last spoke
+	lastLevel time.Time
+	lastVoice time.Time
+	// crossTalk is the time more than one participant spoke at once
+	crossTalk time.Duration
+	mu        sync.Mutex
+}
+
+func newTalkTracker() *talkTracker {
+	return &talkTracker{speakers: make(map[string]*speakerStats)}
+}
+
+// update records the audio level of a packet and reports whether the
+// participant is speaking. Starting a turn while someone else speaks counts
+// as an interruption.
+func (t *talkTracker) update(participantID string, level rtp.AudioLevelExtension, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advance(now)
+	t.lastLevel = now
+	stats, exists := t.speakers[participantID]
+	if !exists {
+		stats = &speakerStats{}
+		t.speakers[participantID] = stats
+	}
+	if stats.speaking && now.Sub(stats.lastVoice) > speechHangover {
+		stats.endTurn()
+	}
+
+	if !level.Voice && level.Level > speechLevel {
+		return stats.speaking
+	}
+
+	if !stats.speaking {
+		for id, other := range t.speakers {
+			if id != participantID && other.speaking && now.Sub(other.lastVoice) <= speechHangover {
+				stats.interruptions++
+				break
+			}
+		}
+		stats.speaking = true
+		stats.turnStart = now
+		stats.turns++
+	}
+	stats.lastVoice = now
+	t.lastVoice = now
+	return true
+}
+
+// advance counts the time since the previous update as cross-talk when more
+// than one participant was sending voice. The tracker lock must be held.
+func (t *talkTracker) advance(now time.Time) {
+	if t.lastLevel.IsZero() {
+		return
+	}
+	elapsed := min(now.Sub(t.lastLevel), voicedWindow)
+	if elapsed <= 0 {
+		return
+	}
+
+	active := 0
+	for _, stats := range t.speakers {
+		if stats.speaking && now.Sub(stats.lastVoice) <= voicedWindow {
+			active++
+		}
+	}
+	if active < 2 {
+		return
+	}
+	t.crossTalk += elapsed
+	for _, stats := range t.speakers {
+		if stats.speaking && now.Sub(stats.lastVoice) <= voicedWindow {
+			stats.overlap += elapsed
+		}
+	}
+}
+
+// silentSince returns when anyone in the call last spoke. It is false when
+// no audio levels were reported lately, since silence can't be told apart
+// from publishers that don't send them.
+func (t *talkTracker) silentSince(now time.Time) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastLevel.IsZero() || now.Sub(t.lastLevel) > levelTimeout {
+		return time.Time{}, false
+	}
+	return t.lastVoice, true
+}
+
+// trackSpeech feeds the audio level of a forwarded packet to the talk tracker
+// and flags whether the publisher is speaking
+func (t *publishedTrack) trackSpeech(talk *talkTracker, header *rtp.Header, now time.Time) {
+	payload := header.GetExtension(t.levelID)
+	if payload == nil {
+		return
+	}
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(payload); err != nil {
+		return
+	}
+
+	speaking := talk.update(t.publisherID, level, now)
+	t.publisher.mu.Lock()
+	t.publisher.IsSpeaking = speaking
+	t.publisher.mu.Unlock()
+}
+
+// ParticipantTalkTime is how much a participant spoke during a call
+type ParticipantTalkTime struct {
+	ParticipantID   string  `json:"participantId"`
+	SpeakingSeconds float64 `json:"speakingSeconds"`
+	// SharePercent is the participant's share of the call's speaking time
+	SharePercent float64 `json:"sharePercent"`
+	// Interruptions counts the turns started while someone else spoke
+	Interruptions           int     `json:"interruptions"`
+	LongestMonologueSeconds float64 `json:"longestMonologueSeconds"`
+	IsSpeaking              bool    `json:"isSpeaking"`
+	Turns                   int     `json:"turns"`
+	AverageTurnSeconds      float64 `json:"averageTurnSeconds"`
+	// OverlapSeconds is the time spent speaking over someone else
+	OverlapSeconds float64 `json:"overlapSeconds"`
+	// Dominance is the speaking share relative to an equal share of all
+	// participants that sent audio, above 1 for those speaking more
+	Dominance float64 `json:"dominance"`
+}
+
+// TalkTimeReport breaks down the speaking time of a call by participant,
+// most talkative first
+type TalkTimeReport struct {
+	SessionID            string  `json:"sessionId"`
+	TotalSpeakingSeconds float64 `json:"totalSpeakingSeconds"`
+	// CrossTalkSeconds is the time more than one participant spoke at once
+	CrossTalkSeconds float64 `json:"crossTalkSeconds"`
+	// Balance is 1 when every participant that sent audio spoke equally
+	// long and approaches 0 as a single speaker dominates
+	Balance      float64               `json:"balance"`
+	Participants []ParticipantTalkTime `json:"participants"`
+}
+
+// report returns the speaking time as of now, counting turns in progress
+func (t *talkTracker) report(sessionID string, now time.Time) *TalkTimeReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := &TalkTimeReport{
+		SessionID:        sessionID,
+		CrossTalkSeconds: t.crossTalk.Seconds(),
+		Participants:     []ParticipantTalkTime{},
+	}
+	for id, stats := range t.speakers {
+		if stats.speaking && now.Sub(stats.lastVoice) > speechHangover {
+			stats.endTurn()
+		}
+
+		total, longest := stats.total, stats.longest
+		if stats.speaking {
+			turn := stats.lastVoice.Sub(stats.turnStart)
+			total += turn
+			longest = max(longest, turn)
+		}
+		report.TotalSpeakingSeconds += total.Seconds()
+		participant := ParticipantTalkTime{
+			ParticipantID:           id,
+			SpeakingSeconds:         total.Seconds(),
+			Interruptions:           stats.interruptions,
+			LongestMonologueSeconds: longest.Seconds(),
+			IsSpeaking:              stats.speaking,
+			Turns:                   stats.turns,
+			OverlapSeconds:          stats.overlap.Seconds(),
+		}
+		if stats.turns > 0 {
+			participant.AverageTurnSeconds = participant.SpeakingSeconds / float64(stats.turns)
+		}
+		report.Participants = append(report.Participants, participant)
+	}
+
+	// The balance is the normalized entropy of the speaking shares
+	var entropy float64
+	for i := range report.Participants {
+		participant := &report.Participants[i]
+		if report.TotalSpeakingSeconds > 0 {
+			share := participant.SpeakingSeconds / report.TotalSpeakingSeconds
+			participant.SharePercent = share * 100
+			participant.Dominance = share * float64(len(report.Participants))
+			if share > 0 {
+				entropy -= share * math.Log(share)
+			}
+		}
+	}
+	if len(report.Participants) > 1 {
+		report.Balance = entropy / math.Log(float64(len(report.Participants)))
+	}
+	sort.Slice(report.Participants, func(i, j int) bool {
+		a, b := report.Participants[i], report.Participants[j]
+		if a.SpeakingSeconds != b.SpeakingSeconds {
+			return a.SpeakingSeconds > b.SpeakingSeconds
+		}
+		return a.ParticipantID < b.ParticipantID
+	})
+	return report
+}
+
+// audioLevelExtensionID returns the negotiated ID of the audio level header
+// extension of a receiver, zero when the publisher doesn't send it
+func audioLevelExtensionID(receiver *webrtc.RTPReceiver) uint8 {
+	for _, extension := range receiver.GetParameters().HeaderExtensions {
+		if extension.URI == peer.AudioLevelURI {
+			return uint8(extension.ID)
+		}
+	}
+	return 0
+}
+
+// GetTalkTime returns the speaking time of every participant that spoke
+// in a call so far
+func (cm *CallManager) GetTalkTime(sessionID string) (*TalkTimeReport, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	return session.talk.report(sessionID, time.Now()), nil
+}
@@ -0,0 +1,163 @@
+package call
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// ParticipantSample is the state of a participant at one point of the
+// stats timeline
+type ParticipantSample struct {
+	ParticipantID  string  `json:"participantId"`
+	BitrateKbps    float64 `json:"bitrateKbps"`
+	LossPercent    float64 `json:"lossPercent"`
+	JitterMs       float64 `json:"jitterMs"`
+	MOS            float64 `json:"mos"`
+	NetworkQuality int     `json:"networkQuality"`
+	IsSpeaking     bool    `json:"isSpeaking"`
+}
+
+// TimelinePoint holds the samples taken from every participant at once
+type TimelinePoint struct {
+	Timestamp    utils.Time          `json:"timestamp"`
+	Participants []ParticipantSample `json:"participants"`
+}
+
+// Timeline is the stats history of a call, oldest point first
+type Timeline struct {
+	SessionID       string          `json:"sessionId"`
+	IntervalSeconds float64         `json:"intervalSeconds"`
+	Points          []TimelinePoint `json:"points"`
+}
+
+// statsRing keeps the latest timeline points, overwriting the oldest once
+// it's full
+type statsRing struct {
+	points []TimelinePoint
+	next   int
+	full   bool
+}
+
+func newStatsRing(capacity int) *statsRing {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &statsRing{points: make([]TimelinePoint, capacity)}
+}
+
+func (r *statsRing) add(point TimelinePoint) {
+	if len(r.points) == 0 {
+		return
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// ordered returns the points from oldest to newest
+func (r *statsRing) ordered() []TimelinePoint {
+	if !r.full {
+		return r.points[:r.next]
+	}
+	return append(append([]TimelinePoint{}, r.points[r.next:]...), r.points[:r.next]...)
+}
+
+// sampleStats adds a point to the timeline of a call every stats interval
+// until the call ends
+func (cm *CallManager) sampleStats(session *CallSession) {
+	ticker := time.NewTicker(cm.cfg.StatsInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		stored, exists := cm.sessions.Load(session.ID)
+		active := exists && stored == session
+		if !active {
+			return
+		}
+
+		session.mu.Lock()
+		session.timeline.add(session.samplePoint(now, cm.cfg.StatsInterval))
+		session.mu.Unlock()
+	}
+}
+
+// samplePoint measures every participant, computing bitrates over the given
+// interval. The session lock must be held.
+func (s *CallSession) samplePoint(now time.Time, interval time.Duration) TimelinePoint {
+	stats := s.collectStats()
+	point := TimelinePoint{Timestamp: utils.NewTime(now), Participants: make([]ParticipantSample, 0, len(stats.Participants))}
+
+	for _, entry := range stats.Participants {
+		participant := s.Participants[entry.ParticipantID]
+
+		var bytes uint64
+		for _, track := range s.tracks {
+			if track.publisherID == entry.ParticipantID {
+				bytes += track.stats.bytesReceived()
+			}
+		}
+
+		// Tracks that ended take their byte counts with them, report no
+		// bitrate for that interval rather than a negative one
+		var bitrate float64
+		if bytes >= participant.sampledBytes {
+			bitrate = float64(bytes-participant.sampledBytes) * 8 / interval.Seconds() / 1000
+		}
+		participant.sampledBytes = bytes
+
+		participant.mu.Lock()
+		speaking := participant.IsSpeaking
+		participant.mu.Unlock()
+
+		point.Participants = append(point.Participants, ParticipantSample{
+			ParticipantID:  entry.ParticipantID,
+			BitrateKbps:    bitrate,
+			LossPercent:    entry.LossPercent,
+			JitterMs:       entry.JitterMs,
+			MOS:            entry.MOS,
+			NetworkQuality: entry.NetworkQuality,
+			IsSpeaking:     speaking,
+		})
+	}
+
+	return point
+}
+
+// GetStatsTimeline returns the sampled stats of a call taken after since,
+// limited to a single participant when participantID is set
+func (cm *CallManager) GetStatsTimeline(sessionID, participantID string, since time.Time) (*Timeline, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	timeline := &Timeline{
+		SessionID:       sessionID,
+		IntervalSeconds: cm.cfg.StatsInterval.Seconds(),
+		Points:          []TimelinePoint{},
+	}
+	for _, point := range session.timeline.ordered() {
+		if !point.Timestamp.After(since) {
+			continue
+		}
+		if participantID != "" {
+			filtered := TimelinePoint{Timestamp: point.Timestamp, Participants: []ParticipantSample{}}
+			for _, sample := range point.Participants {
+				if sample.ParticipantID == participantID {
+					filtered.Participants = append(filtered.Participants, sample)
+				}
+			}
+			point = filtered
+		}
+		timeline.Points = append(timeline.Points, point)
+	}
+
+	return timeline, nil
+}
@@ -0,0 +1,290 @@
+package call
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/utils"
+)
+
+// transferTimeout is how long an attended transfer waits to be answered
+const transferTimeout = time.Minute
+
+// TransferMode is how a participant is moved to another call
+type TransferMode string
+
+const (
+	// TransferBlind moves the participant right away
+	TransferBlind TransferMode = "blind"
+	// TransferAttended waits for a host or co-host of the target call to
+	// accept, the participant stays in their call meanwhile
+	TransferAttended TransferMode = "attended"
+)
+
+// Transfer states
+const (
+	TransferPending   = "pending"
+	TransferCompleted = "completed"
+	TransferRejected  = "rejected"
+)
+
+// Transfer moves a participant with their peer connection from one call to
+// another
+type Transfer struct {
+	ID            string       `json:"id"`
+	Mode          TransferMode `json:"mode"`
+	FromSessionID string       `json:"fromSessionId"`
+	ToSessionID   string       `json:"toSessionId"`
+	ParticipantID string       `json:"participantId"`
+	ActorID       string       `json:"actorId"`
+	Status        string       `json:"status"`
+	CreatedAt     utils.Time   `json:"createdAt"`
+}
+
+// lockPair locks two sessions in a fixed order, so transfers in opposite
+// directions can't deadlock, and returns the function unlocking both
+func lockPair(a, b *CallSession) func() {
+	first, second := a, b
+	if b.ID < a.ID {
+		first, second = b, a
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	return func() {
+		second.mu.Unlock()
+		first.mu.Unlock()
+	}
+}
+
+// transferSessions returns the source and target calls of a transfer
+func (cm *CallManager) transferSessions(fromID, toID string) (*CallSession, *CallSession, *utils.ErrorResponse) {
+	if fromID == toID {
+		return nil, nil, utils.NewErrorResponse(http.StatusBadRequest, "source and target calls must differ")
+	}
+
+	from, fromExists := cm.sessions.Load(fromID)
+	to, toExists := cm.sessions.Load(toID)
+
+	if !fromExists || !toExists {
+		return nil, nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+	return from, to, nil
+}
+
+// checkTransfer reports why a participant can't be moved between two calls.
+// Unless a host or co-host of the target call admitted them, they need its
+// passcode like anyone joining it. Both session locks must be held.
+func checkTransfer(from, to *CallSession, participantID, passcode string, admitted bool) *utils.ErrorResponse {
+	participant, exists := from.Participants[participantID]
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+	if participant.Status != StatusConnected || participant.PeerConnection == nil {
+		return utils.NewErrorResponse(http.StatusConflict, "participant is not connected")
+	}
+	if existing, exists := to.Participants[participantID]; exists && existing.Status != StatusLeft {
+		return utils.NewErrorResponse(http.StatusConflict, "participant is already in the target call")
+	}
+	if errResp := to.checkLocked(participantID); errResp != nil {
+		return errResp
+	}
+	if !admitted {
+		if errResp := to.verifyPasscode(participantID, passcode); errResp != nil {
+			return errResp
+		}
+	}
+	if !participant.IsAudience && to.isFull(participantID) {
+		return utils.NewErrorResponse(http.StatusConflict, "target call is full")
+	}
+	// The peer connection keeps the codecs and keys it was set up with
+	if from.E2EE != to.E2EE {
+		return utils.NewErrorResponse(http.StatusConflict, "calls differ in end-to-end encryption")
+	}
+	if !slices.Equal(from.Codecs.Preferred, to.Codecs.Preferred) || !slices.Equal(from.Codecs.Required, to.Codecs.Required) || from.Audio != to.Audio {
+		return utils.NewErrorResponse(http.StatusConflict, "calls negotiate different codecs")
+	}
+	return nil
+}
+
+// TransferParticipant moves a participant to another call on their own
+// behalf or a host's or co-host's. A blind transfer completes right away,
+// an attended one is pending until the target call answers it. Only hosts
+// and co-hosts of the target call transfer blindly, everyone else's
+// transfers are attended, as joining through the lobby would be.
+func (cm *CallManager) TransferParticipant(fromID, toID, actorID, participantID, passcode string, mode TransferMode) (*Transfer, *utils.ErrorResponse) {
+	if mode != TransferBlind && mode != TransferAttended {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid transfer mode")
+	}
+	from, to, errResp := cm.transferSessions(fromID, toID)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	unlock := lockPair(from, to)
+	defer unlock()
+
+	if actorID != participantID && !from.canModerate(actorID) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can transfer other participants")
+	}
+	admitted := to.canModerate(actorID)
+	if !admitted {
+		mode = TransferAttended
+	}
+	if errResp := checkTransfer(from, to, participantID, passcode, admitted); errResp != nil {
+		return nil, errResp
+	}
+
+	transfer := &Transfer{
+		ID:            utils.NewID(utils.PrefixTransfer),
+		Mode:          mode,
+		FromSessionID: fromID,
+		ToSessionID:   toID,
+		ParticipantID: participantID,
+		ActorID:       actorID,
+		Status:        TransferPending,
+		CreatedAt:     utils.Now(),
+	}
+	if mode == TransferAttended {
+		if to.transfers == nil {
+			to.transfers = make(map[string]*Transfer)
+		}
+		to.transfers[transfer.ID] = transfer
+		cm.Audit.Record(actorID, audit.CallTransfer, fromID, participantID, nil, transfer)
+		return transfer, nil
+	}
+
+	cm.moveParticipant(from, to, from.Participants[participantID])
+	transfer.Status = TransferCompleted
+	cm.Audit.Record(actorID, audit.CallTransfer, fromID, participantID, nil, transfer)
+	cm.publishTransfer(transfer)
+	return transfer, nil
+}
+
+// AnswerTransfer accepts or rejects an attended transfer into a call on
+// behalf of one of its hosts or co-hosts
+func (cm *CallManager) AnswerTransfer(sessionID, actorID, transferID string, accepted bool) (*Transfer, *utils.ErrorResponse) {
+	to, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "call session not found")
+	}
+
+	to.mu.Lock()
+	transfer, exists := to.transfers[transferID]
+	if exists && time.Since(transfer.CreatedAt.Time) > transferTimeout {
+		delete(to.transfers, transferID)
+		exists = false
+	}
+	moderator := to.canModerate(actorID)
+	to.mu.Unlock()
+
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "transfer not found")
+	}
+	if !moderator {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "only hosts and co-hosts can answer transfers")
+	}
+
+	from, _, errResp := cm.transferSessions(transfer.FromSessionID, sessionID)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	unlock := lockPair(from, to)
+	defer unlock()
+
+	// Answered concurrently while the locks were released
+	if _, exists := to.transfers[transferID]; !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "transfer not found")
+	}
+	// The passcode was checked when the transfer was requested
+	if accepted {
+		if errResp := checkTransfer(from, to, transfer.ParticipantID, "", true); errResp != nil {
+			return nil, errResp
+		}
+	}
+
+	delete(to.transfers, transferID)
+	answered := *transfer
+	answered.Status = TransferRejected
+	if accepted {
+		cm.moveParticipant(from, to, from.Participants[transfer.ParticipantID])
+		answered.Status = TransferCompleted
+	}
+	cm.Audit.Record(actorID, audit.CallTransferAnswer, sessionID, transfer.ParticipantID, transfer, answered)
+	cm.publishTransfer(&answered)
+	return &answered, nil
+}
+
+// moveParticipant re-points a participant's peer connection from one call
+// to the other: their subscriptions and published tracks move along, and
+// recording them in the source call stops. Both session locks must be held.
+func (cm *CallManager) moveParticipant(from, to *CallSession, participant *CallParticipant) {
+	pc := participant.PeerConnection
+	now := utils.GetTimestamp()
+
+	for key, track := range from.tracks {
+		if track.publisherID != participant.ID {
+			track.unsubscribe(participant.ID, pc)
+			continue
+		}
+		for id, subscriber := range from.Participants {
+			if subscriber.PeerConnection != nil && track.unsubscribe(id, subscriber.PeerConnection) {
+				cm.renegotiate(subscriber, subscriber.PeerConnection)
+			}
+		}
+		delete(from.tracks, key)
+		to.tracks[key] = track
+		for id, subscriber := range to.Participants {
+			if subscriber.PeerConnection == nil || subscriber.Status == StatusLeft {
+				continue
+			}
+			if err := track.subscribe(subscriber, subscriber.PeerConnection); err != nil {
+				log.Printf("Error subscribing %s to %s: %v\n", id, key, err)
+				continue
+			}
+			cm.renegotiate(subscriber, subscriber.PeerConnection)
+		}
+	}
+	to.subscribeToSession(participant, pc)
+	cm.renegotiate(participant, pc)
+
+	if participant.ID == from.CreatorID {
+		cm.stopVoicemail(from, true)
+	}
+	if participant.ID == from.PinnedID {
+		from.PinnedID = ""
+	}
+	participant.mu.Lock()
+	if participant.MediaRecorder != nil && participant.MediaRecorder.IsRecording() {
+		if _, err := participant.MediaRecorder.Stop(from.layouts); err != nil {
+			log.Printf("Error finalizing recording of transferred %s: %v\n", participant.ID, err)
+		}
+	}
+	participant.session = to
+	participant.mu.Unlock()
+
+	// The time spent in the source call is kept in the history
+	cm.historyMu.Lock()
+	cm.addHistory(historyEntry{
+		participantID: participant.ID,
+		Participation: newParticipation(from, participant, now),
+	})
+	cm.historyMu.Unlock()
+
+	delete(from.Participants, participant.ID)
+	participant.Role = to.roleFor(participant.ID)
+	participant.JoinTime = utils.NewTime(now)
+	to.Participants[participant.ID] = participant
+	cm.watchIdle(from)
+	cm.watchIdle(to)
+}
+
+// publishTransfer publishes a transfer to both calls it involves
+func (cm *CallManager) publishTransfer(transfer *Transfer) {
+	cm.Events.Publish(events.CallTransferred, transfer.FromSessionID, transfer)
+	cm.Events.Publish(events.CallTransferred, transfer.ToSessionID, transfer)
+}
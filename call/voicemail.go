@@ -0,0 +1,212 @@
+package call
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// voicemailRecord is the file describing a voicemail beside its audio
+const voicemailRecord = "voicemail.json"
+
+// Voicemail states
+const (
+	VoicemailRecording = "recording"
+	VoicemailDelivered = "delivered"
+	// VoicemailCancelled marks a voicemail discarded because the callee
+	// joined or the caller sent no audio
+	VoicemailCancelled = "cancelled"
+)
+
+// Voicemail is the audio a caller left when the callee of a 1:1 call didn't
+// join in time
+type Voicemail struct {
+	ID              string     `json:"id"`
+	SessionID       string     `json:"sessionId"`
+	CallerID        string     `json:"callerId"`
+	CalleeID        string     `json:"calleeId"`
+	Status          string     `json:"status"`
+	CreatedAt       utils.Time `json:"createdAt"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	// Path is the recorded Ogg/Opus audio
+	Path string `json:"-"`
+}
+
+// voicemailDir returns where a voicemail of a callee is kept
+func (cm *CallManager) voicemailDir(calleeID, voicemailID string) string {
+	return filepath.Join(cm.recordingDir, "voicemail", filepath.Base(calleeID), filepath.Base(voicemailID))
+}
+
+// awaitCallee turns a 1:1 call into voicemail when the callee didn't join
+// in time: the caller's audio is recorded until the call ends or the
+// voicemail reaches its maximum length
+func (cm *CallManager) awaitCallee(session *CallSession) {
+	stored, exists := cm.sessions.Load(session.ID)
+	active := exists && stored == session
+	if !active {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	// A callee that accepted the ring but hasn't connected is still away
+	if callee, joined := session.Participants[session.CalleeID]; joined && callee.Status != StatusWaiting {
+		return
+	}
+	caller, exists := session.Participants[session.CreatorID]
+	if !exists || caller.Status == StatusLeft {
+		return
+	}
+
+	voicemail := &Voicemail{
+		ID:        utils.NewID(utils.PrefixVoicemail),
+		SessionID: session.ID,
+		CallerID:  caller.ID,
+		CalleeID:  session.CalleeID,
+		Status:    VoicemailRecording,
+		CreatedAt: utils.Now(),
+	}
+	recorder := NewMediaRecorder(cm.voicemailDir(voicemail.CalleeID, voicemail.ID), session.ID, caller.ID)
+	recorder.audioOnly = true
+	var post PostProcessing
+	if session.NoiseSuppression {
+		post.Denoiser = cm.Denoiser
+	}
+
+	caller.mu.Lock()
+	if caller.MediaRecorder != nil && caller.MediaRecorder.IsRecording() {
+		caller.mu.Unlock()
+		log.Printf("Skipping voicemail of call %s, the caller is being recorded\n", session.ID)
+		return
+	}
+	if err := recorder.Start(post, nil); err != nil {
+		caller.mu.Unlock()
+		log.Printf("Error starting voicemail of call %s: %v\n", session.ID, err)
+		return
+	}
+	caller.MediaRecorder = recorder
+	caller.mu.Unlock()
+
+	session.Voicemail = voicemail
+	cm.notifyVoicemail(voicemail)
+
+	time.AfterFunc(cm.cfg.VoicemailMaxLength, func() {
+		session.mu.Lock()
+		recording := session.Voicemail == voicemail && voicemail.Status == VoicemailRecording
+		session.mu.Unlock()
+		if recording {
+			cm.TerminateSession(session.ID, TerminationVoicemail)
+		}
+	})
+}
+
+// stopVoicemail stops recording the voicemail of a call, delivering it to
+// the callee or discarding it. The session lock must be held.
+func (cm *CallManager) stopVoicemail(session *CallSession, deliver bool) {
+	voicemail := session.Voicemail
+	if voicemail == nil || voicemail.Status != VoicemailRecording {
+		return
+	}
+	dir := cm.voicemailDir(voicemail.CalleeID, voicemail.ID)
+
+	var manifest *RecordingManifest
+	if caller, exists := session.Participants[voicemail.CallerID]; exists {
+		caller.mu.Lock()
+		recorder := caller.MediaRecorder
+		caller.MediaRecorder = nil
+		caller.mu.Unlock()
+
+		var err error
+		if manifest, err = recorder.Stop(nil); err != nil {
+			log.Printf("Error finalizing voicemail of call %s: %v\n", session.ID, err)
+		}
+	}
+
+	voicemail.Status = VoicemailCancelled
+	if deliver && manifest != nil && len(manifest.Files) > 0 {
+		voicemail.Status = VoicemailDelivered
+		voicemail.DurationSeconds = manifest.StoppedAt.Sub(manifest.StartedAt.Time).Seconds()
+		voicemail.Path = manifest.Files[0].Path
+		if err := writeManifest(filepath.Join(dir, voicemailRecord), voicemail); err != nil {
+			log.Printf("Error storing voicemail of call %s: %v\n", session.ID, err)
+			voicemail.Status = VoicemailCancelled
+		}
+	}
+	if voicemail.Status == VoicemailCancelled {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Error removing voicemail of call %s: %v\n", session.ID, err)
+		}
+	}
+
+	cm.notifyVoicemail(voicemail)
+}
+
+// notifyVoicemail hands a copy of a voicemail to OnVoicemail in the
+// background, so the callback may use the CallManager
+func (cm *CallManager) notifyVoicemail(voicemail *Voicemail) {
+	if cm.OnVoicemail == nil {
+		return
+	}
+	snapshot := *voicemail
+	go cm.OnVoicemail(&snapshot)
+}
+
+// ListVoicemail returns the voicemail left for a user, newest first
+func (cm *CallManager) ListVoicemail(userID string) ([]*Voicemail, *utils.ErrorResponse) {
+	dir := filepath.Join(cm.recordingDir, "voicemail", filepath.Base(userID))
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read voicemail")
+	}
+
+	voicemails := []*Voicemail{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		voicemail, err := readVoicemail(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		voicemails = append(voicemails, voicemail)
+	}
+	sort.Slice(voicemails, func(i, j int) bool {
+		return voicemails[i].CreatedAt.After(voicemails[j].CreatedAt.Time)
+	})
+	return voicemails, nil
+}
+
+// GetVoicemail returns a voicemail left for a user
+func (cm *CallManager) GetVoicemail(userID, voicemailID string) (*Voicemail, *utils.ErrorResponse) {
+	voicemail, err := readVoicemail(cm.voicemailDir(userID, voicemailID))
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "voicemail not found")
+	}
+	return voicemail, nil
+}
+
+// readVoicemail reads the record of a delivered voicemail
+func readVoicemail(dir string) (*Voicemail, error) {
+	data, err := os.ReadFile(filepath.Join(dir, voicemailRecord))
+	if err != nil {
+		return nil, err
+	}
+	var voicemail Voicemail
+	if err := json.Unmarshal(data, &voicemail); err != nil {
+		return nil, err
+	}
+	// The audio is found beside the record, wherever the recordings moved
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.ogg"))
+	if len(matches) == 0 {
+		return nil, os.ErrNotExist
+	}
+	voicemail.Path = matches[0]
+	return &voicemail, nil
+}
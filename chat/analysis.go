@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+)
+
+// analysisTimeout bounds scoring a message
+const analysisTimeout = 15 * time.Second
+
+// ToxicityFlagAction is the moderation notification action of messages
+// flagged as toxic
+const ToxicityFlagAction = "toxicity_flagged"
+
+// analyzeMessage scores a text message and stores the scores on it.
+// Messages reaching the toxicity threshold are flagged and announced to
+// moderators.
+func (cm *ChatManager) analyzeMessage(sessionID, messageID, senderID, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), analysisTimeout)
+	defer cancel()
+
+	scores, err := cm.Scorer.Score(ctx, text)
+	if err != nil {
+		log.Printf("Error scoring message %s: %v\n", messageID, err)
+		return
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for i, msg := range session.Messages {
+		if msg.ID != messageID {
+			continue
+		}
+		if msg.IsDeleted || msg.Message != text {
+			return
+		}
+
+		scores.Toxic = scores.Toxicity >= cm.ToxicityThreshold
+		session.Messages[i].Analysis = scores
+		if scores.Toxic {
+			session.Messages[i].IsFlagged = true
+		}
+		session.revision++
+		if err := cm.record(session, SessionEvent{Type: EventMessageEdited, Message: &session.Messages[i]}); err != nil {
+			log.Printf("Error persisting scores for message %s: %v\n", messageID, err)
+		}
+
+		cm.Hub.SendNotification(Notification{
+			Type:      MessageUpdateNotification,
+			SessionID: sessionID,
+			Data:      session.Messages[i],
+		})
+		if scores.Toxic {
+			cm.Audit.Record(audit.SystemActor, audit.ChatModeration, sessionID, senderID, nil, session.Messages[i])
+			cm.notifyModerators(session, Notification{
+				Type:      ModerationNotification,
+				SessionID: sessionID,
+				Priority:  HighPriority,
+				Data: map[string]interface{}{
+					"participantId": senderID,
+					"messageId":     messageID,
+					"action":        ToxicityFlagAction,
+					"toxicity":      scores.Toxicity,
+				},
+			})
+		}
+		return
+	}
+}
+
+// scoreSummary aggregates the scores of a session's messages. The session
+// lock must be held.
+func (s *ChatSession) scoreSummary(metrics *UsageMetrics) {
+	var sentimentSum float64
+	for _, msg := range s.Messages {
+		if msg.Analysis == nil {
+			continue
+		}
+		metrics.ScoredMessages++
+		if msg.Analysis.Toxic {
+			metrics.ToxicMessages++
+		}
+		if msg.Analysis.Sentiment != nil {
+			metrics.SentimentMessages++
+			sentimentSum += *msg.Analysis.Sentiment
+		}
+	}
+	if metrics.SentimentMessages > 0 {
+		metrics.AverageSentiment = sentimentSum / float64(metrics.SentimentMessages)
+	}
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// DailyChatMetrics aggregates the chat sessions started on one UTC day
+type DailyChatMetrics struct {
+	Date            string `json:"date"`
+	Sessions        int    `json:"sessions"`
+	Messages        int    `json:"messages"`
+	AttachmentBytes int64  `json:"attachmentBytes"`
+}
+
+// ChatAnalytics aggregates the archived chat sessions started within a
+// window
+type ChatAnalytics struct {
+	Since                     utils.Time `json:"since"`
+	Until                     utils.Time `json:"until"`
+	Sessions                  int        `json:"sessions"`
+	Messages                  int        `json:"messages"`
+	AverageMessagesPerSession float64    `json:"averageMessagesPerSession"`
+	AttachmentBytes           int64      `json:"attachmentBytes"`
+	// ToxicMessages counts the messages flagged as toxic by the scorer
+	ToxicMessages int `json:"toxicMessages"`
+	// AverageSentiment averages the sentiment of all scored messages, nil
+	// when none was rated for sentiment
+	AverageSentiment *float64           `json:"averageSentiment,omitempty"`
+	Daily            []DailyChatMetrics `json:"daily"`
+}
+
+// Analytics aggregates the usage of the archived chat sessions started
+// between since and until, per UTC day
+func (cm *ChatManager) Analytics(since, until time.Time) (*ChatAnalytics, *utils.ErrorResponse) {
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read archived chat sessions")
+	}
+
+	analytics := &ChatAnalytics{Since: utils.NewTime(since), Until: utils.NewTime(until), Daily: []DailyChatMetrics{}}
+	for day := since.UTC().Truncate(24 * time.Hour); day.Before(until); day = day.Add(24 * time.Hour) {
+		analytics.Daily = append(analytics.Daily, DailyChatMetrics{Date: day.Format(time.DateOnly)})
+	}
+	var sentimentSum float64
+	var sentimentMessages int
+	days := make(map[string]*DailyChatMetrics, len(analytics.Daily))
+	for i := range analytics.Daily {
+		days[analytics.Daily[i].Date] = &analytics.Daily[i]
+	}
+
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		session, err := cm.LoadSession(sessionID)
+		if err != nil || session.Usage == nil {
+			continue
+		}
+		if session.StartTime.Before(since) || !session.StartTime.Before(until) {
+			continue
+		}
+
+		analytics.Sessions++
+		analytics.Messages += session.Usage.MessageCount
+		analytics.AttachmentBytes += session.Usage.AttachmentSize
+		analytics.ToxicMessages += session.Usage.ToxicMessages
+		sentimentSum += session.Usage.AverageSentiment * float64(session.Usage.SentimentMessages)
+		sentimentMessages += session.Usage.SentimentMessages
+
+		day := days[session.StartTime.UTC().Format(time.DateOnly)]
+		day.Sessions++
+		day.Messages += session.Usage.MessageCount
+		day.AttachmentBytes += session.Usage.AttachmentSize
+	}
+
+	if analytics.Sessions > 0 {
+		analytics.AverageMessagesPerSession = float64(analytics.Messages) / float64(analytics.Sessions)
+	}
+	if sentimentMessages > 0 {
+		average := sentimentSum / float64(sentimentMessages)
+		analytics.AverageSentiment = &average
+	}
+
+	return analytics, nil
+}
@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// ArchivedSessionSummary describes an archived session in listings
+type ArchivedSessionSummary struct {
+	ID           string     `json:"id"`
+	IsGroup      bool       `json:"isGroup"`
+	Participants int        `json:"participants"`
+	MessageCount int        `json:"messageCount"`
+	Tags         []string   `json:"tags,omitempty"`
+	StartTime    utils.Time `json:"startTime"`
+	ArchivedAt   utils.Time `json:"archivedAt"`
+	OffloadedAt  utils.Time `json:"offloadedAt,omitempty"`
+}
+
+// archive snapshots the metrics of a session that is ending and persists it
+// in the archived state. Sessions on disk outlive the active ones, so the
+// history stays available.
+func (cm *ChatManager) archive(session *ChatSession) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	now := time.Now()
+	session.ArchivedAt = utils.NewTime(now)
+	session.Usage = session.usage(now)
+	// The transcript is indexed again with the final participants
+	cm.indexMessages(session, session.Messages...)
+	return cm.SaveSession(session)
+}
+
+// GetArchivedSession returns an archived session with its messages and
+// participants. Of an offloaded session only the stub without messages is
+// returned, RehydrateArchive fetches the rest.
+func (cm *ChatManager) GetArchivedSession(sessionID string) (*ChatSession, *utils.ErrorResponse) {
+	session, err := cm.LoadSession(sessionID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "archived chat session not found")
+		}
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to load archived chat session")
+	}
+	if session.ArchivedAt.IsZero() {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "archived chat session not found")
+	}
+
+	return session, nil
+}
+
+// ListArchivedSessions lists the archived sessions, most recently archived
+// first, optionally only those labelled with a tag
+func (cm *ChatManager) ListArchivedSessions(tag string) ([]ArchivedSessionSummary, *utils.ErrorResponse) {
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to list archived chat sessions")
+	}
+
+	summaries := []ArchivedSessionSummary{}
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		session, err := cm.LoadSession(sessionID)
+		if err != nil || session.ArchivedAt.IsZero() {
+			continue
+		}
+		if tag != "" && !session.HasTag(tag) {
+			continue
+		}
+
+		summary := ArchivedSessionSummary{
+			ID:           session.ID,
+			IsGroup:      session.IsGroup,
+			Participants: len(session.Participants),
+			MessageCount: len(session.Messages),
+			Tags:         session.Tags,
+			StartTime:    session.StartTime,
+			ArchivedAt:   session.ArchivedAt,
+			OffloadedAt:  session.OffloadedAt,
+		}
+		if session.Usage != nil {
+			summary.MessageCount = session.Usage.MessageCount
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].ArchivedAt.After(summaries[j].ArchivedAt.Time)
+	})
+	return summaries, nil
+}
+
+// PurgeArchives deletes the archived sessions whose retention ran out and
+// returns how many were deleted. Transcripts offloaded to cold storage are
+// left to the lifecycle rules of the store.
+func (cm *ChatManager) PurgeArchives(now time.Time) (int, error) {
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		session, err := cm.LoadSession(sessionID)
+		if err != nil || session.ArchivedAt.IsZero() || session.RetentionDays <= 0 {
+			continue
+		}
+		if now.Before(session.ArchivedAt.AddDate(0, 0, session.RetentionDays)) {
+			continue
+		}
+
+		// Purged messages aren't found by search anymore
+		for i := range session.Messages {
+			session.Messages[i].IsDeleted = true
+		}
+		cm.indexMessages(session, session.Messages...)
+
+		cm.Cache.invalidate(sessionID)
+		if err := os.Remove(filepath.Join(sessionsDir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		if err := os.Remove(eventLogPath(sessionID)); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
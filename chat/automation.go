@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Placeholders of the automatic system messages
+const (
+	placeholderName      = "{name}"
+	placeholderRole      = "{role}"
+	placeholderRemaining = "{remaining}"
+)
+
+// announce posts an automatic system message built from a configured text,
+// nothing when the text is empty. Failures are logged, the change that
+// triggered the message stands. The session lock must be held.
+func (cm *ChatManager) announce(session *ChatSession, text string, replacements ...string) {
+	if text == "" {
+		return
+	}
+	text = strings.NewReplacer(replacements...).Replace(text)
+	if _, errResp := cm.addSystemMessage(session, text); errResp != nil {
+		log.Printf("Error posting system message to chat %s: %s\n", session.ID, errResp.Message)
+	}
+}
+
+// announceJoin posts the join message of a participant. The session lock
+// must be held.
+func (cm *ChatManager) announceJoin(session *ChatSession, participant *Participant) {
+	cm.announce(session, cm.SystemMessages.Join, placeholderName, participant.name())
+}
+
+// announceLeave posts the leave message of a participant. The session lock
+// must be held.
+func (cm *ChatManager) announceLeave(session *ChatSession, participant *Participant) {
+	cm.announce(session, cm.SystemMessages.Leave, placeholderName, participant.name())
+}
+
+// announceRole posts the role change message of a participant. The session
+// lock must be held.
+func (cm *ChatManager) announceRole(session *ChatSession, participant *Participant) {
+	cm.announce(session, cm.SystemMessages.RoleChange,
+		placeholderName, participant.name(),
+		placeholderRole, string(participant.Role),
+	)
+}
+
+// announceExpiry posts the expiry message of a session. The session lock
+// must be held.
+func (cm *ChatManager) announceExpiry(session *ChatSession, remaining time.Duration) {
+	cm.announce(session, cm.SystemMessages.Expiry, placeholderRemaining, formatRemaining(remaining))
+}
+
+// name returns the display name of a participant, or its ID
+func (p *Participant) name() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.ID
+}
+
+// formatRemaining spells out a duration in its largest whole unit, e.g.
+// "5 minutes" or "90 seconds"
+func formatRemaining(d time.Duration) string {
+	unit, size := "second", time.Second
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		unit, size = "hour", time.Hour
+	case d >= time.Minute && d%time.Minute == 0:
+		unit, size = "minute", time.Minute
+	}
+
+	count := int(d / size)
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", count, unit)
+}
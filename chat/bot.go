@@ -0,0 +1,475 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/unfurl"
+	"pion-webrtc-microservice/utils"
+)
+
+// botClient delivers commands to the callback URLs of bots, which are
+// supplied by users and so may only be public addresses
+var botClient = unfurl.NewPublicClient(10 * time.Second)
+
+// botsDir is where the bots of active sessions are kept with their tokens,
+// one JSON file per session
+var botsDir = filepath.Join(sessionsDir, "bots")
+
+// BotCommandType is the type of the payload posted for a command
+const BotCommandType = "command"
+
+// BotCommandJob is the retry job delivering a command whose delivery failed
+const BotCommandJob = "bot-command"
+
+// Bot is an integration posting to a session as a participant of its own.
+// Bots live as long as the session they were registered to and are kept
+// across restarts.
+type Bot struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// CallbackURL receives the commands the bot handles, empty when the bot
+	// only posts
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Commands are the slash commands delivered to the bot without their
+	// slash, e.g. "poll"
+	Commands  []string   `json:"commands,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt utils.Time `json:"createdAt"`
+	// token authenticates the bot and signs the commands delivered to it
+	token string
+}
+
+// storedBot is a bot as kept in botsDir, with its token
+type storedBot struct {
+	Bot
+	Token string `json:"token"`
+}
+
+// BotCommand is posted to the callback URL of a bot when a participant
+// sends one of its commands
+type BotCommand struct {
+	Type      string      `json:"type"`
+	BotID     string      `json:"botId"`
+	SessionID string      `json:"sessionId"`
+	Command   string      `json:"command"`
+	Args      string      `json:"args"`
+	Message   ChatMessage `json:"message"`
+}
+
+// RegisterBot adds a bot to a session on behalf of an admin and returns it
+// with the token it authenticates with, which isn't shown again
+func (cm *ChatManager) RegisterBot(sessionID, adminID, name, callbackURL string, commands []string) (*Bot, string, *utils.ErrorResponse) {
+	if callbackURL != "" {
+		target, err := url.Parse(callbackURL)
+		if err != nil || unfurl.CheckURL(target) != nil {
+			return nil, "", utils.NewErrorResponse(http.StatusBadRequest, "invalid callback URL")
+		}
+	}
+	if len(commands) > 0 && callbackURL == "" {
+		return nil, "", utils.NewErrorResponse(http.StatusBadRequest, "commands require a callback URL")
+	}
+	for i, command := range commands {
+		commands[i] = strings.ToLower(strings.TrimPrefix(command, "/"))
+		if commands[i] == "" || strings.ContainsAny(commands[i], " \t\n") {
+			return nil, "", utils.NewErrorResponse(http.StatusBadRequest, "invalid command")
+		}
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, "", utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return nil, "", utils.NewErrorResponse(http.StatusForbidden, "unauthorized to register bots")
+	}
+	if session.IsLocked {
+		return nil, "", utils.NewErrorResponse(http.StatusLocked, "chat session is locked")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", utils.NewErrorResponse(http.StatusInternalServerError, "failed to generate bot token")
+	}
+	bot := &Bot{
+		ID:          utils.NewID(utils.PrefixBot),
+		Name:        name,
+		CallbackURL: callbackURL,
+		Commands:    commands,
+		CreatedBy:   adminID,
+		CreatedAt:   utils.Now(),
+		token:       hex.EncodeToString(secret),
+	}
+	participant := &Participant{
+		ID:          bot.ID,
+		Role:        RoleBot,
+		DisplayName: name,
+		JoinTime:    bot.CreatedAt,
+	}
+	if session.bots == nil {
+		session.bots = make(map[string]*Bot)
+	}
+	session.bots[bot.ID] = bot
+	if err := saveBots(session); err != nil {
+		log.Printf("Error persisting bots of chat session %s: %v\n", sessionID, err)
+		delete(session.bots, bot.ID)
+		return nil, "", utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist bot")
+	}
+	session.Participants[bot.ID] = participant
+	if err := cm.record(session, participantChanged(participant)); err != nil {
+		delete(session.Participants, bot.ID)
+		delete(session.bots, bot.ID)
+		if err := saveBots(session); err != nil {
+			log.Printf("Error persisting bots of chat session %s: %v\n", sessionID, err)
+		}
+		return nil, "", utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist bot")
+	}
+
+	cm.Audit.Record(adminID, audit.ChatBotRegister, sessionID, bot.ID, nil, bot)
+	cm.Hub.SendNotification(Notification{
+		Type:      ParticipantNotification,
+		SessionID: sessionID,
+		Data:      *participant,
+	})
+
+	return bot, bot.token, nil
+}
+
+// RemoveBot removes a bot and its participant from a session on behalf of
+// an admin
+func (cm *ChatManager) RemoveBot(sessionID, adminID, botID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return utils.NewErrorResponse(http.StatusForbidden, "unauthorized to remove bots")
+	}
+	bot, exists := session.bots[botID]
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "bot not found")
+	}
+
+	delete(session.bots, botID)
+	if err := saveBots(session); err != nil {
+		session.bots[botID] = bot
+		log.Printf("Error persisting bots of chat session %s: %v\n", sessionID, err)
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist bot removal")
+	}
+	if participant, exists := session.Participants[botID]; exists {
+		delete(session.Participants, botID)
+		if err := cm.record(session, SessionEvent{Type: EventParticipantChanged, ParticipantID: botID}); err != nil {
+			session.Participants[botID] = participant
+			session.bots[botID] = bot
+			if err := saveBots(session); err != nil {
+				log.Printf("Error persisting bots of chat session %s: %v\n", sessionID, err)
+			}
+			return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist bot removal")
+		}
+	}
+
+	cm.Audit.Record(adminID, audit.ChatBotRemove, sessionID, botID, bot, nil)
+	cm.Hub.SendNotification(Notification{
+		Type:      ModerationNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"participantId": botID,
+			"action":        "remove",
+		},
+	})
+
+	return nil
+}
+
+// ListBots returns the bots registered to a session
+func (cm *ChatManager) ListBots(sessionID string) ([]Bot, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	bots := make([]Bot, 0, len(session.bots))
+	for _, bot := range session.bots {
+		bots = append(bots, *bot)
+	}
+	return bots, nil
+}
+
+// PostBotMessage stores a message from the bot a token belongs to. Bots
+// aren't held to slow mode or the spam filter, but can be muted.
+func (cm *ChatManager) PostBotMessage(sessionID, token string, message ChatMessage) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	sender, errResp := session.authenticateBot(token)
+	if errResp != nil {
+		return nil, errResp
+	}
+	message.SenderID = sender.ID
+	return cm.addMessage(session, sender, message)
+}
+
+// AddBotAttachment attaches a file to a message the bot a token belongs to
+// posted
+func (cm *ChatManager) AddBotAttachment(sessionID, token, messageID string, attachment Attachment) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	sender, errResp := session.authenticateBot(token)
+	if errResp != nil {
+		return errResp
+	}
+	msg := session.message(messageID)
+	if msg == nil || msg.SenderID != sender.ID {
+		return utils.NewErrorResponse(http.StatusNotFound, "message not found")
+	}
+
+	attachments := msg.Attachments
+	msg.Attachments = append(msg.Attachments, attachment)
+	session.revision++
+	if err := cm.record(session, SessionEvent{Type: EventMessageEdited, Message: msg}); err != nil {
+		msg.Attachments = attachments
+		session.revision--
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist attachment")
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      MessageUpdateNotification,
+		SessionID: sessionID,
+		Data:      *msg,
+	})
+	return nil
+}
+
+// AuthenticateBot returns the ID of the bot a token belongs to
+func (cm *ChatManager) AuthenticateBot(sessionID, token string) (string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return "", utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	sender, errResp := session.authenticateBot(token)
+	if errResp != nil {
+		return "", errResp
+	}
+	return sender.ID, nil
+}
+
+// authenticateBot returns the participant of the bot a token belongs to.
+// The session lock must be held.
+func (s *ChatSession) authenticateBot(token string) (*Participant, *utils.ErrorResponse) {
+	if token != "" {
+		for _, bot := range s.bots {
+			if !hmac.Equal([]byte(token), []byte(bot.token)) {
+				continue
+			}
+			// A bot removed by a moderator can't post anymore
+			if participant, exists := s.Participants[bot.ID]; exists {
+				return participant, nil
+			}
+		}
+	}
+	return nil, utils.NewErrorResponse(http.StatusUnauthorized, "invalid bot token")
+}
+
+// dispatchCommand delivers a message starting with a slash command to the
+// bots handling it. The session lock must be held.
+func (cm *ChatManager) dispatchCommand(session *ChatSession, message ChatMessage) {
+	text, ok := strings.CutPrefix(message.Message, "/")
+	if !ok || len(session.bots) == 0 {
+		return
+	}
+	command, args, _ := strings.Cut(text, " ")
+	command = strings.ToLower(command)
+
+	for _, bot := range session.bots {
+		if !utils.ContainsTag(bot.Commands, command) {
+			continue
+		}
+		payload := BotCommand{
+			Type:      BotCommandType,
+			BotID:     bot.ID,
+			SessionID: session.ID,
+			Command:   command,
+			Args:      strings.TrimSpace(args),
+			Message:   message,
+		}
+		go cm.sendCommand(*bot, payload)
+	}
+}
+
+// sendCommand delivers a command to a bot through the bot breaker. Commands
+// that fail or arrive while it's open are queued for a retry.
+func (cm *ChatManager) sendCommand(bot Bot, command BotCommand) {
+	body, err := json.Marshal(command)
+	if err != nil {
+		log.Printf("Error encoding /%s command for bot %s: %v\n", command.Command, bot.ID, err)
+		return
+	}
+
+	if err := cm.deliverCommand(bot, body); err != nil {
+		if cm.Retries == nil {
+			log.Printf("Error delivering /%s command to bot %s: %v\n", command.Command, bot.ID, err)
+			return
+		}
+		log.Printf("Error delivering /%s command to bot %s, queued for retry: %v\n", command.Command, bot.ID, err)
+		if err := cm.Retries.Enqueue(BotCommandJob, "", body); err != nil {
+			log.Printf("Error queueing /%s command for bot %s: %v\n", command.Command, bot.ID, err)
+		}
+	}
+}
+
+// RetryBotCommand is the handler of BotCommandJob. The command is signed
+// with the current token of the bot, and dropped once the bot or its
+// session is gone.
+func (cm *ChatManager) RetryBotCommand(_ string, body []byte) error {
+	var command BotCommand
+	if err := json.Unmarshal(body, &command); err != nil {
+		log.Printf("Dropping undecodable bot command: %v\n", err)
+		return nil
+	}
+
+	session, exists := cm.sessions.Load(command.SessionID)
+	if !exists {
+		return nil
+	}
+	session.mu.RLock()
+	bot, exists := session.bots[command.BotID]
+	if exists {
+		bot = &Bot{ID: bot.ID, CallbackURL: bot.CallbackURL, token: bot.token}
+	}
+	session.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+
+	return cm.deliverCommand(*bot, body)
+}
+
+// deliverCommand posts an encoded command to a bot through the bot breaker
+func (cm *ChatManager) deliverCommand(bot Bot, body []byte) error {
+	if cm.BotBreaker == nil {
+		return postCommand(bot, body)
+	}
+	return cm.BotBreaker.Do(func() error { return postCommand(bot, body) })
+}
+
+// postCommand posts an encoded command to a bot, signed with its token in
+// the X-Bot-Signature header as "sha256=" and the hex HMAC-SHA256 of the
+// body
+func postCommand(bot Bot, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(bot.token))
+	mac.Write(body)
+	req, err := http.NewRequest(http.MethodPost, bot.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bot-ID", bot.ID)
+	req.Header.Set("X-Bot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := botClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// saveBots writes the bots of a session with their tokens, readable by the
+// service only, or removes the file once none is left. The session lock
+// must be held.
+func saveBots(session *ChatSession) error {
+	path := filepath.Join(botsDir, session.ID+".json")
+	if len(session.bots) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	stored := make([]storedBot, 0, len(session.bots))
+	for _, bot := range session.bots {
+		stored = append(stored, storedBot{Bot: *bot, Token: bot.token})
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(botsDir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// loadBots restores the bots of a session kept by saveBots
+func loadBots(session *ChatSession) error {
+	data, err := os.ReadFile(filepath.Join(botsDir, session.ID+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var stored []storedBot
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	session.bots = make(map[string]*Bot, len(stored))
+	for _, entry := range stored {
+		bot := entry.Bot
+		bot.token = entry.Token
+		session.bots[bot.ID] = &bot
+	}
+	return nil
+}
+
+// forgetBots removes the bots of an ended session with their tokens
+func forgetBots(sessionID string) {
+	if err := os.Remove(filepath.Join(botsDir, sessionID+".json")); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing bots of chat session %s: %v\n", sessionID, err)
+	}
+}
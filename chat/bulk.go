@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// AddParticipants adds users to a session on behalf of an admin, reporting
+// the outcome for each of them. A locked session takes no new participants.
+func (cm *ChatManager) AddParticipants(sessionID, adminID string, participantIDs []string) ([]utils.BulkResult, *utils.ErrorResponse) {
+	if errResp := utils.ValidateBulkSize(len(participantIDs)); errResp != nil {
+		return nil, errResp
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "unauthorized to add participants")
+	}
+	if session.IsLocked {
+		return nil, utils.NewErrorResponse(http.StatusLocked, "chat session is locked")
+	}
+
+	results := make([]utils.BulkResult, 0, len(participantIDs))
+	var added []string
+	for _, participantID := range participantIDs {
+		if participantID == "" {
+			results = append(results, utils.BulkFailure(participantID, "participant ID is required"))
+			continue
+		}
+		if _, exists := session.Participants[participantID]; exists {
+			results = append(results, utils.BulkFailure(participantID, "already a participant"))
+			continue
+		}
+
+		session.Participants[participantID] = &Participant{
+			ID:       participantID,
+			Role:     RoleUser,
+			JoinTime: utils.Now(),
+		}
+		if err := cm.record(session, participantChanged(session.Participants[participantID])); err != nil {
+			delete(session.Participants, participantID)
+			results = append(results, utils.BulkFailure(participantID, "failed to persist participant"))
+			continue
+		}
+		added = append(added, participantID)
+		results = append(results, utils.BulkSuccess(participantID))
+	}
+
+	if len(added) == 0 {
+		return results, nil
+	}
+	for _, participantID := range added {
+		cm.Audit.Record(adminID, audit.ChatParticipantAdd, sessionID, participantID, nil, RoleUser)
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ParticipantNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"action":         "add",
+			"participantIds": added,
+		},
+	})
+	for _, participantID := range added {
+		cm.announceJoin(session, session.Participants[participantID])
+	}
+
+	return results, nil
+}
+
+// RemoveParticipants removes users from a session on behalf of an admin,
+// reporting the outcome for each of them. Admins can't remove themselves.
+func (cm *ChatManager) RemoveParticipants(sessionID, adminID string, participantIDs []string) ([]utils.BulkResult, *utils.ErrorResponse) {
+	if errResp := utils.ValidateBulkSize(len(participantIDs)); errResp != nil {
+		return nil, errResp
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "unauthorized to remove participants")
+	}
+
+	results := make([]utils.BulkResult, 0, len(participantIDs))
+	var removed []string
+	participants := make(map[string]*Participant)
+	for _, participantID := range participantIDs {
+		if participantID == adminID {
+			results = append(results, utils.BulkFailure(participantID, "admins can't remove themselves"))
+			continue
+		}
+		participant, exists := session.Participants[participantID]
+		if !exists {
+			results = append(results, utils.BulkFailure(participantID, "participant not found"))
+			continue
+		}
+
+		delete(session.Participants, participantID)
+		if err := cm.record(session, SessionEvent{Type: EventParticipantChanged, ParticipantID: participantID}); err != nil {
+			session.Participants[participantID] = participant
+			results = append(results, utils.BulkFailure(participantID, "failed to persist participant"))
+			continue
+		}
+		participants[participantID] = participant
+		removed = append(removed, participantID)
+		results = append(results, utils.BulkSuccess(participantID))
+	}
+
+	if len(removed) == 0 {
+		return results, nil
+	}
+	for _, participantID := range removed {
+		cm.Audit.Record(adminID, audit.ChatParticipantRemove, sessionID, participantID, participants[participantID].Role, nil)
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ParticipantNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"action":         "remove",
+			"participantIds": removed,
+		},
+	})
+	for _, participantID := range removed {
+		cm.announceLeave(session, participants[participantID])
+	}
+
+	return results, nil
+}
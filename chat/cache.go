@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"sync/atomic"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// SessionCache keeps the transcripts of hot sessions and recently loaded
+// stored sessions in memory, so repeated reads don't copy the transcript or
+// go to the store again
+type SessionCache struct {
+	transcripts *utils.LRU[string, transcript]
+	stored      *utils.LRU[string, []byte]
+	// writes counts invalidations, a load that raced with a write isn't
+	// cached
+	writes atomic.Uint64
+}
+
+// transcript is a snapshot of the messages of a session as of an ETag
+type transcript struct {
+	etag     string
+	messages []ChatMessage
+}
+
+// NewSessionCache creates a SessionCache holding up to size sessions of
+// each kind
+func NewSessionCache(size int) *SessionCache {
+	return &SessionCache{
+		transcripts: utils.NewLRU[string, transcript](size),
+		stored:      utils.NewLRU[string, []byte](size),
+	}
+}
+
+// transcript returns the cached messages of a session if they are still
+// current
+func (c *SessionCache) transcript(sessionID, etag string) ([]ChatMessage, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	cached, ok := c.transcripts.Get(sessionID)
+	if !ok || cached.etag != etag {
+		return nil, false
+	}
+	return cached.messages, true
+}
+
+func (c *SessionCache) addTranscript(sessionID, etag string, messages []ChatMessage) {
+	if c == nil {
+		return
+	}
+	c.transcripts.Add(sessionID, transcript{etag: etag, messages: messages})
+}
+
+// load returns the stored form of a session, reading it with read on a miss
+func (c *SessionCache) load(sessionID string, read func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return read()
+	}
+
+	if data, ok := c.stored.Get(sessionID); ok {
+		return data, nil
+	}
+
+	writes := c.writes.Load()
+	data, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if c.writes.Load() == writes {
+		c.stored.Add(sessionID, data)
+	}
+	return data, nil
+}
+
+// invalidate drops a session that is being written
+func (c *SessionCache) invalidate(sessionID string) {
+	if c == nil {
+		return
+	}
+
+	c.writes.Add(1)
+	c.transcripts.Remove(sessionID)
+	c.stored.Remove(sessionID)
+}
@@ -1,16 +1,36 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"pion-webrtc-microservice/analysis"
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/coldstorage"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/health"
+	"pion-webrtc-microservice/retry"
+	"pion-webrtc-microservice/search"
+	"pion-webrtc-microservice/shardmap"
+	"pion-webrtc-microservice/translate"
+	"pion-webrtc-microservice/unfurl"
 	"pion-webrtc-microservice/utils"
 )
 
+// previewTimeout bounds fetching all link previews of a single message
+const previewTimeout = 15 * time.Second
+
 // MessageType defines the type of message
 type MessageType string
 
@@ -21,6 +41,12 @@ const (
 	DocumentMessage MessageType = "document"
 	EmojiMessage    MessageType = "emoji"
 	SystemMessage   MessageType = "system"
+	// VoiceMessage carries a single voice attachment
+	VoiceMessage MessageType = "voice"
+	// LocationMessage and ContactMessage carry a Location or a Contact, the
+	// text is an optional caption
+	LocationMessage MessageType = "location"
+	ContactMessage  MessageType = "contact"
 )
 
 // ParticipantRole defines the role of a participant
@@ -30,108 +56,330 @@ const (
 	RoleAdmin     ParticipantRole = "admin"
 	RoleModerator ParticipantRole = "moderator"
 	RoleUser      ParticipantRole = "user"
+	// RoleBot is a bot registered to the session, which posts through the
+	// bot API only
+	RoleBot ParticipantRole = "bot"
 )
 
 // ChatMessage represents a message in the chat
 type ChatMessage struct {
-	ID          string       `json:"id"`
-	SenderID    string       `json:"senderId"`
-	ReceiverID  string       `json:"receiverId"`
-	Type        MessageType  `json:"type"`
-	Message     string       `json:"message"`
-	Attachments []Attachment `json:"attachments,omitempty"`
-	Reactions   []Reaction   `json:"reactions,omitempty"`
-	Timestamp   time.Time    `json:"timestamp"`
-	IsEdited    bool         `json:"isEdited"`
-	IsDeleted   bool         `json:"isDeleted"`
+	ID          string           `json:"id"`
+	SenderID    string           `json:"senderId"`
+	ReceiverID  string           `json:"receiverId"`
+	Type        MessageType      `json:"type"`
+	Message     string           `json:"message"`
+	Attachments []Attachment     `json:"attachments,omitempty"`
+	Reactions   []Reaction       `json:"reactions,omitempty"`
+	Previews    []unfurl.Preview `json:"previews,omitempty"`
+	// Location and Contact are the payloads of location and contact
+	// messages
+	Location *Location `json:"location,omitempty"`
+	Contact  *Contact  `json:"contact,omitempty"`
+	// Translations holds the text in the preferred languages of the
+	// participants, keyed by lowercase language tag
+	Translations map[string]string `json:"translations,omitempty"`
+	// Analysis holds the toxicity and sentiment scores of text messages
+	Analysis  *analysis.Scores `json:"analysis,omitempty"`
+	Timestamp utils.Time       `json:"timestamp"`
+	IsEdited  bool             `json:"isEdited"`
+	IsDeleted bool             `json:"isDeleted"`
+	IsPinned  bool             `json:"isPinned"`
+	// IsFlagged marks messages caught by the spam filter or scored as toxic
+	IsFlagged bool `json:"isFlagged,omitempty"`
+	// IsAnnouncement flags admin announcements so clients can style them
+	IsAnnouncement bool `json:"isAnnouncement,omitempty"`
+	// IsEphemeral marks messages shown to their receiver only, which clients
+	// drop at ExpiresAt
+	IsEphemeral bool        `json:"isEphemeral,omitempty"`
+	ExpiresAt   *utils.Time `json:"expiresAt,omitempty"`
 }
 
 // Participant represents a user in a chat session
 type Participant struct {
-	ID       string          `json:"id"`
-	Role     ParticipantRole `json:"role"`
-	IsPinned bool            `json:"isPinned"`
-	IsMuted  bool            `json:"isMuted"`
-	JoinTime time.Time       `json:"joinTime"`
+	ID          string                 `json:"id"`
+	Role        ParticipantRole        `json:"role"`
+	DisplayName string                 `json:"displayName,omitempty"`
+	AvatarURL   string                 `json:"avatarUrl,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	// Language is the language messages are translated into for the
+	// participant, e.g. "de" or "pt-br"
+	Language  string     `json:"language,omitempty"`
+	IsPinned  bool       `json:"isPinned"`
+	IsMuted   bool       `json:"isMuted"`
+	IsFlagged bool       `json:"isFlagged,omitempty"`
+	JoinTime  utils.Time `json:"joinTime"`
+}
+
+// ParticipantProfile holds the display information of a participant
+type ParticipantProfile struct {
+	DisplayName string                 `json:"displayName"`
+	AvatarURL   string                 `json:"avatarUrl"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Language    string                 `json:"language" validate:"max=35"`
+}
+
+// applyProfile merges a profile into the participant. Empty fields are left
+// untouched and metadata keys with a nil value are removed.
+func (p *Participant) applyProfile(profile ParticipantProfile) {
+	if profile.DisplayName != "" {
+		p.DisplayName = profile.DisplayName
+	}
+	if profile.AvatarURL != "" {
+		p.AvatarURL = profile.AvatarURL
+	}
+	if profile.Language != "" {
+		p.Language = translate.Normalize(profile.Language)
+	}
+	for key, value := range profile.Metadata {
+		if value == nil {
+			delete(p.Metadata, key)
+			continue
+		}
+		if p.Metadata == nil {
+			p.Metadata = make(map[string]interface{})
+		}
+		p.Metadata[key] = utils.CloneJSON(value)
+	}
 }
 
 // ChatSession represents a chat session
 type ChatSession struct {
 	ID           string                  `json:"id"`
 	Participants map[string]*Participant `json:"participants"`
-	StartTime    time.Time               `json:"startTime"`
-	EndTime      time.Time               `json:"endTime"`
+	StartTime    utils.Time              `json:"startTime"`
+	EndTime      utils.Time              `json:"endTime"`
 	Messages     []ChatMessage           `json:"messages"`
 	IsGroup      bool                    `json:"isGroup"`
-	mu           sync.Mutex
+	Metadata     map[string]interface{}  `json:"metadata,omitempty"`
+	Tags         []string                `json:"tags,omitempty"`
+	// SlowModeSeconds is the minimum interval between messages of a
+	// non-moderator participant, zero disables slow mode
+	SlowModeSeconds int `json:"slowModeSeconds,omitempty"`
+	// IsLocked freezes the participant list of the session
+	IsLocked bool `json:"isLocked"`
+	// TemplateID is the template the session was created from, if any
+	TemplateID string `json:"templateId,omitempty"`
+	// AllowedTypes limits the message types participants can send, empty
+	// allows every type
+	AllowedTypes []MessageType `json:"allowedTypes,omitempty"`
+	// RetentionDays is how long the session is kept once archived, zero
+	// keeps it forever
+	RetentionDays int `json:"retentionDays,omitempty"`
+	// ArchivedAt is set once the session ended and was archived
+	ArchivedAt utils.Time `json:"archivedAt,omitempty"`
+	// Usage holds the metrics of an archived session
+	Usage *UsageMetrics `json:"usage,omitempty"`
+	// OffloadedAt is set while the transcript of an archived session is in
+	// cold storage and only a stub is kept
+	OffloadedAt utils.Time `json:"offloadedAt,omitempty"`
+	// RehydratedAt is when an offloaded transcript was last fetched back
+	RehydratedAt utils.Time `json:"rehydratedAt,omitempty"`
+	// EventSeq is the sequence number of the last event applied, events
+	// logged after it are replayed on load
+	EventSeq      uint64 `json:"eventSeq,omitempty"`
+	lastMessageAt map[string]time.Time
+	spamHistory   map[string][]sentMessage
+	// pendingEvents counts the events logged since the last snapshot
+	pendingEvents int
+	// bots are registered to the active session only
+	bots map[string]*Bot
+	// expiry fires at the next expiry warning or the end of the session
+	expiry *time.Timer
+	// lastActivity is when the last message of a participant was added
+	lastActivity time.Time
+	// revision counts changes to existing messages, it's part of the
+	// transcript's ETag
+	revision uint64
+	mu       sync.RWMutex
+}
+
+// SessionOptions holds optional settings applied when a session is created
+type SessionOptions struct {
+	Metadata        map[string]interface{} `json:"metadata"`
+	Tags            []string               `json:"tags"`
+	SlowModeSeconds int                    `json:"slowModeSeconds"`
+	// TemplateID names the template the session starts from. The options
+	// above take precedence over its slow mode and add to its tags.
+	TemplateID string `json:"templateId"`
+}
+
+// HasTag reports whether the session is labelled with the given tag
+func (s *ChatSession) HasTag(tag string) bool {
+	return utils.ContainsTag(s.Tags, tag)
 }
 
 // ChatManager manages all chat sessions
 type ChatManager struct {
-	sessions map[string]*ChatSession
+	sessions *shardmap.Map[*ChatSession]
 	Hub      *NotificationHub
-	mu       sync.Mutex
+	// Unfurler fetches link previews for text messages, nil disables them
+	Unfurler *unfurl.Service
+	// Translator translates text messages into the preferred languages of
+	// the participants, nil disables translation
+	Translator translate.Translator
+	// Scorer rates text messages for toxicity and sentiment, nil disables
+	// scoring
+	Scorer analysis.Scorer
+	// ToxicityThreshold is the toxicity from which messages are flagged
+	ToxicityThreshold float64
+	// SpamFilter checks messages of non-moderators, nil disables it
+	SpamFilter *SpamFilter
+	// Audit records privileged actions, nil disables auditing
+	Audit *audit.Log
+	// SocketAuth verifies the users of chat sockets
+	SocketAuth SocketAuthConfig
+	// ColdStore receives old archived transcripts, nil keeps them in the
+	// primary store
+	ColdStore coldstorage.Store
+	// Retries queues session writes that failed, nil fails them
+	Retries *retry.Queue
+	// BotBreaker guards the delivery of commands to bots, nil calls them
+	// directly
+	BotBreaker *breaker.Breaker
+	// Cache keeps hot transcripts and stored sessions in memory, nil
+	// disables caching
+	Cache *SessionCache
+	// Events publishes session and message events, nil disables them
+	Events *events.Bus
+	// SnapshotEvery is the number of logged events after which a session
+	// is snapshotted, zero snapshots it on every change
+	SnapshotEvery int
+	// Search indexes messages for searching across sessions, nil disables
+	// it
+	Search *search.Indexer
+	// EphemeralTTL is how long ephemeral messages sent without a ttl are
+	// shown
+	EphemeralTTL time.Duration
+	// DraftTTL is how long a draft is kept after it was last saved
+	DraftTTL time.Duration
+	// MaxStarred is the number of messages a user can star
+	MaxStarred int
+	// IdleExpiry extends sessions that had a message within this long
+	// before their end, zero ends them on time
+	IdleExpiry time.Duration
+	// MaxDuration bounds how long a session can be extended to, zero
+	// doesn't bound it
+	MaxDuration time.Duration
+	// SystemMessages are the texts posted when participants join, leave or
+	// change roles and before sessions expire, the zero value posts none
+	SystemMessages config.SystemMessagesConfig
+	// templates are the session templates keyed by ID
+	templates   map[string]*SessionTemplate
+	templatesMu sync.RWMutex
+	// userFilesMu guards the drafts and starred messages of the users
+	userFilesMu sync.Mutex
 }
 
 func NewChatManager() *ChatManager {
 	cm := &ChatManager{
-		sessions: make(map[string]*ChatSession),
+		sessions: shardmap.New[*ChatSession](),
 		Hub:      NewNotificationHub(),
 	}
 	go cm.Hub.Run()
 	return cm
 }
 
-// CreateChatSession creates a new chat session with roles
-func (cm *ChatManager) CreateChatSession(creatorID string, participants []string, duration time.Duration, isGroup bool) (*ChatSession, *utils.ErrorResponse) {
+// CreateChatSession creates a new chat session with roles. Profiles are
+// optional and keyed by participant ID.
+func (cm *ChatManager) CreateChatSession(creatorID string, participants []string, profiles map[string]ParticipantProfile, duration time.Duration, isGroup bool, opts SessionOptions) (*ChatSession, *utils.ErrorResponse) {
+	template := &SessionTemplate{}
+	if opts.TemplateID != "" {
+		var errResp *utils.ErrorResponse
+		if template, errResp = cm.GetTemplate(opts.TemplateID); errResp != nil {
+			return nil, errResp
+		}
+	}
+
 	participantsMap := make(map[string]*Participant)
 
 	// Add creator as admin
 	participantsMap[creatorID] = &Participant{
 		ID:       creatorID,
 		Role:     RoleAdmin,
-		JoinTime: utils.GetTimestamp(),
+		JoinTime: utils.Now(),
 	}
 
-	// Add other participants as users
+	// Add other participants with the role of the template, users by
+	// default
 	for _, pid := range participants {
 		if pid != creatorID {
 			participantsMap[pid] = &Participant{
 				ID:       pid,
-				Role:     RoleUser,
-				JoinTime: utils.GetTimestamp(),
+				Role:     template.roleFor(pid),
+				JoinTime: utils.Now(),
 			}
 		}
 	}
 
+	for pid, profile := range profiles {
+		if participant, exists := participantsMap[pid]; exists {
+			participant.applyProfile(profile)
+		}
+	}
+
 	session := &ChatSession{
-		ID:           utils.GenerateSessionID(),
-		Participants: participantsMap,
-		StartTime:    utils.GetTimestamp(),
-		EndTime:      utils.GetTimestamp().Add(duration),
-		Messages:     []ChatMessage{},
-		IsGroup:      isGroup,
+		ID:            utils.NewID(utils.PrefixChat),
+		Participants:  participantsMap,
+		StartTime:     utils.Now(),
+		EndTime:       utils.NewTime(utils.GetTimestamp().Add(duration)),
+		Messages:      []ChatMessage{},
+		IsGroup:       isGroup,
+		Metadata:      opts.Metadata,
+		Tags:          utils.NormalizeTags(append(slices.Clone(template.Tags), opts.Tags...)),
+		TemplateID:    template.ID,
+		AllowedTypes:  template.AllowedTypes,
+		RetentionDays: template.RetentionDays,
+	}
+	session.SlowModeSeconds = template.SlowModeSeconds
+	if opts.SlowModeSeconds > 0 {
+		session.SlowModeSeconds = opts.SlowModeSeconds
 	}
+	if template.WelcomeMessage != "" {
+		session.Messages = append(session.Messages, ChatMessage{
+			ID:        utils.NewID(utils.PrefixMessage),
+			SenderID:  SystemSenderID,
+			Type:      SystemMessage,
+			Message:   template.WelcomeMessage,
+			Timestamp: session.StartTime,
+		})
+	}
+
+	if err := cm.SaveSession(session); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist chat session")
+	}
+
+	participantIDs := make([]string, 0, len(participantsMap))
+	for id := range participantsMap {
+		participantIDs = append(participantIDs, id)
+	}
+	cm.Events.Publish(events.ChatSessionCreated, session.ID, map[string]interface{}{
+		"sessionId":    session.ID,
+		"creatorId":    creatorID,
+		"participants": participantIDs,
+		"isGroup":      isGroup,
+		"endTime":      session.EndTime,
+	})
+
+	// The session isn't shared yet, the welcome message needs no lock
+	cm.indexMessages(session, session.Messages...)
+
+	cm.sessions.Store(session.ID, session)
 
-	cm.mu.Lock()
-	cm.sessions[session.ID] = session
-	cm.mu.Unlock()
+	for _, message := range session.Messages {
+		cm.publishMessage(session.ID, message)
+	}
 
-	// Auto terminate the session after duration
-	go func() {
-		time.Sleep(duration)
-		cm.TerminateSession(session.ID)
-	}()
+	// Warn of and terminate the session at its end
+	session.mu.Lock()
+	cm.scheduleExpiry(session)
+	session.mu.Unlock()
 
 	return session, nil
 }
 
 // ModifyParticipantRole changes a participant's role
 func (cm *ChatManager) ModifyParticipantRole(sessionID, adminID, participantID string, newRole ParticipantRole) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -150,16 +398,22 @@ func (cm *ChatManager) ModifyParticipantRole(sessionID, adminID, participantID s
 		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
 	}
 
+	previous := participant.Role
 	participant.Role = newRole
+	if err := cm.record(session, participantChanged(participant)); err != nil {
+		participant.Role = previous
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist participant role")
+	}
+	cm.Audit.Record(adminID, audit.ChatRoleChange, sessionID, participantID, previous, newRole)
+	if previous != newRole {
+		cm.announceRole(session, participant)
+	}
 	return nil
 }
 
 // ToggleParticipantPin toggles the pinned status of a participant
 func (cm *ChatManager) ToggleParticipantPin(sessionID, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -173,75 +427,356 @@ func (cm *ChatManager) ToggleParticipantPin(sessionID, participantID string) *ut
 	}
 
 	participant.IsPinned = !participant.IsPinned
+	if err := cm.record(session, participantChanged(participant)); err != nil {
+		participant.IsPinned = !participant.IsPinned
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist participant pin")
+	}
 	return nil
 }
 
-// AddMessage adds a message with support for different types and attachments
-func (cm *ChatManager) AddMessage(sessionID string, message ChatMessage) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+// AddMessage stores a message from a participant and returns it with the
+// ID and timestamp it was given
+func (cm *ChatManager) AddMessage(sessionID string, message ChatMessage) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
-		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
 	// Verify sender is a participant
-	if _, exists := session.Participants[message.SenderID]; !exists {
+	sender, exists := session.Participants[message.SenderID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "sender is not a participant")
+	}
+	if sender.Role == RoleBot {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "bots post through the bot API")
+	}
+
+	return cm.addMessage(session, sender, message)
+}
+
+// CheckSender reports why a user can't post to a session, or nil when they
+// can, so costly uploads are rejected before they're processed
+func (cm *ChatManager) CheckSender(sessionID, senderID string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	sender, exists := session.Participants[senderID]
+	switch {
+	case !exists:
 		return utils.NewErrorResponse(http.StatusForbidden, "sender is not a participant")
+	case sender.Role == RoleBot:
+		return utils.NewErrorResponse(http.StatusForbidden, "bots post through the bot API")
+	case sender.IsMuted:
+		return utils.NewErrorResponse(http.StatusForbidden, "sender is muted")
+	}
+	return nil
+}
+
+// addMessage stores a message from a participant. The session lock must be
+// held.
+func (cm *ChatManager) addMessage(session *ChatSession, sender *Participant, message ChatMessage) (*ChatMessage, *utils.ErrorResponse) {
+	sessionID := session.ID
+	if sender.IsMuted {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "sender is muted")
 	}
 
 	// Verify message type is valid
 	switch message.Type {
 	case TextMessage, ImageMessage, FileMessage, DocumentMessage, EmojiMessage, SystemMessage:
 		// Valid message type
+	case VoiceMessage:
+		if len(message.Attachments) != 1 || message.Attachments[0].Type != VoiceAttachment {
+			return nil, utils.NewErrorResponse(http.StatusBadRequest, "voice messages need a voice attachment")
+		}
+	case LocationMessage:
+		if message.Location == nil {
+			return nil, utils.NewErrorResponse(http.StatusBadRequest, "location messages need a location")
+		}
+		if errResp := message.Location.validate(); errResp != nil {
+			return nil, errResp
+		}
+	case ContactMessage:
+		if message.Contact == nil {
+			return nil, utils.NewErrorResponse(http.StatusBadRequest, "contact messages need a contact")
+		}
+		if errResp := message.Contact.validate(); errResp != nil {
+			return nil, errResp
+		}
 	default:
-		return utils.NewErrorResponse(http.StatusBadRequest, "invalid message type")
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid message type")
+	}
+	if !session.allowsType(message.Type) {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "message type is not allowed in this session")
+	}
+	// Payloads only belong to their own type
+	if message.Type != LocationMessage {
+		message.Location = nil
+	}
+	if message.Type != ContactMessage {
+		message.Contact = nil
 	}
 
-	message.ID = utils.GenerateSessionID()
-	message.Timestamp = utils.GetTimestamp()
+	message.ID = utils.NewID(utils.PrefixMessage)
+	message.Timestamp = utils.Now()
+	if errResp := session.checkSlowMode(sender, message.Timestamp.Time); errResp != nil {
+		return nil, errResp
+	}
+	if reason := cm.SpamFilter.check(session, sender, message.Message, message.Timestamp.Time); reason != "" {
+		if errResp := cm.handleSpam(session, sender, &message, reason); errResp != nil {
+			return nil, errResp
+		}
+	}
+	lastActivity := session.lastActivity
 	session.Messages = append(session.Messages, message)
+	session.revision++
+	session.lastActivity = message.Timestamp.Time
 
-	// Save session after adding message
-	if err := cm.SaveSession(session); err != nil {
-		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist message")
+	if err := cm.record(session, SessionEvent{Type: EventMessageAdded, Message: &message}); err != nil {
+		session.dropLastMessage()
+		session.lastActivity = lastActivity
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist message")
 	}
+	session.noteMessage(sender, message.Timestamp.Time)
 
 	// Send notification
 	cm.Hub.SendNotification(Notification{
 		Type:      MessageNotification,
 		SessionID: sessionID,
+		Summary:   message.Summary(),
 		Data:      message,
 	})
+	cm.publishMessage(sessionID, message)
 
-	return nil
+	if message.Type == TextMessage && cm.Unfurler != nil {
+		if links := unfurl.ExtractLinks(message.Message); len(links) > 0 {
+			go cm.attachPreviews(sessionID, message.ID, links)
+		}
+	}
+	if message.Type == TextMessage && cm.Translator != nil {
+		if targets := session.translationTargets(sender); len(targets) > 0 {
+			go cm.translateMessage(sessionID, message.ID, message.Message, targets)
+		}
+	}
+	if message.Type == TextMessage && cm.Scorer != nil {
+		go cm.analyzeMessage(sessionID, message.ID, sender.ID, message.Message)
+	}
+	if message.Type == TextMessage && sender.Role != RoleBot {
+		cm.dispatchCommand(session, message)
+	}
+
+	return &message, nil
 }
 
-func (cm *ChatManager) GetChatMessages(sessionID string) ([]ChatMessage, *utils.ErrorResponse) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// SystemSenderID is the sender of messages generated by the service
+const SystemSenderID = "system"
+
+// dropLastMessage undoes adding the last message, whose event couldn't be
+// recorded. The session lock must be held.
+func (s *ChatSession) dropLastMessage() {
+	s.Messages = s.Messages[:len(s.Messages)-1]
+	s.revision--
+}
 
-	session, exists := cm.sessions[sessionID]
+// AddSystemMessage posts a system message generated by the service itself
+func (cm *ChatManager) AddSystemMessage(sessionID, text string) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
 
-	return session.Messages, nil
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return cm.addSystemMessage(session, text)
 }
 
-func (cm *ChatManager) GetParticipants(sessionID string) ([]string, *utils.ErrorResponse) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// addSystemMessage posts a system message to a session. The session lock
+// must be held.
+func (cm *ChatManager) addSystemMessage(session *ChatSession, text string) (*ChatMessage, *utils.ErrorResponse) {
+	message := ChatMessage{
+		ID:        utils.NewID(utils.PrefixMessage),
+		SenderID:  SystemSenderID,
+		Type:      SystemMessage,
+		Message:   text,
+		Timestamp: utils.Now(),
+	}
+	session.Messages = append(session.Messages, message)
+	session.revision++
+
+	if err := cm.record(session, SessionEvent{Type: EventMessageAdded, Message: &message}); err != nil {
+		session.dropLastMessage()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist message")
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      MessageNotification,
+		SessionID: session.ID,
+		Summary:   message.Summary(),
+		Data:      message,
+	})
+	cm.publishMessage(session.ID, message)
+
+	return &message, nil
+}
 
-	session, exists := cm.sessions[sessionID]
+// SendAnnouncement posts an announcement from an admin or moderator. It
+// bypasses mute state, can be pinned on send and is delivered as a
+// high priority announcement notification.
+func (cm *ChatManager) SendAnnouncement(sessionID, senderID, text string, pin bool) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
 
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	sender, exists := session.Participants[senderID]
+	if !exists || (sender.Role != RoleAdmin && sender.Role != RoleModerator) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "unauthorized to send announcements")
+	}
+	if text == "" {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "announcement is empty")
+	}
+
+	message := ChatMessage{
+		ID:             utils.NewID(utils.PrefixMessage),
+		SenderID:       senderID,
+		Type:           SystemMessage,
+		Message:        text,
+		Timestamp:      utils.Now(),
+		IsPinned:       pin,
+		IsAnnouncement: true,
+	}
+	session.Messages = append(session.Messages, message)
+	session.revision++
+
+	if err := cm.record(session, SessionEvent{Type: EventMessageAdded, Message: &message}); err != nil {
+		session.dropLastMessage()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist message")
+	}
+
+	cm.Audit.Record(senderID, audit.ChatAnnouncement, sessionID, message.ID, nil, message)
+
+	cm.Hub.SendNotification(Notification{
+		Type:      AnnouncementNotification,
+		SessionID: sessionID,
+		Priority:  HighPriority,
+		Data:      message,
+	})
+	cm.publishMessage(sessionID, message)
+
+	return &message, nil
+}
+
+// publishMessage publishes a message added to a session
+func (cm *ChatManager) publishMessage(sessionID string, message ChatMessage) {
+	cm.Events.Publish(events.MessageCreated, sessionID, map[string]interface{}{
+		"sessionId": sessionID,
+		"message":   message,
+	})
+}
+
+// attachPreviews fetches the previews of the links in a message and
+// broadcasts the updated message once they are ready
+func (cm *ChatManager) attachPreviews(sessionID, messageID string, links []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+
+	var previews []unfurl.Preview
+	for _, link := range links {
+		preview, err := cm.Unfurler.Fetch(ctx, link)
+		if err != nil {
+			continue
+		}
+		previews = append(previews, *preview)
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for i, msg := range session.Messages {
+		if msg.ID != messageID {
+			continue
+		}
+		if msg.IsDeleted {
+			return
+		}
+
+		session.Messages[i].Previews = previews
+		session.revision++
+		if err := cm.record(session, SessionEvent{Type: EventMessageEdited, Message: &session.Messages[i]}); err != nil {
+			log.Printf("Error persisting link previews for message %s: %v\n", messageID, err)
+		}
+
+		cm.Hub.SendNotification(Notification{
+			Type:      MessageUpdateNotification,
+			SessionID: sessionID,
+			Data:      session.Messages[i],
+		})
+		return
+	}
+}
+
+// GetChatMessages returns the transcript of a session with its ETag, which
+// changes whenever a message is added or modified. The messages may be
+// shared with other callers and must not be modified.
+func (cm *ChatManager) GetChatMessages(sessionID string) ([]ChatMessage, string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, "", utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	etag := session.messagesETag()
+	if messages, ok := cm.Cache.transcript(sessionID, etag); ok {
+		return messages, etag, nil
+	}
+
+	// Copied so later messages and edits don't race with the caller
+	messages := make([]ChatMessage, len(session.Messages))
+	copy(messages, session.Messages)
+	cm.Cache.addTranscript(sessionID, etag, messages)
+	return messages, etag, nil
+}
+
+// messagesETag identifies the current transcript by its message count, last
+// message ID and revision. The session lock must be held.
+func (s *ChatSession) messagesETag() string {
+	lastID := ""
+	if len(s.Messages) > 0 {
+		lastID = s.Messages[len(s.Messages)-1].ID
+	}
+	return fmt.Sprintf(`"%d-%s-%d"`, len(s.Messages), lastID, s.revision)
+}
+
+func (cm *ChatManager) GetParticipants(sessionID string) ([]string, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
 	participants := make([]string, 0, len(session.Participants))
 	for id := range session.Participants {
 		participants = append(participants, id)
@@ -250,37 +785,120 @@ func (cm *ChatManager) GetParticipants(sessionID string) ([]string, *utils.Error
 	return participants, nil
 }
 
+// GetRoster returns the participants of a session including their profiles
+func (cm *ChatManager) GetRoster(sessionID string) ([]Participant, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	roster := make([]Participant, 0, len(session.Participants))
+	for _, participant := range session.Participants {
+		roster = append(roster, *participant)
+	}
+
+	return roster, nil
+}
+
+// UpdateParticipantProfile updates the display name, avatar and metadata of a participant
+func (cm *ChatManager) UpdateParticipantProfile(sessionID, participantID string, profile ParticipantProfile) (*Participant, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	participant, exists := session.Participants[participantID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "participant not found")
+	}
+
+	previous := *participant
+	previous.Metadata = utils.CloneMetadata(participant.Metadata)
+	participant.applyProfile(profile)
+	updated := *participant
+	updated.Metadata = utils.CloneMetadata(participant.Metadata)
+
+	if err := cm.record(session, participantChanged(participant)); err != nil {
+		*participant = previous
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist participant profile")
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ParticipantNotification,
+		SessionID: sessionID,
+		Data:      updated,
+	})
+
+	return &updated, nil
+}
+
 func (cm *ChatManager) GetActiveSessions() ([]string, *utils.ErrorResponse) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
 	var activeSessions []string
-	for sessionID, session := range cm.sessions {
-		if time.Now().Before(session.EndTime) {
+	cm.sessions.Range(func(sessionID string, session *ChatSession) bool {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+
+		if time.Now().Before(session.EndTime.Time) {
 			activeSessions = append(activeSessions, sessionID)
 		}
-	}
+		return true
+	})
 
 	return activeSessions, nil
 }
 
-func (cm *ChatManager) TerminateSession(sessionID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// ListSessions returns the active sessions, optionally filtered by tag.
+// Each is encoded while its lock is held, as it keeps changing once listed.
+func (cm *ChatManager) ListSessions(tag string) []json.RawMessage {
+	sessions := []json.RawMessage{}
+	cm.sessions.Range(func(_ string, session *ChatSession) bool {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
 
-	_, exists := cm.sessions[sessionID]
+		if tag != "" && !session.HasTag(tag) {
+			return true
+		}
+		data, err := json.Marshal(session)
+		if err != nil {
+			log.Printf("Error encoding chat session %s: %v\n", session.ID, err)
+			return true
+		}
+		sessions = append(sessions, data)
+		return true
+	})
+
+	return sessions
+}
+
+func (cm *ChatManager) TerminateSession(sessionID string) *utils.ErrorResponse {
+	// Taken out first, so a session is terminated once
+	session, exists := cm.sessions.LoadAndDelete(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
 
-	delete(cm.sessions, sessionID)
+	if err := cm.archive(session); err != nil {
+		log.Printf("Error archiving chat session %s: %v\n", sessionID, err)
+	}
+	session.mu.Lock()
+	if session.expiry != nil {
+		session.expiry.Stop()
+	}
+	session.mu.Unlock()
+	forgetBots(sessionID)
+	cm.Audit.Record(audit.SystemActor, audit.ChatSessionTerminate, sessionID, "", nil, nil)
+	cm.Events.Publish(events.ChatSessionEnded, sessionID, map[string]interface{}{"sessionId": sessionID})
 	return nil
 }
 
 func (cm *ChatManager) AddAttachment(sessionID string, messageID string, attachment Attachment) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -291,6 +909,12 @@ func (cm *ChatManager) AddAttachment(sessionID string, messageID string, attachm
 	for i, msg := range session.Messages {
 		if msg.ID == messageID {
 			session.Messages[i].Attachments = append(session.Messages[i].Attachments, attachment)
+			session.revision++
+			if err := cm.record(session, SessionEvent{Type: EventMessageEdited, Message: &session.Messages[i]}); err != nil {
+				session.Messages[i] = msg
+				session.revision--
+				return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist attachment")
+			}
 			return nil
 		}
 	}
@@ -299,10 +923,7 @@ func (cm *ChatManager) AddAttachment(sessionID string, messageID string, attachm
 }
 
 func (cm *ChatManager) AddReaction(sessionID string, messageID string, reaction Reaction) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -313,6 +934,12 @@ func (cm *ChatManager) AddReaction(sessionID string, messageID string, reaction
 	for i, msg := range session.Messages {
 		if msg.ID == messageID {
 			session.Messages[i].Reactions = append(session.Messages[i].Reactions, reaction)
+			session.revision++
+			if err := cm.record(session, SessionEvent{Type: EventReactionAdded, MessageID: messageID, Reaction: &reaction}); err != nil {
+				session.Messages[i] = msg
+				session.revision--
+				return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist reaction")
+			}
 			return nil
 		}
 	}
@@ -321,10 +948,7 @@ func (cm *ChatManager) AddReaction(sessionID string, messageID string, reaction
 }
 
 func (cm *ChatManager) PinParticipant(sessionID string, participantID string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -338,14 +962,17 @@ func (cm *ChatManager) PinParticipant(sessionID string, participantID string) *u
 	}
 
 	participant.IsPinned = !participant.IsPinned
+	if err := cm.record(session, participantChanged(participant)); err != nil {
+		participant.IsPinned = !participant.IsPinned
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist participant pin")
+	}
 	return nil
 }
 
-func (cm *ChatManager) ModerateParticipant(sessionID string, participantID string, action string) *utils.ErrorResponse {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+// ModerateParticipant mutes, unmutes or removes a participant. The moderator
+// is recorded in the audit log.
+func (cm *ChatManager) ModerateParticipant(sessionID, moderatorID, participantID, action string) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
@@ -353,11 +980,16 @@ func (cm *ChatManager) ModerateParticipant(sessionID string, participantID strin
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	moderator, exists := session.Participants[moderatorID]
+	if !exists || !moderator.isModerator() {
+		return utils.NewErrorResponse(http.StatusForbidden, "unauthorized to moderate participants")
+	}
 	participant, exists := session.Participants[participantID]
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "participant not found")
 	}
 
+	before := *participant
 	switch action {
 	case "mute":
 		participant.IsMuted = true
@@ -369,11 +1001,22 @@ func (cm *ChatManager) ModerateParticipant(sessionID string, participantID strin
 		return utils.NewErrorResponse(http.StatusBadRequest, "invalid moderation action")
 	}
 
-	// Save session after moderation
-	if err := cm.SaveSession(session); err != nil {
+	event := participantChanged(participant)
+	if action == "remove" {
+		event = SessionEvent{Type: EventParticipantChanged, ParticipantID: participantID}
+	}
+	if err := cm.record(session, event); err != nil {
+		*participant = before
+		session.Participants[participantID] = participant
 		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist moderation action")
 	}
 
+	var after interface{}
+	if action != "remove" {
+		after = participant
+	}
+	cm.Audit.Record(moderatorID, audit.ChatModeration, sessionID, participantID, before, after)
+
 	// Send notification
 	cm.Hub.SendNotification(Notification{
 		Type:      ModerationNotification,
@@ -391,42 +1034,98 @@ type UsageMetrics struct {
 	SessionDuration time.Duration
 	MessageCount    int
 	AttachmentSize  int64
+	// ScoredMessages counts the messages rated by the scorer, of which
+	// ToxicMessages were flagged as toxic
+	ScoredMessages int
+	ToxicMessages  int
+	// AverageSentiment averages the SentimentMessages rated for sentiment
+	AverageSentiment  float64
+	SentimentMessages int
 }
 
 func (cm *ChatManager) GetSessionUsage(sessionID string) (*UsageMetrics, *utils.ErrorResponse) {
-	cm.mu.Lock()
-	session, exists := cm.sessions[sessionID]
-	cm.mu.Unlock()
-
+	session, exists := cm.sessions.Load(sessionID)
 	if !exists {
 		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
 	}
 
-	session.mu.Lock()
-	defer session.mu.Unlock()
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	return session.usage(time.Now()), nil
+}
 
+// usage measures the session as of now. The session lock must be held.
+func (s *ChatSession) usage(now time.Time) *UsageMetrics {
 	metrics := &UsageMetrics{
-		SessionDuration: time.Since(session.StartTime),
-		MessageCount:    len(session.Messages),
+		SessionDuration: now.Sub(s.StartTime.Time),
+		MessageCount:    len(s.Messages),
 	}
 
-	for _, msg := range session.Messages {
+	for _, msg := range s.Messages {
 		for _, attachment := range msg.Attachments {
 			metrics.AttachmentSize += attachment.Size
 		}
 	}
+	s.scoreSummary(metrics)
 
-	return metrics, nil
+	return metrics
 }
 
-// Add these methods for persistence
+// sessionsDir is where sessions are snapshotted, one JSON file per session
+var sessionsDir = filepath.Join("data", "sessions")
+
+// SaveSessionJob is the retry job persisting a session whose write failed
+const SaveSessionJob = "chat-session"
+
+// SaveSession snapshots a session. When the write fails and a retry queue
+// is set, the write is queued for a retry instead of failing. Changes that
+// have an event are recorded in the event log instead.
 func (cm *ChatManager) SaveSession(session *ChatSession) error {
 	data, err := json.Marshal(session)
 	if err != nil {
 		return err
 	}
+	// The snapshot covers every logged event, a queued retry writes the
+	// latest state
+	session.pendingEvents = 0
 
-	path := filepath.Join("data", "sessions", session.ID+".json")
+	if err := cm.writeSession(session.ID, data); err != nil {
+		if cm.Retries == nil {
+			return err
+		}
+		log.Printf("Error persisting chat session %s, queued for retry: %v\n", session.ID, err)
+		return cm.Retries.Enqueue(SaveSessionJob, session.ID, data)
+	}
+
+	// A queued write would overwrite this newer state
+	if cm.Retries != nil {
+		cm.Retries.Cancel(SaveSessionJob, session.ID)
+	}
+	return nil
+}
+
+// RetrySave handles SaveSessionJob. Active sessions are written in their
+// current state rather than the one that failed.
+func (cm *ChatManager) RetrySave(sessionID string, data []byte) error {
+	session, exists := cm.sessions.Load(sessionID)
+	if exists {
+		session.mu.RLock()
+		latest, err := json.Marshal(session)
+		session.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		data = latest
+	}
+
+	return cm.writeSession(sessionID, data)
+}
+
+func (cm *ChatManager) writeSession(sessionID string, data []byte) error {
+	cm.Cache.invalidate(sessionID)
+
+	path := filepath.Join(sessionsDir, sessionID+".json")
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
@@ -434,9 +1133,16 @@ func (cm *ChatManager) SaveSession(session *ChatSession) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// CheckStore checks that sessions can be persisted
+func (cm *ChatManager) CheckStore(ctx context.Context) error {
+	return health.WritableDir(sessionsDir)(ctx)
+}
+
 func (cm *ChatManager) LoadSession(sessionID string) (*ChatSession, error) {
-	path := filepath.Join("data", "sessions", sessionID+".json")
-	data, err := os.ReadFile(path)
+	path := filepath.Join(sessionsDir, sessionID+".json")
+	data, err := cm.Cache.load(sessionID, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -446,5 +1152,50 @@ func (cm *ChatManager) LoadSession(sessionID string) (*ChatSession, error) {
 		return nil, err
 	}
 
+	events, err := readEvents(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.replay(events)
+
 	return &session, nil
 }
+
+// RestoreSessions reloads the sessions that were active when the service
+// stopped, with their bots, and returns how many were restored. Sessions
+// whose end passed in the meantime expire right away.
+func (cm *ChatManager) RestoreSessions() int {
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading chat sessions: %v\n", err)
+		}
+		return 0
+	}
+
+	restored := 0
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		session, err := cm.LoadSession(sessionID)
+		if err != nil {
+			log.Printf("Error loading chat session %s: %v\n", sessionID, err)
+			continue
+		}
+		if !session.ArchivedAt.IsZero() {
+			continue
+		}
+		if err := loadBots(session); err != nil {
+			log.Printf("Error loading bots of chat session %s: %v\n", sessionID, err)
+		}
+
+		cm.sessions.Store(sessionID, session)
+		session.mu.Lock()
+		cm.scheduleExpiry(session)
+		session.mu.Unlock()
+		restored++
+	}
+	return restored
+}
@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/coldstorage"
+	"pion-webrtc-microservice/utils"
+)
+
+// coldKey is the key of an archived transcript in cold storage
+func coldKey(sessionID string) string {
+	return "chat/" + sessionID + ".json"
+}
+
+// coldEventsKey is the key of the event log of an archived transcript in
+// cold storage
+func coldEventsKey(sessionID string) string {
+	return "chat/" + sessionID + ".events.jsonl"
+}
+
+// OffloadArchives moves the transcripts of sessions archived before a time
+// to cold storage, leaving stubs with their participants and usage. It
+// returns how many were moved.
+func (cm *ChatManager) OffloadArchives(before time.Time) (int, error) {
+	if cm.ColdStore == nil {
+		return 0, nil
+	}
+
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	offloaded := 0
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		session, err := cm.LoadSession(sessionID)
+		if err != nil || !session.OffloadedAt.IsZero() {
+			continue
+		}
+		if session.ArchivedAt.IsZero() || !coldstorage.Due(session.ArchivedAt.Time, session.RehydratedAt.Time, before) {
+			continue
+		}
+
+		if err := cm.offload(session); err != nil {
+			return offloaded, err
+		}
+		offloaded++
+	}
+
+	return offloaded, nil
+}
+
+// offload copies an archived session and its event log to cold storage and
+// replaces them with a stub
+func (cm *ChatManager) offload(session *ChatSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	// An empty log replaces the one offloaded before, which a compaction
+	// may have dropped since
+	events, err := os.ReadFile(eventLogPath(session.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := cm.ColdStore.Put(coldKey(session.ID), data); err != nil {
+		return err
+	}
+	if err := cm.ColdStore.Put(coldEventsKey(session.ID), events); err != nil {
+		return err
+	}
+
+	stub := &ChatSession{
+		ID:            session.ID,
+		Participants:  session.Participants,
+		StartTime:     session.StartTime,
+		EndTime:       session.EndTime,
+		IsGroup:       session.IsGroup,
+		Metadata:      session.Metadata,
+		Tags:          session.Tags,
+		RetentionDays: session.RetentionDays,
+		ArchivedAt:    session.ArchivedAt,
+		Usage:         session.Usage,
+		OffloadedAt:   utils.Now(),
+		RehydratedAt:  session.RehydratedAt,
+		EventSeq:      session.EventSeq,
+	}
+	if err := cm.SaveSession(stub); err != nil {
+		return err
+	}
+	if err := os.Remove(eventLogPath(session.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fetchOffloaded returns the full session a stub stands for
+func (cm *ChatManager) fetchOffloaded(stub *ChatSession) (*ChatSession, error) {
+	if cm.ColdStore == nil {
+		return nil, errors.New("cold storage is disabled")
+	}
+
+	data, err := cm.ColdStore.Get(coldKey(stub.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	var session ChatSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RehydrateArchive fetches the transcript of an offloaded session back into
+// the primary store. It stays there until it ages out again.
+func (cm *ChatManager) RehydrateArchive(sessionID string) (*ChatSession, *utils.ErrorResponse) {
+	stub, errResp := cm.GetArchivedSession(sessionID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if stub.OffloadedAt.IsZero() {
+		return stub, nil
+	}
+	if cm.ColdStore == nil {
+		return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "cold storage is disabled")
+	}
+
+	session, err := cm.fetchOffloaded(stub)
+	if err != nil {
+		if errors.Is(err, coldstorage.ErrNotFound) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "transcript not found in cold storage")
+		}
+		if errors.Is(err, breaker.ErrOpen) {
+			return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "cold storage is unavailable")
+		}
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to fetch transcript from cold storage")
+	}
+
+	events, err := cm.ColdStore.Get(coldEventsKey(sessionID))
+	if err != nil && !errors.Is(err, coldstorage.ErrNotFound) {
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to fetch transcript from cold storage")
+	}
+	if len(events) > 0 {
+		if err := os.MkdirAll(eventsDir, 0755); err != nil {
+			return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to restore transcript")
+		}
+		if err := os.WriteFile(eventLogPath(sessionID), events, 0644); err != nil {
+			return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to restore transcript")
+		}
+	}
+
+	session.OffloadedAt = utils.Time{}
+	session.RehydratedAt = utils.Now()
+	if err := cm.SaveSession(session); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to restore transcript")
+	}
+
+	return session, nil
+}
@@ -0,0 +1,238 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// draftsDir holds the drafts of each user in <userID>.json, apart from the
+// sessions so they never reach a transcript
+var draftsDir = filepath.Join("data", "drafts")
+
+const (
+	// maxDraftLength bounds the text of a draft, in bytes
+	maxDraftLength = 64 << 10
+	// maxDrafts bounds the drafts a user keeps at once
+	maxDrafts = 200
+)
+
+// Draft is a message a user is composing in a session, shared between their
+// devices
+type Draft struct {
+	SessionID  string `json:"sessionId"`
+	ReceiverID string `json:"receiverId,omitempty"`
+	Text       string `json:"text"`
+	// DeviceID names the device that saved the draft last, so a device can
+	// tell its own changes from those of others
+	DeviceID  string     `json:"deviceId,omitempty"`
+	UpdatedAt utils.Time `json:"updatedAt"`
+	ExpiresAt utils.Time `json:"expiresAt"`
+}
+
+// SaveDraft stores the draft of a participant for a session, replacing the
+// previous one, and notifies their other devices
+func (cm *ChatManager) SaveDraft(userID string, draft Draft) (*Draft, *utils.ErrorResponse) {
+	if len(draft.Text) > maxDraftLength {
+		return nil, utils.NewErrorResponse(http.StatusRequestEntityTooLarge, "draft is too long")
+	}
+
+	session, exists := cm.sessions.Load(draft.SessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	_, isParticipant := session.Participants[userID]
+	session.mu.RUnlock()
+	if !isParticipant {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "user is not a participant")
+	}
+
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	drafts, err := readDrafts(userID)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read drafts")
+	}
+	if _, exists := drafts[draft.SessionID]; !exists && len(drafts) >= maxDrafts {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "too many drafts")
+	}
+
+	draft.UpdatedAt = utils.Now()
+	draft.ExpiresAt = utils.NewTime(draft.UpdatedAt.Add(cm.DraftTTL))
+	drafts[draft.SessionID] = draft
+	if err := writeDrafts(userID, drafts); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to save draft")
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:        DraftNotification,
+		SessionID:   draft.SessionID,
+		RecipientID: userID,
+		Data:        draft,
+	})
+	return &draft, nil
+}
+
+// GetDraft returns the draft of a user for a session
+func (cm *ChatManager) GetDraft(userID, sessionID string) (*Draft, *utils.ErrorResponse) {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	drafts, err := readDrafts(userID)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read drafts")
+	}
+	draft, exists := drafts[sessionID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "draft not found")
+	}
+	return &draft, nil
+}
+
+// ListDrafts returns the drafts of a user, most recently updated first
+func (cm *ChatManager) ListDrafts(userID string) ([]Draft, *utils.ErrorResponse) {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	drafts, err := readDrafts(userID)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read drafts")
+	}
+
+	list := make([]Draft, 0, len(drafts))
+	for _, draft := range drafts {
+		list = append(list, draft)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.After(list[j].UpdatedAt.Time)
+	})
+	return list, nil
+}
+
+// ClearDraft removes the draft of a user for a session, e.g. once it was
+// sent, and notifies their other devices
+func (cm *ChatManager) ClearDraft(userID, sessionID string) *utils.ErrorResponse {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	drafts, err := readDrafts(userID)
+	if err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to read drafts")
+	}
+	if _, exists := drafts[sessionID]; !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "draft not found")
+	}
+	delete(drafts, sessionID)
+	if err := writeDrafts(userID, drafts); err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to clear draft")
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:        DraftNotification,
+		SessionID:   sessionID,
+		RecipientID: userID,
+		Data:        Draft{SessionID: sessionID},
+	})
+	return nil
+}
+
+// PurgeDrafts removes the expired drafts of every user and returns how many
+// were removed
+func (cm *ChatManager) PurgeDrafts() (int, error) {
+	entries, err := os.ReadDir(draftsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	purged := 0
+	for _, entry := range entries {
+		userID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		drafts, err := loadDrafts(userID)
+		if err != nil {
+			return purged, err
+		}
+		expired := dropExpired(drafts)
+		if expired == 0 {
+			continue
+		}
+		if err := writeDrafts(userID, drafts); err != nil {
+			return purged, err
+		}
+		purged += expired
+	}
+	return purged, nil
+}
+
+// eraseDrafts removes every draft of a user and returns how many there were
+func (cm *ChatManager) eraseDrafts(userID string) (int, error) {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	drafts, err := loadDrafts(userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := removeUserFile(draftsDir, userID); err != nil {
+		return 0, err
+	}
+	return len(drafts), nil
+}
+
+// readDrafts returns the unexpired drafts of a user keyed by session ID.
+// userFilesMu must be held.
+func readDrafts(userID string) (map[string]Draft, error) {
+	drafts, err := loadDrafts(userID)
+	if err != nil {
+		return nil, err
+	}
+	dropExpired(drafts)
+	return drafts, nil
+}
+
+// dropExpired removes the expired drafts and returns how many there were
+func dropExpired(drafts map[string]Draft) int {
+	now := time.Now()
+	expired := 0
+	for sessionID, draft := range drafts {
+		if now.After(draft.ExpiresAt.Time) {
+			delete(drafts, sessionID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// loadDrafts returns every stored draft of a user, expired or not.
+// userFilesMu must be held.
+func loadDrafts(userID string) (map[string]Draft, error) {
+	drafts := make(map[string]Draft)
+	if err := readUserFile(draftsDir, userID, &drafts); err != nil {
+		return nil, err
+	}
+	return drafts, nil
+}
+
+// writeDrafts replaces the drafts of a user. userFilesMu must be held.
+func writeDrafts(userID string, drafts map[string]Draft) error {
+	if len(drafts) == 0 {
+		return removeUserFile(draftsDir, userID)
+	}
+	return writeUserFile(draftsDir, userID, drafts)
+}
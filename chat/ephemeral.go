@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// MaxEphemeralTTL bounds how long an ephemeral message is shown
+const MaxEphemeralTTL = 24 * time.Hour
+
+// SendEphemeral sends a message only the receiver sees on behalf of an admin
+// or moderator, e.g. a warning. A zero ttl uses the default of the manager.
+func (cm *ChatManager) SendEphemeral(sessionID, senderID, receiverID, text string, ttl time.Duration) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	sender, exists := session.Participants[senderID]
+	if !exists || (sender.Role != RoleAdmin && sender.Role != RoleModerator) {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "unauthorized to send ephemeral messages")
+	}
+	return cm.sendEphemeral(session, senderID, receiverID, text, ttl)
+}
+
+// PostBotEphemeral sends a message only the receiver sees from the bot a
+// token belongs to, e.g. the response to a command
+func (cm *ChatManager) PostBotEphemeral(sessionID, token, receiverID, text string, ttl time.Duration) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	sender, errResp := session.authenticateBot(token)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if sender.IsMuted {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "sender is muted")
+	}
+	return cm.sendEphemeral(session, sender.ID, receiverID, text, ttl)
+}
+
+// sendEphemeral delivers a message to the chat sockets of the receiver
+// only. It isn't added to the transcript, logged or indexed, so it's gone
+// for clients that aren't connected. The session lock must be held.
+func (cm *ChatManager) sendEphemeral(session *ChatSession, senderID, receiverID, text string, ttl time.Duration) (*ChatMessage, *utils.ErrorResponse) {
+	if _, exists := session.Participants[receiverID]; !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "receiver is not a participant")
+	}
+	if text == "" {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "message is required")
+	}
+	if ttl == 0 {
+		ttl = cm.EphemeralTTL
+	}
+	if ttl < 0 || ttl > MaxEphemeralTTL {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "ttl must lie between 0 and 24h")
+	}
+
+	now := utils.GetTimestamp()
+	expiresAt := utils.NewTime(now.Add(ttl))
+	message := ChatMessage{
+		ID:          utils.NewID(utils.PrefixMessage),
+		SenderID:    senderID,
+		ReceiverID:  receiverID,
+		Type:        TextMessage,
+		Message:     text,
+		Timestamp:   utils.NewTime(now),
+		IsEphemeral: true,
+		ExpiresAt:   &expiresAt,
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:        EphemeralNotification,
+		SessionID:   session.ID,
+		RecipientID: receiverID,
+		Data:        message,
+	})
+	return &message, nil
+}
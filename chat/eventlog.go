@@ -0,0 +1,236 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// eventsDir holds the event log of each session, one JSON event per line.
+// The session file is a snapshot, the log is replayed from its EventSeq.
+var eventsDir = filepath.Join(sessionsDir, "events")
+
+// SessionEventType is the kind of change a SessionEvent describes
+type SessionEventType string
+
+const (
+	EventMessageAdded  SessionEventType = "message-added"
+	EventMessageEdited SessionEventType = "message-edited"
+	// EventMessageDeleted removes the content of a message, the message
+	// itself stays as a tombstone
+	EventMessageDeleted SessionEventType = "message-deleted"
+	EventReactionAdded  SessionEventType = "reaction-added"
+	// EventParticipantChanged replaces a participant, or removes it when
+	// Participant is nil
+	EventParticipantChanged SessionEventType = "participant-changed"
+)
+
+// SessionEvent is a change of a session in its event log
+type SessionEvent struct {
+	Seq       uint64           `json:"seq"`
+	Type      SessionEventType `json:"type"`
+	Timestamp utils.Time       `json:"timestamp"`
+	// Message is the added message or a message as edited
+	Message       *ChatMessage `json:"message,omitempty"`
+	MessageID     string       `json:"messageId,omitempty"`
+	Reaction      *Reaction    `json:"reaction,omitempty"`
+	ParticipantID string       `json:"participantId,omitempty"`
+	Participant   *Participant `json:"participant,omitempty"`
+}
+
+func eventLogPath(sessionID string) string {
+	return filepath.Join(eventsDir, sessionID+".jsonl")
+}
+
+// record appends an event describing a change made to a session, which is
+// snapshotted once SnapshotEvery events were logged since the last snapshot.
+// When the append fails the session is snapshotted instead. An error means
+// the change was neither logged nor snapshotted, and the caller undoes it.
+// The session lock must be held.
+func (cm *ChatManager) record(session *ChatSession, event SessionEvent) error {
+	session.EventSeq++
+	event.Seq = session.EventSeq
+	event.Timestamp = utils.Now()
+
+	if err := appendEvent(session.ID, event); err != nil {
+		log.Printf("Error logging %s event of chat session %s, snapshotting instead: %v\n", event.Type, session.ID, err)
+		if err := cm.SaveSession(session); err != nil {
+			session.EventSeq--
+			return err
+		}
+		cm.indexEvent(session, event)
+		return nil
+	}
+	cm.indexEvent(session, event)
+
+	session.pendingEvents++
+	if session.pendingEvents >= cm.SnapshotEvery {
+		// The event is logged, a failed snapshot only leaves more to replay
+		if err := cm.SaveSession(session); err != nil {
+			log.Printf("Error snapshotting chat session %s: %v\n", session.ID, err)
+		}
+	}
+	return nil
+}
+
+func appendEvent(sessionID string, event SessionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(eventsDir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(eventLogPath(sessionID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readEvents reads the event log of a session. An event cut short by a
+// crash while it was written ends the log.
+func readEvents(sessionID string) ([]SessionEvent, error) {
+	file, err := os.Open(eventLogPath(sessionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []SessionEvent
+	decoder := json.NewDecoder(file)
+	for {
+		var event SessionEvent
+		err := decoder.Decode(&event)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+}
+
+// compactEvents snapshots a session and drops its event log, for changes
+// such as erasures that must not survive in the history. The session lock
+// must be held.
+func (cm *ChatManager) compactEvents(session *ChatSession) error {
+	cm.indexMessages(session, session.Messages...)
+	if err := cm.SaveSession(session); err != nil {
+		return err
+	}
+	if err := os.Remove(eventLogPath(session.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// replay applies the events logged after the snapshot of a session
+func (s *ChatSession) replay(events []SessionEvent) {
+	for _, event := range events {
+		if event.Seq <= s.EventSeq {
+			continue
+		}
+		s.apply(event)
+		s.EventSeq = event.Seq
+	}
+}
+
+// apply makes the change described by an event
+func (s *ChatSession) apply(event SessionEvent) {
+	switch event.Type {
+	case EventMessageAdded:
+		if event.Message != nil {
+			s.Messages = append(s.Messages, *event.Message)
+		}
+
+	case EventMessageEdited:
+		if event.Message == nil {
+			return
+		}
+		if msg := s.message(event.Message.ID); msg != nil {
+			*msg = *event.Message
+		}
+
+	case EventMessageDeleted:
+		if msg := s.message(event.MessageID); msg != nil {
+			msg.Message = ""
+			msg.Attachments = nil
+			msg.Previews = nil
+			msg.Location = nil
+			msg.Contact = nil
+			msg.Translations = nil
+			msg.IsDeleted = true
+		}
+
+	case EventReactionAdded:
+		if msg := s.message(event.MessageID); msg != nil && event.Reaction != nil {
+			msg.Reactions = append(msg.Reactions, *event.Reaction)
+		}
+
+	case EventParticipantChanged:
+		if event.Participant == nil {
+			delete(s.Participants, event.ParticipantID)
+			return
+		}
+		if s.Participants == nil {
+			s.Participants = make(map[string]*Participant)
+		}
+		s.Participants[event.ParticipantID] = event.Participant
+	}
+}
+
+// participantChanged is the event of a participant whose role, profile or
+// state changed
+func participantChanged(participant *Participant) SessionEvent {
+	return SessionEvent{Type: EventParticipantChanged, ParticipantID: participant.ID, Participant: participant}
+}
+
+// message returns a message of the session by ID, nil when there is none
+func (s *ChatSession) message(messageID string) *ChatMessage {
+	for i := range s.Messages {
+		if s.Messages[i].ID == messageID {
+			return &s.Messages[i]
+		}
+	}
+	return nil
+}
+
+// GetSessionEvents returns the events of an active or archived session
+// logged after a sequence number, for replaying its history
+func (cm *ChatManager) GetSessionEvents(sessionID string, since uint64) ([]SessionEvent, *utils.ErrorResponse) {
+	if _, err := os.Stat(filepath.Join(sessionsDir, filepath.Base(sessionID)+".json")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+		}
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read chat session events")
+	}
+
+	logged, err := readEvents(filepath.Base(sessionID))
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read chat session events")
+	}
+
+	events := []SessionEvent{}
+	for _, event := range logged {
+		if event.Seq > since {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
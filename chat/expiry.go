@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"log"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// scheduleExpiry arms the timer of a session for its next expiry warning,
+// or its end when no warning is left. The session lock must be held.
+func (cm *ChatManager) scheduleExpiry(session *ChatSession) {
+	if session.expiry != nil {
+		session.expiry.Stop()
+	}
+
+	now := time.Now()
+	next := session.EndTime.Time
+	for _, remaining := range cm.SystemMessages.ExpiryWarnings {
+		if at := session.EndTime.Add(-remaining); at.After(now) && at.Before(next) {
+			next = at
+		}
+	}
+
+	sessionID := session.ID
+	session.expiry = time.AfterFunc(next.Sub(now), func() {
+		cm.expire(sessionID)
+	})
+}
+
+// expire handles the timer of a session: it warns of the coming end, or
+// at the end extends a session that is still in use and terminates the
+// others
+func (cm *ChatManager) expire(sessionID string) {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return
+	}
+
+	session.mu.Lock()
+	now := time.Now()
+	if now.Before(session.EndTime.Time) {
+		cm.warnExpiry(session, session.EndTime.Sub(now))
+		cm.scheduleExpiry(session)
+		session.mu.Unlock()
+		return
+	}
+	if cm.extend(session, now) {
+		cm.scheduleExpiry(session)
+		session.mu.Unlock()
+		return
+	}
+	session.mu.Unlock()
+
+	cm.TerminateSession(sessionID)
+}
+
+// warnExpiry tells the participants how long a session has left, rounded
+// up to the warning that is due. The session lock must be held.
+func (cm *ChatManager) warnExpiry(session *ChatSession, left time.Duration) {
+	var due time.Duration
+	for _, remaining := range cm.SystemMessages.ExpiryWarnings {
+		if remaining >= left && (due == 0 || remaining < due) {
+			due = remaining
+		}
+	}
+	if due == 0 {
+		return
+	}
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ExpiryNotification,
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"endTime":          session.EndTime,
+			"remainingSeconds": int(due.Seconds()),
+		},
+	})
+	cm.announceExpiry(session, due)
+}
+
+// extend moves the end of a session with a message within the last
+// IdleExpiry to IdleExpiry after that message, at most MaxDuration after
+// its start. It reports whether the session was extended. The session lock
+// must be held.
+func (cm *ChatManager) extend(session *ChatSession, now time.Time) bool {
+	if cm.IdleExpiry <= 0 || session.lastActivity.IsZero() {
+		return false
+	}
+	end := session.lastActivity.Add(cm.IdleExpiry)
+	if cm.MaxDuration > 0 {
+		if limit := session.StartTime.Add(cm.MaxDuration); end.After(limit) {
+			end = limit
+		}
+	}
+	if !end.After(now) {
+		return false
+	}
+
+	session.EndTime = utils.NewTime(end)
+	if err := cm.SaveSession(session); err != nil {
+		log.Printf("Error persisting extension of chat session %s: %v\n", session.ID, err)
+	}
+	cm.Hub.SendNotification(Notification{
+		Type:      ExpiryNotification,
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"endTime":  session.EndTime,
+			"extended": true,
+		},
+	})
+	return true
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"net/http"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// SetLocked locks or unlocks the membership of a session so no new
+// participants are added. Only admins may do this and current participants
+// are unaffected.
+func (cm *ChatManager) SetLocked(sessionID, adminID string, locked bool) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return utils.NewErrorResponse(http.StatusForbidden, "unauthorized to lock the session")
+	}
+
+	previous := session.IsLocked
+	session.IsLocked = locked
+	if err := cm.SaveSession(session); err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist lock state")
+	}
+	cm.Audit.Record(adminID, audit.ChatLock, sessionID, "", previous, locked)
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ModerationNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"action": "lock",
+			"locked": locked,
+		},
+	})
+
+	return nil
+}
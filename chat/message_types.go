@@ -1,6 +1,16 @@
 package chat
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"pion-webrtc-microservice/utils"
+)
 
 type AttachmentType string
 type ReactionType string
@@ -9,6 +19,7 @@ const (
 	ImageAttachment    AttachmentType = "image"
 	DocumentAttachment AttachmentType = "document"
 	FileAttachment     AttachmentType = "file"
+	VoiceAttachment    AttachmentType = "voice"
 
 	EmojiReaction ReactionType = "emoji"
 	RaiseHand     ReactionType = "raise_hand"
@@ -20,11 +31,129 @@ type Attachment struct {
 	Name        string         `json:"name"`
 	Size        int64          `json:"size"`
 	ContentType string         `json:"contentType"`
+	ScanStatus  string         `json:"scanStatus,omitempty"`
+	Width       int            `json:"width,omitempty"`
+	Height      int            `json:"height,omitempty"`
+	Thumbnails  []Thumbnail    `json:"thumbnails,omitempty"`
+	// DurationMs and Waveform describe voice attachments. The waveform holds
+	// the peak level of equal slices of the audio, from 0 to 100.
+	DurationMs int64 `json:"durationMs,omitempty"`
+	Waveform   []int `json:"waveform,omitempty"`
+}
+
+// Thumbnail is a downscaled preview of an image attachment
+type Thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
 type Reaction struct {
 	Type      ReactionType `json:"type"`
 	Content   string       `json:"content"`
 	UserID    string       `json:"userId"`
-	Timestamp time.Time    `json:"timestamp"`
+	Timestamp utils.Time   `json:"timestamp"`
+}
+
+// Location is a place shared in a location message
+type Location struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+	// Accuracy is the radius of uncertainty in meters, zero when unknown
+	Accuracy float64 `json:"accuracy,omitempty"`
+	// Label names the place, e.g. "Office"
+	Label   string `json:"label,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// maxAccuracy bounds the accuracy of a location, in meters
+const maxAccuracy = 100000
+
+func (l *Location) validate() *utils.ErrorResponse {
+	switch {
+	case math.IsNaN(l.Latitude) || l.Latitude < -90 || l.Latitude > 90:
+		return utils.NewErrorResponse(http.StatusBadRequest, "latitude must lie between -90 and 90")
+	case math.IsNaN(l.Longitude) || l.Longitude < -180 || l.Longitude > 180:
+		return utils.NewErrorResponse(http.StatusBadRequest, "longitude must lie between -180 and 180")
+	case math.IsNaN(l.Accuracy) || l.Accuracy < 0 || l.Accuracy > maxAccuracy:
+		return utils.NewErrorResponse(http.StatusBadRequest, "accuracy must lie between 0 and 100000 meters")
+	case len(l.Label) > 200 || len(l.Address) > 500:
+		return utils.NewErrorResponse(http.StatusBadRequest, "location label or address is too long")
+	}
+	return nil
+}
+
+// Contact is a contact card shared in a contact message, with the fields
+// of a vCard clients commonly show
+type Contact struct {
+	// Name is the formatted name of the contact (FN)
+	Name         string   `json:"name"`
+	Phones       []string `json:"phones,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	// UserID links the contact to a user of the service
+	UserID string `json:"userId,omitempty"`
+}
+
+// phonePattern accepts international and local phone numbers with common
+// separators
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()./-]{1,30}$`)
+
+func (c *Contact) validate() *utils.ErrorResponse {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" || len(c.Name) > 200 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "contact name is required and at most 200 bytes")
+	}
+	if len(c.Phones) == 0 && len(c.Emails) == 0 && c.UserID == "" {
+		return utils.NewErrorResponse(http.StatusBadRequest, "contact needs a phone, email or userId")
+	}
+	if len(c.Phones) > 10 || len(c.Emails) > 10 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "contact has too many phones or emails")
+	}
+	for _, phone := range c.Phones {
+		if !phonePattern.MatchString(phone) {
+			return utils.NewErrorResponse(http.StatusBadRequest, "invalid contact phone")
+		}
+	}
+	for _, email := range c.Emails {
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			return utils.NewErrorResponse(http.StatusBadRequest, "invalid contact email")
+		}
+	}
+	if c.URL != "" {
+		if target, err := url.Parse(c.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return utils.NewErrorResponse(http.StatusBadRequest, "invalid contact URL")
+		}
+	}
+	if len(c.Organization) > 200 || len(c.Title) > 200 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "contact organization or title is too long")
+	}
+	return nil
+}
+
+// Summary renders a message as a line of text, for notifications shown
+// without rendering the message itself
+func (m ChatMessage) Summary() string {
+	switch m.Type {
+	case LocationMessage:
+		if m.Location == nil {
+			break
+		}
+		if m.Location.Label != "" {
+			return "📍 " + m.Location.Label
+		}
+		return fmt.Sprintf("📍 %.5f, %.5f", m.Location.Latitude, m.Location.Longitude)
+	case ContactMessage:
+		if m.Contact != nil {
+			return "👤 " + m.Contact.Name
+		}
+	case VoiceMessage:
+		if len(m.Attachments) > 0 {
+			seconds := m.Attachments[0].DurationMs / 1000
+			return fmt.Sprintf("🎤 Voice message (%d:%02d)", seconds/60, seconds%60)
+		}
+	}
+	return m.Message
 }
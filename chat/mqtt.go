@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/mqtt"
+)
+
+// mqttBuffer is the number of notifications waiting for the broker before
+// new ones are dropped
+const mqttBuffer = 1024
+
+// TenantMetadataKey is the session metadata entry naming the tenant a
+// session's MQTT topics belong to
+const TenantMetadataKey = "tenant"
+
+// mqttTopics maps notification types to the last level of their topic
+var mqttTopics = map[NotificationType]string{
+	MessageNotification:       "messages",
+	MessageUpdateNotification: "messages",
+	AnnouncementNotification:  "messages",
+	ReactionNotification:      "reactions",
+	TypingNotification:        "typing",
+	ReceiptNotification:       "receipts",
+	ParticipantNotification:   "participants",
+	ModerationNotification:    "participants",
+	RecordingNotification:     "recording",
+	ExpiryNotification:        "expiry",
+}
+
+// mqttLevel replaces the characters that would split or wildcard a topic
+// level
+var mqttLevel = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// BridgeMQTT publishes every session notification to the topic
+// [prefix/]tenants/{tenant}/sessions/{id}/{kind}, e.g.
+// tenants/acme/sessions/chat_123/messages, so consumers can follow sessions
+// without holding a WebSocket. The broker sits behind a circuit breaker,
+// notifications are dropped while it is unavailable.
+func (cm *ChatManager) BridgeMQTT(client *mqtt.Client, b *breaker.Breaker, cfg config.MQTTConfig) {
+	notifications := make(chan Notification, mqttBuffer)
+	cm.Hub.Subscribe(notifications)
+
+	go func() {
+		for notification := range notifications {
+			// Topics are shared by every consumer of the session
+			if notification.RecipientID != "" {
+				continue
+			}
+			payload, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("Error encoding %s notification for MQTT: %v\n", notification.Type, err)
+				continue
+			}
+
+			topic := cm.mqttTopic(notification, cfg)
+			err = b.Do(func() error { return client.Publish(topic, payload, byte(cfg.QoS)) })
+			if err != nil && !errors.Is(err, breaker.ErrOpen) {
+				log.Printf("Error publishing %s notification to MQTT: %v\n", notification.Type, err)
+			}
+		}
+	}()
+}
+
+// mqttTopic returns the topic of a notification
+func (cm *ChatManager) mqttTopic(notification Notification, cfg config.MQTTConfig) string {
+	kind, ok := mqttTopics[notification.Type]
+	if !ok {
+		kind = string(notification.Type)
+	}
+
+	topic := "tenants/" + mqttLevel.Replace(cm.tenantOf(notification.SessionID, cfg.DefaultTenant)) +
+		"/sessions/" + mqttLevel.Replace(notification.SessionID) + "/" + kind
+	if cfg.TopicPrefix != "" {
+		topic = strings.TrimSuffix(cfg.TopicPrefix, "/") + "/" + topic
+	}
+	return topic
+}
+
+// tenantOf returns the tenant in the metadata of a session, or fallback
+func (cm *ChatManager) tenantOf(sessionID, fallback string) string {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return fallback
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	if tenant, ok := session.Metadata[TenantMetadataKey].(string); ok && tenant != "" {
+		return tenant
+	}
+	return fallback
+}
@@ -1,6 +1,8 @@
 package chat
 
 import (
+	"encoding/json"
+	"log"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -9,16 +11,43 @@ import (
 type NotificationType string
 
 const (
-	ReactionNotification    NotificationType = "reaction"
-	ModerationNotification  NotificationType = "moderation"
-	MessageNotification     NotificationType = "message"
-	ParticipantNotification NotificationType = "participant"
+	ReactionNotification      NotificationType = "reaction"
+	ModerationNotification    NotificationType = "moderation"
+	MessageNotification       NotificationType = "message"
+	MessageUpdateNotification NotificationType = "message_update"
+	ParticipantNotification   NotificationType = "participant"
+	AnnouncementNotification  NotificationType = "announcement"
+	RecordingNotification     NotificationType = "recording"
+	TypingNotification        NotificationType = "typing"
+	ReceiptNotification       NotificationType = "receipt"
+	LayoutNotification        NotificationType = "layout"
+	SpeakingNotification      NotificationType = "speaking"
+	VoicemailNotification     NotificationType = "voicemail"
+	TransferNotification      NotificationType = "transfer"
+	RingNotification          NotificationType = "ring"
+	EphemeralNotification     NotificationType = "ephemeral"
+	DraftNotification         NotificationType = "draft"
+	SpotlightNotification     NotificationType = "spotlight"
+	// ExpiryNotification counts down to the end of a session and announces
+	// its extensions
+	ExpiryNotification NotificationType = "expiry"
 )
 
+// HighPriority marks notifications clients should surface immediately
+const HighPriority = "high"
+
 type Notification struct {
 	Type      NotificationType `json:"type"`
 	SessionID string           `json:"sessionId"`
-	Data      interface{}      `json:"data"`
+	Priority  string           `json:"priority,omitempty"`
+	// RecipientID restricts a notification to the chat sockets of a user.
+	// Such notifications skip the notification sockets, which don't know
+	// their user.
+	RecipientID string `json:"recipientId,omitempty"`
+	// Summary renders the message of a message notification as a line of
+	// text, e.g. "📍 Office" for a location
+	Summary string      `json:"summary,omitempty"`
+	Data    interface{} `json:"data"`
 }
 
 type NotificationHub struct {
@@ -26,18 +55,45 @@ type NotificationHub struct {
 	Broadcast  chan Notification
 	Register   chan *websocket.Conn
 	Unregister chan *websocket.Conn
-	mu         sync.Mutex
+	// subscribers receive notifications on a channel instead of a connection
+	// owned by the hub
+	subscribers map[chan Notification]bool
+	mu          sync.Mutex
 }
 
 func NewNotificationHub() *NotificationHub {
 	return &NotificationHub{
-		clients:    make(map[string]*websocket.Conn),
-		Broadcast:  make(chan Notification),
-		Register:   make(chan *websocket.Conn),
-		Unregister: make(chan *websocket.Conn),
+		clients:     make(map[string]*websocket.Conn),
+		Broadcast:   make(chan Notification),
+		Register:    make(chan *websocket.Conn),
+		Unregister:  make(chan *websocket.Conn),
+		subscribers: make(map[chan Notification]bool),
 	}
 }
 
+// Subscribe delivers every notification to a channel. A subscriber whose
+// channel is full misses notifications rather than stalling the hub.
+func (h *NotificationHub) Subscribe(ch chan Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[ch] = true
+}
+
+// Unsubscribe stops delivering notifications to a channel
+func (h *NotificationHub) Unsubscribe(ch chan Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// ConnectionCount returns the number of notification sockets and chat
+// sockets attached to the hub
+func (h *NotificationHub) ConnectionCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) + len(h.subscribers)
+}
+
 func (h *NotificationHub) Run() {
 	for {
 		select {
@@ -55,14 +111,28 @@ func (h *NotificationHub) Run() {
 			h.mu.Unlock()
 
 		case notification := <-h.Broadcast:
+			data, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("Error encoding %s notification: %v\n", notification.Type, err)
+				continue
+			}
 			h.mu.Lock()
 			for _, client := range h.clients {
-				err := client.WriteJSON(notification)
+				if notification.RecipientID != "" {
+					continue
+				}
+				err := client.WriteMessage(websocket.TextMessage, data)
 				if err != nil {
 					client.Close()
 					delete(h.clients, client.RemoteAddr().String())
 				}
 			}
+			for ch := range h.subscribers {
+				select {
+				case ch <- notification:
+				default:
+				}
+			}
 			h.mu.Unlock()
 		}
 	}
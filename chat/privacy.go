@@ -0,0 +1,240 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// ErasedUserID replaces the ID of an erased user in the messages they sent
+const ErasedUserID = "erased-user"
+
+// ErasureReport summarizes the data removed for a user
+type ErasureReport struct {
+	UserID   string `json:"userId"`
+	Sessions int    `json:"sessions"`
+	Messages int    `json:"messages"`
+	Drafts   int    `json:"drafts"`
+	Starred  int    `json:"starred"`
+	// Attachments lists the files of the erased messages so their storage
+	// can be removed by the caller
+	Attachments []Attachment `json:"-"`
+}
+
+// EraseUser redacts the messages and reactions of a user and removes them
+// from the participants of every active and persisted session
+func (cm *ChatManager) EraseUser(userID string) (*ErasureReport, *utils.ErrorResponse) {
+	if userID == "" {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "userID is required")
+	}
+
+	report := &ErasureReport{UserID: userID}
+	err := cm.visitSessions(func(session *ChatSession) bool {
+		return session.eraseUser(userID, report)
+	})
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to erase user data")
+	}
+	if report.Drafts, err = cm.eraseDrafts(userID); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to erase user data")
+	}
+	if report.Starred, err = cm.eraseStarred(userID); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to erase user data")
+	}
+
+	return report, nil
+}
+
+// visitSessions calls visit for every active and persisted session with the
+// session lock held. Sessions for which visit returns true are saved and
+// their event log dropped, so the history holds nothing visit removed.
+func (cm *ChatManager) visitSessions(visit func(session *ChatSession) bool) error {
+	active := make(map[string]*ChatSession)
+	cm.sessions.Range(func(id string, session *ChatSession) bool {
+		active[id] = session
+		return true
+	})
+
+	for _, session := range active {
+		session.mu.Lock()
+		var err error
+		if visit(session) {
+			err = cm.compactEvents(session)
+		}
+		session.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sessions that already ended only exist on disk
+	files, err := os.ReadDir(sessionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		sessionID, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || active[sessionID] != nil {
+			continue
+		}
+
+		session, err := cm.LoadSession(sessionID)
+		if err != nil {
+			return err
+		}
+
+		// Offloaded transcripts are visited in cold storage and offloaded
+		// again when changed
+		if !session.OffloadedAt.IsZero() {
+			full, err := cm.fetchOffloaded(session)
+			if err != nil {
+				return err
+			}
+			if visit(full) {
+				cm.indexMessages(full, full.Messages...)
+				if err := cm.offload(full); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if visit(session) {
+			if err := cm.compactEvents(session); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// eraseUser removes a user from the session and reports whether anything
+// changed. The session lock must be held.
+func (s *ChatSession) eraseUser(userID string, report *ErasureReport) bool {
+	changed := false
+	if _, exists := s.Participants[userID]; exists {
+		delete(s.Participants, userID)
+		changed = true
+	}
+	delete(s.lastMessageAt, userID)
+	delete(s.spamHistory, userID)
+
+	for i := range s.Messages {
+		msg := &s.Messages[i]
+
+		if msg.SenderID == userID {
+			report.Messages++
+			report.Attachments = append(report.Attachments, msg.Attachments...)
+			msg.SenderID = ErasedUserID
+			msg.Message = ""
+			msg.Attachments = nil
+			msg.Previews = nil
+			msg.Location = nil
+			msg.Contact = nil
+			msg.IsDeleted = true
+			changed = true
+		}
+		if msg.ReceiverID == userID {
+			msg.ReceiverID = ErasedUserID
+			changed = true
+		}
+
+		reactions := msg.Reactions[:0]
+		for _, reaction := range msg.Reactions {
+			if reaction.UserID != userID {
+				reactions = append(reactions, reaction)
+			}
+		}
+		if len(reactions) != len(msg.Reactions) {
+			changed = true
+		}
+		msg.Reactions = reactions
+	}
+
+	if changed {
+		s.revision++
+		report.Sessions++
+	}
+	return changed
+}
+
+// SessionSummary describes a session a user took part in
+type SessionSummary struct {
+	ID        string          `json:"id"`
+	IsGroup   bool            `json:"isGroup"`
+	Role      ParticipantRole `json:"role,omitempty"`
+	JoinTime  utils.Time      `json:"joinTime"`
+	StartTime utils.Time      `json:"startTime"`
+	EndTime   utils.Time      `json:"endTime"`
+}
+
+// ExportedMessage is a message sent by a user along with its session
+type ExportedMessage struct {
+	SessionID string `json:"sessionId"`
+	ChatMessage
+}
+
+// UserExport holds the chat data of a single user
+type UserExport struct {
+	Sessions []SessionSummary  `json:"sessions"`
+	Messages []ExportedMessage `json:"messages"`
+	Drafts   []Draft           `json:"drafts"`
+	// Starred lists the messages the user starred, without their content
+	Starred []StarredMessage `json:"starred"`
+}
+
+// ExportUser collects the sessions a user joined and the messages they sent
+// from every active and persisted session
+func (cm *ChatManager) ExportUser(userID string) (*UserExport, error) {
+	export := &UserExport{Sessions: []SessionSummary{}, Messages: []ExportedMessage{}}
+	err := cm.visitSessions(func(session *ChatSession) bool {
+		joined := false
+		summary := SessionSummary{
+			ID:        session.ID,
+			IsGroup:   session.IsGroup,
+			StartTime: session.StartTime,
+			EndTime:   session.EndTime,
+		}
+		if participant, exists := session.Participants[userID]; exists {
+			joined = true
+			summary.Role = participant.Role
+			summary.JoinTime = participant.JoinTime
+		}
+
+		for _, msg := range session.Messages {
+			if msg.SenderID == userID {
+				joined = true
+				export.Messages = append(export.Messages, ExportedMessage{SessionID: session.ID, ChatMessage: msg})
+			}
+		}
+
+		if joined {
+			export.Sessions = append(export.Sessions, summary)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cm.userFilesMu.Lock()
+	drafts, err := readDrafts(userID)
+	if err == nil {
+		export.Starred = []StarredMessage{}
+		err = readUserFile(starredDir, userID, &export.Starred)
+	}
+	cm.userFilesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	export.Drafts = make([]Draft, 0, len(drafts))
+	for _, draft := range drafts {
+		export.Drafts = append(export.Drafts, draft)
+	}
+
+	return export, nil
+}
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/search"
+	"pion-webrtc-microservice/utils"
+)
+
+// indexEvent hands the message an event added, edited or deleted to the
+// search index. The session lock must be held.
+func (cm *ChatManager) indexEvent(session *ChatSession, event SessionEvent) {
+	switch event.Type {
+	case EventMessageAdded, EventMessageEdited:
+		cm.indexMessages(session, *event.Message)
+	case EventMessageDeleted:
+		if msg := session.message(event.MessageID); msg != nil {
+			cm.indexMessages(session, *msg)
+		}
+	}
+}
+
+// indexMessages hands messages to the search index, findable by the current
+// participants of the session. The session lock must be held.
+func (cm *ChatManager) indexMessages(session *ChatSession, messages ...ChatMessage) {
+	if cm.Search == nil || len(messages) == 0 {
+		return
+	}
+
+	participants := make([]string, 0, len(session.Participants))
+	for id := range session.Participants {
+		participants = append(participants, id)
+	}
+
+	docs := make([]search.Document, 0, len(messages))
+	for _, msg := range messages {
+		docs = append(docs, search.Document{
+			MessageID:    msg.ID,
+			SessionID:    session.ID,
+			SenderID:     msg.SenderID,
+			ReceiverID:   msg.ReceiverID,
+			Participants: participants,
+			Type:         string(msg.Type),
+			Text:         msg.Message,
+			Timestamp:    msg.Timestamp,
+			Deleted:      msg.IsDeleted,
+		})
+	}
+	cm.Search.Index(docs...)
+}
+
+// SearchMessages searches the messages of every session a user took part
+// in, or of a single session, best matches first
+func (cm *ChatManager) SearchMessages(ctx context.Context, query search.Query) ([]search.Hit, *utils.ErrorResponse) {
+	if cm.Search == nil {
+		return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "search is disabled")
+	}
+
+	hits, err := cm.Search.Search(ctx, query)
+	if err != nil {
+		if errors.Is(err, breaker.ErrOpen) {
+			return nil, utils.NewErrorResponse(http.StatusServiceUnavailable, "search is unavailable")
+		}
+		return nil, utils.NewErrorResponse(http.StatusBadGateway, "failed to search messages")
+	}
+	return hits, nil
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/utils"
+)
+
+// isModerator reports whether the participant is exempt from rate limits
+func (p *Participant) isModerator() bool {
+	return p.Role == RoleAdmin || p.Role == RoleModerator
+}
+
+// checkSlowMode enforces the minimum interval between messages of a
+// participant. The session lock must be held.
+func (s *ChatSession) checkSlowMode(sender *Participant, now time.Time) *utils.ErrorResponse {
+	if s.SlowModeSeconds <= 0 || sender.isModerator() || sender.Role == RoleBot {
+		return nil
+	}
+
+	interval := time.Duration(s.SlowModeSeconds) * time.Second
+	if last, ok := s.lastMessageAt[sender.ID]; ok {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return utils.NewRetryErrorResponse(http.StatusTooManyRequests, "slow mode is enabled", wait)
+		}
+	}
+	return nil
+}
+
+// noteMessage starts the slow mode interval of a participant whose message
+// was accepted. The session lock must be held.
+func (s *ChatSession) noteMessage(sender *Participant, at time.Time) {
+	if s.lastMessageAt == nil {
+		s.lastMessageAt = make(map[string]time.Time)
+	}
+	s.lastMessageAt[sender.ID] = at
+}
+
+// SetSlowMode changes the slow mode interval of a session, zero disables it
+func (cm *ChatManager) SetSlowMode(sessionID, adminID string, seconds int) *utils.ErrorResponse {
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+	}
+	if seconds < 0 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid slow mode interval")
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	admin, exists := session.Participants[adminID]
+	if !exists || admin.Role != RoleAdmin {
+		return utils.NewErrorResponse(http.StatusForbidden, "unauthorized to change slow mode")
+	}
+
+	previous := session.SlowModeSeconds
+	session.SlowModeSeconds = seconds
+	if err := cm.SaveSession(session); err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist slow mode")
+	}
+	cm.Audit.Record(adminID, audit.ChatSlowMode, sessionID, "", previous, seconds)
+
+	cm.Hub.SendNotification(Notification{
+		Type:      ModerationNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"action":          "slow_mode",
+			"intervalSeconds": seconds,
+		},
+	})
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frames a client sends over a chat socket
+const (
+	SocketAuth        = "auth"
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketMessage     = "message"
+	SocketTyping      = "typing"
+	SocketReceipt     = "receipt"
+)
+
+// Frames the server answers with. Notifications of subscribed sessions are
+// sent as they are on the notification socket.
+const (
+	SocketAuthOK     = "auth-ok"
+	SocketSubscribed = "subscribed"
+	SocketAck        = "ack"
+	SocketError      = "error"
+)
+
+// socketBuffer is the number of notifications queued for a socket before
+// further ones are dropped
+const socketBuffer = 64
+
+// SocketAuthConfig verifies the users of chat sockets with the HS256 tokens
+// of the signaling handshake. An empty secret trusts the userID query
+// parameter.
+type SocketAuthConfig struct {
+	Secret  string
+	Timeout time.Duration
+}
+
+// socketFrame is a frame sent by a client, fields are used by type
+type socketFrame struct {
+	Type        string      `json:"type"`
+	ID          string      `json:"id"`
+	Token       string      `json:"token"`
+	SessionID   string      `json:"sessionId"`
+	ReceiverID  string      `json:"receiverId"`
+	Message     string      `json:"message"`
+	MessageType MessageType `json:"messageType"`
+	MessageID   string      `json:"messageId"`
+	Typing      bool        `json:"typing"`
+	Status      string      `json:"status"`
+	Location    *Location   `json:"location"`
+	Contact     *Contact    `json:"contact"`
+}
+
+// socketReply answers a client frame
+type socketReply struct {
+	Type       string       `json:"type"`
+	ID         string       `json:"id,omitempty"`
+	UserID     string       `json:"userId,omitempty"`
+	SessionID  string       `json:"sessionId,omitempty"`
+	Message    *ChatMessage `json:"message,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	RetryAfter int          `json:"retryAfter,omitempty"`
+}
+
+// chatSocket is the connection of a user sending and receiving chat over a
+// single WebSocket
+type chatSocket struct {
+	conn     *websocket.Conn
+	userID   string
+	sessions map[string]bool // subscribed session IDs
+	mu       sync.Mutex      // guards sessions
+	writeMu  sync.Mutex
+}
+
+func (s *chatSocket) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *chatSocket) subscribed(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[sessionID]
+}
+
+// ServeSocket serves a chat socket until it closes. After the handshake the
+// user subscribes to the sessions they take part in, then sends messages,
+// typing events and read receipts and receives the notifications of those
+// sessions on the same connection. A socket authenticated before the
+// upgrade skips the handshake and serves the requested user.
+func (cm *ChatManager) ServeSocket(conn *websocket.Conn, requestedUserID string, authenticated bool) {
+	defer conn.Close()
+
+	userID := requestedUserID
+	if !authenticated {
+		var err error
+		if userID, err = cm.authenticateSocket(conn, requestedUserID); err != nil {
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+			return
+		}
+	}
+
+	socket := &chatSocket{conn: conn, userID: userID, sessions: make(map[string]bool)}
+	if err := socket.write(socketReply{Type: SocketAuthOK, UserID: userID}); err != nil {
+		return
+	}
+
+	notifications := make(chan Notification, socketBuffer)
+	cm.Hub.Subscribe(notifications)
+	done := make(chan struct{})
+	defer func() {
+		cm.Hub.Unsubscribe(notifications)
+		close(done)
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case notification := <-notifications:
+				if notification.RecipientID != "" {
+					if notification.RecipientID != socket.userID {
+						continue
+					}
+				} else if !socket.subscribed(notification.SessionID) {
+					continue
+				}
+				if err := socket.write(notification); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var frame socketFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				_ = socket.write(socketReply{Type: SocketError, Error: "invalid frame"})
+				continue
+			}
+			return
+		}
+
+		reply := cm.handleSocketFrame(socket, frame)
+		reply.ID = frame.ID
+		if err := socket.write(reply); err != nil {
+			log.Printf("Error answering chat socket of %s: %v\n", userID, err)
+			return
+		}
+	}
+}
+
+// authenticateSocket returns the user of a new socket, reading the auth
+// frame when a secret is configured
+func (cm *ChatManager) authenticateSocket(conn *websocket.Conn, requestedUserID string) (string, error) {
+	if cm.SocketAuth.Secret == "" {
+		if requestedUserID == "" {
+			return "", errors.New("userID is required")
+		}
+		return requestedUserID, nil
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(cm.SocketAuth.Timeout)); err != nil {
+		return "", err
+	}
+	var frame socketFrame
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != SocketAuth {
+		return "", errors.New("expected an auth frame")
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+
+	userID, err := utils.VerifyToken([]byte(cm.SocketAuth.Secret), frame.Token, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if requestedUserID != "" && requestedUserID != userID {
+		return "", errors.New("userID doesn't match the token")
+	}
+	return userID, nil
+}
+
+// handleSocketFrame acts on a client frame on behalf of the socket's user
+func (cm *ChatManager) handleSocketFrame(socket *chatSocket, frame socketFrame) socketReply {
+	switch frame.Type {
+	case SocketSubscribe:
+		participants, errResp := cm.GetParticipants(frame.SessionID)
+		if errResp != nil {
+			return errorReply(errResp)
+		}
+		for _, participantID := range participants {
+			if participantID == socket.userID {
+				socket.mu.Lock()
+				socket.sessions[frame.SessionID] = true
+				socket.mu.Unlock()
+				return socketReply{Type: SocketSubscribed, SessionID: frame.SessionID}
+			}
+		}
+		return errorReply(utils.NewErrorResponse(http.StatusForbidden, "not a participant of the session"))
+
+	case SocketUnsubscribe:
+		socket.mu.Lock()
+		delete(socket.sessions, frame.SessionID)
+		socket.mu.Unlock()
+		return socketReply{Type: SocketAck, SessionID: frame.SessionID}
+
+	case SocketMessage:
+		messageType := frame.MessageType
+		if messageType == "" {
+			messageType = TextMessage
+		}
+		message, errResp := cm.AddMessage(frame.SessionID, ChatMessage{
+			SenderID:   socket.userID,
+			ReceiverID: frame.ReceiverID,
+			Message:    frame.Message,
+			Type:       messageType,
+			Location:   frame.Location,
+			Contact:    frame.Contact,
+		})
+		if errResp != nil {
+			return errorReply(errResp)
+		}
+		return socketReply{Type: SocketAck, SessionID: frame.SessionID, Message: message}
+
+	case SocketTyping, SocketReceipt:
+		if !socket.subscribed(frame.SessionID) {
+			return errorReply(utils.NewErrorResponse(http.StatusForbidden, "not subscribed to the session"))
+		}
+
+		notification := Notification{
+			Type:      TypingNotification,
+			SessionID: frame.SessionID,
+			Data:      map[string]interface{}{"userId": socket.userID, "typing": frame.Typing},
+		}
+		if frame.Type == SocketReceipt {
+			if frame.MessageID == "" || (frame.Status != "delivered" && frame.Status != "read") {
+				return errorReply(utils.NewErrorResponse(http.StatusBadRequest, "a messageId and a delivered or read status are required"))
+			}
+			notification.Type = ReceiptNotification
+			notification.Data = map[string]interface{}{"userId": socket.userID, "messageId": frame.MessageID, "status": frame.Status}
+		}
+		cm.Hub.SendNotification(notification)
+		return socketReply{Type: SocketAck, SessionID: frame.SessionID}
+	}
+
+	return errorReply(utils.NewErrorResponse(http.StatusBadRequest, "unknown frame type"))
+}
+
+func errorReply(errResp *utils.ErrorResponse) socketReply {
+	return socketReply{Type: SocketError, Error: errResp.Message, RetryAfter: errResp.RetryAfter}
+}
@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/unfurl"
+	"pion-webrtc-microservice/utils"
+)
+
+// Spam actions applied to offenders
+const (
+	SpamActionMute = "mute"
+	SpamActionFlag = "flag"
+)
+
+// sentMessage is a message remembered for spam detection
+type sentMessage struct {
+	text string
+	at   time.Time
+}
+
+// SpamFilter detects duplicate messages, bursts and link flooding
+type SpamFilter struct {
+	cfg config.SpamConfig
+}
+
+// NewSpamFilter creates a SpamFilter, a zero threshold disables that check
+func NewSpamFilter(cfg config.SpamConfig) *SpamFilter {
+	return &SpamFilter{cfg: cfg}
+}
+
+// check records the message and returns why it is considered spam, or an
+// empty string. The session lock must be held.
+func (f *SpamFilter) check(session *ChatSession, sender *Participant, text string, now time.Time) string {
+	if f == nil || sender.isModerator() || sender.Role == RoleBot {
+		return ""
+	}
+
+	if f.cfg.MaxLinks > 0 && unfurl.CountLinks(text) > f.cfg.MaxLinks {
+		return "too many links"
+	}
+
+	window := max(f.cfg.DuplicateWindow, f.cfg.BurstWindow)
+	var history []sentMessage
+	for _, sent := range session.spamHistory[sender.ID] {
+		if now.Sub(sent.at) < window {
+			history = append(history, sent)
+		}
+	}
+	normalized := strings.ToLower(strings.TrimSpace(text))
+	history = append(history, sentMessage{text: normalized, at: now})
+	if session.spamHistory == nil {
+		session.spamHistory = make(map[string][]sentMessage)
+	}
+	session.spamHistory[sender.ID] = history
+
+	var duplicates, burst int
+	for _, sent := range history {
+		if normalized != "" && sent.text == normalized && now.Sub(sent.at) < f.cfg.DuplicateWindow {
+			duplicates++
+		}
+		if now.Sub(sent.at) < f.cfg.BurstWindow {
+			burst++
+		}
+	}
+
+	switch {
+	case f.cfg.DuplicateLimit > 0 && duplicates > f.cfg.DuplicateLimit:
+		return "duplicate messages"
+	case f.cfg.BurstLimit > 0 && burst > f.cfg.BurstLimit:
+		return "message burst"
+	}
+	return ""
+}
+
+// handleSpam applies the configured action to an offender and notifies the
+// moderators. It returns an error when the message must be rejected. The
+// session lock must be held.
+func (cm *ChatManager) handleSpam(session *ChatSession, sender *Participant, message *ChatMessage, reason string) *utils.ErrorResponse {
+	action := cm.SpamFilter.cfg.Action
+	before := *sender
+	sender.IsFlagged = true
+	if action == SpamActionMute {
+		sender.IsMuted = true
+	} else {
+		message.IsFlagged = true
+	}
+
+	if err := cm.record(session, participantChanged(sender)); err != nil {
+		*sender = before
+		message.IsFlagged = false
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist moderation action")
+	}
+	cm.Audit.Record(audit.SystemActor, audit.ChatModeration, session.ID, sender.ID, before, sender)
+
+	cm.notifyModerators(session, Notification{
+		Type:      ModerationNotification,
+		SessionID: session.ID,
+		Data: map[string]interface{}{
+			"participantId": sender.ID,
+			"action":        "spam_" + action,
+			"reason":        reason,
+		},
+	})
+
+	// Warn the offender without telling the rest of the session
+	warning := "Your message was flagged as spam (" + reason + ")"
+	if action == SpamActionMute {
+		warning = "Your message was rejected as spam (" + reason + ") and you were muted"
+	}
+	cm.sendEphemeral(session, SystemSenderID, sender.ID, warning, 0)
+
+	if action == SpamActionMute {
+		return utils.NewErrorResponse(http.StatusForbidden, "message rejected as spam")
+	}
+	return nil
+}
+
+// notifyModerators sends a notification to the admins and moderators of a
+// session only. The session lock must be held.
+func (cm *ChatManager) notifyModerators(session *ChatSession, notification Notification) {
+	for _, participant := range session.Participants {
+		if !participant.isModerator() {
+			continue
+		}
+		notification.RecipientID = participant.ID
+		cm.Hub.SendNotification(notification)
+	}
+}
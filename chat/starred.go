@@ -0,0 +1,205 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// starredDir holds the starred messages of each user in <userID>.json
+var starredDir = filepath.Join("data", "starred")
+
+// StarredMessage is a message a user saved for later
+type StarredMessage struct {
+	SessionID string     `json:"sessionId"`
+	MessageID string     `json:"messageId"`
+	StarredAt utils.Time `json:"starredAt"`
+	// Message is the message as it is now, nil when it was deleted or its
+	// session was offloaded to cold storage
+	Message *ChatMessage `json:"message,omitempty"`
+}
+
+// StarredPage is a page of the starred messages of a user
+type StarredPage struct {
+	Messages []StarredMessage `json:"messages"`
+	// Total is the number of starred messages matching the query
+	Total int `json:"total"`
+}
+
+// StarMessage saves a message of an active or archived session for a
+// participant. Starring a starred message again keeps it where it is.
+func (cm *ChatManager) StarMessage(userID, sessionID, messageID string) (*StarredMessage, *utils.ErrorResponse) {
+	message, errResp := cm.messageFor(userID, sessionID, messageID)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	var starred []StarredMessage
+	if err := readUserFile(starredDir, userID, &starred); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read starred messages")
+	}
+	for _, entry := range starred {
+		if entry.SessionID == sessionID && entry.MessageID == messageID {
+			entry.Message = message
+			return &entry, nil
+		}
+	}
+	if len(starred) >= cm.MaxStarred {
+		return nil, utils.NewErrorResponse(http.StatusConflict, "too many starred messages")
+	}
+
+	entry := StarredMessage{
+		SessionID: sessionID,
+		MessageID: messageID,
+		StarredAt: utils.Now(),
+	}
+	// Newest first
+	starred = append([]StarredMessage{entry}, starred...)
+	if err := writeUserFile(starredDir, userID, starred); err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to star message")
+	}
+
+	entry.Message = message
+	return &entry, nil
+}
+
+// UnstarMessage removes a message from the starred messages of a user
+func (cm *ChatManager) UnstarMessage(userID, sessionID, messageID string) *utils.ErrorResponse {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	var starred []StarredMessage
+	if err := readUserFile(starredDir, userID, &starred); err != nil {
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to read starred messages")
+	}
+	for i, entry := range starred {
+		if entry.SessionID != sessionID || entry.MessageID != messageID {
+			continue
+		}
+		starred = append(starred[:i], starred[i+1:]...)
+		var err error
+		if len(starred) == 0 {
+			err = removeUserFile(starredDir, userID)
+		} else {
+			err = writeUserFile(starredDir, userID, starred)
+		}
+		if err != nil {
+			return utils.NewErrorResponse(http.StatusInternalServerError, "failed to unstar message")
+		}
+		return nil
+	}
+	return utils.NewErrorResponse(http.StatusNotFound, "message is not starred")
+}
+
+// ListStarred returns a page of the starred messages of a user, most
+// recently starred first, optionally only those of one session
+func (cm *ChatManager) ListStarred(userID, sessionID string, offset, limit int) (*StarredPage, *utils.ErrorResponse) {
+	cm.userFilesMu.Lock()
+	var starred []StarredMessage
+	err := readUserFile(starredDir, userID, &starred)
+	cm.userFilesMu.Unlock()
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to read starred messages")
+	}
+
+	if sessionID != "" {
+		matching := starred[:0]
+		for _, entry := range starred {
+			if entry.SessionID == sessionID {
+				matching = append(matching, entry)
+			}
+		}
+		starred = matching
+	}
+
+	page := &StarredPage{Messages: []StarredMessage{}, Total: len(starred)}
+	if offset >= len(starred) {
+		return page, nil
+	}
+	page.Messages = starred[offset:min(offset+limit, len(starred))]
+
+	// Each archived session is read once for the page
+	loaded := make(map[string]*ChatSession)
+	for i := range page.Messages {
+		page.Messages[i].Message = cm.currentMessage(page.Messages[i].SessionID, page.Messages[i].MessageID, loaded)
+	}
+	return page, nil
+}
+
+// messageFor returns a copy of a message of an active or persisted session
+// a user takes part in
+func (cm *ChatManager) messageFor(userID, sessionID, messageID string) (*ChatMessage, *utils.ErrorResponse) {
+	session, exists := cm.sessions.Load(sessionID)
+	if exists {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+	} else {
+		var err error
+		if session, err = cm.LoadSession(filepath.Base(sessionID)); err != nil {
+			if os.IsNotExist(err) {
+				return nil, utils.NewErrorResponse(http.StatusNotFound, "chat session not found")
+			}
+			return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to load chat session")
+		}
+	}
+
+	if _, exists := session.Participants[userID]; !exists {
+		return nil, utils.NewErrorResponse(http.StatusForbidden, "user is not a participant")
+	}
+	msg := session.message(messageID)
+	if msg == nil || msg.IsDeleted {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "message not found")
+	}
+	message := *msg
+	return &message, nil
+}
+
+// currentMessage returns a copy of a message of an active or persisted
+// session, nil when it is gone. Persisted sessions are kept in loaded.
+func (cm *ChatManager) currentMessage(sessionID, messageID string, loaded map[string]*ChatSession) *ChatMessage {
+	session, exists := cm.sessions.Load(sessionID)
+
+	var msg *ChatMessage
+	if exists {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+		msg = session.message(messageID)
+	} else {
+		session, exists = loaded[sessionID]
+		if !exists {
+			// A session that can't be read shows its messages as gone
+			session, _ = cm.LoadSession(filepath.Base(sessionID))
+			loaded[sessionID] = session
+		}
+		if session != nil {
+			msg = session.message(messageID)
+		}
+	}
+
+	if msg == nil || msg.IsDeleted {
+		return nil
+	}
+	message := *msg
+	return &message
+}
+
+// eraseStarred removes the starred messages of a user and returns how many
+// there were
+func (cm *ChatManager) eraseStarred(userID string) (int, error) {
+	cm.userFilesMu.Lock()
+	defer cm.userFilesMu.Unlock()
+
+	var starred []StarredMessage
+	if err := readUserFile(starredDir, userID, &starred); err != nil {
+		return 0, err
+	}
+	if err := removeUserFile(starredDir, userID); err != nil {
+		return 0, err
+	}
+	return len(starred), nil
+}
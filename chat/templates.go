@@ -0,0 +1,233 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// templatesPath holds every session template
+var templatesPath = filepath.Join("data", "templates.json")
+
+// SessionTemplate holds reusable settings sessions are created from
+type SessionTemplate struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// DefaultRole is the role of the participants besides the creator, who
+	// is always an admin. Empty means RoleUser.
+	DefaultRole ParticipantRole `json:"defaultRole,omitempty"`
+	// Roles overrides DefaultRole for participants by ID
+	Roles           map[string]ParticipantRole `json:"roles,omitempty"`
+	SlowModeSeconds int                        `json:"slowModeSeconds,omitempty"`
+	// RetentionDays is how long sessions are kept once archived, zero keeps
+	// them forever
+	RetentionDays int `json:"retentionDays,omitempty"`
+	// AllowedTypes limits the message types participants can send, empty
+	// allows every type
+	AllowedTypes []MessageType `json:"allowedTypes,omitempty"`
+	// WelcomeMessage is posted as a system message when a session starts
+	WelcomeMessage string     `json:"welcomeMessage,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	CreatedAt      utils.Time `json:"createdAt"`
+	UpdatedAt      utils.Time `json:"updatedAt"`
+}
+
+// validate checks the roles and message types of a template
+func (t *SessionTemplate) validate() *utils.ErrorResponse {
+	if t.Name == "" {
+		return utils.NewErrorResponse(http.StatusBadRequest, "template name is required")
+	}
+	if t.SlowModeSeconds < 0 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid slow mode interval")
+	}
+	if t.RetentionDays < 0 {
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid retention")
+	}
+	if t.DefaultRole != "" && t.DefaultRole != RoleModerator && t.DefaultRole != RoleUser {
+		return utils.NewErrorResponse(http.StatusBadRequest, "default role must be moderator or user")
+	}
+	for _, role := range t.Roles {
+		if role != RoleAdmin && role != RoleModerator && role != RoleUser {
+			return utils.NewErrorResponse(http.StatusBadRequest, "invalid role")
+		}
+	}
+	for _, messageType := range t.AllowedTypes {
+		switch messageType {
+		case TextMessage, ImageMessage, FileMessage, DocumentMessage, EmojiMessage, VoiceMessage, LocationMessage, ContactMessage:
+		default:
+			return utils.NewErrorResponse(http.StatusBadRequest, "invalid allowed message type")
+		}
+	}
+	t.Tags = utils.NormalizeTags(t.Tags)
+	return nil
+}
+
+// roleFor returns the role a template gives a participant who isn't the
+// creator
+func (t *SessionTemplate) roleFor(participantID string) ParticipantRole {
+	if role, exists := t.Roles[participantID]; exists {
+		return role
+	}
+	if t.DefaultRole != "" {
+		return t.DefaultRole
+	}
+	return RoleUser
+}
+
+// allowsType reports whether participants of the session can send messages
+// of a type. System messages are always allowed.
+func (s *ChatSession) allowsType(messageType MessageType) bool {
+	if len(s.AllowedTypes) == 0 || messageType == SystemMessage {
+		return true
+	}
+	for _, allowed := range s.AllowedTypes {
+		if allowed == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTemplates reads the stored session templates and returns how many
+// there are
+func (cm *ChatManager) LoadTemplates() (int, error) {
+	data, err := os.ReadFile(templatesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	templates := make(map[string]*SessionTemplate)
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return 0, err
+	}
+
+	cm.templatesMu.Lock()
+	cm.templates = templates
+	cm.templatesMu.Unlock()
+	return len(templates), nil
+}
+
+// CreateTemplate stores a new session template
+func (cm *ChatManager) CreateTemplate(template SessionTemplate) (*SessionTemplate, *utils.ErrorResponse) {
+	if errResp := template.validate(); errResp != nil {
+		return nil, errResp
+	}
+	template.ID = utils.NewID(utils.PrefixTemplate)
+	template.CreatedAt = utils.Now()
+	template.UpdatedAt = template.CreatedAt
+
+	cm.templatesMu.Lock()
+	defer cm.templatesMu.Unlock()
+
+	if cm.templates == nil {
+		cm.templates = make(map[string]*SessionTemplate)
+	}
+	cm.templates[template.ID] = &template
+	if err := cm.saveTemplates(); err != nil {
+		delete(cm.templates, template.ID)
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist session template")
+	}
+	return &template, nil
+}
+
+// UpdateTemplate replaces the settings of a session template. Sessions
+// created from it before keep the settings they got.
+func (cm *ChatManager) UpdateTemplate(templateID string, template SessionTemplate) (*SessionTemplate, *utils.ErrorResponse) {
+	if errResp := template.validate(); errResp != nil {
+		return nil, errResp
+	}
+
+	cm.templatesMu.Lock()
+	defer cm.templatesMu.Unlock()
+
+	previous, exists := cm.templates[templateID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "session template not found")
+	}
+	template.ID = templateID
+	template.CreatedAt = previous.CreatedAt
+	template.UpdatedAt = utils.Now()
+
+	cm.templates[templateID] = &template
+	if err := cm.saveTemplates(); err != nil {
+		cm.templates[templateID] = previous
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist session template")
+	}
+	return &template, nil
+}
+
+// DeleteTemplate removes a session template
+func (cm *ChatManager) DeleteTemplate(templateID string) *utils.ErrorResponse {
+	cm.templatesMu.Lock()
+	defer cm.templatesMu.Unlock()
+
+	template, exists := cm.templates[templateID]
+	if !exists {
+		return utils.NewErrorResponse(http.StatusNotFound, "session template not found")
+	}
+
+	delete(cm.templates, templateID)
+	if err := cm.saveTemplates(); err != nil {
+		cm.templates[templateID] = template
+		return utils.NewErrorResponse(http.StatusInternalServerError, "failed to persist session template")
+	}
+	return nil
+}
+
+// GetTemplate returns a session template
+func (cm *ChatManager) GetTemplate(templateID string) (*SessionTemplate, *utils.ErrorResponse) {
+	cm.templatesMu.RLock()
+	defer cm.templatesMu.RUnlock()
+
+	template, exists := cm.templates[templateID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "session template not found")
+	}
+	copied := *template
+	return &copied, nil
+}
+
+// ListTemplates returns the session templates sorted by name
+func (cm *ChatManager) ListTemplates() []SessionTemplate {
+	cm.templatesMu.RLock()
+	defer cm.templatesMu.RUnlock()
+
+	templates := make([]SessionTemplate, 0, len(cm.templates))
+	for _, template := range cm.templates {
+		templates = append(templates, *template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// saveTemplates writes every template. templatesMu must be held.
+func (cm *ChatManager) saveTemplates() error {
+	data, err := json.Marshal(cm.templates)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(templatesPath), 0755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed, so a crash never leaves a truncated file
+	tmp := templatesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, templatesPath); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
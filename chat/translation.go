@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"pion-webrtc-microservice/translate"
+)
+
+// translationTimeout bounds translating a message into all target languages
+const translationTimeout = 30 * time.Second
+
+// translationTargets returns the preferred languages of the participants
+// other than the sender's own. The session lock must be held.
+func (s *ChatSession) translationTargets(sender *Participant) []string {
+	var targets []string
+	for _, participant := range s.Participants {
+		language := participant.Language
+		if language == "" || slices.Contains(targets, language) {
+			continue
+		}
+		if sender.Language != "" && translate.SameLanguage(language, sender.Language) {
+			continue
+		}
+		targets = append(targets, language)
+	}
+	return targets
+}
+
+// translateMessage translates a text message into the target languages and
+// caches the translations on the message. Languages the text is already
+// written in are skipped.
+func (cm *ChatManager) translateMessage(sessionID, messageID, text string, targets []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), translationTimeout)
+	defer cancel()
+
+	translations := make(map[string]string)
+	for _, target := range targets {
+		translated, source, err := cm.Translator.Translate(ctx, text, target)
+		if err != nil {
+			log.Printf("Error translating message %s to %s: %v\n", messageID, target, err)
+			continue
+		}
+		if source != "" && translate.SameLanguage(source, target) {
+			continue
+		}
+		translations[target] = translated
+	}
+	if len(translations) == 0 {
+		return
+	}
+
+	session, exists := cm.sessions.Load(sessionID)
+	if !exists {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for i, msg := range session.Messages {
+		if msg.ID != messageID {
+			continue
+		}
+		// Edited or deleted in the meantime, the translations are stale
+		if msg.IsDeleted || msg.Message != text {
+			return
+		}
+
+		// Notifications share the map, so it's replaced rather than updated
+		for language, translated := range msg.Translations {
+			if _, ok := translations[language]; !ok {
+				translations[language] = translated
+			}
+		}
+		session.Messages[i].Translations = translations
+		session.revision++
+		if err := cm.record(session, SessionEvent{Type: EventMessageEdited, Message: &session.Messages[i]}); err != nil {
+			log.Printf("Error persisting translations for message %s: %v\n", messageID, err)
+		}
+
+		cm.Hub.SendNotification(Notification{
+			Type:      MessageUpdateNotification,
+			SessionID: sessionID,
+			Data:      session.Messages[i],
+		})
+		return
+	}
+}
+
+// InLanguage returns a copy of messages whose text is replaced by its
+// translation into language, where one was made
+func InLanguage(messages []ChatMessage, language string) []ChatMessage {
+	language = translate.Normalize(language)
+	translated := slices.Clone(messages)
+	for i := range translated {
+		if text, ok := translated[i].Translations[language]; ok {
+			translated[i].Message = text
+		}
+	}
+	return translated
+}
@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Data private to a user, such as drafts, is kept in one JSON file per user
+// below a directory apart from the sessions
+
+func userFilePath(dir, userID string) string {
+	return filepath.Join(dir, filepath.Base(userID)+".json")
+}
+
+// readUserFile decodes the file of a user into v, which is left untouched
+// when the user has none
+func readUserFile(dir, userID string, v interface{}) error {
+	data, err := os.ReadFile(userFilePath(dir, userID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeUserFile replaces the file of a user with v
+func writeUserFile(dir, userID string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed, so a crash never leaves a truncated file
+	path := userFilePath(dir, userID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeUserFile removes the file of a user, if any
+func removeUserFile(dir, userID string) error {
+	if err := os.Remove(userFilePath(dir, userID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
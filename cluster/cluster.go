@@ -0,0 +1,219 @@
+// Package cluster lets several instances of the service run side by side.
+// Every call is owned by one node: new calls are placed on the node a
+// consistent hash ring of the configured nodes maps their ID to, and a node
+// that drains hands its calls over to the next nodes on the ring. Nodes find
+// a call by asking its ring owner first and the other nodes after that.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SecretHeader carries the shared secret on requests between nodes
+const SecretHeader = "X-Cluster-Secret"
+
+// virtualNodes is the number of points every node has on the ring, which
+// spreads the calls evenly
+const virtualNodes = 64
+
+// ErrNotFound is returned when no node owns a call
+var ErrNotFound = errors.New("call not found on any node")
+
+// Node is an instance of the service
+type Node struct {
+	ID string `json:"id"`
+	// URL is where the node serves the API, e.g. http://10.0.0.2:8001
+	URL string `json:"url"`
+}
+
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+// Cluster knows the nodes of the cluster and talks to them
+type Cluster struct {
+	self   Node
+	nodes  map[string]Node
+	ring   []ringPoint
+	secret string
+	client *http.Client
+}
+
+// New creates the Cluster described by the configuration, or returns nil
+// when no nodes are configured and the service runs alone
+func New(cfg config.ClusterConfig) (*Cluster, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, nil
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("a cluster secret is required")
+	}
+
+	c := &Cluster{
+		nodes:  make(map[string]Node, len(cfg.Nodes)),
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+	for _, entry := range cfg.Nodes {
+		id, url, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || url == "" {
+			return nil, fmt.Errorf("invalid node %q, expected id=url", entry)
+		}
+		c.nodes[id] = Node{ID: id, URL: strings.TrimSuffix(url, "/")}
+		for i := 0; i < virtualNodes; i++ {
+			c.ring = append(c.ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(id + "#" + strconv.Itoa(i))), node: id})
+		}
+	}
+	self, exists := c.nodes[cfg.NodeID]
+	if !exists {
+		return nil, fmt.Errorf("node %q is not one of the cluster nodes", cfg.NodeID)
+	}
+	c.self = self
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i].hash < c.ring[j].hash })
+
+	return c, nil
+}
+
+// Self returns the node the service runs as
+func (c *Cluster) Self() Node {
+	return c.self
+}
+
+// Successors returns the nodes in the order a key maps to them on the ring,
+// its owner first
+func (c *Cluster) Successors(key string) []Node {
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= hash })
+
+	nodes := make([]Node, 0, len(c.nodes))
+	seen := make(map[string]bool, len(c.nodes))
+	for i := 0; i < len(c.ring) && len(nodes) < len(c.nodes); i++ {
+		point := c.ring[(start+i)%len(c.ring)]
+		if !seen[point.node] {
+			seen[point.node] = true
+			nodes = append(nodes, c.nodes[point.node])
+		}
+	}
+	return nodes
+}
+
+// Owns reports whether the ring maps a key to this node
+func (c *Cluster) Owns(key string) bool {
+	return c.Successors(key)[0].ID == c.self.ID
+}
+
+// Locate finds the node owning a call, asking the ring owner first since
+// calls are only found elsewhere after a handover
+func (c *Cluster) Locate(ctx context.Context, sessionID string) (Node, error) {
+	for _, node := range c.Successors(sessionID) {
+		if node.ID == c.self.ID {
+			continue
+		}
+		owned, err := c.owns(ctx, node, sessionID)
+		if err != nil {
+			// An unreachable node is skipped, the call may be elsewhere
+			continue
+		}
+		if owned {
+			return node, nil
+		}
+	}
+	return Node{}, ErrNotFound
+}
+
+// owns asks a node whether it owns a call
+func (c *Cluster) owns(ctx context.Context, node Node, sessionID string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.URL+"/v1/cluster/calls/"+sessionID, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("node %s answered %d", node.ID, resp.StatusCode)
+	}
+}
+
+// HandOver passes the state of a call to the next node on the ring that
+// adopts it and returns that node
+func (c *Cluster) HandOver(ctx context.Context, sessionID string, state []byte) (Node, error) {
+	var errs []error
+	for _, node := range c.Successors(sessionID) {
+		if node.ID == c.self.ID {
+			continue
+		}
+		if err := c.adopt(ctx, node, state); err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.ID, err))
+			continue
+		}
+		return node, nil
+	}
+	if len(errs) == 0 {
+		return Node{}, errors.New("no other node to hand over to")
+	}
+	return Node{}, errors.Join(errs...)
+}
+
+// adopt posts the state of a call to a node
+func (c *Cluster) adopt(ctx context.Context, node Node, state []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL+"/v1/cluster/calls", bytes.NewReader(state))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("answered %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (c *Cluster) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(SecretHeader, c.secret)
+	return c.client.Do(req)
+}
+
+// Middleware rejects requests that don't carry the cluster secret, guarding
+// the routes only nodes call
+func (c *Cluster) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if !hmac.Equal([]byte(ctx.Request().Header.Get(SecretHeader)), []byte(c.secret)) {
+				errResp := utils.NewErrorResponse(http.StatusUnauthorized, "invalid cluster secret")
+				return ctx.JSON(errResp.StatusCode, errResp)
+			}
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package coldstorage moves old archived records out of the primary store
+// into cheaper storage, from where they are fetched back on demand.
+package coldstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+)
+
+// ErrNotFound is returned when a key isn't in the store
+var ErrNotFound = errors.New("object not found in cold storage")
+
+// Store keeps objects by key outside the primary store
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// NewStore returns the store selected by the configuration guarded by a
+// circuit breaker, or nil when offloading is disabled
+func NewStore(cfg config.ColdStorageConfig, b *breaker.Breaker) Store {
+	switch cfg.Backend {
+	case "fs":
+		return &breakerStore{Store: &DirStore{Dir: cfg.Dir}, breaker: b}
+	case "http":
+		return &breakerStore{Store: &HTTPStore{URL: strings.TrimSuffix(cfg.URL, "/"), Client: &http.Client{Timeout: cfg.Timeout}}, breaker: b}
+	default:
+		return nil
+	}
+}
+
+// breakerStore fails fast with breaker.ErrOpen while the store keeps
+// failing. Missing keys don't count as failures.
+type breakerStore struct {
+	Store
+	breaker *breaker.Breaker
+}
+
+func (s *breakerStore) Put(key string, data []byte) error {
+	return s.breaker.Do(func() error { return s.Store.Put(key, data) })
+}
+
+func (s *breakerStore) Get(key string) ([]byte, error) {
+	var data []byte
+	notFound := false
+	err := s.breaker.Do(func() error {
+		var err error
+		data, err = s.Store.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			notFound = true
+			return nil
+		}
+		return err
+	})
+	if notFound {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// DirStore keeps objects as files below a directory, e.g. a mounted bucket
+type DirStore struct {
+	Dir string
+}
+
+func (s *DirStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func (s *DirStore) Put(key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write the whole object before it replaces an older copy
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *DirStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// HTTPStore keeps objects in an object store addressed as <URL>/<key>,
+// written with PUT and read with GET, such as an S3 compatible bucket
+// behind an authenticating gateway
+type HTTPStore struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPStore) Put(key string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.URL+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d storing %s", resp.StatusCode, key)
+	}
+	return nil
+}
+
+func (s *HTTPStore) Get(key string) ([]byte, error) {
+	resp, err := s.Client.Get(s.URL + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, key)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Due reports whether an archived record is old enough to be offloaded.
+// Records that were rehydrated age from the time they were fetched back.
+func Due(archivedAt, rehydratedAt, before time.Time) bool {
+	since := archivedAt
+	if rehydratedAt.After(since) {
+		since = rehydratedAt
+	}
+	return !since.IsZero() && since.Before(before)
+}
@@ -0,0 +1,873 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the service configuration loaded from the environment
+type Config struct {
+	Server       ServerConfig
+	WebRTC       WebRTCConfig
+	Recording    RecordingConfig
+	FileTransfer FileTransferConfig
+	Attachment   AttachmentConfig
+	Webhook      WebhookConfig
+	LinkPreview  LinkPreviewConfig
+	Spam         SpamConfig
+	Audit        AuditConfig
+	Privacy      PrivacyConfig
+	Call         CallConfig
+	WebSocket    WebSocketConfig
+	Signaling    SignalingConfig
+	EchoTest     EchoTestConfig
+	ID           IDConfig
+	Session      SessionConfig
+	ColdStorage  ColdStorageConfig
+	State        StateConfig
+	Metrics      MetricsConfig
+	Breaker      BreakerConfig
+	Retry        RetryConfig
+	Chat         ChatConfig
+	Health       HealthConfig
+	LoadShedding LoadSheddingConfig
+	Events       EventsConfig
+	MQTT         MQTTConfig
+	Translation  TranslationConfig
+	Analysis     AnalysisConfig
+	Search       SearchConfig
+	Watermark    WatermarkConfig
+	Voice        VoiceConfig
+	Cluster      ClusterConfig
+	// TimeFormat is how the API writes timestamps: "rfc3339" or "epoch_ms"
+	TimeFormat string
+}
+
+// ServerConfig holds the listen address and TLS settings of the HTTP server
+type ServerConfig struct {
+	Addr string
+	// TLSCertFile and TLSKeyFile serve HTTPS with a fixed certificate
+	TLSCertFile string
+	TLSKeyFile  string
+	// AutocertDomains obtains certificates for these domains from Let's
+	// Encrypt instead, cached in AutocertCacheDir
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+	// RedirectAddr serves redirects from HTTP to HTTPS when TLS is enabled,
+	// empty disables them
+	RedirectAddr string
+	// WebTransportAddr serves signaling over WebTransport on this UDP address
+	// when TLS is enabled, empty disables it
+	WebTransportAddr string
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For, set it
+	// only behind a proxy that overwrites the header
+	TrustProxyHeaders bool
+	// DrainTimeout is how long live calls get to move to another node or
+	// end once the service drains before stopping
+	DrainTimeout time.Duration
+}
+
+// WebRTCConfig holds the ICE and network settings used for peer connections
+type WebRTCConfig struct {
+	ICEServers   []string
+	EnableICETCP bool
+	ICETCPPort   int
+	DisableMDNS  bool
+	Interfaces   []string
+	EnableIPv6   bool
+
+	// NACKBufferSize is the number of sent packets kept for retransmission, a power of two
+	NACKBufferSize uint16
+	// PLIInterval periodically requests keyframes from publishers, zero disables it
+	PLIInterval time.Duration
+	// MaxPeerConnectionsPerIP caps the peer connections a client IP may open
+	// through /offer, zero is unlimited
+	MaxPeerConnectionsPerIP int
+}
+
+// RecordingConfig holds the settings for call recordings
+type RecordingConfig struct {
+	Dir string
+	// FFmpegPath is the ffmpeg binary recordings are post-processed with
+	FFmpegPath string
+	// DenoiseModel is the RNNoise model ffmpeg denoises audio recordings
+	// with, empty disables noise suppression
+	DenoiseModel   string
+	DenoiseTimeout time.Duration
+	// DenoiseWorkers caps the recordings denoised at once
+	DenoiseWorkers int
+	// MixEnabled allows mixing the audio recordings of a call into one file
+	MixEnabled bool
+	MixTimeout time.Duration
+}
+
+// WatermarkConfig holds the settings for watermarking recorded video
+type WatermarkConfig struct {
+	Enabled bool
+	// Logo is the default logo, LogoDir holds per-tenant logos named
+	// <tenant>.png
+	Logo    string
+	LogoDir string
+	// Font is the TrueType font the text is drawn with, empty uses the
+	// fontconfig default
+	Font    string
+	Timeout time.Duration
+	// Workers caps the video files watermarked at once
+	Workers int
+}
+
+// VoiceConfig holds the settings for voice messages in chat
+type VoiceConfig struct {
+	// Enabled accepts voice messages, which are transcoded with the ffmpeg
+	// of the recordings
+	Enabled     bool
+	MaxDuration time.Duration
+	// Bitrate is the Opus bitrate voice messages are encoded at, in bits
+	// per second
+	Bitrate int
+	// WaveformBars is the number of peaks in the waveform of a voice message
+	WaveformBars int
+	Timeout      time.Duration
+	// Workers caps the voice messages transcoded at once
+	Workers int
+}
+
+// FileTransferConfig holds the settings for DataChannel file transfers
+type FileTransferConfig struct {
+	Dir string
+	// QuarantineDir receives transfers until they are complete and scanned
+	// clean, outside the served Dir and on the same filesystem
+	QuarantineDir string
+	MaxSize       int64
+}
+
+// AttachmentConfig holds the settings for uploaded chat attachments
+type AttachmentConfig struct {
+	Dir string
+	// QuarantineDir holds uploads until they are scanned clean, outside the
+	// served Dir and on the same filesystem
+	QuarantineDir string
+	MaxSize       int64
+	// Scanner selects the antivirus backend: "none", "clamav" or "http"
+	Scanner     string
+	ClamAVAddr  string
+	ScannerURL  string
+	ScanTimeout time.Duration
+	// ThumbnailSizes are the longest-side pixel sizes generated for images
+	ThumbnailSizes []int
+}
+
+// WebhookConfig holds the endpoint events are posted to
+type WebhookConfig struct {
+	URL string
+}
+
+// LinkPreviewConfig holds the settings for unfurling links in chat messages
+type LinkPreviewConfig struct {
+	Enabled  bool
+	Timeout  time.Duration
+	CacheTTL time.Duration
+}
+
+// TranslationConfig holds the provider chat messages are translated with
+type TranslationConfig struct {
+	// Provider selects the translation API: "none", "deepl" or "google"
+	Provider string
+	APIKey   string
+	// URL overrides the provider's endpoint, e.g. the DeepL free API
+	URL     string
+	Timeout time.Duration
+}
+
+// AnalysisConfig holds the model chat messages are scored with
+type AnalysisConfig struct {
+	// Provider selects the model: "none", "perspective" or "http"
+	Provider string
+	URL      string
+	APIKey   string
+	Timeout  time.Duration
+	// ToxicityThreshold is the score at which messages are flagged for
+	// moderators
+	ToxicityThreshold float64
+}
+
+// SearchConfig holds the search engine chat messages are indexed in
+type SearchConfig struct {
+	// Provider selects the engine: "none", "elasticsearch" or "opensearch"
+	Provider string
+	URL      string
+	Index    string
+	// Username and Password authenticate with basic auth, APIKey with an
+	// Elasticsearch API key instead
+	Username string
+	Password string
+	APIKey   string
+	Timeout  time.Duration
+	// BatchSize is the number of messages sent per bulk request, sent at
+	// least every FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+	// BufferSize is the number of messages waiting to be indexed before new
+	// ones are dropped
+	BufferSize int
+}
+
+// SpamConfig holds the chat spam detection thresholds, zero disables a check
+type SpamConfig struct {
+	Enabled         bool
+	DuplicateLimit  int
+	DuplicateWindow time.Duration
+	BurstLimit      int
+	BurstWindow     time.Duration
+	MaxLinks        int
+	// Action applied to offenders: "mute" or "flag"
+	Action string
+}
+
+// AuditConfig holds the location of the audit log
+type AuditConfig struct {
+	Path string
+}
+
+// PrivacyConfig holds the settings for user data exports
+type PrivacyConfig struct {
+	ExportDir string
+}
+
+// CallConfig holds the defaults applied to new calls
+type CallConfig struct {
+	// MaxParticipants caps the publishing participants of a call, zero is unlimited
+	MaxParticipants int
+	// ReconnectGrace is how long a participant whose connection failed keeps
+	// their slot
+	ReconnectGrace time.Duration
+	// StatsInterval is how often participant stats are sampled into the
+	// call timeline, zero disables sampling
+	StatsInterval time.Duration
+	// StatsRetention is the number of samples kept per call
+	StatsRetention int
+	// ArchiveDir is where snapshots of ended calls are kept
+	ArchiveDir string
+	// CDRDir is where the call detail records of terminated calls are kept
+	CDRDir string
+	// StateDir is where the state of active calls is kept so they survive a
+	// restart with the fs state backend, empty disables it. StateInterval is
+	// how often the state is checkpointed besides joins.
+	StateDir      string
+	StateInterval time.Duration
+	// ReportRetention is how long the quality report of an ended call is
+	// kept in memory, after which it's read from the archive
+	ReportRetention time.Duration
+	// HistoryDir is where the participations of ended calls are kept with
+	// the fs state backend, empty keeps them in memory only
+	HistoryDir string
+	// SnapshotInterval is how often a thumbnail of every video publisher
+	// is captured into SnapshotDir, zero disables thumbnails
+	SnapshotInterval time.Duration
+	SnapshotDir      string
+	// SilenceThreshold is how long nobody may speak in a call created with
+	// skipSilence before its recordings pause
+	SilenceThreshold time.Duration
+	// VoicemailTimeout is how long the callee of a 1:1 call has to join
+	// before the caller is recorded as voicemail, zero disables voicemail
+	VoicemailTimeout   time.Duration
+	VoicemailMaxLength time.Duration
+	// ConnectTimeout is how long a participant's peer connection may take
+	// to connect before it is treated as failed, zero waits forever
+	ConnectTimeout time.Duration
+	// AudioOnlyQuality is the network quality at or below which a
+	// participant of a video call switches to audio-only until it recovers,
+	// zero disables the switch
+	AudioOnlyQuality int
+	// IdleTimeout ends a call once nobody was connected to it for this long,
+	// zero keeps it until it expires
+	IdleTimeout time.Duration
+	// RingTimeout is how long an invitation rings before it is recorded as
+	// a missed call
+	RingTimeout time.Duration
+}
+
+// WebSocketConfig holds the settings shared by the signaling and
+// notification WebSockets
+type WebSocketConfig struct {
+	// MaxMessageSize is the largest frame a client may send, larger ones close
+	// the connection
+	MaxMessageSize int64
+	// Compression negotiates permessage-deflate with clients that support it
+	Compression bool
+	// AllowedOrigins lists the browser origins besides the server's own that
+	// may open WebSockets, exact or with a "*." subdomain wildcard
+	AllowedOrigins []string
+	// TicketTTL is how long a one-time WebSocket ticket stays valid
+	TicketTTL time.Duration
+	// MaxConnectionsPerIP caps the open WebSockets of a client IP, zero is
+	// unlimited
+	MaxConnectionsPerIP int
+}
+
+// SignalingConfig holds the settings of the signaling server
+type SignalingConfig struct {
+	// DeliveryRetries is how many more times a message that asked for an
+	// acknowledgement is sent to an unreachable target
+	DeliveryRetries int
+	RetryInterval   time.Duration
+	// AuthSecret verifies the HS256 tokens of the signaling handshake, empty
+	// trusts the peerID query parameter
+	AuthSecret  string
+	AuthTimeout time.Duration
+	// HistoryDepth is the number of broadcasts kept per room and replayed
+	// to peers joining it, zero disables the replay
+	HistoryDepth int
+	// HistoryTTL drops older broadcasts from the replay, zero keeps them
+	HistoryTTL time.Duration
+}
+
+// EchoTestConfig holds the settings of the loopback echo test
+type EchoTestConfig struct {
+	// Duration is how long an echo test runs before it's closed
+	Duration time.Duration
+}
+
+// SessionConfig bounds the duration requested for chat and call sessions
+type SessionConfig struct {
+	MinDuration time.Duration
+	MaxDuration time.Duration
+}
+
+// ColdStorageConfig holds the settings of offloading old archives
+type ColdStorageConfig struct {
+	// Backend selects the cold store: "none", "fs" or "http"
+	Backend string
+	Dir     string
+	URL     string
+	Timeout time.Duration
+	// Age is how long archives stay in the primary store
+	Age time.Duration
+	// Interval is how often archives are checked for offloading
+	Interval time.Duration
+}
+
+// StateConfig selects where the state kept across restarts, such as the
+// state of active calls, is stored
+type StateConfig struct {
+	// Backend selects the store: "fs" keeps state in local directories,
+	// "redis" in Redis shared by the nodes of a cluster
+	Backend  string
+	RedisURL string
+	Timeout  time.Duration
+}
+
+// MetricsConfig holds the settings of the request metrics
+type MetricsConfig struct {
+	// SlowThreshold is the latency above which requests are logged, zero
+	// disables logging
+	SlowThreshold time.Duration
+	// RouteThresholds overrides SlowThreshold per route pattern, e.g.
+	// "/v1/call/offer"
+	RouteThresholds map[string]time.Duration
+}
+
+// BreakerConfig holds the settings of the circuit breakers guarding
+// external services
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that open a
+	// breaker
+	FailureThreshold int
+	// Cooldown is how long an open breaker rejects calls before trying again
+	Cooldown time.Duration
+}
+
+// RetryConfig holds the settings of the retry queue for failed writes and
+// deliveries
+type RetryConfig struct {
+	Dir        string
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is how often a job is retried before it's given up, zero
+	// retries forever
+	MaxAttempts int
+}
+
+// ChatConfig holds the settings of chat sessions
+type ChatConfig struct {
+	// CacheSize is the number of sessions whose transcripts and stored form
+	// are cached, zero disables the cache
+	CacheSize int
+	// SnapshotEvery is the number of logged events after which a session
+	// is snapshotted
+	SnapshotEvery int
+	// EphemeralTTL is how long ephemeral messages sent without a ttl are
+	// shown
+	EphemeralTTL time.Duration
+	// DraftTTL is how long a draft is kept after it was last saved
+	DraftTTL time.Duration
+	// MaxStarred is the number of messages a user can star
+	MaxStarred int
+	// IdleExpiry extends sessions that had a message within this long
+	// before their end, zero ends them on time
+	IdleExpiry time.Duration
+	// SystemMessages are posted on joins, leaves, role changes and before
+	// sessions expire
+	SystemMessages SystemMessagesConfig
+}
+
+// SystemMessagesConfig holds the texts of the system messages posted to chat
+// sessions automatically, an empty text posts none. {name} stands for the
+// display name or ID of the participant, {role} for their new role and
+// {remaining} for the time left, e.g. "5 minutes".
+type SystemMessagesConfig struct {
+	Join       string
+	Leave      string
+	RoleChange string
+	Expiry     string
+	// ExpiryWarnings are the times before the end of a session the expiry
+	// notification and message are sent
+	ExpiryWarnings []time.Duration
+}
+
+// LoadSheddingConfig holds the thresholds above which new sessions and
+// WebSocket upgrades are rejected, zero disables a threshold
+type LoadSheddingConfig struct {
+	MaxPeerConnections int
+	MaxGoroutines      int
+	MaxMemoryMB        int
+	// RetryAfter is the delay suggested to rejected clients
+	RetryAfter time.Duration
+}
+
+// EventsConfig holds the brokers domain events are published to
+type EventsConfig struct {
+	// Sinks lists the brokers to publish to: "nats" and/or "kafka", empty
+	// disables publishing
+	Sinks []string
+	// BufferSize is the number of events waiting for the sinks before new
+	// ones are dropped
+	BufferSize int
+	// Timeout bounds each delivery to a sink
+	Timeout time.Duration
+	// NATSURL is the server events are published to, under the subject
+	// <NATSSubjectPrefix>.<event type>
+	NATSURL           string
+	NATSSubjectPrefix string
+	// KafkaRESTURL is the Kafka REST Proxy producing events to KafkaTopic
+	KafkaRESTURL string
+	KafkaTopic   string
+}
+
+// MQTTConfig holds the broker chat notifications are bridged to
+type MQTTConfig struct {
+	// BrokerURL is the broker notifications are published to, empty
+	// disables the bridge
+	BrokerURL string
+	ClientID  string
+	// TopicPrefix is prepended to the tenants/{tenant}/sessions/{id}/...
+	// topics
+	TopicPrefix string
+	// DefaultTenant is used for sessions without a "tenant" metadata entry
+	DefaultTenant string
+	// QoS is the delivery guarantee of published notifications, 0 or 1
+	QoS       int
+	KeepAlive time.Duration
+	Timeout   time.Duration
+}
+
+// HealthConfig holds the limits checked by the readiness endpoint
+type HealthConfig struct {
+	// CheckTimeout bounds each dependency check
+	CheckTimeout time.Duration
+	// MaxGoroutines and MaxConnections are the counts above which the
+	// service reports itself as not ready
+	MaxGoroutines  int
+	MaxConnections int
+}
+
+// ClusterConfig holds the nodes running the service side by side
+type ClusterConfig struct {
+	// NodeID names this node among Nodes
+	NodeID string
+	// Nodes lists every node as id=url, empty runs the service alone
+	Nodes []string
+	// Secret authenticates the requests between nodes
+	Secret string
+	// Timeout bounds each request to another node
+	Timeout time.Duration
+}
+
+// IDConfig holds the settings of generated resource IDs
+type IDConfig struct {
+	// Format is "hex", or "uuidv7" or "ulid" for time ordered IDs
+	Format string
+	// Prefixed starts IDs with their resource kind, e.g. msg_ or call_
+	Prefixed bool
+}
+
+// Load reads the configuration from environment variables, falling back to defaults
+func Load() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              getEnv("LISTEN_ADDR", ":8001"),
+			TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+			AutocertDomains:   getEnvList("AUTOCERT_DOMAINS", nil),
+			AutocertCacheDir:  getEnv("AUTOCERT_CACHE_DIR", filepath.Join("data", "autocert")),
+			AutocertEmail:     getEnv("AUTOCERT_EMAIL", ""),
+			RedirectAddr:      getEnv("HTTP_REDIRECT_ADDR", ":80"),
+			WebTransportAddr:  getEnv("WEBTRANSPORT_ADDR", ""),
+			TrustProxyHeaders: getEnvBool("TRUST_PROXY_HEADERS", false),
+			DrainTimeout:      getEnvDuration("DRAIN_TIMEOUT", 25*time.Second),
+		},
+		WebRTC: WebRTCConfig{
+			ICEServers:   getEnvList("WEBRTC_ICE_SERVERS", []string{"stun:stun.l.google.com:19302"}),
+			EnableICETCP: getEnvBool("WEBRTC_ICE_TCP_ENABLED", false),
+			ICETCPPort:   getEnvInt("WEBRTC_ICE_TCP_PORT", 8443),
+			DisableMDNS:  getEnvBool("WEBRTC_MDNS_DISABLED", false),
+			Interfaces:   getEnvList("WEBRTC_INTERFACES", nil),
+			EnableIPv6:   getEnvBool("WEBRTC_IPV6_ENABLED", false),
+
+			NACKBufferSize: uint16(getEnvInt("WEBRTC_NACK_BUFFER_SIZE", 1024)),
+			PLIInterval:    getEnvDuration("WEBRTC_PLI_INTERVAL", 0),
+
+			MaxPeerConnectionsPerIP: getEnvInt("WEBRTC_MAX_PEER_CONNECTIONS_PER_IP", 20),
+		},
+		Recording: RecordingConfig{
+			Dir:        getEnv("RECORDING_DIR", filepath.Join("data", "recordings")),
+			FFmpegPath: getEnv("FFMPEG_PATH", "ffmpeg"),
+
+			DenoiseModel:   getEnv("NOISE_SUPPRESSION_MODEL", ""),
+			DenoiseTimeout: getEnvDuration("NOISE_SUPPRESSION_TIMEOUT", 10*time.Minute),
+			DenoiseWorkers: getEnvInt("NOISE_SUPPRESSION_WORKERS", 2),
+
+			MixEnabled: getEnvBool("RECORDING_MIX_ENABLED", false),
+			MixTimeout: getEnvDuration("RECORDING_MIX_TIMEOUT", 30*time.Minute),
+		},
+		Watermark: WatermarkConfig{
+			Enabled: getEnvBool("WATERMARK_ENABLED", false),
+			Logo:    getEnv("WATERMARK_LOGO", ""),
+			LogoDir: getEnv("WATERMARK_LOGO_DIR", ""),
+			Font:    getEnv("WATERMARK_FONT", ""),
+			Timeout: getEnvDuration("WATERMARK_TIMEOUT", 30*time.Minute),
+			Workers: getEnvInt("WATERMARK_WORKERS", 1),
+		},
+		Voice: VoiceConfig{
+			Enabled:      getEnvBool("VOICE_MESSAGES_ENABLED", false),
+			MaxDuration:  getEnvDuration("VOICE_MAX_DURATION", 5*time.Minute),
+			Bitrate:      getEnvInt("VOICE_BITRATE", 32000),
+			WaveformBars: getEnvInt("VOICE_WAVEFORM_BARS", 64),
+			Timeout:      getEnvDuration("VOICE_TRANSCODE_TIMEOUT", 30*time.Second),
+			Workers:      getEnvInt("VOICE_WORKERS", 4),
+		},
+		FileTransfer: FileTransferConfig{
+			Dir:           getEnv("FILE_TRANSFER_DIR", filepath.Join("data", "uploads")),
+			QuarantineDir: getEnv("FILE_TRANSFER_QUARANTINE_DIR", filepath.Join("data", "quarantine", "transfers")),
+			MaxSize:       int64(getEnvInt("FILE_TRANSFER_MAX_SIZE", 100<<20)),
+		},
+		Attachment: AttachmentConfig{
+			Dir:           getEnv("ATTACHMENT_DIR", filepath.Join("data", "attachments")),
+			QuarantineDir: getEnv("ATTACHMENT_QUARANTINE_DIR", filepath.Join("data", "quarantine")),
+			MaxSize:       int64(getEnvInt("ATTACHMENT_MAX_SIZE", 25<<20)),
+			Scanner:       getEnv("ATTACHMENT_SCANNER", "none"),
+			ClamAVAddr:    getEnv("CLAMAV_ADDR", "localhost:3310"),
+			ScannerURL:    getEnv("ATTACHMENT_SCANNER_URL", ""),
+			ScanTimeout:   getEnvDuration("ATTACHMENT_SCAN_TIMEOUT", 30*time.Second),
+
+			ThumbnailSizes: getEnvIntList("ATTACHMENT_THUMBNAIL_SIZES", []int{128, 512}),
+		},
+		Webhook: WebhookConfig{
+			URL: getEnv("WEBHOOK_URL", ""),
+		},
+		LinkPreview: LinkPreviewConfig{
+			Enabled:  getEnvBool("LINK_PREVIEW_ENABLED", true),
+			Timeout:  getEnvDuration("LINK_PREVIEW_TIMEOUT", 5*time.Second),
+			CacheTTL: getEnvDuration("LINK_PREVIEW_CACHE_TTL", time.Hour),
+		},
+		Spam: SpamConfig{
+			Enabled:         getEnvBool("SPAM_FILTER_ENABLED", true),
+			DuplicateLimit:  getEnvInt("SPAM_DUPLICATE_LIMIT", 3),
+			DuplicateWindow: getEnvDuration("SPAM_DUPLICATE_WINDOW", time.Minute),
+			BurstLimit:      getEnvInt("SPAM_BURST_LIMIT", 10),
+			BurstWindow:     getEnvDuration("SPAM_BURST_WINDOW", 10*time.Second),
+			MaxLinks:        getEnvInt("SPAM_MAX_LINKS", 5),
+			Action:          getEnv("SPAM_ACTION", "mute"),
+		},
+		Audit: AuditConfig{
+			Path: getEnv("AUDIT_LOG_PATH", filepath.Join("data", "audit.log")),
+		},
+		Privacy: PrivacyConfig{
+			ExportDir: getEnv("EXPORT_DIR", filepath.Join("data", "exports")),
+		},
+		Cluster: ClusterConfig{
+			NodeID:  getEnv("CLUSTER_NODE_ID", hostname()),
+			Nodes:   getEnvList("CLUSTER_NODES", nil),
+			Secret:  getEnv("CLUSTER_SECRET", ""),
+			Timeout: getEnvDuration("CLUSTER_TIMEOUT", 5*time.Second),
+		},
+		Call: CallConfig{
+			MaxParticipants: getEnvInt("CALL_MAX_PARTICIPANTS", 50),
+			ReconnectGrace:  getEnvDuration("CALL_RECONNECT_GRACE", 30*time.Second),
+			StatsInterval:   getEnvDuration("CALL_STATS_INTERVAL", 10*time.Second),
+			StatsRetention:  getEnvInt("CALL_STATS_RETENTION", 360),
+			ArchiveDir:      getEnv("CALL_ARCHIVE_DIR", filepath.Join("data", "archive", "calls")),
+			CDRDir:          getEnv("CALL_CDR_DIR", filepath.Join("data", "cdr")),
+			StateDir:        getEnv("CALL_STATE_DIR", filepath.Join("data", "state", "calls")),
+			StateInterval:   getEnvDuration("CALL_STATE_INTERVAL", 5*time.Second),
+			HistoryDir:      getEnv("CALL_HISTORY_DIR", filepath.Join("data", "history", "calls")),
+			ReportRetention: getEnvDuration("CALL_REPORT_RETENTION", time.Hour),
+
+			SnapshotInterval: getEnvDuration("CALL_SNAPSHOT_INTERVAL", 0),
+			SnapshotDir:      getEnv("CALL_SNAPSHOT_DIR", filepath.Join("data", "snapshots")),
+			SilenceThreshold: getEnvDuration("CALL_SILENCE_THRESHOLD", 30*time.Second),
+
+			VoicemailTimeout:   getEnvDuration("CALL_VOICEMAIL_TIMEOUT", 30*time.Second),
+			VoicemailMaxLength: getEnvDuration("CALL_VOICEMAIL_MAX_LENGTH", 2*time.Minute),
+			RingTimeout:        getEnvDuration("CALL_RING_TIMEOUT", 30*time.Second),
+			IdleTimeout:        getEnvDuration("CALL_IDLE_TIMEOUT", 2*time.Minute),
+			ConnectTimeout:     getEnvDuration("CALL_CONNECT_TIMEOUT", 30*time.Second),
+			AudioOnlyQuality:   getEnvInt("CALL_AUDIO_ONLY_QUALITY", 1),
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageSize:      int64(getEnvInt("WS_MAX_MESSAGE_SIZE", 64<<10)),
+			Compression:         getEnvBool("WS_COMPRESSION_ENABLED", true),
+			AllowedOrigins:      getEnvList("WS_ALLOWED_ORIGINS", nil),
+			TicketTTL:           getEnvDuration("WS_TICKET_TTL", 30*time.Second),
+			MaxConnectionsPerIP: getEnvInt("WS_MAX_CONNECTIONS_PER_IP", 50),
+		},
+		Signaling: SignalingConfig{
+			DeliveryRetries: getEnvInt("SIGNALING_DELIVERY_RETRIES", 3),
+			RetryInterval:   getEnvDuration("SIGNALING_RETRY_INTERVAL", 500*time.Millisecond),
+			AuthSecret:      getEnv("SIGNALING_AUTH_SECRET", ""),
+			AuthTimeout:     getEnvDuration("SIGNALING_AUTH_TIMEOUT", 10*time.Second),
+			HistoryDepth:    getEnvInt("SIGNALING_HISTORY_DEPTH", 50),
+			HistoryTTL:      getEnvDuration("SIGNALING_HISTORY_TTL", 10*time.Minute),
+		},
+		EchoTest: EchoTestConfig{
+			Duration: getEnvDuration("ECHO_TEST_DURATION", 30*time.Second),
+		},
+		ID: IDConfig{
+			Format:   getEnv("ID_FORMAT", "hex"),
+			Prefixed: getEnvBool("ID_PREFIXES", false),
+		},
+		TimeFormat: getEnv("TIME_FORMAT", "rfc3339"),
+		Session: SessionConfig{
+			MinDuration: getEnvDuration("SESSION_MIN_DURATION", time.Minute),
+			MaxDuration: getEnvDuration("SESSION_MAX_DURATION", 24*time.Hour),
+		},
+		ColdStorage: ColdStorageConfig{
+			Backend:  getEnv("COLD_STORAGE_BACKEND", "none"),
+			Dir:      getEnv("COLD_STORAGE_DIR", filepath.Join("data", "cold")),
+			URL:      getEnv("COLD_STORAGE_URL", ""),
+			Timeout:  getEnvDuration("COLD_STORAGE_TIMEOUT", 30*time.Second),
+			Age:      getEnvDuration("COLD_STORAGE_AGE", 30*24*time.Hour),
+			Interval: getEnvDuration("COLD_STORAGE_INTERVAL", time.Hour),
+		},
+		State: StateConfig{
+			Backend:  getEnv("STATE_BACKEND", "fs"),
+			RedisURL: getEnv("STATE_REDIS_URL", "redis://localhost:6379/0"),
+			Timeout:  getEnvDuration("STATE_TIMEOUT", 5*time.Second),
+		},
+		Metrics: MetricsConfig{
+			SlowThreshold:   getEnvDuration("SLOW_REQUEST_THRESHOLD", time.Second),
+			RouteThresholds: getEnvDurationMap("SLOW_REQUEST_ROUTE_THRESHOLDS"),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getEnvInt("BREAKER_FAILURE_THRESHOLD", 5),
+			Cooldown:         getEnvDuration("BREAKER_COOLDOWN", 30*time.Second),
+		},
+		Retry: RetryConfig{
+			Dir:         getEnv("RETRY_QUEUE_DIR", filepath.Join("data", "retry")),
+			MinBackoff:  getEnvDuration("RETRY_MIN_BACKOFF", time.Second),
+			MaxBackoff:  getEnvDuration("RETRY_MAX_BACKOFF", 5*time.Minute),
+			MaxAttempts: getEnvInt("RETRY_MAX_ATTEMPTS", 50),
+		},
+		Chat: ChatConfig{
+			CacheSize:     getEnvInt("CHAT_CACHE_SIZE", 1000),
+			SnapshotEvery: getEnvInt("CHAT_SNAPSHOT_EVERY", 100),
+			EphemeralTTL:  getEnvDuration("CHAT_EPHEMERAL_TTL", 5*time.Minute),
+			DraftTTL:      getEnvDuration("CHAT_DRAFT_TTL", 7*24*time.Hour),
+			MaxStarred:    getEnvInt("CHAT_MAX_STARRED", 1000),
+			IdleExpiry:    getEnvDuration("CHAT_IDLE_EXPIRY", 0),
+			SystemMessages: SystemMessagesConfig{
+				Join:           getEnv("CHAT_JOIN_MESSAGE", "{name} joined the chat"),
+				Leave:          getEnv("CHAT_LEAVE_MESSAGE", "{name} left the chat"),
+				RoleChange:     getEnv("CHAT_ROLE_MESSAGE", "{name} is now {role}"),
+				Expiry:         getEnv("CHAT_EXPIRY_MESSAGE", "{remaining} remaining"),
+				ExpiryWarnings: getEnvDurationList("CHAT_EXPIRY_WARNINGS", []time.Duration{5 * time.Minute}),
+			},
+		},
+		Health: HealthConfig{
+			CheckTimeout:   getEnvDuration("READY_CHECK_TIMEOUT", 2*time.Second),
+			MaxGoroutines:  getEnvInt("READY_MAX_GOROUTINES", 10000),
+			MaxConnections: getEnvInt("READY_MAX_CONNECTIONS", 5000),
+		},
+		Events: EventsConfig{
+			Sinks:             getEnvList("EVENT_SINKS", nil),
+			BufferSize:        getEnvInt("EVENTS_BUFFER_SIZE", 10000),
+			Timeout:           getEnvDuration("EVENTS_TIMEOUT", 5*time.Second),
+			NATSURL:           getEnv("EVENTS_NATS_URL", "nats://localhost:4222"),
+			NATSSubjectPrefix: getEnv("EVENTS_NATS_SUBJECT_PREFIX", "webrtc"),
+			KafkaRESTURL:      getEnv("EVENTS_KAFKA_REST_URL", "http://localhost:8082"),
+			KafkaTopic:        getEnv("EVENTS_KAFKA_TOPIC", "webrtc-events"),
+		},
+		Translation: TranslationConfig{
+			Provider: getEnv("TRANSLATION_PROVIDER", "none"),
+			APIKey:   getEnv("TRANSLATION_API_KEY", ""),
+			URL:      getEnv("TRANSLATION_URL", ""),
+			Timeout:  getEnvDuration("TRANSLATION_TIMEOUT", 10*time.Second),
+		},
+		Analysis: AnalysisConfig{
+			Provider:          getEnv("MESSAGE_ANALYSIS_PROVIDER", "none"),
+			URL:               getEnv("MESSAGE_ANALYSIS_URL", ""),
+			APIKey:            getEnv("MESSAGE_ANALYSIS_API_KEY", ""),
+			Timeout:           getEnvDuration("MESSAGE_ANALYSIS_TIMEOUT", 10*time.Second),
+			ToxicityThreshold: getEnvFloat("TOXICITY_THRESHOLD", 0.8),
+		},
+		Search: SearchConfig{
+			Provider:      getEnv("SEARCH_PROVIDER", "none"),
+			URL:           getEnv("SEARCH_URL", "http://localhost:9200"),
+			Index:         getEnv("SEARCH_INDEX", "chat-messages"),
+			Username:      getEnv("SEARCH_USERNAME", ""),
+			Password:      getEnv("SEARCH_PASSWORD", ""),
+			APIKey:        getEnv("SEARCH_API_KEY", ""),
+			Timeout:       getEnvDuration("SEARCH_TIMEOUT", 10*time.Second),
+			BatchSize:     getEnvInt("SEARCH_BATCH_SIZE", 500),
+			FlushInterval: getEnvDuration("SEARCH_FLUSH_INTERVAL", time.Second),
+			BufferSize:    getEnvInt("SEARCH_BUFFER_SIZE", 10000),
+		},
+		MQTT: MQTTConfig{
+			BrokerURL:     getEnv("MQTT_BROKER_URL", ""),
+			ClientID:      getEnv("MQTT_CLIENT_ID", "pion-webrtc-microservice"),
+			TopicPrefix:   getEnv("MQTT_TOPIC_PREFIX", ""),
+			DefaultTenant: getEnv("MQTT_DEFAULT_TENANT", "default"),
+			QoS:           getEnvInt("MQTT_QOS", 0),
+			KeepAlive:     getEnvDuration("MQTT_KEEPALIVE", 30*time.Second),
+			Timeout:       getEnvDuration("MQTT_TIMEOUT", 5*time.Second),
+		},
+		LoadShedding: LoadSheddingConfig{
+			MaxPeerConnections: getEnvInt("SHED_MAX_PEER_CONNECTIONS", 0),
+			MaxGoroutines:      getEnvInt("SHED_MAX_GOROUTINES", 0),
+			MaxMemoryMB:        getEnvInt("SHED_MAX_MEMORY_MB", 0),
+			RetryAfter:         getEnvDuration("SHED_RETRY_AFTER", 10*time.Second),
+		},
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(getEnv(key, ""), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// hostname returns the host name, which names the pod on Kubernetes
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// getEnvList reads a comma separated list, ignoring empty entries
+func getEnvList(key string, fallback []string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvIntList reads a comma separated list of positive integers
+func getEnvIntList(key string, fallback []int) []int {
+	items := getEnvList(key, nil)
+	if items == nil {
+		return fallback
+	}
+
+	var list []int
+	for _, item := range items {
+		value, err := strconv.Atoi(item)
+		if err != nil || value <= 0 {
+			return fallback
+		}
+		list = append(list, value)
+	}
+	return list
+}
+
+// getEnvDurationList reads a comma separated list of durations, skipping
+// those that aren't positive, so "0" disables the list
+func getEnvDurationList(key string, fallback []time.Duration) []time.Duration {
+	items := getEnvList(key, nil)
+	if items == nil {
+		return fallback
+	}
+
+	var list []time.Duration
+	for _, item := range items {
+		value, err := time.ParseDuration(item)
+		if err != nil {
+			return fallback
+		}
+		if value > 0 {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// getEnvDurationMap reads a comma separated list of key=duration pairs,
+// skipping malformed entries
+func getEnvDurationMap(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, item := range getEnvList(key, nil) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		durations[strings.TrimSpace(name)] = duration
+	}
+	return durations
+}
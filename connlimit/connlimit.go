@@ -0,0 +1,42 @@
+// Package connlimit caps the long-lived connections a single client may
+// hold, so one misbehaving client can't exhaust ports and file descriptors.
+package connlimit
+
+import "sync"
+
+// Limiter counts the open connections per client IP
+type Limiter struct {
+	max    int
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+// New creates a Limiter allowing max connections per IP, zero is unlimited
+func New(max int) *Limiter {
+	return &Limiter{max: max, counts: make(map[string]int)}
+}
+
+// Acquire counts a new connection of ip, or reports false when ip already
+// holds the maximum. Every successful Acquire must be paired with a Release.
+func (l *Limiter) Acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// Release forgets a connection of ip once it is closed
+func (l *Limiter) Release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] <= 1 {
+		delete(l.counts, ip)
+		return
+	}
+	l.counts[ip]--
+}
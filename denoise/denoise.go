@@ -0,0 +1,67 @@
+// Package denoise removes background noise from recorded audio with the
+// RNNoise filter of ffmpeg.
+package denoise
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/config"
+)
+
+// Denoiser runs ffmpeg's arnndn filter over Opus recordings, a bounded
+// number at a time
+type Denoiser struct {
+	ffmpeg  string
+	model   string
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// New returns a Denoiser using the configured RNNoise model, or nil when no
+// model is configured
+func New(cfg config.RecordingConfig) *Denoiser {
+	if cfg.DenoiseModel == "" {
+		return nil
+	}
+	return &Denoiser{
+		ffmpeg:  cfg.FFmpegPath,
+		model:   cfg.DenoiseModel,
+		timeout: cfg.DenoiseTimeout,
+		slots:   make(chan struct{}, max(cfg.DenoiseWorkers, 1)),
+	}
+}
+
+// Denoise replaces the Ogg/Opus file at path with a denoised copy. The
+// original is kept when ffmpeg fails.
+func (d *Denoiser) Denoise(ctx context.Context, path string) error {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	tmp := strings.TrimSuffix(path, filepath.Ext(path)) + ".denoised.ogg"
+	cmd := exec.CommandContext(ctx, d.ffmpeg,
+		"-nostdin", "-y", "-loglevel", "error",
+		"-i", path,
+		"-af", "arnndn=m="+filterEscape(d.model),
+		"-c:a", "libopus",
+		tmp,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(tmp, path)
+}
+
+// filterEscape quotes a value of an ffmpeg filter option
+func filterEscape(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,163 @@
+// Package events publishes domain events of the service to message brokers,
+// so downstream systems can follow chats, calls and peers without polling
+// the REST API.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/retry"
+	"pion-webrtc-microservice/utils"
+)
+
+// Event types
+const (
+	ChatSessionCreated = "chat.session.created"
+	ChatSessionEnded   = "chat.session.ended"
+	MessageCreated     = "message.created"
+	CallStarted        = "call.started"
+	CallJoined         = "call.joined"
+	CallLeft           = "call.left"
+	CallEnded          = "call.ended"
+	CallTransferred    = "call.transferred"
+	PeerConnected      = "peer.connected"
+	PeerDisconnected   = "peer.disconnected"
+)
+
+// Event is the message published to the sinks
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Key is the chat, call or peer the event belongs to. Events with the
+	// same key keep their order where the sink supports it.
+	Key       string      `json:"key"`
+	Timestamp utils.Time  `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Sink delivers encoded events to a broker
+type Sink interface {
+	Publish(eventType, key string, body []byte) error
+}
+
+// publishJob is the retry job kind of events a sink failed to take
+func publishJob(sink string) string {
+	return "event-" + sink
+}
+
+type pending struct {
+	eventType string
+	key       string
+	body      []byte
+}
+
+type guardedSink struct {
+	name    string
+	sink    Sink
+	breaker *breaker.Breaker
+}
+
+// Bus hands events to every configured sink in the background. Events a
+// sink fails to take, or that arrive while its breaker is open, are queued
+// for a retry.
+type Bus struct {
+	sinks   []guardedSink
+	queue   chan pending
+	retries *retry.Queue
+}
+
+// NewBus creates a Bus publishing to the sinks listed in the configuration,
+// or returns nil when none is configured
+func NewBus(cfg config.EventsConfig, breakers config.BreakerConfig, retries *retry.Queue) (*Bus, error) {
+	if len(cfg.Sinks) == 0 {
+		return nil, nil
+	}
+
+	b := &Bus{
+		queue:   make(chan pending, cfg.BufferSize),
+		retries: retries,
+	}
+	for _, name := range cfg.Sinks {
+		var sink Sink
+		switch name {
+		case "nats":
+			nats, err := NewNATSSink(cfg.NATSURL, cfg.NATSSubjectPrefix, cfg.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			sink = nats
+		case "kafka":
+			sink = NewKafkaSink(cfg.KafkaRESTURL, cfg.KafkaTopic, cfg.Timeout)
+		default:
+			return nil, fmt.Errorf("unknown event sink %q", name)
+		}
+
+		guarded := guardedSink{name: name, sink: sink, breaker: breaker.New("events "+name, breakers)}
+		b.sinks = append(b.sinks, guarded)
+		retries.Handle(publishJob(name), func(_ string, body []byte) error {
+			var envelope struct {
+				Type string `json:"type"`
+				Key  string `json:"key"`
+			}
+			if err := json.Unmarshal(body, &envelope); err != nil {
+				log.Printf("Error decoding queued event for %s, dropped: %v\n", guarded.name, err)
+				return nil
+			}
+			return guarded.publish(envelope.Type, envelope.Key, body)
+		})
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// Publish encodes an event and queues it for the sinks. The data is encoded
+// right away, so it may be changed once Publish returns. Events that find
+// the buffer full are dropped.
+func (b *Bus) Publish(eventType, key string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	event := Event{
+		ID:        utils.NewID(utils.PrefixEvent),
+		Type:      eventType,
+		Key:       key,
+		Timestamp: utils.Now(),
+		Data:      data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding event %s: %v\n", eventType, err)
+		return
+	}
+
+	select {
+	case b.queue <- pending{eventType: eventType, key: key, body: body}:
+	default:
+		log.Printf("Error publishing event %s of %s: buffer is full, dropped\n", eventType, key)
+	}
+}
+
+func (b *Bus) run() {
+	for event := range b.queue {
+		for _, sink := range b.sinks {
+			err := sink.publish(event.eventType, event.key, event.body)
+			if err == nil {
+				continue
+			}
+			log.Printf("Error publishing event %s to %s, queued for retry: %v\n", event.eventType, sink.name, err)
+			if err := b.retries.Enqueue(publishJob(sink.name), "", event.body); err != nil {
+				log.Printf("Error queueing event %s for %s: %v\n", event.eventType, sink.name, err)
+			}
+		}
+	}
+}
+
+func (s guardedSink) publish(eventType, key string, body []byte) error {
+	return s.breaker.Do(func() error { return s.sink.Publish(eventType, key, body) })
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaSink produces events to a Kafka topic through a Kafka REST Proxy
+// (v2 API), keyed by the chat, call or peer they belong to so each keeps its
+// order within a partition
+type KafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaSink creates a sink producing to topic through the REST Proxy at
+// restURL
+func NewKafkaSink(restURL, topic string, timeout time.Duration) *KafkaSink {
+	return &KafkaSink{
+		url:    strings.TrimSuffix(restURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+type kafkaRecord struct {
+	Key   *string         `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (s *KafkaSink) Publish(_, key string, body []byte) error {
+	record := kafkaRecord{Value: body}
+	if key != "" {
+		record.Key = &key
+	}
+	payload, err := json.Marshal(map[string][]kafkaRecord{"records": {record}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// The proxy answers 200 even when producing a record failed
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	for _, offset := range result.Offsets {
+		if offset.Error != "" {
+			return fmt.Errorf("producing record: %s", offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package health runs the readiness checks of the service's dependencies.
+package health
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// CheckFunc reports a dependency as down by returning an error
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// Critical checks that are down make the service unready, the others
+	// are only reported
+	Critical  bool    `json:"critical"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// Checker runs a set of named checks
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+}
+
+// NewChecker creates a Checker whose checks each get timeout to complete
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check, a failing critical check makes Run report the
+// service as not ready
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+// Run runs every check concurrently and reports whether all critical checks
+// are up
+func (c *Checker) Run(ctx context.Context) (bool, []Result) {
+	results := make([]Result, len(c.checks))
+
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, result := range results {
+		if result.Critical && result.Status == StatusDown {
+			ready = false
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return ready, results
+}
+
+func (c *Checker) run(ctx context.Context, chk check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- chk.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Name:      chk.name,
+		Status:    StatusUp,
+		Critical:  chk.critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// WritableDir checks that files can be created in a directory
+func WritableDir(dir string) CheckFunc {
+	return func(context.Context) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		file, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		file.Close()
+		return os.Remove(file.Name())
+	}
+}
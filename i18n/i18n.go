@@ -0,0 +1,128 @@
+// Package i18n translates the messages of the service. Messages are written
+// in English and double as the keys of the bundled locales.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language messages are written in
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a lowercase language tag to its translations
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic("i18n: invalid locale " + file.Name() + ": " + err.Error())
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(file.Name(), ".json"))] = catalog
+	}
+	return catalogs
+}
+
+// Translate returns a message in the language preferred by an
+// Accept-Language header. Each accepted language falls back to its base
+// language, e.g. pt-BR to pt, and messages no accepted language translates
+// stay in English.
+func Translate(acceptLanguage, message string) string {
+	for _, tag := range fallbacks(acceptLanguage) {
+		if tag == DefaultLanguage {
+			return message
+		}
+		if translated, ok := catalogs[tag][message]; ok {
+			return translated
+		}
+	}
+	return message
+}
+
+// Negotiate returns the supported language matching an Accept-Language
+// header best
+func Negotiate(acceptLanguage string) string {
+	for _, tag := range fallbacks(acceptLanguage) {
+		if _, ok := catalogs[tag]; ok || tag == DefaultLanguage {
+			return tag
+		}
+	}
+	return DefaultLanguage
+}
+
+// fallbacks lists the languages to try for an Accept-Language header, most
+// preferred first
+func fallbacks(acceptLanguage string) []string {
+	var tags []string
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		for {
+			tags = append(tags, tag)
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return tags
+}
+
+// parseAcceptLanguage returns the lowercase tags of an Accept-Language
+// header ordered by their quality. Tags with a quality of 0 and the
+// wildcard are dropped.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	var accepted []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+		if quality <= 0 {
+			continue
+		}
+		accepted = append(accepted, weighted{tag: strings.ReplaceAll(tag, "_", "-"), quality: quality})
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+
+	tags := make([]string, len(accepted))
+	for i, a := range accepted {
+		tags[i] = a.tag
+	}
+	return tags
+}
@@ -0,0 +1,139 @@
+// Package lifecycle drains the service before it stops, so rolling deploys
+// on Kubernetes move or end the live calls instead of dropping them.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// retryAfter is the Retry-After of requests rejected while draining, by
+// then the load balancer sends them to another instance
+const retryAfter = 5 * time.Second
+
+// Phase is where the service is in its lifecycle
+type Phase string
+
+const (
+	PhaseServing  Phase = "serving"
+	PhaseDraining Phase = "draining"
+	// PhaseDrained is reached once the drain finished, the process only
+	// waits to be stopped
+	PhaseDrained Phase = "drained"
+)
+
+// Status describes the lifecycle of the service for the health endpoints
+type Status struct {
+	Phase          Phase       `json:"phase"`
+	DrainStartedAt *utils.Time `json:"drainStartedAt,omitempty"`
+	DrainDeadline  *utils.Time `json:"drainDeadline,omitempty"`
+	ActiveSessions int         `json:"activeSessions"`
+}
+
+// Drainer stops the service from taking new sessions and runs the drain of
+// the existing ones within a deadline
+type Drainer struct {
+	timeout time.Duration
+	// drain moves or ends the live sessions before its context is done
+	drain func(ctx context.Context)
+	// active counts the live sessions
+	active func() int
+
+	phase     Phase
+	startedAt time.Time
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewDrainer creates a Drainer running drain within timeout
+func NewDrainer(timeout time.Duration, drain func(ctx context.Context), active func() int) *Drainer {
+	return &Drainer{
+		timeout: timeout,
+		drain:   drain,
+		active:  active,
+		phase:   PhaseServing,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start begins draining unless it already began, and returns a channel
+// closed once the drain finished
+func (d *Drainer) Start() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.phase != PhaseServing {
+		return d.done
+	}
+	d.phase = PhaseDraining
+	d.startedAt = utils.GetTimestamp()
+	log.Printf("Draining %d sessions within %s\n", d.active(), d.timeout)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		d.drain(ctx)
+
+		d.mu.Lock()
+		d.phase = PhaseDrained
+		d.mu.Unlock()
+		log.Printf("Drained, %d sessions left\n", d.active())
+		close(d.done)
+	}()
+	return d.done
+}
+
+// Draining reports whether the service stopped taking new sessions
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.phase != PhaseServing
+}
+
+// Status returns the current phase and drain deadline
+func (d *Drainer) Status() Status {
+	d.mu.Lock()
+	status := Status{Phase: d.phase}
+	if d.phase != PhaseServing {
+		startedAt, deadline := utils.NewTime(d.startedAt), utils.NewTime(d.startedAt.Add(d.timeout))
+		status.DrainStartedAt = &startedAt
+		status.DrainDeadline = &deadline
+	}
+	d.mu.Unlock()
+
+	status.ActiveSessions = d.active()
+	return status
+}
+
+// Check is the readiness check failing once draining began, so the service
+// is taken out of the load balancer
+func (d *Drainer) Check(context.Context) error {
+	if d.Draining() {
+		return errors.New("draining")
+	}
+	return nil
+}
+
+// Middleware rejects requests with 503 and a Retry-After header while
+// draining. It is meant for routes that start new sessions.
+func (d *Drainer) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if d.Draining() {
+				errResp := utils.NewRetryErrorResponse(http.StatusServiceUnavailable, "server is draining", retryAfter)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(errResp.RetryAfter))
+				return c.JSON(errResp.StatusCode, errResp)
+			}
+			return next(c)
+		}
+	}
+}
@@ -1,471 +1,3323 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"pion-webrtc-microservice/analysis"
+	"pion-webrtc-microservice/apiauth"
+	"pion-webrtc-microservice/attachment"
+	"pion-webrtc-microservice/audit"
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/buildinfo"
 	"pion-webrtc-microservice/call"
 	"pion-webrtc-microservice/chat"
+	"pion-webrtc-microservice/cluster"
+	"pion-webrtc-microservice/coldstorage"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/connlimit"
+	"pion-webrtc-microservice/denoise"
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/health"
+	"pion-webrtc-microservice/i18n"
+	"pion-webrtc-microservice/lifecycle"
+	"pion-webrtc-microservice/metrics"
+	"pion-webrtc-microservice/mixer"
+	"pion-webrtc-microservice/mqtt"
+	"pion-webrtc-microservice/overload"
 	"pion-webrtc-microservice/peer"
+	"pion-webrtc-microservice/privacy"
+	"pion-webrtc-microservice/retry"
+	"pion-webrtc-microservice/search"
 	"pion-webrtc-microservice/signaling"
+	"pion-webrtc-microservice/statestore"
+	"pion-webrtc-microservice/translate"
+	"pion-webrtc-microservice/unfurl"
 	"pion-webrtc-microservice/utils"
+	"pion-webrtc-microservice/voice"
+	"pion-webrtc-microservice/watermark"
+	"pion-webrtc-microservice/webhook"
+	"pion-webrtc-microservice/wsauth"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/pion/webrtc/v3"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var (
+	appConfig       = config.Load()
 	chatManger      = chat.NewChatManager()
-	signalingManger = signaling.NewSignalingServer()
-	callManager     = call.NewCallManager()
+	signalingManger = signaling.NewSignalingServer(appConfig.Signaling)
+	callManager     *call.CallManager
+	auditLog        *audit.Log
+	exporter        *privacy.Exporter
+	retries         = retry.NewQueue(appConfig.Retry)
+	originPolicy    = wsauth.NewOriginPolicy(appConfig.WebSocket.AllowedOrigins)
+	// apiAuth guards the admin, analytics and privacy endpoints
+	apiAuth = apiauth.New(appConfig.Signaling.AuthSecret)
+	// wsTickets is nil when WebSockets aren't authenticated
+	wsTickets *wsauth.Tickets
+	// loadShedder rejects new sessions and WebSocket upgrades while the
+	// service is overloaded
+	loadShedder      *overload.Guard
+	wsLimits         = connlimit.New(appConfig.WebSocket.MaxConnectionsPerIP)
+	peerLimits       = connlimit.New(appConfig.WebRTC.MaxPeerConnectionsPerIP)
+	webhookBreaker   = breaker.New("webhook", appConfig.Breaker)
+	scannerBreaker   = breaker.New("scanner", appConfig.Breaker)
+	coldStoreBreaker = breaker.New("cold storage", appConfig.Breaker)
+	searchBreaker    = breaker.New("search", appConfig.Breaker)
+	botBreaker       = breaker.New("bots", appConfig.Breaker)
+	webhooks         = webhook.NewNotifier(appConfig.Webhook.URL, webhookBreaker, retries)
+	attachmentStore  = attachment.NewStore(appConfig.Attachment.Dir, appConfig.Attachment.QuarantineDir, "/attachments", attachment.NewScanner(appConfig.Attachment, scannerBreaker), webhooks, appConfig.Attachment.ThumbnailSizes)
+	// voiceTranscoder is nil when voice messages are disabled
+	voiceTranscoder = voice.New(appConfig.Voice, appConfig.Recording.FFmpegPath)
+	// nodes is nil when the service runs alone
+	nodes *cluster.Cluster
+	// drainer stops new sessions and moves or ends the live calls before
+	// the service stops
+	drainer *lifecycle.Drainer
+	// webTransportServer is nil when signaling isn't served over WebTransport
+	webTransportServer *webtransport.Server
 )
 
 func main() {
+	if err := utils.ConfigureTimes(utils.TimeFormat(appConfig.TimeFormat)); err != nil {
+		log.Fatalf("failed to configure time format: %v", err)
+	}
+	if err := utils.ConfigureIDs(utils.IDFormat(appConfig.ID.Format), appConfig.ID.Prefixed); err != nil {
+		log.Fatalf("failed to configure IDs: %v", err)
+	}
+
+	peerFactory, err := peer.NewFactory(appConfig.WebRTC)
+	if err != nil {
+		log.Fatalf("failed to configure WebRTC: %v", err)
+	}
+	webrtcAPI, err := peerFactory.NewAPI(peer.CodecPreferences{}, peer.DefaultOpusOptions())
+	if err != nil {
+		log.Fatalf("failed to create WebRTC API: %v", err)
+	}
+	callManager = call.NewCallManager(peerFactory, appConfig.Recording.Dir, appConfig.Call)
+	if nodes, err = cluster.New(appConfig.Cluster); err != nil {
+		log.Fatalf("failed to configure cluster: %v", err)
+	}
+	if nodes != nil {
+		callManager.ClaimID = nodes.Owns
+	}
+	// Every node keeps its own calls, and restores them when it restarts
+	// with the same node ID
+	if callManager.State, err = statestore.New(appConfig.State, appConfig.Call.StateDir, "calls:"+appConfig.Cluster.NodeID+":"); err != nil {
+		log.Fatalf("failed to configure call state: %v", err)
+	}
+	// The call history is shared by the nodes, a user's export covers the
+	// calls they took part in on any of them
+	if callManager.History, err = statestore.New(appConfig.State, appConfig.Call.HistoryDir, "history:"); err != nil {
+		log.Fatalf("failed to configure call history: %v", err)
+	}
+
+	auditLog, err = audit.Open(appConfig.Audit.Path)
+	if err != nil {
+		log.Fatalf("failed to open audit log: %v", err)
+	}
+	chatManger.Audit = auditLog
+	chatManger.SocketAuth = chat.SocketAuthConfig{
+		Secret:  appConfig.Signaling.AuthSecret,
+		Timeout: appConfig.Signaling.AuthTimeout,
+	}
+	callManager.Audit = auditLog
+	callManager.Denoiser = denoise.New(appConfig.Recording)
+	callManager.Mixer = mixer.New(appConfig.Recording)
+	callManager.Watermark = watermark.New(appConfig.Watermark, appConfig.Recording.FFmpegPath)
+	callManager.Snapshots = call.NewSnapshots(appConfig.Call, appConfig.Recording.FFmpegPath)
+	callManager.OnInvitation = announceInvitation
+	callManager.OnRenegotiate = offerRenegotiation
+	callManager.OnCallDetailRecord = func(record *call.CallDetailRecord) {
+		webhooks.Send("call.cdr", record)
+	}
+	callManager.OnVoicemail = func(voicemail *call.Voicemail) {
+		chatManger.Hub.SendNotification(chat.Notification{
+			Type:      chat.VoicemailNotification,
+			SessionID: voicemail.SessionID,
+			Data:      voicemail,
+		})
+		if voicemail.Status == call.VoicemailDelivered {
+			webhooks.Send("call.voicemail", voicemail)
+		}
+	}
+	callManager.OnSpeaking = func(sessionID string, event call.SpeakingEvent) {
+		chatManger.Hub.SendNotification(chat.Notification{
+			Type:      chat.SpeakingNotification,
+			SessionID: sessionID,
+			Data:      event,
+		})
+	}
+	exporter = privacy.NewExporter(appConfig.Privacy.ExportDir, chatManger, callManager)
+
+	if appConfig.LinkPreview.Enabled {
+		chatManger.Unfurler = unfurl.NewService(appConfig.LinkPreview.Timeout, appConfig.LinkPreview.CacheTTL)
+	}
+	chatManger.Translator = translate.NewTranslator(appConfig.Translation, breaker.New("translation", appConfig.Breaker))
+	chatManger.Scorer = analysis.NewScorer(appConfig.Analysis, breaker.New("analysis", appConfig.Breaker))
+	chatManger.ToxicityThreshold = appConfig.Analysis.ToxicityThreshold
+	if appConfig.Spam.Enabled {
+		chatManger.SpamFilter = chat.NewSpamFilter(appConfig.Spam)
+	}
+	if store := coldstorage.NewStore(appConfig.ColdStorage, coldStoreBreaker); store != nil {
+		chatManger.ColdStore = store
+		callManager.ColdStore = store
+		go offloadArchives(appConfig.ColdStorage)
+	}
+
+	if appConfig.Signaling.AuthSecret != "" {
+		wsTickets = wsauth.NewTickets(appConfig.Signaling.AuthSecret, appConfig.WebSocket.TicketTTL)
+	}
+	chatManger.Retries = retries
+	chatManger.BotBreaker = botBreaker
+	bus, err := events.NewBus(appConfig.Events, appConfig.Breaker, retries)
+	if err != nil {
+		log.Fatalf("failed to configure event sinks: %v", err)
+	}
+	chatManger.Events = bus
+	indexer, err := search.NewIndexer(appConfig.Search, searchBreaker, retries)
+	if err != nil {
+		log.Fatalf("failed to configure search: %v", err)
+	}
+	chatManger.Search = indexer
+	if appConfig.MQTT.BrokerURL != "" {
+		if appConfig.MQTT.QoS != 0 && appConfig.MQTT.QoS != 1 {
+			log.Fatalf("failed to configure MQTT bridge: unsupported QoS %d", appConfig.MQTT.QoS)
+		}
+		client, err := mqtt.NewClient(appConfig.MQTT.BrokerURL, appConfig.MQTT.ClientID, appConfig.MQTT.KeepAlive, appConfig.MQTT.Timeout)
+		if err != nil {
+			log.Fatalf("failed to configure MQTT bridge: %v", err)
+		}
+		chatManger.BridgeMQTT(client, breaker.New("mqtt", appConfig.Breaker), appConfig.MQTT)
+	}
+	callManager.Events = bus
+	signalingManger.Events = bus
+	if appConfig.Chat.CacheSize > 0 {
+		chatManger.Cache = chat.NewSessionCache(appConfig.Chat.CacheSize)
+	}
+	chatManger.SnapshotEvery = appConfig.Chat.SnapshotEvery
+	chatManger.EphemeralTTL = appConfig.Chat.EphemeralTTL
+	chatManger.DraftTTL = appConfig.Chat.DraftTTL
+	chatManger.MaxStarred = appConfig.Chat.MaxStarred
+	chatManger.SystemMessages = appConfig.Chat.SystemMessages
+	chatManger.IdleExpiry = appConfig.Chat.IdleExpiry
+	chatManger.MaxDuration = appConfig.Session.MaxDuration
+	if loaded, err := chatManger.LoadTemplates(); err != nil {
+		log.Fatalf("failed to load chat session templates: %v", err)
+	} else if loaded > 0 {
+		log.Printf("Loaded %d chat session templates\n", loaded)
+	}
+	go purgeChatData()
+	retries.Handle(chat.SaveSessionJob, chatManger.RetrySave)
+	retries.Handle(chat.BotCommandJob, chatManger.RetryBotCommand)
+	if err := retries.Start(); err != nil {
+		log.Fatalf("failed to start retry queue: %v", err)
+	}
+
+	registerSignalingHandlers()
+	if restored := chatManger.RestoreSessions(); restored > 0 {
+		log.Printf("Restored %d chat sessions\n", restored)
+	}
+	if restored := callManager.RestoreSessions(); restored > 0 {
+		log.Printf("Restored %d calls\n", restored)
+	}
+
 	e := echo.New()
+	e.JSONSerializer = apiSerializer{}
+	if appConfig.Server.TrustProxyHeaders {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	requestMetrics := metrics.NewRecorder(appConfig.Metrics)
 
 	e.Use(middleware.Logger())
+	e.Use(requestMetrics.Middleware())
 	e.Use(middleware.Recover())
 
-	peerManager := peer.NewPeerManager()
+	fileTransfers := peer.NewFileTransferRelay(appConfig.FileTransfer.Dir, appConfig.FileTransfer.QuarantineDir, appConfig.FileTransfer.MaxSize)
+	fileTransfers.OnComplete = func(transfer *peer.FileTransfer) {
+		attachFileTransfer(fileTransfers, transfer)
+	}
+	peerManager := peer.NewPeerManager(webrtcAPI, peerFactory.Configuration(), fileTransfers)
 
-	e.POST("/offer", func(c echo.Context) error {
-		return handleOffer(c, peerManager)
+	loadShedder = overload.NewGuard(appConfig.LoadShedding, func() int {
+		return peerManager.Count() + callManager.PeerConnectionCount()
+	})
+
+	echoTester := peer.NewEchoTester(webrtcAPI, peerFactory.Configuration(), appConfig.EchoTest.Duration)
+
+	e.Static("/uploads", appConfig.FileTransfer.Dir)
+	e.Static("/attachments", appConfig.Attachment.Dir)
+
+	// /health predates the split into liveness and readiness and stays an
+	// alias of /healthz
+	drainer = lifecycle.NewDrainer(appConfig.Server.DrainTimeout, drainCalls, func() int {
+		return callManager.SessionCount()
+	})
+	liveness := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "Server is healthy", drainer.Status()))
+	}
+	e.GET("/health", liveness)
+	e.GET("/healthz", liveness)
+	e.GET("/readyz", readiness(newReadinessChecker(appConfig.Health)))
+	// Kubernetes calls the preStop hook and waits for it before sending
+	// SIGTERM, which drains as well when the hook isn't configured
+	e.GET("/prestop", func(c echo.Context) error {
+		select {
+		case <-drainer.Start():
+		case <-c.Request().Context().Done():
+		}
+		return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "Server is draining", drainer.Status()))
+	})
+	go shutdownOnSignal(e)
+	e.GET("/version", getVersion)
+	e.GET("/metrics", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4")
+		c.Response().WriteHeader(http.StatusOK)
+		return requestMetrics.WritePrometheus(c.Response())
+	})
+
+	// Every API version is registered under its own prefix. The unprefixed
+	// routes predate versioning and stay as deprecated aliases of v1.
+	registerV1Routes(e.Group("/v1"), peerManager, echoTester)
+	registerV1Routes(e.Group(""), peerManager, echoTester, deprecated("/v1"))
+	if nodes != nil {
+		registerClusterRoutes(e.Group("/v1/cluster"))
+	}
+
+	if err := startServer(e, appConfig.Server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		e.Logger.Fatal(err)
+	}
+	// The calls ended while draining are removed from the state store
+	callManager.FlushState()
+}
+
+// getVersion describes the build and the optional features it runs with,
+// letting clients check their compatibility
+func getVersion(c echo.Context) error {
+	server := appConfig.Server
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "version retrieved successfully", map[string]interface{}{
+		"build": buildinfo.Get(),
+		// Calls are always routed through the built-in SFU
+		"features": map[string]bool{
+			"sfu":                true,
+			"recording":          appConfig.Recording.Dir != "",
+			"noiseSuppression":   callManager.Denoiser != nil,
+			"audioMixing":        callManager.Mixer != nil,
+			"watermarking":       callManager.Watermark != nil,
+			"snapshots":          callManager.Snapshots != nil,
+			"redis":              appConfig.State.Backend == "redis",
+			"tls":                len(server.AutocertDomains) > 0 || server.TLSCertFile != "",
+			"webTransport":       webTransportServer != nil,
+			"webSocketTickets":   wsTickets != nil,
+			"coldStorage":        chatManger.ColdStore != nil,
+			"chatCache":          chatManger.Cache != nil,
+			"webhooks":           appConfig.Webhook.URL != "",
+			"attachmentScanning": appConfig.Attachment.Scanner != "none",
+			"linkPreviews":       appConfig.LinkPreview.Enabled,
+			"translation":        chatManger.Translator != nil,
+			"messageAnalysis":    chatManger.Scorer != nil,
+			"spamFilter":         appConfig.Spam.Enabled,
+			"search":             chatManger.Search != nil,
+			"voiceMessages":      voiceTranscoder != nil,
+		},
+	}))
+}
+
+// newReadinessChecker checks the storage the service writes to, the load
+// against its limits and, without failing readiness, the external services
+// guarded by circuit breakers
+func newReadinessChecker(cfg config.HealthConfig) *health.Checker {
+	checker := health.NewChecker(cfg.CheckTimeout)
+
+	checker.Add("chat-store", true, chatManger.CheckStore)
+	checker.Add("lifecycle", true, drainer.Check)
+	checker.Add("call-archive", true, health.WritableDir(appConfig.Call.ArchiveDir))
+	checker.Add("call-cdr", true, health.WritableDir(appConfig.Call.CDRDir))
+	if callManager.State != nil {
+		checker.Add("call-state", true, callManager.State.Check)
+	}
+	if callManager.History != nil {
+		checker.Add("call-history", true, callManager.History.Check)
+	}
+	checker.Add("recordings", true, health.WritableDir(appConfig.Recording.Dir))
+	checker.Add("attachments", true, health.WritableDir(appConfig.Attachment.Dir))
+	checker.Add("quarantine", true, health.WritableDir(appConfig.Attachment.QuarantineDir))
+	checker.Add("file-transfers", true, health.WritableDir(appConfig.FileTransfer.Dir))
+	checker.Add("file-transfer-quarantine", true, health.WritableDir(appConfig.FileTransfer.QuarantineDir))
+	checker.Add("retry-queue", true, health.WritableDir(appConfig.Retry.Dir))
+
+	checker.Add("goroutines", true, func(context.Context) error {
+		if count := runtime.NumGoroutine(); count > cfg.MaxGoroutines {
+			return fmt.Errorf("%d goroutines exceed the limit of %d", count, cfg.MaxGoroutines)
+		}
+		return nil
+	})
+	checker.Add("connections", true, func(context.Context) error {
+		if count := webSocketConnections(); count > cfg.MaxConnections {
+			return fmt.Errorf("%d connections exceed the limit of %d", count, cfg.MaxConnections)
+		}
+		return nil
+	})
+
+	breakers := map[string]*breaker.Breaker{"webhook": webhookBreaker, "bots": botBreaker}
+	if appConfig.Attachment.Scanner != "none" {
+		breakers["scanner"] = scannerBreaker
+	}
+	if chatManger.ColdStore != nil {
+		breakers["cold-storage"] = coldStoreBreaker
+	}
+	if chatManger.Search != nil {
+		breakers["search"] = searchBreaker
+	}
+	for name, b := range breakers {
+		checker.Add(name, false, func(context.Context) error {
+			if b.State() == breaker.Open {
+				return breaker.ErrOpen
+			}
+			return nil
+		})
+	}
+
+	return checker
+}
+
+// webSocketConnections counts the open signaling and chat sockets
+func webSocketConnections() int {
+	return signalingManger.ConnectionCount() + chatManger.Hub.ConnectionCount()
+}
+
+// readiness answers 503 while a critical dependency is down, listing the
+// status of every dependency
+func readiness(checker *health.Checker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ready, results := checker.Run(c.Request().Context())
+
+		status, message := http.StatusOK, "Server is ready"
+		if !ready {
+			status, message = http.StatusServiceUnavailable, "Server is not ready"
+		}
+		return c.JSON(status, utils.NewSuccessResponse(status, message, map[string]interface{}{
+			"ready":       ready,
+			"goroutines":  runtime.NumGoroutine(),
+			"connections": webSocketConnections(),
+			"checks":      results,
+			"lifecycle":   drainer.Status(),
+		}))
+	}
+}
+
+// shutdownOnSignal drains the service on SIGTERM or an interrupt and then
+// stops the server
+func shutdownOnSignal(e *echo.Echo) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+
+	<-drainer.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v\n", err)
+	}
+	if webTransportServer != nil {
+		if err := webTransportServer.Close(); err != nil {
+			log.Printf("Error closing WebTransport server: %v\n", err)
+		}
+	}
+}
+
+// drainCalls hands the calls over to the other nodes of the cluster, waits
+// for the remaining ones to end and ends those still live at the deadline
+func drainCalls(ctx context.Context) {
+	if nodes != nil {
+		for _, result := range handOverAll(ctx) {
+			if !result.Success {
+				log.Printf("Error handing over call %s: %s\n", result.ID, result.Error)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for callManager.SessionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			for _, id := range callManager.SessionIDs() {
+				callManager.TerminateSession(id, call.TerminationShutdown)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startServer serves HTTPS with Let's Encrypt certificates when domains are
+// configured, or with the configured certificate, and plain HTTP otherwise.
+// With TLS, plain HTTP requests are redirected to HTTPS.
+func startServer(e *echo.Echo, cfg config.ServerConfig) error {
+	switch {
+	case len(cfg.AutocertDomains) > 0:
+		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.AutocertDomains...)
+		e.AutoTLSManager.Cache = autocert.DirCache(cfg.AutocertCacheDir)
+		e.AutoTLSManager.Email = cfg.AutocertEmail
+		if cfg.RedirectAddr != "" {
+			// Also answers the HTTP-01 challenges of Let's Encrypt
+			go serveRedirects(cfg.RedirectAddr, e.AutoTLSManager.HTTPHandler(redirectToHTTPS(cfg.Addr)))
+		}
+		if cfg.WebTransportAddr != "" {
+			server := newWebTransportServer(e, cfg.WebTransportAddr)
+			server.H3.TLSConfig = e.AutoTLSManager.TLSConfig()
+			go serveWebTransport(server.ListenAndServe)
+		}
+		return e.StartAutoTLS(cfg.Addr)
+
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		if cfg.RedirectAddr != "" {
+			go serveRedirects(cfg.RedirectAddr, redirectToHTTPS(cfg.Addr))
+		}
+		if cfg.WebTransportAddr != "" {
+			server := newWebTransportServer(e, cfg.WebTransportAddr)
+			go serveWebTransport(func() error {
+				return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			})
+		}
+		return e.StartTLS(cfg.Addr, cfg.TLSCertFile, cfg.TLSKeyFile)
+
+	default:
+		if cfg.WebTransportAddr != "" {
+			log.Println("WebTransport requires TLS, not serving it")
+		}
+		return e.Start(cfg.Addr)
+	}
+}
+
+// newWebTransportServer returns the HTTP/3 server accepting WebTransport
+// signaling sessions on /v1/wt. It is closed when the service stops.
+func newWebTransportServer(e *echo.Echo, addr string) *webtransport.Server {
+	server := &webtransport.Server{CheckOrigin: originPolicy.Allowed}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/wt", func(w http.ResponseWriter, r *http.Request) {
+		handleWebTransport(e.NewContext(r, w), server)
+	})
+	server.H3 = http3.Server{Addr: addr, Handler: mux}
+	webTransportServer = server
+	return server
+}
+
+func serveWebTransport(listen func() error) {
+	if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("WebTransport server stopped: %v", err)
+	}
+}
+
+func serveRedirects(addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("HTTP redirect server stopped: %v", err)
+	}
+}
+
+// redirectToHTTPS redirects requests to the same URL on the HTTPS address
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
 	})
-	e.POST("/ice-candidate", func(c echo.Context) error {
+}
+
+// registerV1Routes registers the v1 API on a group. Requests and responses
+// of v1 don't change, breaking changes go to a new version registered next
+// to it.
+func registerV1Routes(g *echo.Group, peerManager *peer.PeerManager, echoTester *peer.EchoTester, m ...echo.MiddlewareFunc) {
+	// shed guards the routes that start new sessions, peer connections or
+	// sockets, everything serving existing ones keeps working under load
+	shed := append(slices.Clone(m), loadShedder.Middleware(), drainer.Middleware())
+
+	g.POST("/offer", func(c echo.Context) error {
+		return handleOffer(c, peerManager)
+	}, shed...)
+	g.POST("/ice-candidate", func(c echo.Context) error {
 		return handleICECandidate(c, peerManager)
+	}, m...)
+	g.POST("/peer/echo-test", func(c echo.Context) error {
+		return startEchoTest(c, echoTester)
+	}, shed...)
+
+	g.GET("/ws", handleWebSocket, shed...)
+	g.POST("/ws/ticket", issueWebSocketTicket, m...)
+
+	g.POST("/chat/session", createChatSession, shed...)
+	g.POST("/chat/message", sendChatMessage, m...)
+	g.GET("/chat/messages/:sessionID", getChatMessages, m...)
+	g.GET("/chat/events/:sessionID", getChatEvents, m...)
+	g.GET("/search", searchMessages, m...)
+
+	g.POST("/call/session", createCallSession, shed...)
+	g.POST("/call/join", joinCall, shed...)
+	g.POST("/call/offer", handleCallOffer, m...)
+	g.POST("/call/answer", handleCallAnswer, m...)
+	g.POST("/call/lobby", addToLobby, m...)
+	g.POST("/call/lobby/admit", admitFromLobby, m...)
+	g.POST("/call/cohost", setCoHost, m...)
+	g.POST("/call/end", endCall, m...)
+	g.POST("/call/lock", lockCall, m...)
+	g.POST("/call/passcode", rotatePasscode, m...)
+	g.POST("/call/mute", toggleMute, m...)
+	g.POST("/call/mute-all", muteAll, m...)
+	g.POST("/call/mute-all/lift", liftHardMute, m...)
+	g.POST("/call/recording", toggleRecording, m...)
+	g.POST("/call/quality", updateCallQuality, m...)
+	g.POST("/call/quality/preset", setParticipantQuality, m...)
+	g.POST("/call/audio-only", setAudioOnly, m...)
+	g.GET("/call/sessions", listCallSessions, m...)
+	g.GET("/call/resolve/:code", resolveJoinCode, m...)
+	g.POST("/call/code/regenerate", regenerateJoinCode, m...)
+	g.GET("/call/session/:sessionID", getCallSession, m...)
+	g.GET("/call/stats/:sessionID", getCallStats, m...)
+	g.GET("/call/stats/:sessionID/timeline", getCallStatsTimeline, m...)
+	g.GET("/call/report/:sessionID", getCallQualityReport, m...)
+	g.GET("/call/talk-time/:sessionID", getCallTalkTime, m...)
+	g.GET("/call/:sessionID/snapshot", getCallSnapshot, m...)
+	g.GET("/call/voicemail/:userID", listVoicemail, m...)
+	g.GET("/call/voicemail/:userID/:voicemailID", getVoicemailAudio, m...)
+	g.GET("/call/archive", listArchivedCalls, m...)
+	g.GET("/call/archive/:sessionID", getArchivedCall, m...)
+	g.POST("/call/archive/:sessionID/rehydrate", rehydrateArchivedCall, m...)
+	g.PATCH("/call/participant", updateCallParticipant, m...)
+	g.POST("/call/participants/add", addCallParticipants, m...)
+	g.POST("/call/participants/remove", removeCallParticipants, m...)
+	g.POST("/call/recording/start", startRecording, m...)
+	g.POST("/call/recording/stop", stopRecording, m...)
+	g.POST("/call/recording/mix", mixRecordings, m...)
+	g.POST("/call/gain", setParticipantGain, m...)
+	g.POST("/call/layout", setCallLayout, m...)
+	g.POST("/call/pin", pinCallParticipant, m...)
+	g.POST("/call/invite", inviteToCall, m...)
+	g.POST("/call/invite/answer", answerCallInvitation, m...)
+	g.GET("/call/missed/:userID", listMissedCalls, m...)
+	g.POST("/call/transfer", transferCallParticipant, m...)
+	g.POST("/call/transfer/answer", answerCallTransfer, m...)
+
+	g.POST("/chat/attachment", addChatAttachment, m...)
+	g.POST("/chat/upload", uploadChatAttachment, m...)
+	g.POST("/chat/voice", sendVoiceMessage, m...)
+	g.POST("/chat/reaction", addChatReaction, m...)
+	g.POST("/chat/pin", pinParticipant, m...)
+	g.POST("/chat/moderate", moderateParticipant, m...)
+	g.POST("/chat/announcement", sendAnnouncement, m...)
+	g.POST("/chat/ephemeral", sendEphemeral, m...)
+	g.POST("/chat/slowmode", setSlowMode, m...)
+	g.POST("/chat/lock", lockChat, m...)
+	g.GET("/chat/sessions", listChatSessions, m...)
+	g.GET("/chat/usage/:sessionID", getChatUsage, m...)
+	g.GET("/chat/participants/:sessionID", getChatParticipants, m...)
+	g.GET("/chat/archive", listArchivedChatSessions, m...)
+	g.GET("/chat/archive/:sessionID", getArchivedChatSession, m...)
+	g.POST("/chat/archive/:sessionID/rehydrate", rehydrateArchivedChatSession, m...)
+	g.PATCH("/chat/participant", updateChatParticipant, m...)
+	g.POST("/chat/drafts", saveChatDraft, m...)
+	g.GET("/chat/drafts", listChatDrafts, m...)
+	g.GET("/chat/drafts/:sessionID", getChatDraft, m...)
+	g.DELETE("/chat/drafts/:sessionID", clearChatDraft, m...)
+	g.POST("/chat/starred", starChatMessage, m...)
+	g.GET("/chat/starred", listStarredMessages, m...)
+	g.DELETE("/chat/starred/:sessionID/:messageID", unstarChatMessage, m...)
+	g.POST("/chat/participants/add", addChatParticipants, m...)
+	g.POST("/chat/participants/remove", removeChatParticipants, m...)
+	g.POST("/chat/bots", registerChatBot, m...)
+	g.POST("/chat/bots/remove", removeChatBot, m...)
+	g.GET("/chat/bots/:sessionID", listChatBots, m...)
+	g.POST("/bots/messages", postBotMessage, m...)
+	g.POST("/bots/attachments", uploadBotAttachment, m...)
+
+	g.GET("/chat/notifications", handleChatNotifications, shed...)
+	g.GET("/chat/ws", handleChatSocket, shed...)
+
+	// Admin endpoints take an admin token, users may also reach their own
+	// data through the privacy endpoints
+	admin := append(slices.Clone(m), apiAuth.Admin())
+	g.GET("/admin/audit", getAuditLog, admin...)
+	g.GET("/admin/cdr", listCallDetailRecords, admin...)
+	g.GET("/admin/cdr/:sessionID", getCallDetailRecord, admin...)
+	g.POST("/admin/loadtest", startLoadTest, append(slices.Clone(admin), loadShedder.Middleware(), drainer.Middleware())...)
+	g.DELETE("/admin/loadtest/:testID", stopLoadTest, admin...)
+	g.POST("/admin/chat/templates", createChatTemplate, admin...)
+	g.GET("/admin/chat/templates", listChatTemplates, admin...)
+	g.GET("/admin/chat/templates/:templateID", getChatTemplate, admin...)
+	g.PUT("/admin/chat/templates/:templateID", updateChatTemplate, admin...)
+	g.DELETE("/admin/chat/templates/:templateID", deleteChatTemplate, admin...)
+
+	g.GET("/analytics/calls", getCallAnalytics, admin...)
+	g.GET("/analytics/chat", getChatAnalytics, admin...)
+
+	user := append(slices.Clone(m), apiAuth.AdminOr(func(c echo.Context) string {
+		return c.Param("userID")
+	}))
+	owner := append(slices.Clone(m), apiAuth.AdminOr(exportOwner))
+	g.DELETE("/privacy/user/:userID", eraseUser, user...)
+	g.GET("/privacy/export/:userID", startExport, user...)
+	g.GET("/privacy/exports/:jobID", getExport, owner...)
+	g.GET("/privacy/exports/:jobID/download", downloadExport, owner...)
+}
+
+// deprecated marks responses of a legacy route with a Deprecation header and
+// links the route of the successor version
+func deprecated(successor string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			header.Set("Deprecation", "true")
+			header.Set("Link", "<"+successor+c.Request().URL.Path+">; rel=\"successor-version\"")
+			return next(c)
+		}
+	}
+}
+
+// apiSerializer translates the ErrorResponses the handlers return to the
+// language of the request's Accept-Language header
+type apiSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+func (s apiSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	if errResp, ok := i.(*utils.ErrorResponse); ok && errResp != nil {
+		acceptLanguage := c.Request().Header.Get("Accept-Language")
+		header := c.Response().Header()
+		header.Add("Vary", "Accept-Language")
+		header.Set("Content-Language", i18n.Negotiate(acceptLanguage))
+
+		localized := *errResp
+		localized.Message = i18n.Translate(acceptLanguage, errResp.Message)
+		if len(errResp.Errors) > 0 {
+			localized.Errors = make([]utils.FieldError, len(errResp.Errors))
+			for j, fieldErr := range errResp.Errors {
+				localized.Errors[j] = utils.FieldError{Field: fieldErr.Field, Reason: i18n.Translate(acceptLanguage, fieldErr.Reason)}
+			}
+		}
+		i = &localized
+	}
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	if indent != "" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", indent); err != nil {
+			return err
+		}
+		data = indented.Bytes()
+	}
+	_, err = c.Response().Write(append(data, '\n'))
+	return err
+}
+
+// bind decodes the body of a request and checks its validate tags. Fields of
+// the wrong type and fields breaking a rule are listed in the error.
+func bind(c echo.Context, request interface{}) *utils.ErrorResponse {
+	if err := c.Bind(request); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			reason := "must be a " + typeErr.Type.String()
+			if typeErr.Type == reflect.TypeOf(utils.Duration(0)) {
+				reason = "must be a duration such as \"90m\" or \"PT1H30M\""
+			}
+			return utils.NewValidationErrorResponse([]utils.FieldError{{Field: typeErr.Field, Reason: reason}})
+		}
+		return utils.NewErrorResponse(http.StatusBadRequest, "invalid request")
+	}
+
+	if errs := utils.Validate(request); len(errs) > 0 {
+		return utils.NewValidationErrorResponse(errs)
+	}
+	return nil
+}
+
+// validateSessionDuration checks the requested length of a chat or call
+// session against the configured bounds
+func validateSessionDuration(duration utils.Duration) *utils.ErrorResponse {
+	d := time.Duration(duration)
+	if d < appConfig.Session.MinDuration || d > appConfig.Session.MaxDuration {
+		reason := fmt.Sprintf("must be between %s and %s", appConfig.Session.MinDuration, appConfig.Session.MaxDuration)
+		return utils.NewValidationErrorResponse([]utils.FieldError{{Field: "duration", Reason: reason}})
+	}
+	return nil
+}
+
+// validateOffer checks the SDP offer posted to the peer endpoints
+func validateOffer(offer webrtc.SessionDescription) *utils.ErrorResponse {
+	var errs []utils.FieldError
+	if offer.Type != webrtc.SDPTypeOffer {
+		errs = append(errs, utils.FieldError{Field: "type", Reason: "must be offer"})
+	}
+	if offer.SDP == "" {
+		errs = append(errs, utils.FieldError{Field: "sdp", Reason: "is required"})
+	}
+	if len(errs) > 0 {
+		return utils.NewValidationErrorResponse(errs)
+	}
+	return nil
+}
+
+func handleOffer(c echo.Context, peerManager *peer.PeerManager) error {
+	var offer webrtc.SessionDescription
+	if errResp := bind(c, &offer); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if errResp := validateOffer(offer); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	peerID := c.QueryParam("peerID")
+	if peerID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "peerID is required"))
+	}
+
+	ip := c.RealIP()
+	if !peerLimits.Acquire(ip) {
+		return c.JSON(http.StatusTooManyRequests, utils.NewErrorResponse(http.StatusTooManyRequests, "too many peer connections from this address"))
+	}
+
+	peerConnectionState, errResp := peerManager.CreatePeerConnection(peerID)
+	if errResp != nil {
+		peerLimits.Release(ip)
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	// The connection counts against the address until it fails or closes
+	var release sync.Once
+	peerConnectionState.PeerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			release.Do(func() { peerLimits.Release(ip) })
+		}
 	})
 
-	e.GET("/ws", func(c echo.Context) error {
-		return handleWebSocket(c)
+	// A failed negotiation frees the peer ID and the slot of the address, so
+	// the client can retry
+	negotiated := false
+	defer func() {
+		if negotiated {
+			return
+		}
+		if errResp := peerManager.ClosePeerConnection(peerID); errResp != nil {
+			log.Printf("Error closing peer connection %s: %s\n", peerID, errResp.Message)
+		}
+		release.Do(func() { peerLimits.Release(ip) })
+	}()
+
+	if err := peer.ApplyOffer(peerConnectionState.PeerConnection, offer); err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set remote description"))
+	}
+
+	answer, err := peerConnectionState.PeerConnection.CreateAnswer(nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create answer"))
+	}
+
+	if err := peerConnectionState.PeerConnection.SetLocalDescription(answer); err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set local description"))
+	}
+	negotiated = true
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "answer created successfully", answer))
+}
+
+func handleICECandidate(c echo.Context, peerManager *peer.PeerManager) error {
+	var candidate webrtc.ICECandidateInit
+	if errResp := bind(c, &candidate); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if candidate.Candidate == "" {
+		errResp := utils.NewValidationErrorResponse([]utils.FieldError{{Field: "candidate", Reason: "is required"}})
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	peerID := c.QueryParam("peerID")
+	if peerID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "peerID is required"))
+	}
+
+	if errResp := peerManager.AddICECandidate(peerID, candidate); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "ICE candidate added successfully", nil))
+}
+
+func startEchoTest(c echo.Context, echoTester *peer.EchoTester) error {
+	var offer webrtc.SessionDescription
+	if errResp := bind(c, &offer); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if errResp := validateOffer(offer); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	test, errResp := echoTester.Start(offer)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "echo test started successfully", test))
+}
+
+// websocket handler for signaling
+func handleWebSocket(c echo.Context) error {
+	peerID, authenticated, errResp := authorizeUpgrade(c, wsauth.ScopeSignaling, c.QueryParam("peerID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	release, errResp := acquireSocket(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	defer release()
+
+	upgrader := newUpgrader(signaling.Subprotocols...)
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to upgrade connection: "+err.Error()))
+	}
+	ws.SetReadLimit(appConfig.WebSocket.MaxMessageSize)
+
+	// Without a ticket the peer ID is confirmed by the signaling handshake
+	signalingManger.HandleWebSocket(ws, peerID, authenticated)
+	return nil
+}
+
+// handleWebTransport serves signaling over a WebTransport session, with the
+// tickets, limits and messages of the WebSocket endpoint. The wire format is
+// chosen with the protocol query parameter, as there is no subprotocol
+// negotiation.
+func handleWebTransport(c echo.Context, server *webtransport.Server) {
+	peerID, authenticated, errResp := authorizeUpgrade(c, wsauth.ScopeSignaling, c.QueryParam("peerID"))
+	if errResp != nil {
+		_ = c.JSON(errResp.StatusCode, errResp)
+		return
+	}
+
+	release, errResp := acquireSocket(c)
+	if errResp != nil {
+		_ = c.JSON(errResp.StatusCode, errResp)
+		return
+	}
+	defer release()
+
+	// The session takes over the raw HTTP/3 stream, which echo's response
+	// writer doesn't expose
+	session, err := server.Upgrade(c.Response().Writer, c.Request())
+	if err != nil {
+		_ = c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "Failed to upgrade connection: "+err.Error()))
+		return
+	}
+
+	signalingManger.HandleWebTransport(session, c.QueryParam("protocol"), peerID, authenticated, appConfig.WebSocket.MaxMessageSize)
+}
+
+// registerSignalingHandlers wires the signaling messages handled by the
+// server and limits signaling rooms to the participants of a call or chat
+func registerSignalingHandlers() {
+	signalingManger.AuthorizeRoom = func(peerID, roomID string) bool {
+		if callManager.IsParticipant(roomID, peerID) {
+			return true
+		}
+		participants, errResp := chatManger.GetParticipants(roomID)
+		return errResp == nil && slices.Contains(participants, peerID)
+	}
+	signalingManger.Handle(signaling.KnockMessage, handleKnock)
+	signalingManger.Handle(signaling.KnockResponseMessage, handleKnockResponse)
+	signalingManger.Handle(signaling.LayoutMessage, handleLayout)
+	signalingManger.OnConnect = promptRejoin
+}
+
+// promptRejoin asks a peer that connects to rejoin the calls in which it is
+// reconnecting, such as those restored after a restart
+func promptRejoin(peerID string) {
+	for _, rejoin := range callManager.PendingRejoins(peerID) {
+		if err := signalingManger.Send(peerID, struct {
+			Type string `json:"type"`
+			call.Rejoin
+		}{signaling.RejoinMessage, rejoin}); err != nil {
+			log.Printf("Error prompting %s to rejoin call %s: %v\n", peerID, rejoin.SessionID, err)
+		}
+	}
+}
+
+// handleKnock asks the hosts and co-hosts of a call to let the peer in
+func handleKnock(peerID string, message []byte) {
+	var request struct {
+		SessionID string                  `json:"sessionId"`
+		Profile   call.ParticipantProfile `json:"profile"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		signalingManger.SendError(peerID, "invalid knock")
+		return
+	}
+
+	knock, moderators, errResp := callManager.Knock(request.SessionID, peerID, request.Profile)
+	if errResp != nil {
+		signalingManger.SendError(peerID, errResp.Message)
+		return
+	}
+
+	prompt := struct {
+		Type string `json:"type"`
+		*call.Knock
+	}{signaling.KnockMessage, knock}
+	for _, id := range moderators {
+		if err := signalingManger.Send(id, prompt); err != nil {
+			log.Printf("Error prompting %s about knock from %s: %v\n", id, peerID, err)
+		}
+	}
+}
+
+// handleLayout switches the layout of a call on behalf of a host or co-host
+func handleLayout(peerID string, message []byte) {
+	var request struct {
+		SessionID   string `json:"sessionId"`
+		Layout      string `json:"layout"`
+		PresenterID string `json:"presenterId"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		signalingManger.SendError(peerID, "invalid layout")
+		return
+	}
+
+	change, participants, errResp := callManager.SetLayout(request.SessionID, peerID, call.Layout(request.Layout), request.PresenterID)
+	if errResp != nil {
+		signalingManger.SendError(peerID, errResp.Message)
+		return
+	}
+	announceLayout(request.SessionID, change, participants)
+}
+
+// announceLayout relays a layout change to the participants of a call over
+// signaling and to every client as a notification
+func announceLayout(sessionID string, change *call.LayoutChange, participants []string) {
+	relayed := struct {
+		Type      string `json:"type"`
+		SessionID string `json:"sessionId"`
+		*call.LayoutChange
+	}{signaling.LayoutMessage, sessionID, change}
+	for _, id := range participants {
+		// Participants not connected to signaling miss the change, they get
+		// the current layout when they join again
+		_ = signalingManger.Send(id, relayed)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.LayoutNotification,
+		SessionID: sessionID,
+		Data:      change,
 	})
+}
+
+// handleKnockResponse applies a host's answer to a knock and tells the requester
+func handleKnockResponse(peerID string, message []byte) {
+	var request struct {
+		SessionID   string `json:"sessionId"`
+		RequesterID string `json:"requesterId"`
+		Approved    bool   `json:"approved"`
+		Mode        string `json:"mode"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		signalingManger.SendError(peerID, "invalid knock response")
+		return
+	}
+
+	if errResp := callManager.AnswerKnock(request.SessionID, peerID, request.RequesterID, request.Approved, request.Mode); errResp != nil {
+		signalingManger.SendError(peerID, errResp.Message)
+		return
+	}
+
+	err := signalingManger.Send(request.RequesterID, map[string]interface{}{
+		"type":      signaling.KnockResultMessage,
+		"sessionId": request.SessionID,
+		"approved":  request.Approved,
+		"mode":      request.Mode,
+	})
+	if err != nil {
+		log.Printf("Error answering knock of %s: %v\n", request.RequesterID, err)
+	}
+}
+
+// attachmentTypeFor picks the attachment type matching a content type
+func attachmentTypeFor(contentType string) chat.AttachmentType {
+	if strings.HasPrefix(contentType, "image/") {
+		return chat.ImageAttachment
+	}
+	return chat.FileAttachment
+}
+
+// attachFileTransfer posts a completed DataChannel transfer to its chat session as a file message
+func attachFileTransfer(fileTransfers *peer.FileTransferRelay, transfer *peer.FileTransfer) {
+	// Infected files and failed scans are deleted by the scan
+	scan, errResp := attachmentStore.ScanFile(transfer.Path)
+	if errResp != nil {
+		log.Printf("Error attaching file transfer %s: %s\n", transfer.ID, errResp.Message)
+		return
+	}
+	if err := fileTransfers.Publish(transfer); err != nil {
+		log.Printf("Error publishing file transfer %s: %v\n", transfer.ID, err)
+		return
+	}
+
+	message := chat.ChatMessage{
+		SenderID:   transfer.SenderID,
+		ReceiverID: transfer.ReceiverID,
+		Type:       chat.FileMessage,
+		Message:    transfer.Name,
+		Attachments: []chat.Attachment{{
+			Type:        attachmentTypeFor(transfer.MimeType),
+			URL:         "/uploads/" + url.PathEscape(transfer.StorageID) + "/" + url.PathEscape(transfer.Name),
+			Name:        transfer.Name,
+			Size:        transfer.Size,
+			ContentType: transfer.MimeType,
+			ScanStatus:  string(scan.Status),
+		}},
+	}
+	if _, errResp := chatManger.AddMessage(transfer.SessionID, message); errResp != nil {
+		log.Printf("Error attaching file transfer %s: %s\n", transfer.ID, errResp.Message)
+		os.RemoveAll(filepath.Dir(transfer.Path))
+	}
+}
+
+func createChatSession(c echo.Context) error {
+	var request struct {
+		CreatorID    string                             `json:"creatorId" validate:"required"`
+		Participants []string                           `json:"participants"`
+		Profiles     map[string]chat.ParticipantProfile `json:"profiles"`
+		Duration     utils.Duration                     `json:"duration"`
+		IsGroup      bool                               `json:"isGroup"`
+		Metadata     map[string]interface{}             `json:"metadata"`
+		Tags         []string                           `json:"tags"`
+		SlowMode     int                                `json:"slowModeSeconds" validate:"min=0"`
+		TemplateID   string                             `json:"templateId"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if errResp := validateSessionDuration(request.Duration); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	opts := chat.SessionOptions{
+		Metadata:        request.Metadata,
+		Tags:            request.Tags,
+		SlowModeSeconds: request.SlowMode,
+		TemplateID:      request.TemplateID,
+	}
+	session, errResp := chatManger.CreateChatSession(request.CreatorID, request.Participants, request.Profiles, time.Duration(request.Duration), request.IsGroup, opts)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat session created successfully", session))
+}
+
+// chatTemplateRequest is the body creating or replacing a session template
+type chatTemplateRequest struct {
+	Name            string                          `json:"name" validate:"required"`
+	Description     string                          `json:"description"`
+	DefaultRole     string                          `json:"defaultRole" validate:"oneof=moderator user"`
+	Roles           map[string]chat.ParticipantRole `json:"roles"`
+	SlowModeSeconds int                             `json:"slowModeSeconds" validate:"min=0"`
+	RetentionDays   int                             `json:"retentionDays" validate:"min=0"`
+	AllowedTypes    []chat.MessageType              `json:"allowedTypes"`
+	WelcomeMessage  string                          `json:"welcomeMessage"`
+	Tags            []string                        `json:"tags"`
+}
+
+func (r chatTemplateRequest) template() chat.SessionTemplate {
+	return chat.SessionTemplate{
+		Name:            r.Name,
+		Description:     r.Description,
+		DefaultRole:     chat.ParticipantRole(r.DefaultRole),
+		Roles:           r.Roles,
+		SlowModeSeconds: r.SlowModeSeconds,
+		RetentionDays:   r.RetentionDays,
+		AllowedTypes:    r.AllowedTypes,
+		WelcomeMessage:  r.WelcomeMessage,
+		Tags:            r.Tags,
+	}
+}
+
+func createChatTemplate(c echo.Context) error {
+	var request chatTemplateRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	template, errResp := chatManger.CreateTemplate(request.template())
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "session template created", template))
+}
+
+func listChatTemplates(c echo.Context) error {
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "session templates retrieved successfully", chatManger.ListTemplates()))
+}
+
+func getChatTemplate(c echo.Context) error {
+	template, errResp := chatManger.GetTemplate(c.Param("templateID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "session template retrieved successfully", template))
+}
+
+func updateChatTemplate(c echo.Context) error {
+	var request chatTemplateRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	template, errResp := chatManger.UpdateTemplate(c.Param("templateID"), request.template())
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "session template updated", template))
+}
+
+func deleteChatTemplate(c echo.Context) error {
+	if errResp := chatManger.DeleteTemplate(c.Param("templateID")); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "session template deleted", nil))
+}
+
+func sendChatMessage(c echo.Context) error {
+	var request struct {
+		SessionID  string         `json:"sessionID" validate:"required"`
+		SenderID   string         `json:"senderID" validate:"required"`
+		ReceiverID string         `json:"receiverID"`
+		Message    string         `json:"message"`
+		Type       string         `json:"type" validate:"required,oneof=text image file document emoji system location contact"`
+		Location   *chat.Location `json:"location"`
+		Contact    *chat.Contact  `json:"contact"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	message := chat.ChatMessage{
+		ID:         utils.NewID(utils.PrefixMessage),
+		SenderID:   request.SenderID,
+		ReceiverID: request.ReceiverID,
+		Message:    request.Message,
+		Type:       chat.MessageType(request.Type),
+		Location:   request.Location,
+		Contact:    request.Contact,
+		Timestamp:  utils.Now(),
+	}
+	_, errResp := chatManger.AddMessage(request.SessionID, message)
+	if errResp != nil {
+		if errResp.RetryAfter > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(errResp.RetryAfter))
+		}
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "message sent successfully", nil))
+}
+
+func getChatMessages(c echo.Context) error {
+	sessionID := c.Param("sessionID")
+
+	messages, etag, errResp := chatManger.GetChatMessages(sessionID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	// Messages generated by the service are shown in the client's language,
+	// so each language is a separate representation of the transcript
+	acceptLanguage := c.Request().Header.Get("Accept-Language")
+	if language := i18n.Negotiate(acceptLanguage); language != i18n.DefaultLanguage {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + language + `"`
+		messages = slices.Clone(messages)
+		for i := range messages {
+			if messages[i].SenderID == chat.SystemSenderID {
+				messages[i].Message = i18n.Translate(acceptLanguage, messages[i].Message)
+			}
+		}
+	}
+	c.Response().Header().Set("Vary", "Accept-Language")
+
+	// ?lang= returns the text of messages translated into that language,
+	// messages without such a translation keep their original text
+	if lang := c.QueryParam("lang"); lang != "" {
+		etag = strings.TrimSuffix(etag, `"`) + "-lang-" + translate.Normalize(lang) + `"`
+		messages = chat.InLanguage(messages, lang)
+	}
+
+	// Polling clients revalidate with If-None-Match instead of downloading
+	// an unchanged transcript again
+	c.Response().Header().Set("ETag", etag)
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "messages retrieved successfully", messages))
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag. Weak
+// validators match their strong counterpart.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func createCallSession(c echo.Context) error {
+	var request struct {
+		CreatorID string                 `json:"creatorId" validate:"required"`
+		Type      call.CallType          `json:"type" validate:"oneof=video audio"`
+		Quality   call.CallQuality       `json:"quality" validate:"oneof=sd hd 4k"`
+		Duration  utils.Duration         `json:"duration"`
+		Metadata  map[string]interface{} `json:"metadata"`
+		Tags      []string               `json:"tags"`
+		Codecs    peer.CodecPreferences  `json:"codecs"`
+		Audio     peer.OpusOptions       `json:"audio"`
+		Passcode  string                 `json:"passcode"`
+		E2EE      bool                   `json:"e2ee"`
+		ChatID    string                 `json:"chatSessionId"`
+		MaxCount  int                    `json:"maxParticipants" validate:"min=0"`
+		Overflow  bool                   `json:"overflow"`
+		Denoise   bool                   `json:"noiseSuppression"`
+		Watermark bool                   `json:"watermark"`
+		Silence   bool                   `json:"skipSilence"`
+		CalleeID  string                 `json:"calleeId"`
+	}
+	request.Audio = peer.DefaultOpusOptions()
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if errResp := validateSessionDuration(request.Duration); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	opts := call.SessionOptions{
+		Metadata: request.Metadata,
+		Tags:     request.Tags,
+		Codecs:   request.Codecs,
+		Audio:    request.Audio,
+		Passcode: request.Passcode,
+		E2EE:     request.E2EE,
+
+		ChatSessionID:   request.ChatID,
+		MaxParticipants: request.MaxCount,
+		Overflow:        request.Overflow,
+
+		NoiseSuppression: request.Denoise,
+		Watermark:        request.Watermark,
+		SkipSilence:      request.Silence,
+		CalleeID:         request.CalleeID,
+	}
+	session, errResp := callManager.CreateCallSession(request.CreatorID, request.Type, request.Quality, time.Duration(request.Duration), opts)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session created", session))
+}
+
+func joinCall(c echo.Context) error {
+	var request struct {
+		SessionID     string                  `json:"sessionId" validate:"required"`
+		ParticipantID string                  `json:"participantId" validate:"required"`
+		Passcode      string                  `json:"passcode"`
+		Profile       call.ParticipantProfile `json:"profile"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	info, errResp := callManager.JoinCall(request.SessionID, request.ParticipantID, request.Passcode, request.Profile)
+	if errResp != nil && errResp.StatusCode == http.StatusNotFound && nodes != nil {
+		// The call may be owned by another node, which the client is sent to
+		if node, err := nodes.Locate(c.Request().Context(), request.SessionID); err == nil {
+			return c.Redirect(http.StatusTemporaryRedirect, node.URL+c.Request().URL.RequestURI())
+		}
+	}
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "joined call successfully", info))
+}
+
+// registerClusterRoutes registers the routes the nodes of a cluster call on
+// each other, guarded by the cluster secret
+func registerClusterRoutes(g *echo.Group) {
+	g.Use(nodes.Middleware())
+	g.GET("/calls/:sessionID", getClusterCall)
+	g.POST("/calls", adoptClusterCall)
+	g.POST("/handover", handOverCalls)
+}
+
+// getClusterCall answers whether this node owns a call
+func getClusterCall(c echo.Context) error {
+	if _, errResp := callManager.GetCallSession(c.Param("sessionID")); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session owned", nodes.Self()))
+}
+
+// adoptClusterCall takes over a call handed over by another node
+func adoptClusterCall(c echo.Context) error {
+	state, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid call session state"))
+	}
+	if errResp := callManager.AdoptSession(state); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session adopted", nodes.Self()))
+}
+
+// handOverCalls hands every call of this node over to the other nodes,
+// reporting the outcome for each call
+func handOverCalls(c echo.Context) error {
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "calls handed over", handOverAll(c.Request().Context())))
+}
+
+// handOverAll hands every call over to the next node on the ring that
+// adopts it. Its participants are asked over signaling to rejoin there.
+func handOverAll(ctx context.Context) []utils.BulkResult {
+	ids := callManager.SessionIDs()
+	results := make([]utils.BulkResult, 0, len(ids))
+	for _, id := range ids {
+		var target cluster.Node
+		rejoin, errResp := callManager.HandOver(id, func(state []byte) error {
+			var err error
+			target, err = nodes.HandOver(ctx, id, state)
+			return err
+		})
+		if errResp != nil {
+			results = append(results, utils.BulkFailure(id, errResp.Message))
+			continue
+		}
+		for _, participantID := range rejoin {
+			_ = signalingManger.Send(participantID, struct {
+				Type string `json:"type"`
+				call.Rejoin
+			}{signaling.RejoinMessage, call.Rejoin{SessionID: id, URL: target.URL}})
+		}
+		log.Printf("Handed call %s over to node %s\n", id, target.ID)
+		results = append(results, utils.BulkSuccess(id))
+	}
+	return results
+}
+
+func handleCallOffer(c echo.Context) error {
+	var request struct {
+		SessionID     string                    `json:"sessionId" validate:"required"`
+		ParticipantID string                    `json:"participantId" validate:"required"`
+		Offer         webrtc.SessionDescription `json:"offer"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	answer, errResp := callManager.HandleOffer(request.SessionID, request.ParticipantID, request.Offer)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "answer created successfully", answer))
+}
+
+func handleCallAnswer(c echo.Context) error {
+	var request struct {
+		SessionID     string                    `json:"sessionId" validate:"required"`
+		ParticipantID string                    `json:"participantId" validate:"required"`
+		Answer        webrtc.SessionDescription `json:"answer"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.HandleAnswer(request.SessionID, request.ParticipantID, request.Answer); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "answer applied successfully", nil))
+}
+
+// offerRenegotiation sends a server offer to a call participant over
+// signaling
+func offerRenegotiation(participantID string, renegotiation call.Renegotiation) {
+	if err := signalingManger.Send(participantID, struct {
+		Type string `json:"type"`
+		call.Renegotiation
+	}{signaling.RenegotiateMessage, renegotiation}); err != nil {
+		log.Printf("Error sending renegotiation to %s: %v\n", participantID, err)
+	}
+}
+
+func addToLobby(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Passcode      string `json:"passcode"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.AddToLobby(request.SessionID, request.ParticipantID, request.Passcode)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "added to lobby", nil))
+}
+
+func rotatePasscode(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		HostID    string `json:"hostId" validate:"required"`
+		Passcode  string `json:"passcode"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.RotatePasscode(request.SessionID, request.HostID, request.Passcode)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "passcode updated", nil))
+}
+
+func admitFromLobby(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.AdmitFromLobby(request.SessionID, request.ActorID, request.ParticipantID); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant admitted", nil))
+}
+
+func setCoHost(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		HostID        string `json:"hostId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Enabled       bool   `json:"enabled"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.SetCoHost(request.SessionID, request.HostID, request.ParticipantID, request.Enabled); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.ModerationNotification,
+		SessionID: request.SessionID,
+		Data: map[string]interface{}{
+			"action":        "cohost",
+			"participantId": request.ParticipantID,
+			"enabled":       request.Enabled,
+		},
+	})
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "co-host updated", nil))
+}
+
+func endCall(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		ActorID   string `json:"actorId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.EndCall(request.SessionID, request.ActorID); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call ended", nil))
+}
+
+func lockCall(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		ActorID   string `json:"actorId" validate:"required"`
+		Locked    bool   `json:"locked"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.SetLocked(request.SessionID, request.ActorID, request.Locked); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call lock updated", map[string]bool{"locked": request.Locked}))
+}
+
+func toggleMute(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.ToggleMute(request.SessionID, request.ActorID, request.ParticipantID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "toggled mute", nil))
+}
+
+func muteAll(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		HostID    string `json:"hostId" validate:"required"`
+		Hard      bool   `json:"hard"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	muted, errResp := callManager.MuteAll(request.SessionID, request.HostID, request.Hard)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.ModerationNotification,
+		SessionID: request.SessionID,
+		Data: map[string]interface{}{
+			"action":       "mute_all",
+			"hard":         request.Hard,
+			"participants": muted,
+		},
+	})
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants muted", muted))
+}
+
+func liftHardMute(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		HostID        string `json:"hostId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.LiftHardMute(request.SessionID, request.HostID, request.ParticipantID); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.ModerationNotification,
+		SessionID: request.SessionID,
+		Data: map[string]interface{}{
+			"action":        "lift_hard_mute",
+			"participantId": request.ParticipantID,
+		},
+	})
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "hard mute lifted", nil))
+}
+
+func toggleRecording(c echo.Context) error {
+	sessionID := c.QueryParam("sessionId")
+	if sessionID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "sessionId is required"))
+	}
+
+	recording, errResp := callManager.ToggleRecording(sessionID, c.QueryParam("actorId"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceRecording(sessionID, "", recording)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "toggled recording", nil))
+}
+
+func updateCallQuality(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Quality       int    `json:"quality"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.UpdateNetworkQuality(request.SessionID, request.ParticipantID, request.Quality)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "updated call quality", nil))
+}
+
+func setParticipantQuality(c echo.Context) error {
+	var request struct {
+		SessionID     string           `json:"sessionId" validate:"required"`
+		ParticipantID string           `json:"participantId" validate:"required"`
+		Quality       call.CallQuality `json:"quality" validate:"oneof=sd hd 4k"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	preset, errResp := callManager.SetParticipantQuality(request.SessionID, request.ParticipantID, request.Quality)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "updated quality preset", preset))
+}
+
+func setAudioOnly(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Enabled       bool   `json:"enabled"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.SetAudioOnly(request.SessionID, request.ActorID, request.ParticipantID, request.Enabled)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "updated audio-only mode", nil))
+}
+
+func getCallSession(c echo.Context) error {
+	sessionID := c.Param("sessionID")
+
+	session, errResp := callManager.GetCallSession(sessionID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session retrieved successfully", session))
+}
+
+func getCallStats(c echo.Context) error {
+	stats, errResp := callManager.GetCallStats(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call stats retrieved successfully", stats))
+}
+
+func getCallStatsTimeline(c echo.Context) error {
+	var since time.Time
+	if value := c.QueryParam("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid since"))
+		}
+	}
+
+	timeline, errResp := callManager.GetStatsTimeline(c.Param("sessionID"), c.QueryParam("participantId"), since)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call stats timeline retrieved successfully", timeline))
+}
+
+func getCallQualityReport(c echo.Context) error {
+	report, errResp := callManager.GetQualityReport(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "quality report retrieved successfully", report))
+}
+
+func getCallTalkTime(c echo.Context) error {
+	report, errResp := callManager.GetTalkTime(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "talk time retrieved successfully", report))
+}
+
+func listArchivedCalls(c echo.Context) error {
+	calls, errResp := callManager.ListArchivedCalls(c.QueryParam("tag"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived call sessions retrieved successfully", calls))
+}
+
+func getArchivedCall(c echo.Context) error {
+	archived, errResp := callManager.GetArchivedCall(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived call session retrieved successfully", archived))
+}
+
+func rehydrateArchivedCall(c echo.Context) error {
+	archived, errResp := callManager.RehydrateArchive(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived call session rehydrated successfully", archived))
+}
+
+func resolveJoinCode(c echo.Context) error {
+	info, errResp := callManager.ResolveJoinCode(c.Param("code"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "join code resolved", info))
+}
+
+func regenerateJoinCode(c echo.Context) error {
+	var request struct {
+		SessionID string         `json:"sessionId" validate:"required"`
+		HostID    string         `json:"hostId" validate:"required"`
+		TTL       utils.Duration `json:"ttl"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	info, errResp := callManager.RegenerateJoinCode(request.SessionID, request.HostID, time.Duration(request.TTL))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "join code regenerated", info))
+}
+
+func listCallSessions(c echo.Context) error {
+	sessions := callManager.ListSessions(c.QueryParam("tag"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call sessions retrieved successfully", sessions))
+}
+
+func updateCallParticipant(c echo.Context) error {
+	var request struct {
+		SessionID     string                  `json:"sessionId" validate:"required"`
+		ParticipantID string                  `json:"participantId" validate:"required"`
+		Profile       call.ParticipantProfile `json:"profile"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	participant, errResp := callManager.UpdateParticipantProfile(request.SessionID, request.ParticipantID, request.Profile)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.ParticipantNotification,
+		SessionID: request.SessionID,
+		Data:      participant,
+	})
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant updated", participant))
+}
+
+func startRecording(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := callManager.StartRecording(request.SessionID, request.ActorID, request.ParticipantID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceRecording(request.SessionID, request.ParticipantID, true)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "recording started", nil))
+}
+
+func stopRecording(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	manifest, errResp := callManager.StopRecording(request.SessionID, request.ActorID, request.ParticipantID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceRecording(request.SessionID, request.ParticipantID, false)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "recording stopped", manifest))
+}
+
+func getCallSnapshot(c echo.Context) error {
+	path, errResp := callManager.Snapshot(c.Param("sessionID"), c.QueryParam("participantId"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	// Thumbnails are replaced in place, previews revalidate on every poll
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	return c.File(path)
+}
+
+func listVoicemail(c echo.Context) error {
+	voicemails, errResp := callManager.ListVoicemail(c.Param("userID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "voicemail retrieved successfully", voicemails))
+}
+
+func getVoicemailAudio(c echo.Context) error {
+	voicemail, errResp := callManager.GetVoicemail(c.Param("userID"), c.Param("voicemailID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.File(voicemail.Path)
+}
+
+func mixRecordings(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		ActorID   string `json:"actorId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	manifest, errResp := callManager.MixRecordings(request.SessionID, request.ActorID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusAccepted, utils.NewSuccessResponse(http.StatusAccepted, "mixing started", manifest))
+}
+
+func setParticipantGain(c echo.Context) error {
+	var request struct {
+		SessionID     string  `json:"sessionId" validate:"required"`
+		ActorID       string  `json:"actorId" validate:"required"`
+		ParticipantID string  `json:"participantId" validate:"required"`
+		Gain          float64 `json:"gain" validate:"min=0,max=4"`
+	}
+	request.Gain = 1
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := callManager.SetGain(request.SessionID, request.ActorID, request.ParticipantID, request.Gain); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "gain updated", map[string]interface{}{
+		"participantId": request.ParticipantID,
+		"gain":          request.Gain,
+	}))
+}
+
+func setCallLayout(c echo.Context) error {
+	var request struct {
+		SessionID   string `json:"sessionId" validate:"required"`
+		ActorID     string `json:"actorId" validate:"required"`
+		Layout      string `json:"layout" validate:"required,oneof=grid spotlight presenter"`
+		PresenterID string `json:"presenterId"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	change, participants, errResp := callManager.SetLayout(request.SessionID, request.ActorID, call.Layout(request.Layout), request.PresenterID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceLayout(request.SessionID, change, participants)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "layout updated", change))
+}
+
+func pinCallParticipant(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	change, errResp := callManager.PinParticipant(request.SessionID, request.ActorID, request.ParticipantID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.SpotlightNotification,
+		SessionID: request.SessionID,
+		Data:      change,
+	})
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "pin updated", change))
+}
+
+func inviteToCall(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		CallerID  string `json:"callerId" validate:"required"`
+		InviteeID string `json:"inviteeId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	invitation, errResp := callManager.Invite(request.SessionID, request.CallerID, request.InviteeID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceInvitation(invitation)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "invitee is being rung", invitation))
+}
+
+func answerCallInvitation(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		InviteeID string `json:"inviteeId" validate:"required"`
+		Accepted  bool   `json:"accepted"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	invitation, errResp := callManager.AnswerInvitation(request.SessionID, request.InviteeID, request.Accepted)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceInvitation(invitation)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "invitation answered", invitation))
+}
+
+func listMissedCalls(c echo.Context) error {
+	missed := callManager.MissedCallsOf(c.Param("userID"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "missed calls retrieved successfully", missed))
+}
+
+// announceInvitation rings the invitee of a new invitation, or tells the
+// caller how it was answered or that it rang out, over signaling and the
+// notification hub. Webhooks push rings and missed calls to users that
+// aren't connected.
+func announceInvitation(invitation *call.Invitation) {
+	messageType, target := signaling.RingResultMessage, invitation.CallerID
+	if invitation.Status == call.InvitationRinging {
+		messageType, target = signaling.RingMessage, invitation.InviteeID
+	}
+	// A peer that isn't connected is reached by the notification instead
+	_ = signalingManger.Send(target, struct {
+		Type string `json:"type"`
+		*call.Invitation
+	}{messageType, invitation})
+
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.RingNotification,
+		SessionID: invitation.SessionID,
+		Priority:  chat.HighPriority,
+		Data:      invitation,
+	})
+
+	switch invitation.Status {
+	case call.InvitationRinging:
+		webhooks.Send("call.ring", invitation)
+	case call.InvitationMissed:
+		webhooks.Send("call.missed", invitation)
+	}
+}
+
+func transferCallParticipant(c echo.Context) error {
+	var request struct {
+		FromSessionID string `json:"fromSessionId" validate:"required"`
+		ToSessionID   string `json:"toSessionId" validate:"required"`
+		ActorID       string `json:"actorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Passcode      string `json:"passcode"`
+		Mode          string `json:"mode" validate:"oneof=blind attended"`
+	}
+	request.Mode = string(call.TransferBlind)
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	transfer, errResp := callManager.TransferParticipant(request.FromSessionID, request.ToSessionID, request.ActorID, request.ParticipantID, request.Passcode, call.TransferMode(request.Mode))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceTransfer(transfer)
+
+	if transfer.Status == call.TransferPending {
+		return c.JSON(http.StatusAccepted, utils.NewSuccessResponse(http.StatusAccepted, "transfer awaiting answer", transfer))
+	}
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant transferred", transfer))
+}
+
+func answerCallTransfer(c echo.Context) error {
+	var request struct {
+		SessionID  string `json:"sessionId" validate:"required"`
+		ActorID    string `json:"actorId" validate:"required"`
+		TransferID string `json:"transferId" validate:"required"`
+		Accepted   bool   `json:"accepted"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	transfer, errResp := callManager.AnswerTransfer(request.SessionID, request.ActorID, request.TransferID, request.Accepted)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	announceTransfer(transfer)
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "transfer answered", transfer))
+}
+
+// announceTransfer tells the clients of both calls about a transfer, so the
+// transferred participant renegotiates and hosts can answer attended ones
+func announceTransfer(transfer *call.Transfer) {
+	for _, sessionID := range []string{transfer.FromSessionID, transfer.ToSessionID} {
+		chatManger.Hub.SendNotification(chat.Notification{
+			Type:      chat.TransferNotification,
+			SessionID: sessionID,
+			Data:      transfer,
+		})
+	}
+}
+
+// announceRecording tells every client that recording of a call started or
+// stopped, and posts a system message to the call's chat session if it has one
+func announceRecording(sessionID, participantID string, recording bool) {
+	chatManger.Hub.SendNotification(chat.Notification{
+		Type:      chat.RecordingNotification,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"participantId": participantID,
+			"isRecording":   recording,
+		},
+	})
+
+	chatSessionID, errResp := callManager.ChatSessionOf(sessionID)
+	if errResp != nil || chatSessionID == "" {
+		return
+	}
+
+	text := "Recording stopped"
+	if recording {
+		text = "This call is being recorded"
+	}
+	if _, errResp := chatManger.AddSystemMessage(chatSessionID, text); errResp != nil {
+		log.Printf("Error posting recording message to chat %s: %s\n", chatSessionID, errResp.Message)
+	}
+}
+
+func addChatAttachment(c echo.Context) error {
+	var request struct {
+		SessionID  string          `json:"sessionId" validate:"required"`
+		MessageID  string          `json:"messageId" validate:"required"`
+		Attachment chat.Attachment `json:"attachment"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := chatManger.AddAttachment(request.SessionID, request.MessageID, request.Attachment)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "attachment added", nil))
+}
+
+func uploadChatAttachment(c echo.Context) error {
+	sessionID := c.FormValue("sessionId")
+	messageID := c.FormValue("messageId")
+	if sessionID == "" || messageID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "sessionId and messageId are required"))
+	}
+
+	upload, stored, errResp := storeUpload(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	if errResp := chatManger.AddAttachment(sessionID, messageID, upload); errResp != nil {
+		attachmentStore.Delete(stored)
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "attachment uploaded", upload))
+}
+
+// storeUpload stores the file of a multipart upload as a chat attachment
+func storeUpload(c echo.Context) (chat.Attachment, *attachment.StoredFile, *utils.ErrorResponse) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return chat.Attachment{}, nil, utils.NewErrorResponse(http.StatusBadRequest, "file is required")
+	}
+	if fileHeader.Size > appConfig.Attachment.MaxSize {
+		return chat.Attachment{}, nil, utils.NewErrorResponse(http.StatusRequestEntityTooLarge, "attachment is too large")
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return chat.Attachment{}, nil, utils.NewErrorResponse(http.StatusBadRequest, "invalid file")
+	}
+	defer src.Close()
+
+	stored, errResp := attachmentStore.Save(fileHeader.Filename, fileHeader.Header.Get("Content-Type"), src)
+	if errResp != nil {
+		return chat.Attachment{}, nil, errResp
+	}
+
+	upload := chat.Attachment{
+		Type:        attachmentTypeFor(stored.ContentType),
+		URL:         stored.URL,
+		Name:        stored.Name,
+		Size:        stored.Size,
+		ContentType: stored.ContentType,
+		ScanStatus:  string(stored.Scan.Status),
+	}
+	if stored.Image != nil {
+		upload.Width = stored.Image.Width
+		upload.Height = stored.Image.Height
+		for _, thumb := range stored.Image.Thumbnails {
+			upload.Thumbnails = append(upload.Thumbnails, chat.Thumbnail{URL: thumb.URL, Width: thumb.Width, Height: thumb.Height})
+		}
+	}
+	return upload, stored, nil
+}
+
+// sendVoiceMessage transcodes an audio upload to Ogg/Opus and posts it as a
+// voice message
+func sendVoiceMessage(c echo.Context) error {
+	if voiceTranscoder == nil {
+		return c.JSON(http.StatusServiceUnavailable, utils.NewErrorResponse(http.StatusServiceUnavailable, "voice messages are disabled"))
+	}
+	sessionID := c.FormValue("sessionId")
+	senderID := c.FormValue("senderId")
+	if sessionID == "" || senderID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "sessionId and senderId are required"))
+	}
+	if errResp := chatManger.CheckSender(sessionID, senderID); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	_, stored, errResp := storeUpload(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	// Browsers record audio into WebM or MP4 containers, which can be
+	// sniffed as video
+	if !strings.HasPrefix(stored.ContentType, "audio/") && stored.ContentType != "video/webm" && stored.ContentType != "video/mp4" && stored.ContentType != "application/ogg" {
+		attachmentStore.Delete(stored)
+		return c.JSON(http.StatusUnsupportedMediaType, utils.NewErrorResponse(http.StatusUnsupportedMediaType, "voice messages must be audio"))
+	}
+
+	transcoded := filepath.Join(filepath.Dir(stored.Path), "voice.partial.ogg")
+	note, err := voiceTranscoder.Transcode(c.Request().Context(), stored.Path, transcoded)
+	if err == nil {
+		err = attachmentStore.Replace(stored, transcoded, "voice.ogg", "audio/ogg")
+	}
+	if err != nil {
+		attachmentStore.Delete(stored)
+		if errors.Is(err, voice.ErrTooLong) {
+			return c.JSON(http.StatusRequestEntityTooLarge, utils.NewErrorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("voice messages are limited to %s", appConfig.Voice.MaxDuration)))
+		}
+		log.Printf("Error transcoding voice message of %s: %v\n", senderID, err)
+		return c.JSON(http.StatusUnprocessableEntity, utils.NewErrorResponse(http.StatusUnprocessableEntity, "audio could not be transcoded"))
+	}
+
+	message := chat.ChatMessage{
+		SenderID:   senderID,
+		ReceiverID: c.FormValue("receiverId"),
+		Type:       chat.VoiceMessage,
+		Attachments: []chat.Attachment{{
+			Type:        chat.VoiceAttachment,
+			URL:         stored.URL,
+			Name:        stored.Name,
+			Size:        stored.Size,
+			ContentType: stored.ContentType,
+			ScanStatus:  string(stored.Scan.Status),
+			DurationMs:  note.Duration.Milliseconds(),
+			Waveform:    note.Waveform,
+		}},
+	}
+	posted, errResp := chatManger.AddMessage(sessionID, message)
+	if errResp != nil {
+		attachmentStore.Delete(stored)
+		if errResp.RetryAfter > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(errResp.RetryAfter))
+		}
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "voice message sent", posted))
+}
+
+func sendAnnouncement(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		SenderID  string `json:"senderId"`
+		Message   string `json:"message"`
+		Pin       bool   `json:"pin"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	message, errResp := chatManger.SendAnnouncement(request.SessionID, request.SenderID, request.Message, request.Pin)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "announcement sent", message))
+}
+
+func sendEphemeral(c echo.Context) error {
+	var request struct {
+		SessionID  string         `json:"sessionId" validate:"required"`
+		SenderID   string         `json:"senderId" validate:"required"`
+		ReceiverID string         `json:"receiverId" validate:"required"`
+		Message    string         `json:"message" validate:"required"`
+		TTL        utils.Duration `json:"ttl"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	message, errResp := chatManger.SendEphemeral(request.SessionID, request.SenderID, request.ReceiverID, request.Message, time.Duration(request.TTL))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "ephemeral message sent", message))
+}
+
+func setSlowMode(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		AdminID   string `json:"adminId" validate:"required"`
+		Seconds   int    `json:"intervalSeconds" validate:"min=0"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := chatManger.SetSlowMode(request.SessionID, request.AdminID, request.Seconds); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "slow mode updated", nil))
+}
+
+func lockChat(c echo.Context) error {
+	var request struct {
+		SessionID string `json:"sessionId" validate:"required"`
+		AdminID   string `json:"adminId" validate:"required"`
+		Locked    bool   `json:"locked"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	if errResp := chatManger.SetLocked(request.SessionID, request.AdminID, request.Locked); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat lock updated", map[string]bool{"locked": request.Locked}))
+}
+
+func addChatReaction(c echo.Context) error {
+	var request struct {
+		SessionID string        `json:"sessionId" validate:"required"`
+		MessageID string        `json:"messageId" validate:"required"`
+		Reaction  chat.Reaction `json:"reaction"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := chatManger.AddReaction(request.SessionID, request.MessageID, request.Reaction)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "reaction added", nil))
+}
+
+func pinParticipant(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := chatManger.PinParticipant(request.SessionID, request.ParticipantID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant pinned", nil))
+}
+
+func moderateParticipant(c echo.Context) error {
+	var request struct {
+		SessionID     string `json:"sessionId" validate:"required"`
+		ModeratorID   string `json:"moderatorId" validate:"required"`
+		ParticipantID string `json:"participantId" validate:"required"`
+		Action        string `json:"action" validate:"required,oneof=mute unmute remove"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	errResp := chatManger.ModerateParticipant(request.SessionID, request.ModeratorID, request.ParticipantID, request.Action)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant moderated", nil))
+}
+
+func getChatUsage(c echo.Context) error {
+	sessionID := c.Param("sessionID")
+
+	usage, errResp := chatManger.GetSessionUsage(sessionID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat usage retrieved successfully", usage))
+}
+
+func listChatSessions(c echo.Context) error {
+	sessions := chatManger.ListSessions(c.QueryParam("tag"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat sessions retrieved successfully", sessions))
+}
+
+// getChatEvents returns the event log of an active or archived session
+// after the sequence number since
+func getChatEvents(c echo.Context) error {
+	var since uint64
+	if value := c.QueryParam("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid since"))
+		}
+	}
+
+	events, errResp := chatManger.GetSessionEvents(c.Param("sessionID"), since)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat events retrieved successfully", events))
+}
+
+// searchMessages searches the messages of the sessions a user took part in
+func searchMessages(c echo.Context) error {
+	query := search.Query{
+		UserID:    c.QueryParam("userID"),
+		Text:      c.QueryParam("q"),
+		SessionID: c.QueryParam("sessionId"),
+	}
+	if query.UserID == "" || query.Text == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID and q are required"))
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > 100 {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid limit"))
+		}
+	}
+
+	hits, errResp := chatManger.SearchMessages(c.Request().Context(), query)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "messages retrieved successfully", hits))
+}
+
+func listArchivedChatSessions(c echo.Context) error {
+	sessions, errResp := chatManger.ListArchivedSessions(c.QueryParam("tag"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived chat sessions retrieved successfully", sessions))
+}
+
+func getArchivedChatSession(c echo.Context) error {
+	session, errResp := chatManger.GetArchivedSession(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived chat session retrieved successfully", session))
+}
+
+func rehydrateArchivedChatSession(c echo.Context) error {
+	session, errResp := chatManger.RehydrateArchive(c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "archived chat session rehydrated successfully", session))
+}
+
+// offloadArchives periodically moves archives older than the configured age
+// to cold storage
+func offloadArchives(cfg config.ColdStorageConfig) {
+	offload := func() {
+		before := time.Now().Add(-cfg.Age)
+
+		chats, err := chatManger.OffloadArchives(before)
+		if err != nil {
+			log.Printf("failed to offload chat archives: %v", err)
+		}
+		calls, err := callManager.OffloadArchives(before)
+		if err != nil {
+			log.Printf("failed to offload call archives: %v", err)
+		}
+		if chats > 0 || calls > 0 {
+			log.Printf("offloaded %d chat and %d call archives to cold storage", chats, calls)
+		}
+	}
+
+	offload()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		offload()
+	}
+}
+
+// purgeChatData removes expired chat drafts and the archived sessions past
+// their retention every hour
+func purgeChatData() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		purged, err := chatManger.PurgeDrafts()
+		if err != nil {
+			log.Printf("failed to purge chat drafts: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d expired chat drafts", purged)
+		}
+
+		purged, err = chatManger.PurgeArchives(now)
+		if err != nil {
+			log.Printf("failed to purge chat archives: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d chat archives past their retention", purged)
+		}
+	}
+}
+
+func getChatParticipants(c echo.Context) error {
+	sessionID := c.Param("sessionID")
+
+	roster, errResp := chatManger.GetRoster(sessionID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants retrieved successfully", roster))
+}
+
+func updateChatParticipant(c echo.Context) error {
+	var request struct {
+		SessionID     string                  `json:"sessionId" validate:"required"`
+		ParticipantID string                  `json:"participantId" validate:"required"`
+		Profile       chat.ParticipantProfile `json:"profile"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	participant, errResp := chatManger.UpdateParticipantProfile(request.SessionID, request.ParticipantID, request.Profile)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant updated", participant))
+}
+
+func saveChatDraft(c echo.Context) error {
+	var request struct {
+		SessionID  string `json:"sessionId" validate:"required"`
+		UserID     string `json:"userId" validate:"required"`
+		ReceiverID string `json:"receiverId"`
+		Text       string `json:"text"`
+		DeviceID   string `json:"deviceId" validate:"max=100"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	draft, errResp := chatManger.SaveDraft(request.UserID, chat.Draft{
+		SessionID:  request.SessionID,
+		ReceiverID: request.ReceiverID,
+		Text:       request.Text,
+		DeviceID:   request.DeviceID,
+	})
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "draft saved", draft))
+}
+
+func listChatDrafts(c echo.Context) error {
+	userID := c.QueryParam("userID")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID is required"))
+	}
+
+	drafts, errResp := chatManger.ListDrafts(userID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "drafts retrieved successfully", drafts))
+}
+
+func getChatDraft(c echo.Context) error {
+	userID := c.QueryParam("userID")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID is required"))
+	}
 
-	e.POST("/chat/session", func(c echo.Context) error {
-		return createChatSession(c)
-	})
+	draft, errResp := chatManger.GetDraft(userID, c.Param("sessionID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	e.POST("/chat/message", func(c echo.Context) error {
-		return sendChatMessage(c)
-	})
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "draft retrieved successfully", draft))
+}
 
-	e.GET("/chat/messages/:sessionID", func(c echo.Context) error {
-		return getChatMessages(c)
-	})
+func clearChatDraft(c echo.Context) error {
+	userID := c.QueryParam("userID")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID is required"))
+	}
 
-	e.GET("/health", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "Server is healthy", nil))
-	})
+	if errResp := chatManger.ClearDraft(userID, c.Param("sessionID")); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	e.POST("/call/session", createCallSession)
-	e.POST("/call/join", joinCall)
-	e.POST("/call/lobby", addToLobby)
-	e.POST("/call/mute", toggleMute)
-	e.POST("/call/recording", toggleRecording)
-	e.POST("/call/quality", updateCallQuality)
-	e.GET("/call/session/:sessionID", getCallSession)
-	e.POST("/call/recording/start", startRecording)
-	e.POST("/call/recording/stop", stopRecording)
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "draft cleared", nil))
+}
 
-	e.POST("/chat/attachment", addChatAttachment)
-	e.POST("/chat/reaction", addChatReaction)
-	e.POST("/chat/pin", pinParticipant)
-	e.POST("/chat/moderate", moderateParticipant)
-	e.GET("/chat/usage/:sessionID", getChatUsage)
+func starChatMessage(c echo.Context) error {
+	var request struct {
+		UserID    string `json:"userId" validate:"required"`
+		SessionID string `json:"sessionId" validate:"required"`
+		MessageID string `json:"messageId" validate:"required"`
+	}
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	e.GET("/chat/notifications", handleChatNotifications)
+	starred, errResp := chatManger.StarMessage(request.UserID, request.SessionID, request.MessageID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	e.Logger.Fatal(e.Start(":8001"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "message starred", starred))
 }
 
-func handleOffer(c echo.Context, peerManager *peer.PeerManager) error {
-	var offer webrtc.SessionDescription
-	if err := c.Bind(&offer); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid offer"))
+func unstarChatMessage(c echo.Context) error {
+	userID := c.QueryParam("userID")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID is required"))
 	}
 
-	peerID := c.QueryParam("peerID")
-	if peerID == "" {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "peerID is required"))
+	if errResp := chatManger.UnstarMessage(userID, c.Param("sessionID"), c.Param("messageID")); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	peerConnectionState, errResp := peerManager.CreatePeerConnection(peerID)
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "message unstarred", nil))
+}
+
+// listStarredMessages pages through the starred messages of a user with
+// offset and limit
+func listStarredMessages(c echo.Context) error {
+	userID := c.QueryParam("userID")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "userID is required"))
+	}
+	offset, limit := 0, 20
+	var err error
+	if value := c.QueryParam("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid offset"))
+		}
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid limit"))
+		}
+	}
+
+	page, errResp := chatManger.ListStarred(userID, c.QueryParam("sessionId"), offset, limit)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	if err := peerConnectionState.PeerConnection.SetRemoteDescription(offer); err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set remote description"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "starred messages retrieved successfully", page))
+}
+
+func handleChatNotifications(c echo.Context) error {
+	if !originPolicy.Allowed(c.Request()) {
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(http.StatusForbidden, "origin not allowed"))
 	}
+	release, errResp := acquireSocket(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	defer release()
 
-	answer, err := peerConnectionState.PeerConnection.CreateAnswer(nil)
+	upgrader := newUpgrader()
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create answer"))
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to upgrade connection"))
 	}
+	ws.SetReadLimit(appConfig.WebSocket.MaxMessageSize)
 
-	if err := peerConnectionState.PeerConnection.SetLocalDescription(answer); err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set local description"))
+	// Register client for notifications
+	chatManger.Hub.Register <- ws
+
+	// Notifications only flow to the client. Reading processes its control
+	// frames and enforces the read limit until it disconnects.
+	for {
+		if _, _, err := ws.NextReader(); err != nil {
+			break
+		}
 	}
+	chatManger.Hub.Unregister <- ws
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "answer created successfully", answer))
+	return nil
 }
 
-func handleICECandidate(c echo.Context, peerManager *peer.PeerManager) error {
-	var candidate webrtc.ICECandidateInit
-	if err := c.Bind(&candidate); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid ICE candidate"))
+func handleChatSocket(c echo.Context) error {
+	userID, authenticated, errResp := authorizeUpgrade(c, wsauth.ScopeChat, c.QueryParam("userID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
+	release, errResp := acquireSocket(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
+	defer release()
 
-	peerID := c.QueryParam("peerID")
-	if peerID == "" {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "peerID is required"))
+	upgrader := newUpgrader()
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to upgrade connection"))
 	}
+	ws.SetReadLimit(appConfig.WebSocket.MaxMessageSize)
 
-	if errResp := peerManager.AddICECandidate(peerID, candidate); errResp != nil {
-		return c.JSON(errResp.StatusCode, errResp)
+	chatManger.ServeSocket(ws, userID, authenticated)
+	return nil
+}
+
+// acquireSocket counts a WebSocket against the client's address until the
+// returned release is called, or rejects it when the address holds too many
+func acquireSocket(c echo.Context) (func(), *utils.ErrorResponse) {
+	ip := c.RealIP()
+	if !wsLimits.Acquire(ip) {
+		return nil, utils.NewErrorResponse(http.StatusTooManyRequests, "too many connections from this address")
 	}
+	return func() { wsLimits.Release(ip) }, nil
+}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "ICE candidate added successfully", nil))
+// newUpgrader returns a WebSocket upgrader accepting the given subprotocols,
+// negotiating compression when enabled. Handlers check the origin before
+// upgrading to answer with a JSON error, the upgrader checks it again.
+func newUpgrader(subprotocols ...string) websocket.Upgrader {
+	return websocket.Upgrader{
+		Subprotocols:      subprotocols,
+		EnableCompression: appConfig.WebSocket.Compression,
+		CheckOrigin:       originPolicy.Allowed,
+	}
 }
 
-// websocket handler for signaling
-func handleWebSocket(c echo.Context) error {
+// authorizeUpgrade checks the origin of a WebSocket upgrade and redeems its
+// ticket, if any. It returns the ID the socket serves and whether a ticket
+// authenticated it.
+func authorizeUpgrade(c echo.Context, scope, requestedID string) (string, bool, *utils.ErrorResponse) {
+	if !originPolicy.Allowed(c.Request()) {
+		return "", false, utils.NewErrorResponse(http.StatusForbidden, "origin not allowed")
+	}
 
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	ticket := c.QueryParam("ticket")
+	if ticket == "" {
+		return requestedID, false, nil
+	}
+	if wsTickets == nil {
+		return "", false, utils.NewErrorResponse(http.StatusBadRequest, "websocket tickets are disabled")
 	}
 
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	userID, err := wsTickets.Redeem(ticket, scope, c.Request().Header.Get("Origin"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to upgrade connection: "+err.Error()))
+		return "", false, utils.NewErrorResponse(http.StatusUnauthorized, err.Error())
 	}
-	defer ws.Close()
-
-	peerID := c.QueryParam("peerID")
-	if peerID == "" {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "peerID is required"))
+	if requestedID != "" && requestedID != userID {
+		return "", false, utils.NewErrorResponse(http.StatusForbidden, "requested ID doesn't match the ticket")
 	}
+	return userID, true, nil
+}
 
-	signalingManger.HandleWebSocket(ws, peerID)
-	return nil
+// webSocketTicketRequest is the body of POST /ws/ticket
+type webSocketTicketRequest struct {
+	Scope string `json:"scope" validate:"required,oneof=signaling chat"`
 }
 
-func createChatSession(c echo.Context) error {
-	var request struct {
-		CreatorID    string        `json:"creatorId"`
-		Participants []string      `json:"participants"`
-		Duration     time.Duration `json:"duration"`
-		IsGroup      bool          `json:"isGroup"`
+// issueWebSocketTicket exchanges the bearer token of a browser for a
+// one-time ticket opening a WebSocket from the same origin
+func issueWebSocketTicket(c echo.Context) error {
+	if wsTickets == nil {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "websocket tickets are disabled"))
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, "bearer token is required"))
 	}
-	session, errResp := chatManger.CreateChatSession(request.CreatorID, request.Participants, request.Duration, request.IsGroup)
-	if errResp != nil {
+	userID, err := utils.VerifyToken([]byte(appConfig.Signaling.AuthSecret), token, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, utils.NewErrorResponse(http.StatusUnauthorized, err.Error()))
+	}
+
+	var request webSocketTicketRequest
+	if errResp := bind(c, &request); errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat session created successfully", session))
+
+	ticket, expiresAt, err := wsTickets.Issue(userID, request.Scope, c.Request().Header.Get("Origin"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to issue websocket ticket"))
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "websocket ticket issued", map[string]interface{}{
+		"ticket":    ticket,
+		"expiresAt": utils.NewTime(expiresAt),
+	}))
 }
 
-func sendChatMessage(c echo.Context) error {
-	var request struct {
-		SessionID  string `json:"sessionID"`
-		SenderID   string `json:"senderID"`
-		ReceiverID string `json:"receiverID"`
-		Message    string `json:"message"`
-		Type       string `json:"type"`
+func getAuditLog(c echo.Context) error {
+	filter := audit.Filter{
+		Actor:     c.QueryParam("actor"),
+		Action:    c.QueryParam("action"),
+		SessionID: c.QueryParam("sessionId"),
+		Target:    c.QueryParam("target"),
+	}
+
+	var err error
+	if since := c.QueryParam("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid since"))
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid until"))
+		}
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid limit"))
+		}
 	}
 
-	message := chat.ChatMessage{
-		ID:         utils.GenerateSessionID(),
-		SenderID:   request.SenderID,
-		ReceiverID: request.ReceiverID,
-		Message:    request.Message,
-		Type:       chat.MessageType(request.Type),
-		Timestamp:  utils.GetTimestamp(),
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "audit log retrieved", auditLog.Query(filter)))
+}
+
+// listCallDetailRecords exports the records of the calls that ended within
+// the window as JSON, or as CSV with format=csv
+func listCallDetailRecords(c echo.Context) error {
+	since, until, errResp := analyticsWindow(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
-	errResp := chatManger.AddMessage(request.SessionID, message)
+
+	records, errResp := callManager.ListCallDetailRecords(since, until)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "message sent successfully", nil))
-}
 
-func getChatMessages(c echo.Context) error {
-	sessionID := c.Param("sessionID")
+	switch c.QueryParam("format") {
+	case "", "json":
+		return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call detail records retrieved successfully", records))
+	case "csv":
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="cdr.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+		return call.WriteCallDetailRecordsCSV(c.Response(), records)
+	default:
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid format"))
+	}
+}
 
-	messages, errResp := chatManger.GetChatMessages(sessionID)
+func getCallDetailRecord(c echo.Context) error {
+	record, errResp := callManager.GetCallDetailRecord(c.Param("sessionID"))
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "messages retrieved successfully", messages))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call detail record retrieved successfully", record))
 }
 
-func createCallSession(c echo.Context) error {
-	var request struct {
-		CreatorID string           `json:"creatorId"`
-		Type      call.CallType    `json:"type"`
-		Quality   call.CallQuality `json:"quality"`
-		Duration  time.Duration    `json:"duration"`
+// maxAnalyticsWindow is the longest window analytics are aggregated over
+const maxAnalyticsWindow = 366 * 24 * time.Hour
+
+// analyticsWindow reads the since and until query parameters, the window
+// defaults to the last 30 days
+func analyticsWindow(c echo.Context) (time.Time, time.Time, *utils.ErrorResponse) {
+	until := time.Now()
+	if value := c.QueryParam("until"); value != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, value); err != nil {
+			return time.Time{}, time.Time{}, utils.NewErrorResponse(http.StatusBadRequest, "invalid until")
+		}
+	}
+
+	since := until.Add(-30 * 24 * time.Hour)
+	if value := c.QueryParam("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return time.Time{}, time.Time{}, utils.NewErrorResponse(http.StatusBadRequest, "invalid since")
+		}
+	}
+
+	if !since.Before(until) {
+		return time.Time{}, time.Time{}, utils.NewErrorResponse(http.StatusBadRequest, "since must be before until")
+	}
+	if until.Sub(since) > maxAnalyticsWindow {
+		return time.Time{}, time.Time{}, utils.NewErrorResponse(http.StatusBadRequest, "analytics window must not exceed 366 days")
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	return since, until, nil
+}
+
+func getCallAnalytics(c echo.Context) error {
+	since, until, errResp := analyticsWindow(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	session, errResp := callManager.CreateCallSession(request.CreatorID, request.Type, request.Quality, request.Duration)
+	analytics, errResp := callManager.Analytics(since, until)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session created", session))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call analytics retrieved successfully", analytics))
 }
 
-func joinCall(c echo.Context) error {
-	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
+func getChatAnalytics(c echo.Context) error {
+	since, until, errResp := analyticsWindow(c)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+
+	analytics, errResp := chatManger.Analytics(since, until)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	// Create peer connection
-	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{
-			URLs: []string{"stun:stun.l.google.com:19302"},
-		}},
-	})
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create peer connection"))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat analytics retrieved successfully", analytics))
+}
+
+// bulkParticipantsRequest is the body of the bulk participant endpoints
+type bulkParticipantsRequest struct {
+	SessionID      string   `json:"sessionId" validate:"required"`
+	ActorID        string   `json:"actorId" validate:"required"`
+	ParticipantIDs []string `json:"participantIds" validate:"required,max=500"`
+}
+
+func addChatParticipants(c echo.Context) error {
+	var request bulkParticipantsRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.JoinCall(request.SessionID, request.ParticipantID, pc)
+	results, errResp := chatManger.AddParticipants(request.SessionID, request.ActorID, request.ParticipantIDs)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "joined call successfully", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants processed", results))
 }
 
-func addToLobby(c echo.Context) error {
+func registerChatBot(c echo.Context) error {
 	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
+		SessionID   string   `json:"sessionId" validate:"required"`
+		AdminID     string   `json:"adminId" validate:"required"`
+		Name        string   `json:"name" validate:"required,max=100"`
+		CallbackURL string   `json:"callbackUrl"`
+		Commands    []string `json:"commands" validate:"max=20"`
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.AddToLobby(request.SessionID, request.ParticipantID)
+	bot, token, errResp := chatManger.RegisterBot(request.SessionID, request.AdminID, request.Name, request.CallbackURL, request.Commands)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "added to lobby", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "bot registered successfully", map[string]interface{}{
+		"bot":   bot,
+		"token": token,
+	}))
 }
 
-func toggleMute(c echo.Context) error {
+func removeChatBot(c echo.Context) error {
 	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
+		SessionID string `json:"sessionId" validate:"required"`
+		AdminID   string `json:"adminId" validate:"required"`
+		BotID     string `json:"botId" validate:"required"`
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.ToggleMute(request.SessionID, request.ParticipantID)
-	if errResp != nil {
+	if errResp := chatManger.RemoveBot(request.SessionID, request.AdminID, request.BotID); errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "toggled mute", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "bot removed successfully", nil))
 }
 
-func toggleRecording(c echo.Context) error {
-	sessionID := c.QueryParam("sessionId")
-	if sessionID == "" {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "sessionId is required"))
-	}
-
-	errResp := callManager.ToggleRecording(sessionID)
+func listChatBots(c echo.Context) error {
+	bots, errResp := chatManger.ListBots(c.Param("sessionID"))
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "toggled recording", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "bots retrieved successfully", bots))
 }
 
-func updateCallQuality(c echo.Context) error {
+// botToken returns the bearer token a bot authenticates with
+func botToken(c echo.Context) string {
+	token, _ := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return token
+}
+
+// postBotMessage posts a message as the bot authenticated by the bearer
+// token
+func postBotMessage(c echo.Context) error {
 	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
-		Quality       int    `json:"quality"`
+		SessionID  string         `json:"sessionId" validate:"required"`
+		ReceiverID string         `json:"receiverId"`
+		Message    string         `json:"message"`
+		Type       string         `json:"type" validate:"required,oneof=text image file document emoji location contact"`
+		Location   *chat.Location `json:"location"`
+		Contact    *chat.Contact  `json:"contact"`
+		// Ephemeral shows a text message to the receiver only
+		Ephemeral bool           `json:"ephemeral"`
+		TTL       utils.Duration `json:"ttl"`
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.UpdateNetworkQuality(request.SessionID, request.ParticipantID, request.Quality)
+	if request.Ephemeral {
+		if request.Type != string(chat.TextMessage) {
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "ephemeral messages must be text"))
+		}
+		posted, errResp := chatManger.PostBotEphemeral(request.SessionID, botToken(c), request.ReceiverID, request.Message, time.Duration(request.TTL))
+		if errResp != nil {
+			return c.JSON(errResp.StatusCode, errResp)
+		}
+		return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "ephemeral message sent", posted))
+	}
+
+	message := chat.ChatMessage{
+		ReceiverID: request.ReceiverID,
+		Message:    request.Message,
+		Type:       chat.MessageType(request.Type),
+		Location:   request.Location,
+		Contact:    request.Contact,
+	}
+	posted, errResp := chatManger.PostBotMessage(request.SessionID, botToken(c), message)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "updated call quality", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "message sent successfully", posted))
 }
 
-func getCallSession(c echo.Context) error {
-	sessionID := c.Param("sessionID")
+// uploadBotAttachment attaches a file to a message the bot authenticated by
+// the bearer token posted
+func uploadBotAttachment(c echo.Context) error {
+	sessionID := c.FormValue("sessionId")
+	messageID := c.FormValue("messageId")
+	if sessionID == "" || messageID == "" {
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "sessionId and messageId are required"))
+	}
+	// Checked before storing the file, and again when attaching it
+	if _, errResp := chatManger.AuthenticateBot(sessionID, botToken(c)); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	session, errResp := callManager.GetCallSession(sessionID)
+	upload, stored, errResp := storeUpload(c)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
+	if errResp := chatManger.AddBotAttachment(sessionID, botToken(c), messageID, upload); errResp != nil {
+		attachmentStore.Delete(stored)
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "call session retrieved successfully", session))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "attachment uploaded", upload))
 }
 
-func startRecording(c echo.Context) error {
-	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
-	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+func removeChatParticipants(c echo.Context) error {
+	var request bulkParticipantsRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.StartRecording(request.SessionID, request.ParticipantID)
+	results, errResp := chatManger.RemoveParticipants(request.SessionID, request.ActorID, request.ParticipantIDs)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "recording started", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants processed", results))
 }
 
-func stopRecording(c echo.Context) error {
-	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
-	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+func addCallParticipants(c echo.Context) error {
+	var request bulkParticipantsRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := callManager.StopRecording(request.SessionID, request.ParticipantID)
+	results, errResp := callManager.AddParticipants(request.SessionID, request.ActorID, request.ParticipantIDs)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "recording stopped", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants processed", results))
 }
 
-func addChatAttachment(c echo.Context) error {
-	var request struct {
-		SessionID  string          `json:"sessionId"`
-		MessageID  string          `json:"messageId"`
-		Attachment chat.Attachment `json:"attachment"`
-	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+func removeCallParticipants(c echo.Context) error {
+	var request bulkParticipantsRequest
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := chatManger.AddAttachment(request.SessionID, request.MessageID, request.Attachment)
+	results, errResp := callManager.RemoveParticipants(request.SessionID, request.ActorID, request.ParticipantIDs)
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "attachment added", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participants processed", results))
 }
 
-func addChatReaction(c echo.Context) error {
+func startLoadTest(c echo.Context) error {
 	var request struct {
-		SessionID string        `json:"sessionId"`
-		MessageID string        `json:"messageId"`
-		Reaction  chat.Reaction `json:"reaction"`
+		SessionID    string         `json:"sessionId" validate:"required"`
+		Passcode     string         `json:"passcode"`
+		Participants int            `json:"participants" validate:"required,min=1,max=100"`
+		Video        bool           `json:"video"`
+		Duration     utils.Duration `json:"duration"`
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+	if errResp := bind(c, &request); errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	errResp := chatManger.AddReaction(request.SessionID, request.MessageID, request.Reaction)
+	test, errResp := callManager.StartLoadTest(request.SessionID, request.Passcode, request.Participants, request.Video, time.Duration(request.Duration))
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "reaction added", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "load test started successfully", test))
 }
 
-func pinParticipant(c echo.Context) error {
-	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
-	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
-	}
-
-	errResp := chatManger.PinParticipant(request.SessionID, request.ParticipantID)
-	if errResp != nil {
+func stopLoadTest(c echo.Context) error {
+	if errResp := callManager.StopLoadTest(c.Param("testID")); errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant pinned", nil))
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "load test stopped successfully", nil))
 }
 
-func moderateParticipant(c echo.Context) error {
-	var request struct {
-		SessionID     string `json:"sessionId"`
-		ParticipantID string `json:"participantId"`
-		Action        string `json:"action"`
+func eraseUser(c echo.Context) error {
+	userID := c.Param("userID")
+
+	report, errResp := chatManger.EraseUser(userID)
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(http.StatusBadRequest, "invalid request"))
+
+	removed := 0
+	for _, file := range report.Attachments {
+		if attachmentStore.DeleteURL(file.URL) || attachment.DeleteServed(appConfig.FileTransfer.Dir, "/uploads", file.URL) {
+			removed++
+		}
 	}
 
-	errResp := chatManger.ModerateParticipant(request.SessionID, request.ParticipantID, request.Action)
-	if errResp != nil {
+	calls, err := callManager.EraseHistory(userID)
+	if err != nil {
+		log.Printf("Error erasing call history of user %s: %v\n", userID, err)
+		errResp := utils.NewErrorResponse(http.StatusInternalServerError, "failed to erase call history")
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "participant moderated", nil))
-}
+	auditLog.Record(audit.SystemActor, audit.PrivacyErase, "", userID, nil, map[string]int{
+		"sessions":    report.Sessions,
+		"messages":    report.Messages,
+		"drafts":      report.Drafts,
+		"starred":     report.Starred,
+		"attachments": removed,
+		"calls":       calls,
+	})
 
-func getChatUsage(c echo.Context) error {
-	sessionID := c.Param("sessionID")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "user data erased", map[string]interface{}{
+		"userId":      userID,
+		"sessions":    report.Sessions,
+		"messages":    report.Messages,
+		"drafts":      report.Drafts,
+		"starred":     report.Starred,
+		"attachments": removed,
+		"calls":       calls,
+	}))
+}
 
-	usage, errResp := chatManger.GetSessionUsage(sessionID)
+func startExport(c echo.Context) error {
+	job, errResp := exporter.Start(c.Param("userID"))
 	if errResp != nil {
 		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "chat usage retrieved successfully", usage))
+	return c.JSON(http.StatusAccepted, utils.NewSuccessResponse(http.StatusAccepted, "export started", job))
 }
 
-func handleChatNotifications(c echo.Context) error {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+// exportOwner returns the user an export job belongs to
+func exportOwner(c echo.Context) string {
+	job, errResp := exporter.Job(c.Param("jobID"))
+	if errResp != nil {
+		return ""
 	}
+	return job.UserID
+}
 
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(http.StatusInternalServerError, "Failed to upgrade connection"))
+func getExport(c echo.Context) error {
+	job, errResp := exporter.Job(c.Param("jobID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
 	}
 
-	// Register client for notifications
-	chatManger.Hub.Register <- ws
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(http.StatusOK, "export retrieved", job))
+}
 
-	// Handle disconnection
-	go func() {
-		<-c.Request().Context().Done()
-		chatManger.Hub.Unregister <- ws
-	}()
+func downloadExport(c echo.Context) error {
+	path, errResp := exporter.ArchivePath(c.Param("jobID"))
+	if errResp != nil {
+		return c.JSON(errResp.StatusCode, errResp)
+	}
 
-	return nil
+	return c.Attachment(path, "export-"+c.Param("jobID")+".zip")
 }
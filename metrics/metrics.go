@@ -0,0 +1,203 @@
+// Package metrics records the latency and outcome of HTTP requests per route
+// and exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// unmatchedRoute labels requests that matched no route, so unknown paths
+// don't each get their own series
+const unmatchedRoute = "unmatched"
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+// routeMetrics holds the counters of a single route
+type routeMetrics struct {
+	// buckets counts the requests per latency bucket, the last one is +Inf
+	buckets []uint64
+	sum     float64
+	count   uint64
+	// statuses counts the responses per status class, e.g. "2xx"
+	statuses map[string]uint64
+	errors   uint64
+	slow     uint64
+}
+
+// Recorder collects request metrics per route
+type Recorder struct {
+	cfg    config.MetricsConfig
+	routes map[routeKey]*routeMetrics
+	mu     sync.Mutex
+}
+
+// NewRecorder creates a Recorder
+func NewRecorder(cfg config.MetricsConfig) *Recorder {
+	return &Recorder{
+		cfg:    cfg,
+		routes: make(map[routeKey]*routeMetrics),
+	}
+}
+
+// Middleware records every request and logs those slower than the threshold
+// of their route. WebSocket upgrades are counted but stay out of the latency
+// histogram since they last as long as the connection.
+func (r *Recorder) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				// Let the error handler write the response so its status
+				// is the one recorded
+				c.Error(err)
+			}
+			elapsed := time.Since(start)
+
+			route := c.Path()
+			if route == "" {
+				route = unmatchedRoute
+			}
+			key := routeKey{method: c.Request().Method, route: route}
+			status := c.Response().Status
+			websocket := c.IsWebSocket()
+
+			slow := false
+			if threshold := r.threshold(route); threshold > 0 && elapsed > threshold && !websocket {
+				slow = true
+				log.Printf("Slow request: %s %s took %v (threshold %v, status %d)\n",
+					key.method, c.Request().URL.Path, elapsed, threshold, status)
+			}
+
+			r.record(key, status, elapsed, slow, websocket)
+			return nil
+		}
+	}
+}
+
+// threshold returns the slow request threshold of a route
+func (r *Recorder) threshold(route string) time.Duration {
+	if threshold, ok := r.cfg.RouteThresholds[route]; ok {
+		return threshold
+	}
+	return r.cfg.SlowThreshold
+}
+
+func (r *Recorder) record(key routeKey, status int, elapsed time.Duration, slow, websocket bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics, exists := r.routes[key]
+	if !exists {
+		metrics = &routeMetrics{
+			buckets:  make([]uint64, len(latencyBuckets)+1),
+			statuses: make(map[string]uint64),
+		}
+		r.routes[key] = metrics
+	}
+
+	metrics.statuses[strconv.Itoa(status/100)+"xx"]++
+	if status >= http.StatusInternalServerError {
+		metrics.errors++
+	}
+	if slow {
+		metrics.slow++
+	}
+	if websocket {
+		return
+	}
+
+	seconds := elapsed.Seconds()
+	metrics.buckets[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	metrics.sum += seconds
+	metrics.count++
+}
+
+// WritePrometheus writes the metrics of every route in the Prometheus text
+// exposition format
+func (r *Recorder) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(r.routes))
+	for key := range r.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route == keys[j].route {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].route < keys[j].route
+	})
+
+	var b strings.Builder
+
+	b.WriteString("# HELP http_request_duration_seconds Latency of HTTP requests per route.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		metrics := r.routes[key]
+		labels := routeLabels(key)
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += metrics.buckets[i]
+			fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		cumulative += metrics.buckets[len(latencyBuckets)]
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, cumulative)
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(metrics.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "http_request_duration_seconds_count{%s} %d\n", labels, metrics.count)
+	}
+
+	b.WriteString("# HELP http_requests_total HTTP requests per route and status class.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, key := range keys {
+		metrics := r.routes[key]
+		classes := make([]string, 0, len(metrics.statuses))
+		for class := range metrics.statuses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		for _, class := range classes {
+			fmt.Fprintf(&b, "http_requests_total{%s,status=\"%s\"} %d\n", routeLabels(key), class, metrics.statuses[class])
+		}
+	}
+
+	b.WriteString("# HELP http_request_errors_total HTTP requests per route answered with a 5xx status.\n")
+	b.WriteString("# TYPE http_request_errors_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_request_errors_total{%s} %d\n", routeLabels(key), r.routes[key].errors)
+	}
+
+	b.WriteString("# HELP http_slow_requests_total HTTP requests per route slower than its threshold.\n")
+	b.WriteString("# TYPE http_slow_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_slow_requests_total{%s} %d\n", routeLabels(key), r.routes[key].slow)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func routeLabels(key routeKey) string {
+	return fmt.Sprintf("method=%q,route=%q", key.method, key.route)
+}
@@ -0,0 +1,78 @@
+// Package mixer mixes the Opus audio of several recordings into one stream
+// with ffmpeg.
+package mixer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/config"
+)
+
+// Input is an audio file placed on the mix timeline
+type Input struct {
+	Path string
+	// Offset delays the input relative to the start of the mix
+	Offset time.Duration
+	// Gain scales the input, 1 keeps its level and 0 silences it
+	Gain float64
+}
+
+// Mixer decodes, mixes and re-encodes audio with ffmpeg
+type Mixer struct {
+	ffmpeg  string
+	timeout time.Duration
+}
+
+// New returns a Mixer, or nil when mixing is disabled
+func New(cfg config.RecordingConfig) *Mixer {
+	if !cfg.MixEnabled {
+		return nil
+	}
+	return &Mixer{ffmpeg: cfg.FFmpegPath, timeout: cfg.MixTimeout}
+}
+
+// Mix writes the inputs mixed into a single Ogg/Opus stream to out. A
+// limiter keeps loud passages from clipping.
+func (m *Mixer) Mix(ctx context.Context, inputs []Input, out string) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("nothing to mix")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	args := []string{"-nostdin", "-y", "-loglevel", "error"}
+	for _, input := range inputs {
+		args = append(args, "-i", input.Path)
+	}
+	args = append(args, "-filter_complex", filterGraph(inputs), "-map", "[mix]", "-c:a", "libopus")
+
+	tmp := strings.TrimSuffix(out, filepath.Ext(out)) + ".partial.ogg"
+	args = append(args, tmp)
+	if output, err := exec.CommandContext(ctx, m.ffmpeg, args...).CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(tmp, out)
+}
+
+// filterGraph delays and scales every input, then sums them without
+// normalization so the gains keep their meaning
+func filterGraph(inputs []Input) string {
+	var graph strings.Builder
+	for i, input := range inputs {
+		fmt.Fprintf(&graph, "[%d:a]adelay=%d:all=1,volume=%s[a%d];", i, input.Offset.Milliseconds(), strconv.FormatFloat(input.Gain, 'f', -1, 64), i)
+	}
+	for i := range inputs {
+		fmt.Fprintf(&graph, "[a%d]", i)
+	}
+	fmt.Fprintf(&graph, "amix=inputs=%d:duration=longest:normalize=0,alimiter[mix]", len(inputs))
+	return graph.String()
+}
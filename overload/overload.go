@@ -0,0 +1,92 @@
+// Package overload sheds new work while the service runs short of
+// resources, so the calls and sockets already served stay healthy.
+package overload
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// memorySampleInterval limits how often memory statistics are read, since
+// reading them briefly stops the world
+const memorySampleInterval = time.Second
+
+// Guard compares the resource usage of the service with the configured
+// thresholds
+type Guard struct {
+	cfg config.LoadSheddingConfig
+	// peers counts the open peer connections
+	peers func() int
+
+	memory    uint64
+	sampledAt time.Time
+	mu        sync.Mutex
+}
+
+// NewGuard creates a Guard counting peer connections with peers
+func NewGuard(cfg config.LoadSheddingConfig, peers func() int) *Guard {
+	return &Guard{cfg: cfg, peers: peers}
+}
+
+// Overloaded reports the first exceeded threshold, or "" while the service
+// has room for new work
+func (g *Guard) Overloaded() string {
+	if g.cfg.MaxGoroutines > 0 {
+		if count := runtime.NumGoroutine(); count > g.cfg.MaxGoroutines {
+			return fmt.Sprintf("%d goroutines exceed the limit of %d", count, g.cfg.MaxGoroutines)
+		}
+	}
+	if g.cfg.MaxPeerConnections > 0 {
+		if count := g.peers(); count > g.cfg.MaxPeerConnections {
+			return fmt.Sprintf("%d peer connections exceed the limit of %d", count, g.cfg.MaxPeerConnections)
+		}
+	}
+	if g.cfg.MaxMemoryMB > 0 {
+		if mb := g.memoryMB(); mb > uint64(g.cfg.MaxMemoryMB) {
+			return fmt.Sprintf("%d MB of memory exceed the limit of %d MB", mb, g.cfg.MaxMemoryMB)
+		}
+	}
+	return ""
+}
+
+// memoryMB returns the memory obtained from the OS and not yet returned to
+// it, sampled at most once per memorySampleInterval
+func (g *Guard) memoryMB() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Since(g.sampledAt) >= memorySampleInterval {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		g.memory = stats.Sys - stats.HeapReleased
+		g.sampledAt = time.Now()
+	}
+	return g.memory >> 20
+}
+
+// Middleware rejects requests with 503 and a Retry-After header while the
+// service is overloaded. It is meant for routes that start new work, such
+// as session creation and WebSocket upgrades.
+func (g *Guard) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if reason := g.Overloaded(); reason != "" {
+				log.Printf("Shedding %s %s: %s\n", c.Request().Method, c.Request().URL.Path, reason)
+				errResp := utils.NewRetryErrorResponse(http.StatusServiceUnavailable, "server is overloaded", g.cfg.RetryAfter)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(errResp.RetryAfter))
+				return c.JSON(errResp.StatusCode, errResp)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+
+	"pion-webrtc-microservice/config"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/webrtc/v3"
+)
+
+// Factory builds webrtc APIs sharing the process wide network settings
+type Factory struct {
+	settingEngine *webrtc.SettingEngine
+	cfg           config.WebRTCConfig
+}
+
+// NewFactory creates a Factory from the WebRTC configuration. When ICE-TCP is
+// enabled a TCP listener is opened, so it should only be called once per process.
+func NewFactory(cfg config.WebRTCConfig) (*Factory, error) {
+	settingEngine, err := newSettingEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Factory{settingEngine: settingEngine, cfg: cfg}, nil
+}
+
+// NewAPI creates a webrtc API with the configured interceptors and the codecs
+// selected by the preferences. Zero preferences keep the default codec set.
+func (f *Factory) NewAPI(prefs CodecPreferences, opus OpusOptions) (*webrtc.API, error) {
+	mediaEngine, err := NewMediaEngine(prefs, opus)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &interceptor.Registry{}
+	if err := f.registerInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(*f.settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+// Configuration returns the peer connection configuration for the configured ICE servers
+func (f *Factory) Configuration() webrtc.Configuration {
+	return webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{
+			URLs: f.cfg.ICEServers,
+		}},
+	}
+}
+
+// registerInterceptors registers NACK generation and retransmission, RTCP
+// reports, TWCC and, when configured, periodic keyframe requests
+func (f *Factory) registerInterceptors(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return err
+	}
+
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(f.cfg.NACKBufferSize))
+	if err != nil {
+		return fmt.Errorf("invalid NACK buffer size %d: %w", f.cfg.NACKBufferSize, err)
+	}
+
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	registry.Add(responder)
+	registry.Add(generator)
+
+	if f.cfg.PLIInterval > 0 {
+		pli, err := intervalpli.NewReceiverInterceptor(intervalpli.GeneratorInterval(f.cfg.PLIInterval))
+		if err != nil {
+			return err
+		}
+		registry.Add(pli)
+	}
+
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return err
+	}
+
+	return webrtc.ConfigureTWCCSender(mediaEngine, registry)
+}
+
+func newSettingEngine(cfg config.WebRTCConfig) (*webrtc.SettingEngine, error) {
+	settingEngine := &webrtc.SettingEngine{}
+
+	networkTypes := []webrtc.NetworkType{webrtc.NetworkTypeUDP4}
+	if cfg.EnableIPv6 {
+		networkTypes = append(networkTypes, webrtc.NetworkTypeUDP6)
+	}
+
+	if cfg.EnableICETCP {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: cfg.ICETCPPort})
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for ICE-TCP on port %d: %w", cfg.ICETCPPort, err)
+		}
+		settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, 8))
+
+		networkTypes = append(networkTypes, webrtc.NetworkTypeTCP4)
+		if cfg.EnableIPv6 {
+			networkTypes = append(networkTypes, webrtc.NetworkTypeTCP6)
+		}
+	}
+	settingEngine.SetNetworkTypes(networkTypes)
+
+	if cfg.DisableMDNS {
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	}
+
+	if len(cfg.Interfaces) > 0 {
+		allowed := make(map[string]bool, len(cfg.Interfaces))
+		for _, name := range cfg.Interfaces {
+			allowed[name] = true
+		}
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			return allowed[name]
+		})
+	}
+
+	return settingEngine, nil
+}
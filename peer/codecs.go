@@ -0,0 +1,178 @@
+package peer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// CodecPreferences controls which codecs are negotiated and in which order.
+// Codecs are identified by MIME type, e.g. "video/H264" or "audio/opus".
+type CodecPreferences struct {
+	// Preferred codecs are registered first so they win negotiation when
+	// the remote side supports them.
+	Preferred []string `json:"preferred"`
+	// Required restricts a media kind to the listed codecs. Kinds without a
+	// required codec keep the full default set.
+	Required []string `json:"required"`
+}
+
+// AudioLevelURI identifies the RTP header extension carrying the audio level
+// of each packet (RFC 6464)
+const AudioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+type codecEntry struct {
+	kind   webrtc.RTPCodecType
+	params webrtc.RTPCodecParameters
+	rtx    webrtc.PayloadType // zero when the codec has no retransmission stream
+}
+
+var videoRTCPFeedback = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+
+// supportedCodecs mirrors the pion default codec set in default preference order
+var supportedCodecs = []codecEntry{
+	{kind: webrtc.RTPCodecTypeAudio, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}},
+	{kind: webrtc.RTPCodecTypeAudio, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeG722, ClockRate: 8000},
+		PayloadType:        9,
+	}},
+	{kind: webrtc.RTPCodecTypeAudio, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+		PayloadType:        0,
+	}},
+	{kind: webrtc.RTPCodecTypeAudio, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+		PayloadType:        8,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 97, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        96,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 103, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        102,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 107, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        106,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 125, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        127,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 46, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        45,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 99, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        98,
+	}},
+	{kind: webrtc.RTPCodecTypeVideo, rtx: 113, params: webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f", RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        112,
+	}},
+}
+
+// Validate checks that every referenced codec is supported
+func (p CodecPreferences) Validate() error {
+	for _, mimeType := range append(append([]string{}, p.Preferred...), p.Required...) {
+		if !isSupportedCodec(mimeType) {
+			return fmt.Errorf("unsupported codec %q", mimeType)
+		}
+	}
+	return nil
+}
+
+// NewMediaEngine registers the supported codecs filtered and ordered by the
+// preferences, with Opus configured from the given options
+func NewMediaEngine(prefs CodecPreferences, opus OpusOptions) (*webrtc.MediaEngine, error) {
+	if err := prefs.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opus.Validate(); err != nil {
+		return nil, err
+	}
+
+	requiredKinds := make(map[webrtc.RTPCodecType]bool)
+	for _, mimeType := range prefs.Required {
+		requiredKinds[codecKind(mimeType)] = true
+	}
+
+	var ordered []codecEntry
+	for _, mimeType := range prefs.Preferred {
+		for _, entry := range supportedCodecs {
+			if strings.EqualFold(entry.params.MimeType, mimeType) {
+				ordered = append(ordered, entry)
+			}
+		}
+	}
+	for _, entry := range supportedCodecs {
+		if !containsFold(prefs.Preferred, entry.params.MimeType) {
+			ordered = append(ordered, entry)
+		}
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, entry := range ordered {
+		if requiredKinds[entry.kind] && !containsFold(prefs.Required, entry.params.MimeType) {
+			continue
+		}
+
+		if entry.params.MimeType == webrtc.MimeTypeOpus {
+			entry.params.SDPFmtpLine = opus.fmtpLine()
+		}
+
+		if err := mediaEngine.RegisterCodec(entry.params, entry.kind); err != nil {
+			return nil, err
+		}
+
+		if entry.rtx != 0 {
+			rtx := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					MimeType:    "video/rtx",
+					ClockRate:   90000,
+					SDPFmtpLine: fmt.Sprintf("apt=%d", entry.params.PayloadType),
+				},
+				PayloadType: entry.rtx,
+			}
+			if err := mediaEngine.RegisterCodec(rtx, entry.kind); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Publishers report the level of every audio packet, which the server
+	// follows speakers with without decoding the audio
+	if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
+
+	return mediaEngine, nil
+}
+
+func isSupportedCodec(mimeType string) bool {
+	return codecKind(mimeType) != 0
+}
+
+func codecKind(mimeType string) webrtc.RTPCodecType {
+	for _, entry := range supportedCodecs {
+		if strings.EqualFold(entry.params.MimeType, mimeType) {
+			return entry.kind
+		}
+	}
+	return 0
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// EchoTest is a loopback connection sending a client's media back to it
+type EchoTest struct {
+	ID        string                     `json:"id"`
+	Answer    *webrtc.SessionDescription `json:"answer"`
+	ExpiresAt utils.Time                 `json:"expiresAt"`
+}
+
+// EchoTester runs echo tests so users can check their microphone, camera
+// and network before joining a call
+type EchoTester struct {
+	api           *webrtc.API
+	configuration webrtc.Configuration
+	duration      time.Duration
+	tests         map[string]*webrtc.PeerConnection
+	mutex         sync.Mutex
+}
+
+// NewEchoTester creates an EchoTester whose tests are closed after duration
+func NewEchoTester(api *webrtc.API, configuration webrtc.Configuration, duration time.Duration) *EchoTester {
+	return &EchoTester{
+		api:           api,
+		configuration: configuration,
+		duration:      duration,
+		tests:         make(map[string]*webrtc.PeerConnection),
+	}
+}
+
+// Start answers the offer of an echo test. The client's Opus audio and VP8
+// video are looped back on matching tracks until the test expires or the
+// connection ends.
+func (et *EchoTester) Start(offer webrtc.SessionDescription) (*EchoTest, *utils.ErrorResponse) {
+	pc, err := et.api.NewPeerConnection(et.configuration)
+	if err != nil {
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, err.Error())
+	}
+
+	// The loopback tracks are added before the offer is applied, so they are
+	// sent back on the transceivers the client offered
+	loopbacks := make(map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP)
+	for kind, mimeType := range map[webrtc.RTPCodecType]string{
+		webrtc.RTPCodecTypeAudio: webrtc.MimeTypeOpus,
+		webrtc.RTPCodecTypeVideo: webrtc.MimeTypeVP8,
+	} {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mimeType}, kind.String(), "echo")
+		if err == nil {
+			_, err = pc.AddTrack(track)
+		}
+		if err != nil {
+			pc.Close()
+			return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to add loopback track")
+		}
+		loopbacks[kind] = track
+	}
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		loopback, exists := loopbacks[remote.Kind()]
+		if !exists {
+			return
+		}
+		if remote.Kind() == webrtc.RTPCodecTypeVideo {
+			_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remote.SSRC())}})
+		}
+
+		for {
+			packet, _, err := remote.ReadRTP()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Println("Error reading echo test track:", err)
+				}
+				return
+			}
+			if err := loopback.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				log.Println("Error echoing track:", err)
+				return
+			}
+		}
+	})
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "failed to set remote description")
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to create answer")
+	}
+
+	// Wait for candidate gathering so the answer is usable without trickle ICE
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return nil, utils.NewErrorResponse(http.StatusInternalServerError, "failed to set local description")
+	}
+	<-gatherComplete
+
+	test := &EchoTest{
+		ID:        utils.NewID(utils.PrefixEchoTest),
+		Answer:    pc.LocalDescription(),
+		ExpiresAt: utils.NewTime(time.Now().Add(et.duration)),
+	}
+
+	et.mutex.Lock()
+	et.tests[test.ID] = pc
+	et.mutex.Unlock()
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			et.end(test.ID)
+		}
+	})
+	time.AfterFunc(et.duration, func() {
+		et.end(test.ID)
+	})
+
+	return test, nil
+}
+
+// end closes an echo test, it's a no-op once the test has ended
+func (et *EchoTester) end(id string) {
+	et.mutex.Lock()
+	pc, exists := et.tests[id]
+	delete(et.tests, id)
+	et.mutex.Unlock()
+
+	if exists {
+		if err := pc.Close(); err != nil {
+			log.Println("Error closing echo test:", err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"pion-webrtc-microservice/utils"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// FileTransferLabel is the DataChannel label used for file transfers
+const FileTransferLabel = "file-transfer"
+
+// FileTransferMessageType identifies a step of the transfer protocol
+type FileTransferMessageType string
+
+const (
+	TransferOffer    FileTransferMessageType = "offer"
+	TransferAccept   FileTransferMessageType = "accept"
+	TransferReject   FileTransferMessageType = "reject"
+	TransferChunk    FileTransferMessageType = "chunk"
+	TransferAck      FileTransferMessageType = "ack"
+	TransferComplete FileTransferMessageType = "complete"
+)
+
+// transferIDPattern is what a client chosen transfer ID must look like
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// FileTransferMessage is exchanged over the file transfer DataChannel. Chunk
+// data is base64 encoded by the JSON encoding.
+type FileTransferMessage struct {
+	Type         FileTransferMessageType `json:"type"`
+	TransferID   string                  `json:"transferId"`
+	TargetPeerID string                  `json:"targetPeerId,omitempty"`
+	SenderPeerID string                  `json:"senderPeerId,omitempty"`
+	SessionID    string                  `json:"sessionId,omitempty"`
+	Name         string                  `json:"name,omitempty"`
+	MimeType     string                  `json:"mimeType,omitempty"`
+	Size         int64                   `json:"size,omitempty"`
+	Seq          int                     `json:"seq,omitempty"`
+	Data         []byte                  `json:"data,omitempty"`
+	Reason       string                  `json:"reason,omitempty"`
+}
+
+// FileTransfer is the server side state of a relayed transfer
+type FileTransfer struct {
+	ID         string `json:"id"`
+	SenderID   string `json:"senderId"`
+	ReceiverID string `json:"receiverId"`
+	SessionID  string `json:"sessionId,omitempty"`
+	Name       string `json:"name"`
+	MimeType   string `json:"mimeType"`
+	Size       int64  `json:"size"`
+	Received   int64  `json:"received"`
+	// Path is the stored copy, only kept for transfers within a chat session.
+	// It is in quarantine until Publish moves it to the served directory.
+	Path string `json:"path,omitempty"`
+	// StorageID names the directory of the stored copy. It's generated by
+	// the server, the client chosen ID never becomes part of a path.
+	StorageID string `json:"storageId,omitempty"`
+	accepted  bool
+	file      *os.File
+}
+
+// FileTransferRelay relays chunked file transfers between peers connected to
+// the server and keeps a copy of transfers made within a chat session
+type FileTransferRelay struct {
+	channels   map[string]*webrtc.DataChannel
+	transfers  map[string]*FileTransfer
+	dir        string
+	quarantine string
+	maxSize    int64
+	// OnComplete is called after a transfer within a chat session finished
+	// with its announced size. Its copy is still in quarantine, to be
+	// scanned and published or deleted.
+	OnComplete func(transfer *FileTransfer)
+	mu         sync.Mutex
+}
+
+// NewFileTransferRelay creates a relay receiving session transfers below
+// quarantineDir and publishing them below dir, on the same filesystem
+func NewFileTransferRelay(dir, quarantineDir string, maxSize int64) *FileTransferRelay {
+	return &FileTransferRelay{
+		channels:   make(map[string]*webrtc.DataChannel),
+		transfers:  make(map[string]*FileTransfer),
+		dir:        dir,
+		quarantine: quarantineDir,
+		maxSize:    maxSize,
+	}
+}
+
+// Attach registers a peer's file transfer DataChannel
+func (r *FileTransferRelay) Attach(peerID string, dc *webrtc.DataChannel) {
+	r.mu.Lock()
+	r.channels[peerID] = dc
+	r.mu.Unlock()
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var message FileTransferMessage
+		if err := json.Unmarshal(msg.Data, &message); err != nil {
+			log.Printf("Error decoding file transfer message from %s: %v\n", peerID, err)
+			return
+		}
+		r.handleMessage(peerID, message)
+	})
+
+	dc.OnClose(func() {
+		r.detach(peerID, dc)
+	})
+}
+
+// detach drops the peer's channel and aborts its pending transfers
+func (r *FileTransferRelay) detach(peerID string, dc *webrtc.DataChannel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.channels[peerID] == dc {
+		delete(r.channels, peerID)
+	}
+	for id, transfer := range r.transfers {
+		if transfer.SenderID == peerID || transfer.ReceiverID == peerID {
+			r.abort(transfer)
+			delete(r.transfers, id)
+		}
+	}
+}
+
+func (r *FileTransferRelay) handleMessage(peerID string, message FileTransferMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if message.Type == TransferOffer {
+		r.handleOffer(peerID, message)
+		return
+	}
+
+	transfer, exists := r.transfers[message.TransferID]
+	if !exists {
+		r.send(peerID, FileTransferMessage{Type: TransferReject, TransferID: message.TransferID, Reason: "unknown transfer"})
+		return
+	}
+
+	switch message.Type {
+	case TransferAccept, TransferAck:
+		if peerID != transfer.ReceiverID {
+			return
+		}
+		transfer.accepted = true
+		r.send(transfer.SenderID, message)
+
+	case TransferReject:
+		if peerID != transfer.ReceiverID && peerID != transfer.SenderID {
+			return
+		}
+		r.abort(transfer)
+		delete(r.transfers, transfer.ID)
+		r.send(transfer.otherPeer(peerID), message)
+
+	case TransferChunk:
+		if peerID != transfer.SenderID || !transfer.accepted {
+			return
+		}
+		if err := transfer.write(message.Data); err != nil {
+			r.abort(transfer)
+			delete(r.transfers, transfer.ID)
+			reject := FileTransferMessage{Type: TransferReject, TransferID: transfer.ID, Reason: err.Error()}
+			r.send(transfer.SenderID, reject)
+			r.send(transfer.ReceiverID, reject)
+			return
+		}
+		r.send(transfer.ReceiverID, message)
+
+	case TransferComplete:
+		if peerID != transfer.SenderID {
+			return
+		}
+		delete(r.transfers, transfer.ID)
+		r.send(transfer.ReceiverID, message)
+
+		if transfer.file == nil {
+			return
+		}
+		if transfer.Received != transfer.Size || r.OnComplete == nil {
+			log.Printf("Discarding file transfer %s, received %d of %d bytes\n", transfer.ID, transfer.Received, transfer.Size)
+			r.abort(transfer)
+			return
+		}
+		transfer.file.Close()
+		go r.OnComplete(transfer)
+	}
+}
+
+func (r *FileTransferRelay) handleOffer(peerID string, message FileTransferMessage) {
+	reject := func(reason string) {
+		r.send(peerID, FileTransferMessage{Type: TransferReject, TransferID: message.TransferID, Reason: reason})
+	}
+
+	name := filepath.Base(message.Name)
+	if !transferIDPattern.MatchString(message.TransferID) || message.Name == "" || name == "." || name == ".." || name == "/" {
+		reject("a valid transferId and name are required")
+		return
+	}
+	if _, exists := r.transfers[message.TransferID]; exists {
+		reject("transfer already exists")
+		return
+	}
+	if message.Size <= 0 || message.Size > r.maxSize {
+		reject(fmt.Sprintf("size must be between 1 and %d bytes", r.maxSize))
+		return
+	}
+	if _, connected := r.channels[message.TargetPeerID]; !connected {
+		reject("target peer is not connected")
+		return
+	}
+
+	transfer := &FileTransfer{
+		ID:         message.TransferID,
+		SenderID:   peerID,
+		ReceiverID: message.TargetPeerID,
+		SessionID:  message.SessionID,
+		Name:       name,
+		MimeType:   message.MimeType,
+		Size:       message.Size,
+	}
+
+	// Keep a copy of transfers made within a chat session for the attachment
+	if transfer.SessionID != "" {
+		transfer.StorageID = utils.NewID(utils.PrefixFile)
+		dir := filepath.Join(r.quarantine, transfer.StorageID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			reject("failed to store transfer")
+			return
+		}
+		file, err := os.Create(filepath.Join(dir, transfer.Name))
+		if err != nil {
+			reject("failed to store transfer")
+			return
+		}
+		transfer.file = file
+		transfer.Path = file.Name()
+	}
+
+	r.transfers[transfer.ID] = transfer
+
+	message.SenderPeerID = peerID
+	r.send(transfer.ReceiverID, message)
+}
+
+// Publish moves the copy of a completed transfer out of quarantine into the
+// served directory, once it was scanned clean. The copy is deleted when it
+// can't be moved.
+func (r *FileTransferRelay) Publish(transfer *FileTransfer) error {
+	quarantined := filepath.Dir(transfer.Path)
+	dir := filepath.Join(r.dir, transfer.StorageID)
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		os.RemoveAll(quarantined)
+		return err
+	}
+	if err := os.Rename(quarantined, dir); err != nil {
+		os.RemoveAll(quarantined)
+		return err
+	}
+	transfer.Path = filepath.Join(dir, transfer.Name)
+	return nil
+}
+
+// send writes a message to a peer's channel. The relay lock must be held.
+func (r *FileTransferRelay) send(peerID string, message FileTransferMessage) {
+	dc, exists := r.channels[peerID]
+	if !exists {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	if err := dc.SendText(string(data)); err != nil {
+		log.Printf("Error sending file transfer message to %s: %v\n", peerID, err)
+	}
+}
+
+// abort closes and removes a partially stored transfer
+func (r *FileTransferRelay) abort(transfer *FileTransfer) {
+	if transfer.file != nil {
+		transfer.file.Close()
+		os.RemoveAll(filepath.Dir(transfer.Path))
+	}
+}
+
+func (t *FileTransfer) write(data []byte) error {
+	if t.Received+int64(len(data)) > t.Size {
+		return fmt.Errorf("transfer exceeds announced size")
+	}
+
+	if t.file != nil {
+		if _, err := t.file.Write(data); err != nil {
+			return fmt.Errorf("failed to store chunk")
+		}
+	}
+	t.Received += int64(len(data))
+	return nil
+}
+
+func (t *FileTransfer) otherPeer(peerID string) string {
+	if peerID == t.SenderID {
+		return t.ReceiverID
+	}
+	return t.SenderID
+}
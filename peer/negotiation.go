@@ -0,0 +1,46 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrNegotiationPending is returned when an offer arrives while an earlier
+// negotiation is pending and can't be rolled back
+var ErrNegotiationPending = errors.New("negotiation already in progress")
+
+// ApplyOffer sets a remote offer on a connection the server answers as the
+// polite peer: a pending description is rolled back first, so a colliding
+// offer doesn't leave the connection wedged. A rollback description only
+// rolls back.
+func ApplyOffer(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) error {
+	if err := Rollback(pc); err != nil {
+		return err
+	}
+	if offer.Type == webrtc.SDPTypeRollback {
+		return nil
+	}
+	return pc.SetRemoteDescription(offer)
+}
+
+// Rollback returns a connection to the stable signaling state by discarding
+// its pending local or remote description
+func Rollback(pc *webrtc.PeerConnection) error {
+	rollback := webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}
+
+	var err error
+	switch pc.SignalingState() {
+	case webrtc.SignalingStateStable:
+		return nil
+	case webrtc.SignalingStateHaveLocalOffer, webrtc.SignalingStateHaveLocalPranswer:
+		err = pc.SetLocalDescription(rollback)
+	default:
+		err = pc.SetRemoteDescription(rollback)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNegotiationPending, err)
+	}
+	return nil
+}
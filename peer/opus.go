@@ -0,0 +1,49 @@
+package peer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpusOptions configures the Opus encoder parameters advertised in SDP
+type OpusOptions struct {
+	// DTX enables discontinuous transmission to suppress packets during silence
+	DTX bool `json:"dtx"`
+	// FEC enables inband forward error correction for lossy links
+	FEC bool `json:"fec"`
+	// Stereo enables two channel audio, e.g. for music
+	Stereo bool `json:"stereo"`
+	// MaxAverageBitrate caps the encoder bitrate in bits per second, zero leaves it to the encoder
+	MaxAverageBitrate int `json:"maxAverageBitrate"`
+}
+
+// DefaultOpusOptions matches the pion defaults with FEC enabled
+func DefaultOpusOptions() OpusOptions {
+	return OpusOptions{FEC: true}
+}
+
+// Validate checks the option values
+func (o OpusOptions) Validate() error {
+	if o.MaxAverageBitrate != 0 && (o.MaxAverageBitrate < 6000 || o.MaxAverageBitrate > 510000) {
+		return fmt.Errorf("opus maxAverageBitrate must be between 6000 and 510000")
+	}
+	return nil
+}
+
+// fmtpLine renders the options as an Opus fmtp line
+func (o OpusOptions) fmtpLine() string {
+	params := []string{"minptime=10"}
+	if o.FEC {
+		params = append(params, "useinbandfec=1")
+	}
+	if o.DTX {
+		params = append(params, "usedtx=1")
+	}
+	if o.Stereo {
+		params = append(params, "stereo=1", "sprop-stereo=1")
+	}
+	if o.MaxAverageBitrate > 0 {
+		params = append(params, fmt.Sprintf("maxaveragebitrate=%d", o.MaxAverageBitrate))
+	}
+	return strings.Join(params, ";")
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"sync"
 
+	"pion-webrtc-microservice/shardmap"
 	"pion-webrtc-microservice/utils"
 
 	"github.com/pion/webrtc/v3"
@@ -17,43 +18,52 @@ type PeerConnectionState struct {
 
 // PeerManager manages all active peer connections
 type PeerManager struct {
-	peerConnections map[string]*PeerConnectionState
-	mutex           sync.Mutex
+	peerConnections *shardmap.Map[*PeerConnectionState]
+	api             *webrtc.API
+	configuration   webrtc.Configuration
+	FileTransfers   *FileTransferRelay
 }
 
 // NewPeerManager creates a new PeerManager
-func NewPeerManager() *PeerManager {
-	return &PeerManager{peerConnections: make(map[string]*PeerConnectionState)}
+func NewPeerManager(api *webrtc.API, configuration webrtc.Configuration, fileTransfers *FileTransferRelay) *PeerManager {
+	return &PeerManager{
+		peerConnections: shardmap.New[*PeerConnectionState](),
+		api:             api,
+		configuration:   configuration,
+		FileTransfers:   fileTransfers,
+	}
 }
 
 // CreatePeerConnection creates a new peer connection
 func (pm *PeerManager) CreatePeerConnection(peerID string) (*PeerConnectionState, *utils.ErrorResponse) {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
-	if _, exists := pm.peerConnections[peerID]; exists {
+	if _, exists := pm.peerConnections.Load(peerID); exists {
 		return nil, utils.NewErrorResponse(http.StatusConflict, "peer connection already exists")
 	}
 
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{
-			URLs: []string{"stun:stun.l.google.com:19302"},
-		}},
-	})
+	peerConnection, err := pm.api.NewPeerConnection(pm.configuration)
 	if err != nil {
 		return nil, utils.NewErrorResponse(http.StatusInternalServerError, err.Error())
 	}
 
-	pm.peerConnections[peerID] = &PeerConnectionState{PeerConnection: peerConnection}
-	return pm.peerConnections[peerID], nil
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() == FileTransferLabel {
+			pm.FileTransfers.Attach(peerID, dc)
+		}
+	})
+
+	// The connection is created without holding a lock, a concurrent request
+	// for the same peer may have won meanwhile
+	state := &PeerConnectionState{PeerConnection: peerConnection}
+	if _, exists := pm.peerConnections.LoadOrStore(peerID, state); exists {
+		peerConnection.Close()
+		return nil, utils.NewErrorResponse(http.StatusConflict, "peer connection already exists")
+	}
+	return state, nil
 }
 
 // GetPeerConnection retrieves a peer connection by ID
 func (pm *PeerManager) GetPeerConnection(peerID string) (*webrtc.PeerConnection, *utils.ErrorResponse) {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
-	peerConnection, exists := pm.peerConnections[peerID]
+	peerConnection, exists := pm.peerConnections.Load(peerID)
 	if !exists {
 		return nil, utils.NewErrorResponse(http.StatusNotFound, "peer connection not found")
 	}
@@ -61,12 +71,14 @@ func (pm *PeerManager) GetPeerConnection(peerID string) (*webrtc.PeerConnection,
 	return peerConnection.PeerConnection, nil
 }
 
+// Count returns the number of open peer connections
+func (pm *PeerManager) Count() int {
+	return pm.peerConnections.Len()
+}
+
 // ClosePeerConnection closes a peer connection by ID
 func (pm *PeerManager) ClosePeerConnection(peerID string) *utils.ErrorResponse {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
-	peerConnection, exists := pm.peerConnections[peerID]
+	peerConnection, exists := pm.peerConnections.LoadAndDelete(peerID)
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "peer connection not found")
 	}
@@ -76,16 +88,13 @@ func (pm *PeerManager) ClosePeerConnection(peerID string) *utils.ErrorResponse {
 		return utils.NewErrorResponse(http.StatusInternalServerError, err.Error())
 	}
 
-	delete(pm.peerConnections, peerID)
 	return nil
 }
 
 // AddICECandidate adds an ICE candidate to a peer connection
 func (pm *PeerManager) AddICECandidate(peerID string, candidate webrtc.ICECandidateInit) *utils.ErrorResponse {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
+	peerConnection, exists := pm.peerConnections.Load(peerID)
 
-	peerConnection, exists := pm.peerConnections[peerID]
 	if !exists {
 		return utils.NewErrorResponse(http.StatusNotFound, "peer connection not found")
 	}
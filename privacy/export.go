@@ -0,0 +1,217 @@
+package privacy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pion-webrtc-microservice/call"
+	"pion-webrtc-microservice/chat"
+	"pion-webrtc-microservice/utils"
+)
+
+// ExportStatus is the state of an export job
+type ExportStatus string
+
+const (
+	ExportPending  ExportStatus = "pending"
+	ExportComplete ExportStatus = "complete"
+	ExportFailed   ExportStatus = "failed"
+)
+
+// ExportJob tracks the generation of a user's data archive
+type ExportJob struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"userId"`
+	Status      ExportStatus `json:"status"`
+	CreatedAt   utils.Time   `json:"createdAt"`
+	CompletedAt utils.Time   `json:"completedAt"`
+	Error       string       `json:"error,omitempty"`
+	path        string
+}
+
+// UserData is the content of data.json in an export archive
+type UserData struct {
+	UserID     string                   `json:"userId"`
+	ExportedAt utils.Time               `json:"exportedAt"`
+	Chat       *chat.UserExport         `json:"chat"`
+	Calls      []call.Participation     `json:"calls"`
+	Recordings []call.RecordingManifest `json:"recordings"`
+}
+
+// Exporter builds per-user data archives in the background
+type Exporter struct {
+	dir   string
+	chats *chat.ChatManager
+	calls *call.CallManager
+	jobs  map[string]*ExportJob
+	mu    sync.Mutex
+}
+
+// NewExporter creates an Exporter writing archives below dir
+func NewExporter(dir string, chats *chat.ChatManager, calls *call.CallManager) *Exporter {
+	return &Exporter{
+		dir:   dir,
+		chats: chats,
+		calls: calls,
+		jobs:  make(map[string]*ExportJob),
+	}
+}
+
+// Start queues an export for a user. A pending export of the same user is
+// returned instead of starting another one.
+func (e *Exporter) Start(userID string) (*ExportJob, *utils.ErrorResponse) {
+	if userID == "" {
+		return nil, utils.NewErrorResponse(http.StatusBadRequest, "userID is required")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, job := range e.jobs {
+		if job.UserID == userID && job.Status == ExportPending {
+			snapshot := *job
+			return &snapshot, nil
+		}
+	}
+
+	job := &ExportJob{
+		ID:        utils.NewID(utils.PrefixExport),
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: utils.Now(),
+	}
+	e.jobs[job.ID] = job
+
+	go e.run(job.ID, userID)
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Job returns the current state of an export
+func (e *Exporter) Job(jobID string) (*ExportJob, *utils.ErrorResponse) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, exists := e.jobs[jobID]
+	if !exists {
+		return nil, utils.NewErrorResponse(http.StatusNotFound, "export not found")
+	}
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// ArchivePath returns the archive of a completed export
+func (e *Exporter) ArchivePath(jobID string) (string, *utils.ErrorResponse) {
+	job, errResp := e.Job(jobID)
+	if errResp != nil {
+		return "", errResp
+	}
+	if job.Status != ExportComplete {
+		return "", utils.NewErrorResponse(http.StatusConflict, "export is not complete")
+	}
+	return job.path, nil
+}
+
+func (e *Exporter) run(jobID, userID string) {
+	path := filepath.Join(e.dir, jobID+".zip")
+	err := e.build(path, userID)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job := e.jobs[jobID]
+	job.CompletedAt = utils.Now()
+	if err != nil {
+		log.Printf("Error exporting data of user %s: %v\n", userID, err)
+		os.Remove(path)
+		job.Status = ExportFailed
+		job.Error = "failed to build export"
+		return
+	}
+	job.Status = ExportComplete
+	job.path = path
+}
+
+// build writes data.json and the recordings of the user's calls into a zip
+// archive
+func (e *Exporter) build(path, userID string) error {
+	chatData, err := e.chats.ExportUser(userID)
+	if err != nil {
+		return err
+	}
+	calls, err := e.calls.ParticipationsOf(userID)
+	if err != nil {
+		return err
+	}
+	recordings, err := e.calls.RecordingsOf(calls)
+	if err != nil {
+		return err
+	}
+
+	data := UserData{
+		UserID:     userID,
+		ExportedAt: utils.Now(),
+		Chat:       chatData,
+		Calls:      calls,
+		Recordings: recordings,
+	}
+
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	w, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return err
+	}
+
+	for _, manifest := range recordings {
+		for _, recording := range manifest.Files {
+			name := filepath.Join("recordings", manifest.SessionID, filepath.Base(recording.Path))
+			if err := addFile(archive, name, recording.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+func addFile(archive *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// The recording may have been cleaned up since the manifest was written
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := archive.Create(filepath.ToSlash(name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
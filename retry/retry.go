@@ -0,0 +1,307 @@
+// Package retry keeps operations that failed on disk and retries them in the
+// background with exponential backoff, so state changes survive outages and
+// restarts instead of being dropped.
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/utils"
+)
+
+// Handler performs the operation of a job, a returned error schedules
+// another attempt
+type Handler func(key string, payload []byte) error
+
+// Job is an operation waiting for a retry
+type Job struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key,omitempty"`
+	Payload     []byte    `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	CreatedAt   time.Time `json:"createdAt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	// version changes whenever the job is replaced, so an attempt that
+	// raced with a newer enqueue doesn't settle it
+	version uint64
+}
+
+// Queue is a disk-backed retry queue. Each job is a file in the queue
+// directory, jobs that exhaust their attempts are moved to its dead
+// subdirectory.
+type Queue struct {
+	cfg      config.RetryConfig
+	handlers map[string]Handler
+	jobs     map[string]*Job
+	wake     chan struct{}
+	versions uint64
+	mu       sync.Mutex
+}
+
+// NewQueue creates a Queue, Start loads the jobs left from earlier runs and
+// begins retrying
+func NewQueue(cfg config.RetryConfig) *Queue {
+	return &Queue{
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+		jobs:     make(map[string]*Job),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler of a kind of job
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.handlers[kind] = handler
+}
+
+// Start loads the persisted jobs and retries them in the background
+func (q *Queue) Start() error {
+	if err := os.MkdirAll(q.cfg.Dir, 0755); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(q.cfg.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			q.mu.Unlock()
+			return err
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("Skipping unreadable retry job %s: %v\n", path, err)
+			continue
+		}
+		if _, exists := q.jobs[job.ID]; !exists {
+			q.jobs[job.ID] = &job
+		}
+	}
+	if len(q.jobs) > 0 {
+		log.Printf("Loaded %d pending retry jobs\n", len(q.jobs))
+	}
+	q.mu.Unlock()
+
+	go q.run()
+	return nil
+}
+
+// Enqueue persists an operation for a retry. Jobs with a key replace the
+// pending job of the same kind and key, so only the latest state is
+// retried. An empty key always adds a new job.
+func (q *Queue) Enqueue(kind, key string, payload []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := jobID(kind, key)
+	if key == "" {
+		id = jobID(kind, utils.NewID(utils.PrefixJob))
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:          id,
+		Kind:        kind,
+		Key:         key,
+		Payload:     payload,
+		CreatedAt:   now,
+		NextAttempt: now.Add(q.cfg.MinBackoff),
+	}
+	if previous, exists := q.jobs[id]; exists {
+		job.CreatedAt = previous.CreatedAt
+	}
+	q.versions++
+	job.version = q.versions
+
+	if err := q.write(job); err != nil {
+		return err
+	}
+	q.jobs[id] = job
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Cancel drops the pending job of a kind and key, e.g. once a later attempt
+// of the same operation succeeded
+func (q *Queue) Cancel(kind, key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := jobID(kind, key)
+	if _, exists := q.jobs[id]; !exists {
+		return
+	}
+	delete(q.jobs, id)
+	os.Remove(q.path(id))
+}
+
+// Len returns the number of pending jobs
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.jobs)
+}
+
+func (q *Queue) run() {
+	for {
+		wait := q.attemptDue()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// attemptDue runs the jobs that are due, oldest first, and returns how long
+// to wait for the next one
+func (q *Queue) attemptDue() time.Duration {
+	now := time.Now()
+
+	q.mu.Lock()
+	var due []Job
+	for _, job := range q.jobs {
+		if !job.NextAttempt.After(now) {
+			due = append(due, *job)
+		}
+	}
+	q.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	for _, job := range due {
+		q.attempt(job)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := q.cfg.MaxBackoff
+	for _, job := range q.jobs {
+		if until := time.Until(job.NextAttempt); until < wait {
+			wait = until
+		}
+	}
+	return max(wait, 0)
+}
+
+func (q *Queue) attempt(job Job) {
+	q.mu.Lock()
+	handler := q.handlers[job.Kind]
+	q.mu.Unlock()
+
+	var err error
+	if handler == nil {
+		err = fmt.Errorf("no handler for %s jobs", job.Kind)
+	} else {
+		err = handler(job.Key, job.Payload)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	current, exists := q.jobs[job.ID]
+	if !exists || current.version != job.version {
+		// Cancelled or replaced meanwhile
+		return
+	}
+
+	if err == nil {
+		delete(q.jobs, job.ID)
+		os.Remove(q.path(job.ID))
+		return
+	}
+
+	current.Attempts++
+	current.LastError = err.Error()
+	if q.cfg.MaxAttempts > 0 && current.Attempts >= q.cfg.MaxAttempts {
+		log.Printf("Giving up on %s job %s after %d attempts: %v\n", job.Kind, job.ID, current.Attempts, err)
+		delete(q.jobs, job.ID)
+		q.bury(current)
+		return
+	}
+
+	current.NextAttempt = time.Now().Add(q.backoff(current.Attempts))
+	if err := q.write(current); err != nil {
+		log.Printf("Error persisting retry job %s: %v\n", job.ID, err)
+	}
+}
+
+// backoff doubles the delay with every attempt up to the maximum
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.cfg.MinBackoff
+	for i := 1; i < attempts && delay < q.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, q.cfg.MaxBackoff)
+}
+
+// jobID names the job of a kind and key, it's also its file name
+func jobID(kind, key string) string {
+	return kind + "-" + url.PathEscape(key)
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.cfg.Dir, id+".json")
+}
+
+// write persists a job, replacing its file atomically
+func (q *Queue) write(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(q.cfg.Dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := q.path(job.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(job.ID))
+}
+
+// bury moves a job that exhausted its attempts to the dead subdirectory for
+// inspection
+func (q *Queue) bury(job *Job) {
+	if err := q.write(job); err != nil {
+		log.Printf("Error persisting dead retry job %s: %v\n", job.ID, err)
+		return
+	}
+
+	dead := filepath.Join(q.cfg.Dir, "dead")
+	if err := os.MkdirAll(dead, 0755); err != nil {
+		log.Printf("Error moving dead retry job %s: %v\n", job.ID, err)
+		return
+	}
+	if err := os.Rename(q.path(job.ID), filepath.Join(dead, job.ID+".json")); err != nil {
+		log.Printf("Error moving dead retry job %s: %v\n", job.ID, err)
+	}
+}
@@ -0,0 +1,242 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"pion-webrtc-microservice/config"
+)
+
+// defaultLimit is the number of hits returned when a query sets none
+const defaultLimit = 20
+
+// mapping keeps the IDs exact so messages are filtered by participant and
+// session, while the text is analyzed for full-text search
+var mapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"messageId":    map[string]string{"type": "keyword"},
+			"sessionId":    map[string]string{"type": "keyword"},
+			"senderId":     map[string]string{"type": "keyword"},
+			"receiverId":   map[string]string{"type": "keyword"},
+			"participants": map[string]string{"type": "keyword"},
+			"type":         map[string]string{"type": "keyword"},
+			"text":         map[string]string{"type": "text"},
+			"timestamp":    map[string]string{"type": "date"},
+		},
+	},
+}
+
+// ElasticEngine indexes into Elasticsearch or OpenSearch through their
+// common REST API
+type ElasticEngine struct {
+	url      string
+	index    string
+	username string
+	password string
+	apiKey   string
+	client   *http.Client
+}
+
+// NewElasticEngine creates an engine for the cluster and index of the
+// configuration
+func NewElasticEngine(cfg config.SearchConfig) *ElasticEngine {
+	return &ElasticEngine{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		index:    cfg.Index,
+		username: cfg.Username,
+		password: cfg.Password,
+		apiKey:   cfg.APIKey,
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (e *ElasticEngine) EnsureIndex(ctx context.Context) error {
+	resp, err := e.do(ctx, http.MethodHead, "/"+url.PathEscape(e.index), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	resp, err = e.do(ctx, http.MethodPut, "/"+url.PathEscape(e.index), "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Another instance may have created it in the meantime
+	if resp.StatusCode == http.StatusBadRequest {
+		var failure struct {
+			Error struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error.Type == "resource_already_exists_exception" {
+			return nil
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Bulk indexes the documents and deletes those marked deleted in a single
+// request
+func (e *ElasticEngine) Bulk(ctx context.Context, docs []Document) error {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action := "index"
+		if doc.Deleted {
+			action = "delete"
+		}
+		meta, err := json.Marshal(map[string]map[string]string{action: {"_index": e.index, "_id": doc.MessageID}})
+		if err != nil {
+			return err
+		}
+		body.Write(meta)
+		body.WriteByte('\n')
+
+		if doc.Deleted {
+			continue
+		}
+		source, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		body.Write(source)
+		body.WriteByte('\n')
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// The request succeeds as a whole even when items fail
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			// Deleting a message that was never indexed isn't a failure
+			if outcome.Error == nil || (action == "delete" && outcome.Status == http.StatusNotFound) {
+				continue
+			}
+			return fmt.Errorf("bulk %s failed: %s", action, outcome.Error.Reason)
+		}
+	}
+	return nil
+}
+
+func (e *ElasticEngine) Search(ctx context.Context, query Query) ([]Hit, error) {
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]string{"participants": query.UserID}},
+	}
+	if query.SessionID != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"sessionId": query.SessionID}})
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   map[string]interface{}{"match": map[string]string{"text": query.Text}},
+				"filter": filters,
+			},
+		},
+		"sort":      []interface{}{"_score", map[string]string{"timestamp": "desc"}},
+		"highlight": map[string]interface{}{"fields": map[string]interface{}{"text": struct{}{}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, "/"+url.PathEscape(e.index)+"/_search", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Score     float64  `json:"_score"`
+				Source    Document `json:"_source"`
+				Highlight struct {
+					Text []string `json:"text"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	hits := make([]Hit, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		hits = append(hits, Hit{Document: hit.Source, Score: hit.Score, Highlights: hit.Highlight.Text})
+	}
+	return hits, nil
+}
+
+// do sends a request to the cluster with its credentials
+func (e *ElasticEngine) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case e.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	case e.username != "":
+		req.SetBasicAuth(e.username, e.password)
+	}
+	return e.client.Do(req)
+}
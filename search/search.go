@@ -0,0 +1,195 @@
+// Package search indexes chat messages in Elasticsearch or OpenSearch in
+// the background, so users can search the messages of every session they
+// took part in.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/retry"
+	"pion-webrtc-microservice/utils"
+)
+
+// indexJob is the retry job of batches the engine failed to take
+const indexJob = "search-index"
+
+// Document is a chat message as indexed
+type Document struct {
+	MessageID  string `json:"messageId"`
+	SessionID  string `json:"sessionId"`
+	SenderID   string `json:"senderId"`
+	ReceiverID string `json:"receiverId,omitempty"`
+	// Participants are the users who find the message, the participants of
+	// its session when it was indexed
+	Participants []string   `json:"participants"`
+	Type         string     `json:"type"`
+	Text         string     `json:"text"`
+	Timestamp    utils.Time `json:"timestamp"`
+	// Deleted removes the message from the index
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Query selects the messages a user may find
+type Query struct {
+	UserID string
+	Text   string
+	// SessionID limits the search to a session when set
+	SessionID string
+	Limit     int
+}
+
+// Hit is a message matching a query
+type Hit struct {
+	Document
+	Score float64 `json:"score"`
+	// Highlights are the fragments of the text matching the query, the
+	// terms wrapped in <em>
+	Highlights []string `json:"highlights,omitempty"`
+}
+
+// Engine stores and searches documents
+type Engine interface {
+	// EnsureIndex creates the index with its mapping unless it exists
+	EnsureIndex(ctx context.Context) error
+	Bulk(ctx context.Context, docs []Document) error
+	Search(ctx context.Context, query Query) ([]Hit, error)
+}
+
+// Indexer hands documents to the engine in batches in the background.
+// Batches the engine fails to take, or that arrive while its breaker is
+// open, are queued for a retry.
+type Indexer struct {
+	engine    Engine
+	breaker   *breaker.Breaker
+	retries   *retry.Queue
+	queue     chan Document
+	batchSize int
+	interval  time.Duration
+	timeout   time.Duration
+	// ensured is set once the index exists
+	ensured atomic.Bool
+}
+
+// NewIndexer creates an Indexer for the engine selected by the
+// configuration, or returns nil when search is disabled
+func NewIndexer(cfg config.SearchConfig, b *breaker.Breaker, retries *retry.Queue) (*Indexer, error) {
+	var engine Engine
+	switch cfg.Provider {
+	case "", "none":
+		return nil, nil
+	case "elasticsearch", "opensearch":
+		engine = NewElasticEngine(cfg)
+	default:
+		return nil, fmt.Errorf("unknown search provider %q", cfg.Provider)
+	}
+
+	i := &Indexer{
+		engine:    engine,
+		breaker:   b,
+		retries:   retries,
+		queue:     make(chan Document, cfg.BufferSize),
+		batchSize: max(cfg.BatchSize, 1),
+		interval:  cfg.FlushInterval,
+		timeout:   cfg.Timeout,
+	}
+	retries.Handle(indexJob, func(_ string, body []byte) error {
+		var docs []Document
+		if err := json.Unmarshal(body, &docs); err != nil {
+			log.Printf("Error decoding queued search batch, dropped: %v\n", err)
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+		defer cancel()
+		return i.bulk(ctx, docs)
+	})
+
+	go i.run()
+	return i, nil
+}
+
+// Index queues documents for indexing. Documents that find the buffer full
+// are dropped.
+func (i *Indexer) Index(docs ...Document) {
+	if i == nil {
+		return
+	}
+
+	for _, doc := range docs {
+		select {
+		case i.queue <- doc:
+		default:
+			log.Printf("Error indexing message %s: buffer is full, dropped\n", doc.MessageID)
+		}
+	}
+}
+
+// Search returns the messages matching a query, best matches first
+func (i *Indexer) Search(ctx context.Context, query Query) ([]Hit, error) {
+	var hits []Hit
+	err := i.breaker.Do(func() error {
+		var err error
+		hits, err = i.engine.Search(ctx, query)
+		return err
+	})
+	return hits, err
+}
+
+func (i *Indexer) run() {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	batch := make([]Document, 0, i.batchSize)
+	for {
+		select {
+		case doc := <-i.queue:
+			batch = append(batch, doc)
+			if len(batch) < i.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		i.flush(batch)
+		batch = make([]Document, 0, i.batchSize)
+	}
+}
+
+// flush sends a batch to the engine, creating the index first
+func (i *Indexer) flush(batch []Document) {
+	ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+	defer cancel()
+
+	err := i.bulk(ctx, batch)
+	if err == nil {
+		return
+	}
+	log.Printf("Error indexing %d messages, queued for retry: %v\n", len(batch), err)
+	body, err := json.Marshal(batch)
+	if err == nil {
+		err = i.retries.Enqueue(indexJob, "", body)
+	}
+	if err != nil {
+		log.Printf("Error queueing %d messages for indexing: %v\n", len(batch), err)
+	}
+}
+
+func (i *Indexer) bulk(ctx context.Context, docs []Document) error {
+	return i.breaker.Do(func() error {
+		if !i.ensured.Load() {
+			if err := i.engine.EnsureIndex(ctx); err != nil {
+				return err
+			}
+			i.ensured.Store(true)
+		}
+		return i.engine.Bulk(ctx, docs)
+	})
+}
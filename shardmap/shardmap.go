@@ -0,0 +1,152 @@
+// Package shardmap provides a map keyed by string split into independently
+// locked shards, so lookups of unrelated keys don't contend on one lock.
+package shardmap
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// shardCount is the number of shards of a Map, a power of two
+const shardCount = 32
+
+type shard[V any] struct {
+	entries map[string]V
+	mu      sync.RWMutex
+}
+
+// Map is a concurrency-safe map from string keys to values of type V. Its
+// zero value isn't usable, create one with New.
+type Map[V any] struct {
+	seed   maphash.Seed
+	shards [shardCount]shard[V]
+}
+
+// New creates an empty Map
+func New[V any]() *Map[V] {
+	m := &Map[V]{seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]V)
+	}
+	return m
+}
+
+func (m *Map[V]) shard(key string) *shard[V] {
+	return &m.shards[maphash.String(m.seed, key)&(shardCount-1)]
+}
+
+// Load returns the value stored for a key and whether there is one
+func (m *Map[V]) Load(key string) (V, bool) {
+	s := m.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.entries[key]
+	return value, ok
+}
+
+// Store sets the value of a key
+func (m *Map[V]) Store(key string, value V) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = value
+}
+
+// Swap stores the value of a key and returns the previous value, if there
+// was one
+func (m *Map[V]) Swap(key string, value V) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.entries[key]
+	s.entries[key] = value
+	return previous, ok
+}
+
+// LoadOrStore returns the value stored for a key if there is one, otherwise
+// it stores the given value. It reports whether the value was loaded.
+func (m *Map[V]) LoadOrStore(key string, value V) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.entries[key]; ok {
+		return existing, true
+	}
+	s.entries[key] = value
+	return value, false
+}
+
+// Delete removes a key
+func (m *Map[V]) Delete(key string) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete removes a key and returns its value, if there was one
+func (m *Map[V]) LoadAndDelete(key string) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.entries[key]
+	delete(s.entries, key)
+	return value, ok
+}
+
+// CompareAndDelete removes a key if its value is the given one, as decided
+// by equal, and reports whether it was removed
+func (m *Map[V]) CompareAndDelete(key string, equal func(V) bool) bool {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.entries[key]
+	if !ok || !equal(value) {
+		return false
+	}
+	delete(s.entries, key)
+	return true
+}
+
+// Len returns the number of keys. Keys added or removed meanwhile may or
+// may not be counted.
+func (m *Map[V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Range calls fn for every key and value until it returns false. Each shard
+// is read locked while its entries are visited, so fn must not modify the
+// map.
+func (m *Map[V]) Range(fn func(key string, value V) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for key, value := range s.entries {
+			if !fn(key, value) {
+				s.mu.RUnlock()
+				return
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// Values returns the values of every key
+func (m *Map[V]) Values() []V {
+	values := make([]V, 0, m.Len())
+	m.Range(func(_ string, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
@@ -0,0 +1,76 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"pion-webrtc-microservice/utils"
+)
+
+// Handshake messages exchanged before a peer may signal
+const (
+	AuthMessage   = "auth"
+	AuthOKMessage = "auth-ok"
+)
+
+// authenticate runs the handshake of a new connection and returns the peer
+// ID it may use, and whether the peer proved it. Without an auth secret the
+// requested peer ID is taken unproven. Otherwise the first message must
+// carry a token whose subject becomes the peer ID, and a requested peer ID
+// that differs from it is rejected. Connections authenticated before the
+// upgrade skip the handshake and are only told their peer ID.
+func (s *SignalingServer) authenticate(self *client, requestedPeerID string, authenticated bool) (string, bool, error) {
+	if authenticated {
+		return requestedPeerID, true, s.confirmAuth(self, requestedPeerID)
+	}
+	if s.cfg.AuthSecret == "" {
+		if requestedPeerID == "" {
+			return "", false, errors.New("peerID is required")
+		}
+		return requestedPeerID, false, nil
+	}
+
+	conn := self.conn
+	if err := conn.SetReadDeadline(time.Now().Add(s.cfg.AuthTimeout)); err != nil {
+		return "", false, err
+	}
+	frameType, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", false, errors.New("no auth message received")
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", false, err
+	}
+
+	message, err := self.codec.decode(frameType, data)
+	if err != nil {
+		return "", false, err
+	}
+	var request struct {
+		Type  string `json:"type"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || request.Type != AuthMessage {
+		return "", false, errors.New("expected an auth message")
+	}
+
+	peerID, err := utils.VerifyToken([]byte(s.cfg.AuthSecret), request.Token, time.Now())
+	if err != nil {
+		return "", false, err
+	}
+	if requestedPeerID != "" && requestedPeerID != peerID {
+		return "", false, errors.New("peerID doesn't match the token")
+	}
+
+	return peerID, true, s.confirmAuth(self, peerID)
+}
+
+// confirmAuth tells a client the peer ID it was authenticated as
+func (s *SignalingServer) confirmAuth(self *client, peerID string) error {
+	data, err := json.Marshal(map[string]string{"type": AuthOKMessage, "peerId": peerID})
+	if err != nil {
+		return err
+	}
+	return self.write(data)
+}
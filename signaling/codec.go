@@ -0,0 +1,277 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols negotiated on /ws. Clients that request none use JSON.
+const (
+	JSONSubprotocol     = "signaling.json"
+	ProtobufSubprotocol = "signaling.protobuf"
+)
+
+// Subprotocols lists the subprotocols the signaling upgrader accepts, in
+// order of preference
+var Subprotocols = []string{ProtobufSubprotocol, JSONSubprotocol}
+
+// codec translates between a peer's wire format and the JSON messages the
+// server routes internally
+type codec interface {
+	// decode converts a received frame to a JSON message
+	decode(frameType int, data []byte) ([]byte, error)
+	// encode converts a JSON message to a frame
+	encode(message []byte) (int, []byte, error)
+}
+
+// codecFor returns the codec of a negotiated subprotocol
+func codecFor(subprotocol string) codec {
+	if subprotocol == ProtobufSubprotocol {
+		return protobufCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) decode(frameType int, data []byte) ([]byte, error) {
+	if frameType != websocket.TextMessage {
+		return nil, errors.New("expected a text frame")
+	}
+	return data, nil
+}
+
+func (jsonCodec) encode(message []byte) (int, []byte, error) {
+	return websocket.TextMessage, message, nil
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) decode(frameType int, data []byte) ([]byte, error) {
+	if frameType != websocket.BinaryMessage {
+		return nil, errors.New("expected a binary frame")
+	}
+
+	var env envelope
+	if err := env.unmarshal(data); err != nil {
+		return nil, err
+	}
+	return env.toJSON()
+}
+
+func (protobufCodec) encode(message []byte) (int, []byte, error) {
+	env, err := envelopeFromJSON(message)
+	if err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, env.marshal(), nil
+}
+
+// iceCandidate mirrors webrtc.ICECandidateInit
+type iceCandidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+// envelope is the Envelope message of signaling.proto
+type envelope struct {
+	Type          string
+	TargetPeerID  string
+	SDP           string
+	Candidate     *iceCandidate
+	Payload       []byte
+	ID            string
+	Ack           bool
+	TargetPeerIDs []string
+	Broadcast     bool
+	Extra         []byte
+}
+
+// envelopeFromJSON splits a JSON message into the typed envelope fields,
+// keeping the remaining fields as a JSON object
+func envelopeFromJSON(message []byte) (*envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, err
+	}
+
+	env := &envelope{}
+	for key, target := range map[string]interface{}{"type": &env.Type, "targetPeerId": &env.TargetPeerID, "sdp": &env.SDP, "id": &env.ID, "ack": &env.Ack, "targetPeerIds": &env.TargetPeerIDs, "broadcast": &env.Broadcast} {
+		if raw, exists := fields[key]; exists && json.Unmarshal(raw, target) == nil {
+			delete(fields, key)
+		}
+	}
+	if raw, exists := fields["candidate"]; exists {
+		var candidate iceCandidate
+		if json.Unmarshal(raw, &candidate) == nil {
+			env.Candidate = &candidate
+			delete(fields, "candidate")
+		}
+	}
+	if raw, exists := fields["payload"]; exists {
+		env.Payload = raw
+		delete(fields, "payload")
+	}
+
+	if len(fields) > 0 {
+		extra, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		env.Extra = extra
+	}
+	return env, nil
+}
+
+// toJSON merges the envelope fields back into a single JSON object
+func (e *envelope) toJSON() ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(e.Extra) > 0 {
+		if err := json.Unmarshal(e.Extra, &fields); err != nil {
+			return nil, fmt.Errorf("invalid extra fields: %w", err)
+		}
+	}
+
+	set := func(key string, value interface{}) error {
+		raw, err := json.Marshal(value)
+		fields[key] = raw
+		return err
+	}
+	if err := set("type", e.Type); err != nil {
+		return nil, err
+	}
+	if e.TargetPeerID != "" {
+		if err := set("targetPeerId", e.TargetPeerID); err != nil {
+			return nil, err
+		}
+	}
+	if e.SDP != "" {
+		if err := set("sdp", e.SDP); err != nil {
+			return nil, err
+		}
+	}
+	if e.Candidate != nil {
+		if err := set("candidate", e.Candidate); err != nil {
+			return nil, err
+		}
+	}
+	if e.ID != "" {
+		if err := set("id", e.ID); err != nil {
+			return nil, err
+		}
+	}
+	if e.Ack {
+		if err := set("ack", true); err != nil {
+			return nil, err
+		}
+	}
+	if len(e.TargetPeerIDs) > 0 {
+		if err := set("targetPeerIds", e.TargetPeerIDs); err != nil {
+			return nil, err
+		}
+	}
+	if e.Broadcast {
+		if err := set("broadcast", true); err != nil {
+			return nil, err
+		}
+	}
+	if len(e.Payload) > 0 {
+		if !json.Valid(e.Payload) {
+			return nil, errors.New("payload is not valid JSON")
+		}
+		fields["payload"] = e.Payload
+	}
+
+	return json.Marshal(fields)
+}
+
+func (e *envelope) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, e.Type)
+	b = appendString(b, 2, e.TargetPeerID)
+	b = appendString(b, 3, e.SDP)
+	if e.Candidate != nil {
+		b = appendBytes(b, 4, e.Candidate.marshal())
+	}
+	b = appendBytes(b, 5, e.Payload)
+	b = appendString(b, 6, e.ID)
+	if e.Ack {
+		b = appendVarintField(b, 7, 1)
+	}
+	for _, target := range e.TargetPeerIDs {
+		b = appendString(b, 8, target)
+	}
+	if e.Broadcast {
+		b = appendVarintField(b, 9, 1)
+	}
+	b = appendBytes(b, 15, e.Extra)
+	return b
+}
+
+func (e *envelope) unmarshal(data []byte) error {
+	return consumeFields(data, func(field int, value []byte, number uint64) error {
+		switch field {
+		case 1:
+			e.Type = string(value)
+		case 2:
+			e.TargetPeerID = string(value)
+		case 3:
+			e.SDP = string(value)
+		case 4:
+			e.Candidate = &iceCandidate{}
+			return e.Candidate.unmarshal(value)
+		case 5:
+			e.Payload = value
+		case 6:
+			e.ID = string(value)
+		case 7:
+			e.Ack = number != 0
+		case 8:
+			e.TargetPeerIDs = append(e.TargetPeerIDs, string(value))
+		case 9:
+			e.Broadcast = number != 0
+		case 15:
+			e.Extra = value
+		}
+		return nil
+	})
+}
+
+func (c *iceCandidate) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, c.Candidate)
+	if c.SDPMid != nil {
+		b = appendString(b, 2, *c.SDPMid)
+	}
+	if c.SDPMLineIndex != nil {
+		b = appendVarintField(b, 3, uint64(*c.SDPMLineIndex))
+	}
+	if c.UsernameFragment != nil {
+		b = appendString(b, 4, *c.UsernameFragment)
+	}
+	return b
+}
+
+func (c *iceCandidate) unmarshal(data []byte) error {
+	return consumeFields(data, func(field int, value []byte, number uint64) error {
+		switch field {
+		case 1:
+			c.Candidate = string(value)
+		case 2:
+			mid := string(value)
+			c.SDPMid = &mid
+		case 3:
+			index := uint16(number)
+			c.SDPMLineIndex = &index
+		case 4:
+			ufrag := string(value)
+			c.UsernameFragment = &ufrag
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package signaling
+
+import (
+	"log"
+	"time"
+)
+
+// Negotiation messages the server inspects to resolve offer collisions
+const (
+	OfferMessage                = "offer"
+	AnswerMessage               = "answer"
+	RollbackMessage             = "rollback"
+	NegotiationCollisionMessage = "negotiation-collision"
+)
+
+// offerTimeout is how long an unanswered offer counts as in flight
+const offerTimeout = 10 * time.Second
+
+// negotiationPair identifies the offers sent from one peer to another
+type negotiationPair struct {
+	from, to string
+}
+
+// Polite reports whether a peer is the polite side of its negotiation with
+// another peer. The peer with the lower ID yields when offers collide.
+func Polite(peerID, otherID string) bool {
+	return peerID < otherID
+}
+
+// mediateNegotiation tracks the offers in flight between two peers and
+// resolves glare: when both sent an offer, the polite peer's offer is
+// dropped and it's told to roll back and accept the other one. It reports
+// whether the message should still be delivered.
+func (s *SignalingServer) mediateNegotiation(peerID, targetPeerID, messageType string) bool {
+	now := time.Now()
+
+	switch messageType {
+	case AnswerMessage:
+		s.mutex.Lock()
+		delete(s.offers, negotiationPair{from: targetPeerID, to: peerID})
+		s.mutex.Unlock()
+		return true
+	case RollbackMessage:
+		s.mutex.Lock()
+		delete(s.offers, negotiationPair{from: peerID, to: targetPeerID})
+		s.mutex.Unlock()
+		return true
+	case OfferMessage:
+	default:
+		// Other messages are relayed without taking the lock
+		return true
+	}
+
+	s.mutex.Lock()
+
+	incoming := negotiationPair{from: targetPeerID, to: peerID}
+	sentAt, pending := s.offers[incoming]
+	if !pending || now.Sub(sentAt) > offerTimeout {
+		s.offers[negotiationPair{from: peerID, to: targetPeerID}] = now
+		s.mutex.Unlock()
+		return true
+	}
+
+	// Both peers sent an offer, the impolite peer's offer wins
+	loser, winner := targetPeerID, peerID
+	if Polite(peerID, targetPeerID) {
+		loser, winner = peerID, targetPeerID
+	} else {
+		delete(s.offers, incoming)
+		s.offers[negotiationPair{from: peerID, to: targetPeerID}] = now
+	}
+	s.mutex.Unlock()
+
+	err := s.Send(loser, map[string]interface{}{
+		"type":         NegotiationCollisionMessage,
+		"targetPeerId": winner,
+		"action":       RollbackMessage,
+	})
+	if err != nil {
+		log.Printf("Error resolving offer collision for %s: %v\n", loser, err)
+	}
+
+	return loser != peerID
+}
+
+// forgetNegotiations drops the offers in flight from or to a peer
+func (s *SignalingServer) forgetNegotiations(peerID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for pair := range s.offers {
+		if pair.from == peerID || pair.to == peerID {
+			delete(s.offers, pair)
+		}
+	}
+}
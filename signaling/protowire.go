@@ -0,0 +1,108 @@
+package signaling
+
+import "errors"
+
+// Minimal protocol buffers wire format support for the signaling envelope,
+// see https://protobuf.dev/programming-guides/encoding/
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return appendVarint(appendTag(b, field, wireVarint), v)
+}
+
+// appendBytes writes a length-delimited field, omitting empty values as
+// proto3 does
+func appendBytes(b []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendString(b []byte, field int, value string) []byte {
+	return appendBytes(b, field, []byte(value))
+}
+
+func consumeVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+// consumeFields calls visit for each field of a message with the value of
+// length-delimited fields or the number of varint fields. Fixed width fields
+// are skipped.
+func consumeFields(b []byte, visit func(field int, value []byte, number uint64) error) error {
+	for len(b) > 0 {
+		tag, n, err := consumeVarint(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		switch wireType {
+		case wireVarint:
+			number, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if err := visit(field, nil, number); err != nil {
+				return err
+			}
+		case wireBytes:
+			length, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if uint64(len(b)) < length {
+				return errTruncated
+			}
+			if err := visit(field, b[:length], 0); err != nil {
+				return err
+			}
+			b = b[length:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+		default:
+			return errors.New("unsupported protobuf wire type")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package signaling
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Room membership messages, handled by the server
+const (
+	JoinRoomMessage   = "join-room"
+	LeaveRoomMessage  = "leave-room"
+	RoomJoinedMessage = "room-joined"
+)
+
+// RoomAuthorizer reports whether a peer may join a room
+type RoomAuthorizer func(peerID, roomID string) bool
+
+// handleJoinRoom moves a peer into the room named in the message. A peer is
+// in at most one room, joining another leaves the previous one.
+func (s *SignalingServer) handleJoinRoom(peerID string, message []byte) {
+	var request struct {
+		RoomID string `json:"roomId"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || request.RoomID == "" {
+		s.SendError(peerID, "roomId is required")
+		return
+	}
+	if s.AuthorizeRoom != nil && !s.AuthorizeRoom(peerID, request.RoomID) {
+		s.SendError(peerID, "not allowed to join room")
+		return
+	}
+
+	s.leaveRoom(peerID)
+
+	s.mutex.Lock()
+	members, exists := s.rooms[request.RoomID]
+	if !exists {
+		members = make(map[string]bool)
+		s.rooms[request.RoomID] = members
+	}
+	peers := make([]string, 0, len(members))
+	polite := make(map[string]bool, len(members))
+	for member := range members {
+		peers = append(peers, member)
+		polite[member] = Polite(peerID, member)
+	}
+	members[peerID] = true
+	s.peerRooms[peerID] = request.RoomID
+	replay := s.recentHistory(request.RoomID, time.Now())
+	s.mutex.Unlock()
+
+	err := s.Send(peerID, map[string]interface{}{
+		"type":        RoomJoinedMessage,
+		"roomId":      request.RoomID,
+		"peers":       peers,
+		"polite":      polite,
+		"replayCount": len(replay),
+	})
+	if err != nil {
+		log.Printf("Error confirming room join to %s: %v\n", peerID, err)
+		return
+	}
+
+	// Replay the room's recent broadcasts so the peer catches up on its state
+	for _, message := range replay {
+		if err := s.write(peerID, message); err != nil {
+			log.Printf("Error replaying room history to %s: %v\n", peerID, err)
+			return
+		}
+	}
+}
+
+// historyMessage is a broadcast kept for peers that join the room later
+type historyMessage struct {
+	sentAt  time.Time
+	message []byte
+}
+
+// recordHistory keeps a broadcast of a room, dropping the oldest once the
+// configured depth is reached. The server lock must be held.
+func (s *SignalingServer) recordHistory(roomID string, message []byte, now time.Time) {
+	if s.cfg.HistoryDepth <= 0 {
+		return
+	}
+
+	history := append(s.history[roomID], historyMessage{sentAt: now, message: message})
+	if len(history) > s.cfg.HistoryDepth {
+		history = history[len(history)-s.cfg.HistoryDepth:]
+	}
+	s.history[roomID] = history
+}
+
+// recentHistory returns the broadcasts of a room that haven't expired,
+// oldest first. The server lock must be held.
+func (s *SignalingServer) recentHistory(roomID string, now time.Time) [][]byte {
+	var recent [][]byte
+	for _, entry := range s.history[roomID] {
+		if s.cfg.HistoryTTL > 0 && now.Sub(entry.sentAt) > s.cfg.HistoryTTL {
+			continue
+		}
+		recent = append(recent, entry.message)
+	}
+	return recent
+}
+
+// leaveRoom removes a peer from its room, dropping the room once empty
+func (s *SignalingServer) leaveRoom(peerID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roomID, joined := s.peerRooms[peerID]
+	if !joined {
+		return
+	}
+	delete(s.peerRooms, peerID)
+	delete(s.rooms[roomID], peerID)
+	if len(s.rooms[roomID]) == 0 {
+		delete(s.rooms, roomID)
+		delete(s.history, roomID)
+	}
+}
+
+// SendError tells a peer why its message was rejected
+func (s *SignalingServer) SendError(peerID, message string) {
+	err := s.Send(peerID, map[string]string{
+		"type":    ErrorMessage,
+		"message": message,
+	})
+	if err != nil {
+		log.Printf("Error sending error to %s: %v\n", peerID, err)
+	}
+}
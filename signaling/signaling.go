@@ -2,68 +2,387 @@ package signaling
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"time"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/events"
+	"pion-webrtc-microservice/shardmap"
 
 	"github.com/gorilla/websocket"
 )
 
+// KeyExchangeMessage is the type of end-to-end encryption key distribution
+// messages. Their payload is relayed byte for byte and never inspected or logged.
+const KeyExchangeMessage = "e2ee-key"
+
+// Knock-to-join messages, handled by the server instead of being relayed
+const (
+	KnockMessage         = "knock"
+	KnockResponseMessage = "knock-response"
+	KnockResultMessage   = "knock-result"
+)
+
+// Call invitation messages: ring is sent to the invitee, ring-result tells
+// the caller how it was answered or that it rang out
+const (
+	RingMessage       = "ring"
+	RingResultMessage = "ring-result"
+)
+
+// RejoinMessage asks a peer to rejoin a call it is reconnecting to, such as
+// one restored after a restart, with a new peer connection
+const RejoinMessage = "rejoin"
+
+// RenegotiateMessage carries an offer of the server to a call participant
+// whose subscriptions changed, answered with POST /call/answer
+const RenegotiateMessage = "renegotiate"
+
+// LayoutMessage switches the layout of a call. Hosts and co-hosts send it to
+// the server, which relays the change to every participant of the call.
+const LayoutMessage = "layout"
+
+// ErrorMessage is sent to a peer whose message the server couldn't handle
+const ErrorMessage = "error"
+
+// Delivery reports sent back to the sender of a relayed message
+const (
+	AckMessage            = "ack"
+	DeliveryFailedMessage = "delivery-failed"
+)
+
+// signalEnvelope holds the routing fields shared by all signaling messages
+type signalEnvelope struct {
+	Type         string `json:"type"`
+	TargetPeerID string `json:"targetPeerId"`
+	// TargetPeerIDs addresses the message to several peers at once
+	TargetPeerIDs []string `json:"targetPeerIds"`
+	// Broadcast addresses the message to every other peer of the sender's room
+	Broadcast bool `json:"broadcast"`
+	// ID identifies the message in delivery reports
+	ID string `json:"id"`
+	// Ack asks the server to retry delivery and acknowledge it
+	Ack bool `json:"ack"`
+}
+
+// deliveryReport tells a sender whether its message reached the target
+type deliveryReport struct {
+	Type         string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	TargetPeerID string `json:"targetPeerId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// HandlerFunc handles a signaling message addressed to the server rather
+// than relayed to another peer
+type HandlerFunc func(peerID string, message []byte)
+
+// client is a connected peer. Writes are serialized because a WebSocket
+// connection or WebTransport stream supports a single concurrent writer.
+type client struct {
+	conn  transport
+	codec codec
+	mu    sync.Mutex
+}
+
+// write sends a JSON message in the wire format of the peer
+func (c *client) write(message []byte) error {
+	frameType, data, err := c.codec.encode(message)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(frameType, data)
+}
+
 type SignalingServer struct {
-	clients map[string]*websocket.Conn
-	mutex   sync.Mutex
+	clients   *shardmap.Map[*client]
+	handlers  map[string]HandlerFunc
+	rooms     map[string]map[string]bool // room ID -> member peer IDs
+	peerRooms map[string]string          // peer ID -> room ID
+	history   map[string][]historyMessage
+	offers    map[negotiationPair]time.Time // offers awaiting an answer
+	cfg       config.SignalingConfig
+	// AuthorizeRoom decides whether a peer may join a room, nil lets any
+	// peer join any room
+	AuthorizeRoom RoomAuthorizer
+	// Events publishes peer connection events, nil disables them
+	Events *events.Bus
+	// OnConnect is called once a peer is authenticated, before its messages
+	// are read
+	OnConnect func(peerID string)
+	// mutex guards the handlers, rooms, history and offers, the clients are
+	// sharded
+	mutex sync.RWMutex
+}
+
+// ConnectionCount returns the number of connected peers
+func (s *SignalingServer) ConnectionCount() int {
+	return s.clients.Len()
 }
 
-func NewSignalingServer() *SignalingServer {
-	return &SignalingServer{clients: make(map[string]*websocket.Conn)}
+func NewSignalingServer(cfg config.SignalingConfig) *SignalingServer {
+	s := &SignalingServer{
+		clients:   shardmap.New[*client](),
+		handlers:  make(map[string]HandlerFunc),
+		rooms:     make(map[string]map[string]bool),
+		peerRooms: make(map[string]string),
+		history:   make(map[string][]historyMessage),
+		offers:    make(map[negotiationPair]time.Time),
+		cfg:       cfg,
+	}
+	s.handlers[JoinRoomMessage] = s.handleJoinRoom
+	s.handlers[LeaveRoomMessage] = func(peerID string, _ []byte) {
+		s.leaveRoom(peerID)
+	}
+	return s
 }
 
-func (s *SignalingServer) HandleWebSocket(conn *websocket.Conn, peerID string) {
+// Handle registers a handler for a message type. Messages of that type are
+// passed to the handler instead of being relayed.
+func (s *SignalingServer) Handle(messageType string, handler HandlerFunc) {
 	s.mutex.Lock()
-	s.clients[peerID] = conn
-	s.mutex.Unlock()
+	defer s.mutex.Unlock()
+	s.handlers[messageType] = handler
+}
+
+// Send writes a message to a connected peer
+func (s *SignalingServer) Send(peerID string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.write(peerID, data)
+}
+
+// HandleWebSocket serves a peer until its connection closes. The wire format
+// follows the subprotocol negotiated on the connection, and the peer ID is
+// established by the auth handshake unless the connection was authenticated
+// before the upgrade.
+func (s *SignalingServer) HandleWebSocket(conn *websocket.Conn, requestedPeerID string, authenticated bool) {
+	s.serve(webSocketTransport{conn}, codecFor(conn.Subprotocol()), requestedPeerID, authenticated)
+}
+
+// serve runs the handshake of a peer and relays its messages until its
+// transport closes
+func (s *SignalingServer) serve(conn transport, codec codec, requestedPeerID string, authenticated bool) {
+	defer conn.Close()
+	self := &client{conn: conn, codec: codec}
+
+	peerID, verified, err := s.authenticate(self, requestedPeerID, authenticated)
+	if err != nil {
+		log.Println("Error authenticating peer:", err)
+		conn.reject(err.Error())
+		return
+	}
+
+	// A peer that proved its ID and connects again replaces its previous
+	// connection, which is closed rather than left to linger. An unproven
+	// peer ID can't take over a connected one.
+	var previous *client
+	replaced := false
+	if verified {
+		previous, replaced = s.clients.Swap(peerID, self)
+	} else if _, taken := s.clients.LoadOrStore(peerID, self); taken {
+		log.Printf("Rejecting unauthenticated peer %s, the ID is already connected\n", peerID)
+		conn.reject("peer ID is already connected")
+		return
+	}
+	if replaced {
+		previous.mu.Lock()
+		previous.conn.reject("replaced by a new connection")
+		previous.mu.Unlock()
+		previous.conn.Close()
+	}
+	s.Events.Publish(events.PeerConnected, peerID, map[string]interface{}{"peerId": peerID})
+	if s.OnConnect != nil {
+		s.OnConnect(peerID)
+	}
 
 	defer func() {
-		s.mutex.Lock()
-		delete(s.clients, peerID)
-		s.mutex.Unlock()
-		conn.Close()
+		current := s.clients.CompareAndDelete(peerID, func(c *client) bool { return c == self })
+		if current {
+			s.leaveRoom(peerID)
+			s.forgetNegotiations(peerID)
+			s.Events.Publish(events.PeerDisconnected, peerID, map[string]interface{}{"peerId": peerID})
+		}
 	}()
 
 	for {
-		_, message, err := conn.ReadMessage()
+		frameType, data, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("Error reading message:", err)
 			break
 		}
 
+		message, err := self.codec.decode(frameType, data)
+		if err != nil {
+			log.Println("Error decoding message:", err)
+			continue
+		}
+
+		var envelope signalEnvelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			log.Println("Error decoding message:", err)
+			continue
+		}
+
+		if envelope.Type == KeyExchangeMessage {
+			s.relayOpaque(peerID, envelope, message)
+			continue
+		}
+
+		s.mutex.RLock()
+		handler, handled := s.handlers[envelope.Type]
+		s.mutex.RUnlock()
+		if handled {
+			handler(peerID, message)
+			continue
+		}
+
 		var msg map[string]interface{}
 		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Panicln("Error decoding message:", err)
+			log.Println("Error decoding message:", err)
 			continue
 		}
 
-		s.handleSignalMessage(peerID, msg)
+		s.handleSignalMessage(peerID, envelope, msg)
 	}
 }
 
-func (s *SignalingServer) handleSignalMessage(peerID string, msg map[string]interface{}) {
-	targetPeerId, ok := msg["targetPeerId"].(string)
-	if !ok {
-		log.Println("targetPeerId is not a string")
+// handleSignalMessage relays a message to its target peer, to each peer of
+// targetPeerIds, or to the sender's room when broadcast is set
+func (s *SignalingServer) handleSignalMessage(peerID string, envelope signalEnvelope, msg map[string]interface{}) {
+	targets, err := s.targetsOf(peerID, envelope)
+	if err != nil {
+		log.Printf("Error routing message from %s: %v\n", peerID, err)
+		s.report(peerID, deliveryReport{Type: DeliveryFailedMessage, ID: envelope.ID, Error: err.Error()})
 		return
 	}
 
-	s.mutex.Lock()
-	targetConn, exists := s.clients[targetPeerId]
-	s.mutex.Unlock()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error encoding message:", err)
+		return
+	}
+
+	// Broadcasts carry room state that late joiners get replayed
+	if envelope.Broadcast {
+		s.mutex.Lock()
+		if roomID, joined := s.peerRooms[peerID]; joined {
+			s.recordHistory(roomID, data, time.Now())
+		}
+		s.mutex.Unlock()
+	}
+
+	for _, target := range targets {
+		if !s.mediateNegotiation(peerID, target, envelope.Type) {
+			continue
+		}
+		s.deliver(peerID, envelope.ID, target, envelope.Ack, data)
+	}
+}
+
+// relayOpaque forwards a message to its targets without decoding its
+// payload. JSON peers get the message byte for byte, the payload of binary
+// peers is carried as opaque bytes.
+func (s *SignalingServer) relayOpaque(peerID string, envelope signalEnvelope, message []byte) {
+	targets, err := s.targetsOf(peerID, envelope)
+	if err != nil {
+		s.report(peerID, deliveryReport{Type: DeliveryFailedMessage, ID: envelope.ID, Error: err.Error()})
+		return
+	}
+
+	for _, target := range targets {
+		s.deliver(peerID, envelope.ID, target, envelope.Ack, message)
+	}
+}
+
+// targetsOf resolves the peers a message is addressed to
+func (s *SignalingServer) targetsOf(peerID string, envelope signalEnvelope) ([]string, error) {
+	if envelope.Broadcast {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+
+		roomID, joined := s.peerRooms[peerID]
+		if !joined {
+			return nil, errors.New("broadcast requires joining a room")
+		}
+		targets := make([]string, 0, len(s.rooms[roomID]))
+		for member := range s.rooms[roomID] {
+			if member != peerID {
+				targets = append(targets, member)
+			}
+		}
+		return targets, nil
+	}
+
+	if len(envelope.TargetPeerIDs) > 0 {
+		return envelope.TargetPeerIDs, nil
+	}
+	if envelope.TargetPeerID != "" {
+		return []string{envelope.TargetPeerID}, nil
+	}
+	return nil, errors.New("targetPeerId is required")
+}
+
+// write sends a JSON message to a connected peer
+func (s *SignalingServer) write(peerID string, message []byte) error {
+	target, exists := s.clients.Load(peerID)
 
 	if !exists {
-		log.Printf("No client found for peerId: %s\n", targetPeerId)
+		return fmt.Errorf("peer %s is not connected", peerID)
+	}
+	return target.write(message)
+}
+
+// deliver sends a message to its target and reports a failure back to the
+// sender. When the sender asked for an acknowledgement, delivery is retried
+// before giving up and a successful delivery is acknowledged.
+func (s *SignalingServer) deliver(senderID, messageID, targetPeerID string, ack bool, message []byte) {
+	err := s.write(targetPeerID, message)
+	if err != nil && ack && s.cfg.DeliveryRetries > 0 {
+		// Retried in the background, so the sender's other messages aren't
+		// held up meanwhile
+		go s.retry(senderID, messageID, targetPeerID, message)
 		return
 	}
+	s.delivered(senderID, messageID, targetPeerID, ack, err)
+}
+
+// retry writes a message that didn't reach its target again, every retry
+// interval until it's written or the retries are used up
+func (s *SignalingServer) retry(senderID, messageID, targetPeerID string, message []byte) {
+	var err error
+	for attempt := 0; attempt < s.cfg.DeliveryRetries; attempt++ {
+		time.Sleep(s.cfg.RetryInterval)
+		if err = s.write(targetPeerID, message); err == nil {
+			break
+		}
+	}
+	s.delivered(senderID, messageID, targetPeerID, true, err)
+}
 
-	if err := targetConn.WriteJSON(msg); err != nil {
-		log.Printf("Error writing to client for peerId: %s, error: %v\n", targetPeerId, err)
+// delivered reports the outcome of a delivery to the sender
+func (s *SignalingServer) delivered(senderID, messageID, targetPeerID string, ack bool, err error) {
+	if err != nil {
+		log.Printf("Error writing to client for peerId: %s, error: %v\n", targetPeerID, err)
+		s.report(senderID, deliveryReport{Type: DeliveryFailedMessage, ID: messageID, TargetPeerID: targetPeerID, Error: err.Error()})
 		return
 	}
+	if ack {
+		s.report(senderID, deliveryReport{Type: AckMessage, ID: messageID, TargetPeerID: targetPeerID})
+	}
+}
+
+// report tells a sender the outcome of a message it sent
+func (s *SignalingServer) report(senderID string, report deliveryReport) {
+	if err := s.Send(senderID, report); err != nil {
+		log.Printf("Error reporting delivery to %s: %v\n", senderID, err)
+	}
 }
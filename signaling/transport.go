@@ -0,0 +1,28 @@
+package signaling
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// transport carries the frames of a connected peer, over a WebSocket or a
+// WebTransport stream
+type transport interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(frameType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+	// reject tells the peer why its connection is closed
+	reject(reason string)
+}
+
+// webSocketTransport is a peer connected over a WebSocket
+type webSocketTransport struct {
+	*websocket.Conn
+}
+
+func (t webSocketTransport) reject(reason string) {
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	_ = t.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
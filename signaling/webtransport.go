@@ -0,0 +1,94 @@
+package signaling
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go/quicvarint"
+	"github.com/quic-go/webtransport-go"
+)
+
+// Session error codes sent when the server closes a WebTransport session
+const (
+	webTransportClosed   webtransport.SessionErrorCode = 0
+	webTransportRejected webtransport.SessionErrorCode = 1
+)
+
+// webTransportStream is a peer connected over WebTransport. It signals on
+// the first bidirectional stream it opens, where every message is prefixed
+// with its length as a QUIC variable-length integer. The frames follow the
+// subprotocol requested when connecting, JSON unless it asks for protobuf.
+// Empty frames are skipped, a peer with nothing to send yet writes one to
+// open the stream.
+type webTransportStream struct {
+	webtransport.Stream
+	session   *webtransport.Session
+	reader    *bufio.Reader
+	frameType int
+	maxSize   int64
+}
+
+func (t *webTransportStream) ReadMessage() (int, []byte, error) {
+	size, err := quicvarint.Read(t.reader)
+	for err == nil && size == 0 {
+		size, err = quicvarint.Read(t.reader)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if t.maxSize > 0 && size > uint64(t.maxSize) {
+		return 0, nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", size, t.maxSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(t.reader, data); err != nil {
+		return 0, nil, err
+	}
+	return t.frameType, data, nil
+}
+
+func (t *webTransportStream) WriteMessage(_ int, data []byte) error {
+	frame := quicvarint.Append(make([]byte, 0, quicvarint.Len(uint64(len(data)))+len(data)), uint64(len(data)))
+	_, err := t.Write(append(frame, data...))
+	return err
+}
+
+func (t *webTransportStream) Close() error {
+	return t.session.CloseWithError(webTransportClosed, "")
+}
+
+func (t *webTransportStream) reject(reason string) {
+	_ = t.session.CloseWithError(webTransportRejected, reason)
+}
+
+// HandleWebTransport serves a peer connected over WebTransport until its
+// session closes, with the same handshake and messages as HandleWebSocket.
+// Messages larger than maxMessageSize close the session, zero is unlimited.
+func (s *SignalingServer) HandleWebTransport(session *webtransport.Session, subprotocol, requestedPeerID string, authenticated bool, maxMessageSize int64) {
+	ctx, cancel := context.WithTimeout(session.Context(), s.cfg.AuthTimeout)
+	stream, err := session.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		log.Println("Error accepting signaling stream:", err)
+		_ = session.CloseWithError(webTransportRejected, "no signaling stream opened")
+		return
+	}
+
+	codec := codecFor(subprotocol)
+	frameType := websocket.TextMessage
+	if subprotocol == ProtobufSubprotocol {
+		frameType = websocket.BinaryMessage
+	}
+
+	s.serve(&webTransportStream{
+		Stream:    stream,
+		session:   session,
+		reader:    bufio.NewReader(stream),
+		frameType: frameType,
+		maxSize:   maxMessageSize,
+	}, codec, requestedPeerID, authenticated)
+}
@@ -0,0 +1,270 @@
+package statestore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanCount is how many keys a SCAN is asked to visit per call
+const scanCount = "100"
+
+// redisError is an error reply of the server. The connection stays usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisStore keeps values in Redis under a key prefix, so every node of a
+// cluster reaches the same state. It holds a single connection, opened on
+// the first command and again after it failed.
+type RedisStore struct {
+	addr    string
+	user    string
+	pass    string
+	db      int
+	prefix  string
+	timeout time.Duration
+
+	conn   net.Conn
+	reader *bufio.Reader
+	// mu serializes commands on the connection
+	mu sync.Mutex
+}
+
+// NewRedisStore creates a store for the server at rawURL, e.g.
+// "redis://:secret@localhost:6379/0", whose keys start with prefix
+func NewRedisStore(rawURL, prefix string, timeout time.Duration) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid Redis URL %q, expected redis://host:port/db", rawURL)
+	}
+
+	s := &RedisStore{addr: u.Host, prefix: prefix, timeout: timeout}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.pass, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Put(key string, data []byte) error {
+	_, err := s.do("SET", s.prefix+key, string(data))
+	return err
+}
+
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	reply, err := s.do("GET", s.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+func (s *RedisStore) Delete(key string) error {
+	_, err := s.do("DEL", s.prefix+key)
+	return err
+}
+
+// Keys scans the keys of the prefix. Keys added or removed meanwhile may or
+// may not be returned.
+func (s *RedisStore) Keys() ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", escapePattern(s.prefix)+"*", "COUNT", scanCount)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, errors.New("redis: unexpected SCAN reply")
+		}
+		cursor, _ = page[0].(string)
+		found, _ := page[1].([]interface{})
+		for _, key := range found {
+			if key, ok := key.(string); ok {
+				keys = append(keys, strings.TrimPrefix(key, s.prefix))
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+func (s *RedisStore) Check(context.Context) error {
+	_, err := s.do("PING")
+	return err
+}
+
+// do sends a command and returns its reply: a string, an int64, a slice of
+// replies or nil
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return s.command(args...)
+}
+
+// connect opens a connection, authenticates and selects the database. The
+// lock must be held.
+func (s *RedisStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	if s.pass != "" {
+		auth := []string{"AUTH", s.pass}
+		if s.user != "" {
+			auth = []string{"AUTH", s.user, s.pass}
+		}
+		if _, err := s.command(auth...); err != nil {
+			s.drop()
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.command("SELECT", strconv.Itoa(s.db)); err != nil {
+			s.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+// command writes a command on the open connection and reads its reply. A
+// failed connection is dropped. The lock must be held.
+func (s *RedisStore) command(args ...string) (interface{}, error) {
+	var request []byte
+	request = fmt.Appendf(request, "*%d\r\n", len(args))
+	for _, arg := range args {
+		request = fmt.Appendf(request, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(request); err != nil {
+		s.drop()
+		return nil, err
+	}
+	reply, err := readReply(s.reader)
+	if err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			s.drop()
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// drop closes the connection. The lock must be held.
+func (s *RedisStore) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.reader = nil
+	}
+}
+
+// readReply reads a RESP2 reply
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line[1:])
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			item, err := readReply(reader)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				// The rest of the array still has to be read
+				item, err = replyErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// escapePattern escapes the glob characters of a literal key prefix
+func escapePattern(literal string) string {
+	var b strings.Builder
+	for _, r := range literal {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
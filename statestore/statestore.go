@@ -0,0 +1,113 @@
+// Package statestore keeps state that has to outlive the process, such as
+// the state of active calls, either in a local directory or in Redis shared
+// by the nodes of a cluster.
+package statestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pion-webrtc-microservice/config"
+	"pion-webrtc-microservice/health"
+)
+
+// ErrNotFound is returned when a key isn't in the store
+var ErrNotFound = errors.New("key not found in state store")
+
+// Store keeps values by key
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	// Delete removes a key, removing a missing key isn't an error
+	Delete(key string) error
+	// Keys returns every key in the store
+	Keys() ([]string, error)
+	// Check reports whether the store can be written
+	Check(ctx context.Context) error
+}
+
+// New returns the store the configuration selects for one kind of state:
+// the fs backend keeps it in dir, the redis backend under keys prefixed
+// with namespace. Without a directory the fs backend returns nil, which
+// disables keeping that state.
+func New(cfg config.StateConfig, dir, namespace string) (Store, error) {
+	switch cfg.Backend {
+	case "fs":
+		if dir == "" {
+			return nil, nil
+		}
+		return &DirStore{Dir: dir}, nil
+	case "redis":
+		return NewRedisStore(cfg.RedisURL, namespace, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", cfg.Backend)
+	}
+}
+
+// DirStore keeps every value as a JSON file in a directory
+type DirStore struct {
+	Dir string
+}
+
+func (s *DirStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.Base(key)+".json")
+}
+
+func (s *DirStore) Put(key string, data []byte) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed, so a crash never leaves a truncated value
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *DirStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *DirStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *DirStore) Keys() ([]string, error) {
+	files, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if key, ok := strings.CutSuffix(file.Name(), ".json"); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *DirStore) Check(ctx context.Context) error {
+	return health.WritableDir(s.Dir)(ctx)
+}
@@ -0,0 +1,177 @@
+// Package translate translates chat messages through an external provider.
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/config"
+)
+
+// Translator translates text into a target language, reporting the
+// language it detected the text to be in
+type Translator interface {
+	Translate(ctx context.Context, text, target string) (translated, source string, err error)
+}
+
+// NewTranslator returns the provider selected by the configuration guarded
+// by a circuit breaker, or nil when translation is disabled
+func NewTranslator(cfg config.TranslationConfig, b *breaker.Breaker) Translator {
+	client := &http.Client{Timeout: cfg.Timeout}
+	switch cfg.Provider {
+	case "deepl":
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = "https://api.deepl.com/v2/translate"
+		}
+		return &breakerTranslator{Translator: &DeepLTranslator{URL: endpoint, AuthKey: cfg.APIKey, Client: client}, breaker: b}
+	case "google":
+		endpoint := cfg.URL
+		if endpoint == "" {
+			endpoint = "https://translation.googleapis.com/language/translate/v2"
+		}
+		return &breakerTranslator{Translator: &GoogleTranslator{URL: endpoint, APIKey: cfg.APIKey, Client: client}, breaker: b}
+	default:
+		return nil
+	}
+}
+
+// Normalize lowercases a language tag, e.g. "pt-BR" to "pt-br"
+func Normalize(tag string) string {
+	return strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, "_", "-")))
+}
+
+// SameLanguage reports whether two tags name the same base language, e.g.
+// "en" and "en-gb"
+func SameLanguage(a, b string) bool {
+	base := func(tag string) string {
+		tag, _, _ = strings.Cut(Normalize(tag), "-")
+		return tag
+	}
+	return base(a) == base(b)
+}
+
+// breakerTranslator fails fast with breaker.ErrOpen while the provider
+// keeps failing
+type breakerTranslator struct {
+	Translator
+	breaker *breaker.Breaker
+}
+
+func (t *breakerTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	var translated, source string
+	err := t.breaker.Do(func() error {
+		var err error
+		translated, source, err = t.Translator.Translate(ctx, text, target)
+		return err
+	})
+	return translated, source, err
+}
+
+// DeepLTranslator translates with the DeepL API
+type DeepLTranslator struct {
+	URL     string
+	AuthKey string
+	Client  *http.Client
+}
+
+// deeplTargets maps languages DeepL only accepts with a variant as target
+var deeplTargets = map[string]string{
+	"en": "EN-US",
+	"pt": "PT-PT",
+}
+
+func (t *DeepLTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	targetLang, ok := deeplTargets[Normalize(target)]
+	if !ok {
+		targetLang = strings.ToUpper(Normalize(target))
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": targetLang,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+t.AuthKey)
+
+	var result struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := do(t.Client, req, &result); err != nil {
+		return "", "", err
+	}
+	if len(result.Translations) == 0 {
+		return "", "", fmt.Errorf("no translation returned")
+	}
+	return result.Translations[0].Text, Normalize(result.Translations[0].DetectedSourceLanguage), nil
+}
+
+// GoogleTranslator translates with the Google Cloud Translation API (v2)
+type GoogleTranslator struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (t *GoogleTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"q":      []string{text},
+		"target": Normalize(target),
+		"format": "text",
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL+"?key="+url.QueryEscape(t.APIKey), bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := do(t.Client, req, &result); err != nil {
+		return "", "", err
+	}
+	if len(result.Data.Translations) == 0 {
+		return "", "", fmt.Errorf("no translation returned")
+	}
+	return result.Data.Translations[0].TranslatedText, Normalize(result.Data.Translations[0].DetectedSourceLanguage), nil
+}
+
+// do sends a request and decodes the JSON response into result
+func do(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,35 @@
+package unfurl
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxLinks is the number of links previewed per message
+const MaxLinks = 3
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractLinks returns the distinct http(s) links found in text, up to MaxLinks
+func ExtractLinks(text string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		// Drop punctuation that usually ends the sentence rather than the URL
+		link = strings.TrimRight(link, ".,;:!?)]}")
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == MaxLinks {
+			break
+		}
+	}
+	return links
+}
+
+// CountLinks returns the number of http(s) links in text
+func CountLinks(text string) int {
+	return len(linkPattern.FindAllStringIndex(text, -1))
+}
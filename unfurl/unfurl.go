@@ -0,0 +1,263 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxBodySize    = 512 << 10
+	maxRedirects   = 5
+	maxCacheSize   = 1000
+	failedCacheTTL = time.Minute
+)
+
+var errBlockedAddress = errors.New("address is not allowed")
+
+// Preview is the Open Graph metadata of a linked page
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
+
+type cacheEntry struct {
+	preview *Preview
+	err     error
+	expires time.Time
+}
+
+// Service fetches link previews. Requests are only made to public addresses
+// and results, including failures, are cached.
+type Service struct {
+	client   *http.Client
+	cacheTTL time.Duration
+	cache    map[string]cacheEntry
+	mu       sync.Mutex
+}
+
+// NewService creates a Service with the given request timeout and cache TTL
+func NewService(timeout, cacheTTL time.Duration) *Service {
+	return &Service{
+		client:   NewPublicClient(timeout),
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]cacheEntry),
+	}
+}
+
+// NewPublicClient creates an HTTP client with the given timeout that only
+// connects to public addresses, for requests to URLs supplied by users
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// Checking the resolved address at connect time also covers DNS
+		// rebinding and redirects to internal hosts
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return CheckURL(req.URL)
+		},
+	}
+}
+
+// Fetch returns the preview of a page, served from the cache when possible
+func (s *Service) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[rawURL]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.preview, entry.err
+	}
+
+	preview, err := s.fetch(ctx, rawURL)
+
+	ttl := s.cacheTTL
+	if err != nil {
+		ttl = failedCacheTTL
+	}
+	s.store(rawURL, cacheEntry{preview: preview, err: err, expires: time.Now().Add(ttl)})
+
+	return preview, err
+}
+
+func (s *Service) store(key string, entry cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cache) >= maxCacheSize {
+		now := time.Now()
+		for k, e := range s.cache {
+			if now.After(e.expires) {
+				delete(s.cache, k)
+			}
+		}
+		// Still full, drop an arbitrary entry
+		for k := range s.cache {
+			if len(s.cache) < maxCacheSize {
+				break
+			}
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = entry
+}
+
+func (s *Service) fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckURL(target); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "pion-webrtc-microservice-unfurl/1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	preview := parse(io.LimitReader(resp.Body, maxBodySize), resp.Request.URL)
+	preview.URL = rawURL
+	if preview.Title == "" && preview.Description == "" {
+		return nil, errors.New("page has no preview metadata")
+	}
+	return preview, nil
+}
+
+// parse reads the Open Graph tags of a page, falling back to the title and
+// description meta tags
+func parse(r io.Reader, base *url.URL) *Preview {
+	preview := &Preview{}
+	var title, description string
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finish(preview, title, description)
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				return finish(preview, title, description)
+
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(tokenizer.Token().Data)
+				}
+
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "og:site_name":
+					preview.SiteName = content
+				case "og:image":
+					if image, err := base.Parse(content); err == nil && (image.Scheme == "http" || image.Scheme == "https") {
+						preview.Image = image.String()
+					}
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+}
+
+func finish(preview *Preview, title, description string) *Preview {
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	return preview
+}
+
+// CheckURL reports whether a URL can be requested with a public client: it
+// must be http or https without credentials, and a host given as an IP
+// address must be public
+func CheckURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+	if target.Hostname() == "" || target.User != nil {
+		return errBlockedAddress
+	}
+	if ip := net.ParseIP(target.Hostname()); ip != nil && !isPublic(ip) {
+		return errBlockedAddress
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// net.IP doesn't count as private
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
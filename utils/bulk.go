@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MaxBulkSize is the largest number of entries a bulk request may carry
+const MaxBulkSize = 500
+
+// BulkResult is the outcome of one entry of a bulk request
+type BulkResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkSuccess reports a successful entry
+func BulkSuccess(id string) BulkResult {
+	return BulkResult{ID: id, Success: true}
+}
+
+// BulkFailure reports a failed entry
+func BulkFailure(id, message string) BulkResult {
+	return BulkResult{ID: id, Error: message}
+}
+
+// ValidateBulkSize rejects empty bulk requests and ones above MaxBulkSize
+func ValidateBulkSize(n int) *ErrorResponse {
+	if n == 0 || n > MaxBulkSize {
+		return NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("between 1 and %d participant IDs are required", MaxBulkSize))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a Go duration such as "90m" or "1h30m", or an ISO
+// 8601 duration such as "PT1H30M" or "P1DT12H". ISO days are 24 hours,
+// years and months are rejected as their length varies.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "P") || strings.HasPrefix(s, "-P") {
+		return parseISODuration(s)
+	}
+	return time.ParseDuration(s)
+}
+
+// isoUnits are the length of each ISO 8601 designator before and after the
+// T separating the date from the time
+var isoUnits = [2]map[byte]time.Duration{
+	{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour},
+	{'H': time.Hour, 'M': time.Minute, 'S': time.Second},
+}
+
+func parseISODuration(s string) (time.Duration, error) {
+	invalid := errors.New("invalid ISO 8601 duration " + strconv.Quote(s))
+
+	negative := strings.HasPrefix(s, "-")
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "P")
+	if rest == "" {
+		return 0, invalid
+	}
+
+	var total float64
+	part := 0
+	for rest != "" {
+		if rest[0] == 'T' {
+			if part == 1 || len(rest) == 1 {
+				return 0, invalid
+			}
+			part = 1
+			rest = rest[1:]
+			continue
+		}
+
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != ','
+		})
+		if end <= 0 {
+			return 0, invalid
+		}
+		value, err := strconv.ParseFloat(strings.Replace(rest[:end], ",", ".", 1), 64)
+		if err != nil {
+			return 0, invalid
+		}
+
+		designator := rest[end]
+		unit, ok := isoUnits[part][designator]
+		if !ok {
+			if part == 0 && (designator == 'Y' || designator == 'M') {
+				return 0, errors.New("ISO 8601 years and months aren't supported")
+			}
+			return 0, invalid
+		}
+		total += value * float64(unit)
+		rest = rest[end+1:]
+	}
+
+	if total > float64(1<<63-1) {
+		return 0, errors.New("ISO 8601 duration " + strconv.Quote(s) + " out of range")
+	}
+	if negative {
+		total = -total
+	}
+	return time.Duration(total), nil
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDFormat selects how generated IDs are encoded
+type IDFormat string
+
+const (
+	// IDFormatHex is 32 random hex characters, IDs aren't ordered
+	IDFormatHex IDFormat = "hex"
+	// IDFormatUUIDv7 is a RFC 9562 version 7 UUID, ordered by creation time
+	IDFormatUUIDv7 IDFormat = "uuidv7"
+	// IDFormatULID is a 26 character ULID, ordered by creation time
+	IDFormatULID IDFormat = "ulid"
+)
+
+// Prefixes naming the kind of resource an ID belongs to
+const (
+	PrefixChat       = "chat_"
+	PrefixCall       = "call_"
+	PrefixMessage    = "msg_"
+	PrefixAttachment = "att_"
+	PrefixAudit      = "audit_"
+	PrefixEchoTest   = "echo_"
+	PrefixLoadTest   = "load_"
+	PrefixExport     = "export_"
+	PrefixJob        = "job_"
+	PrefixEvent      = "evt_"
+	PrefixVoicemail  = "vm_"
+	PrefixTransfer   = "xfer_"
+	PrefixFile       = "file_"
+	PrefixCDR        = "cdr_"
+	PrefixBot        = "bot_"
+	PrefixTemplate   = "tpl_"
+)
+
+// idGenerator creates IDs in the configured format. Time ordered IDs
+// created within the same millisecond stay ordered by incrementing the
+// random part of the previous one.
+type idGenerator struct {
+	format   IDFormat
+	prefixed bool
+
+	mu     sync.Mutex
+	lastMs uint64
+	last   [16]byte
+}
+
+var ids = &idGenerator{format: IDFormatHex}
+
+// ConfigureIDs sets the format of the IDs generated from now on and whether
+// they start with the prefix of their resource kind
+func ConfigureIDs(format IDFormat, prefixed bool) error {
+	switch format {
+	case IDFormatHex, IDFormatUUIDv7, IDFormatULID:
+	default:
+		return fmt.Errorf("unknown ID format %q", format)
+	}
+
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.format = format
+	ids.prefixed = prefixed
+	return nil
+}
+
+// NewID generates a unique ID for a resource of the kind named by prefix
+func NewID(prefix string) string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	var id string
+	switch ids.format {
+	case IDFormatUUIDv7:
+		id = ids.uuidv7()
+	case IDFormatULID:
+		id = ids.ulid()
+	default:
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	if ids.prefixed {
+		return prefix + id
+	}
+	return id
+}
+
+// next returns 16 bytes starting with a 48 bit millisecond timestamp
+// followed by random bytes, or by the random bytes of the previous ID plus
+// one when the clock hasn't moved forward. The lock must be held.
+func (g *idGenerator) next() [16]byte {
+	ms := uint64(time.Now().UnixMilli())
+	if ms > g.lastMs {
+		g.lastMs = ms
+		_, _ = rand.Read(g.last[6:])
+	} else {
+		for i := 15; i >= 6; i-- {
+			g.last[i]++
+			if g.last[i] != 0 {
+				break
+			}
+			if i == 6 {
+				// The random part overflowed, borrow the next millisecond
+				g.lastMs++
+			}
+		}
+	}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], g.lastMs)
+	copy(g.last[:6], ts[2:])
+	return g.last
+}
+
+// uuidv7 encodes the next ID as a version 7 UUID. The version and variant
+// bits overwrite 6 of the random bits. The lock must be held.
+func (g *idGenerator) uuidv7() string {
+	b := g.next()
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+// crockford is the Crockford base32 alphabet ULIDs are written in
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid encodes the next ID as a ULID: the 128 bits in 26 base32 digits,
+// padded with two leading zero bits. The lock must be held.
+func (g *idGenerator) ulid() string {
+	b := g.next()
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
@@ -0,0 +1,30 @@
+package utils
+
+// CloneMetadata returns a deep copy of decoded JSON metadata, so nested maps
+// and slices aren't shared with the original
+func CloneMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+	clone := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		clone[key] = CloneJSON(value)
+	}
+	return clone
+}
+
+// CloneJSON returns a deep copy of a value decoded from JSON
+func CloneJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return CloneMetadata(value)
+	case []interface{}:
+		clone := make([]interface{}, len(value))
+		for i, item := range value {
+			clone[i] = CloneJSON(item)
+		}
+		return clone
+	default:
+		return value
+	}
+}
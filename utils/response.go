@@ -2,7 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
-	"fmt"
+	"math"
 	"time"
 )
 
@@ -13,6 +13,10 @@ import (
 type ErrorResponse struct {
 	StatusCode int    `json:"status_code"`
 	Message    string `json:"message"`
+	// RetryAfter is the number of seconds to wait before retrying, if any
+	RetryAfter int `json:"retry_after,omitempty"`
+	// Errors lists the invalid fields of a rejected request
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
 // NewErrorResponse creates a new ErrorResponse
@@ -23,6 +27,15 @@ func NewErrorResponse(statusCode int, message string) *ErrorResponse {
 	}
 }
 
+// NewRetryErrorResponse creates an ErrorResponse telling the client when to retry
+func NewRetryErrorResponse(statusCode int, message string, retryAfter time.Duration) *ErrorResponse {
+	return &ErrorResponse{
+		StatusCode: statusCode,
+		Message:    message,
+		RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+	}
+}
+
 // SuccessResponse represents a structured success response
 type SuccessResponse struct {
 	StatusCode int         `json:"status_code"`
@@ -39,11 +52,22 @@ func NewSuccessResponse(statusCode int, message string, data interface{}) *Succe
 	}
 }
 
-// GenerateSessionID generates a unique session ID.
-func GenerateSessionID() string {
-	b := make([]byte, 16)
+// joinCodeAlphabet omits characters that are easily confused when read aloud or typed
+const joinCodeAlphabet = "abcdefghjkmnpqrstuvwxyz"
+
+// GenerateJoinCode generates a short human friendly code such as "abc-defg-hjk".
+func GenerateJoinCode() string {
+	b := make([]byte, 10)
 	_, _ = rand.Read(b)
-	return fmt.Sprintf("%x", b)
+
+	code := make([]byte, 0, 12)
+	for i, v := range b {
+		if i == 3 || i == 7 {
+			code = append(code, '-')
+		}
+		code = append(code, joinCodeAlphabet[int(v)%len(joinCodeAlphabet)])
+	}
+	return string(code)
 }
 
 // GetTimestamp returns the current timestamp.
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// TimeFormat selects how timestamps and durations are written in API
+// responses and read from requests
+type TimeFormat string
+
+const (
+	// TimeFormatRFC3339 writes timestamps as RFC 3339 strings in UTC and
+	// reads numeric durations as nanoseconds
+	TimeFormatRFC3339 TimeFormat = "rfc3339"
+	// TimeFormatEpochMillis writes timestamps as milliseconds since the Unix
+	// epoch, or null when unset, and reads numeric durations as milliseconds
+	TimeFormatEpochMillis TimeFormat = "epoch_ms"
+)
+
+var timeFormat = TimeFormatRFC3339
+
+// ConfigureTimes sets the format of timestamps and durations in the API
+func ConfigureTimes(format TimeFormat) error {
+	switch format {
+	case TimeFormatRFC3339, TimeFormatEpochMillis:
+	default:
+		return fmt.Errorf("unknown time format %q", format)
+	}
+	timeFormat = format
+	return nil
+}
+
+// Duration is a time.Duration in a request body. Strings are parsed with
+// ParseDuration, numbers are nanoseconds, or milliseconds when timestamps
+// are written as epoch milliseconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := ParseDuration(s)
+		if err != nil {
+			return &json.UnmarshalTypeError{Value: "string " + s, Type: reflect.TypeOf(*d)}
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*d)}
+	}
+	if timeFormat == TimeFormatEpochMillis {
+		n *= int64(time.Millisecond)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if timeFormat == TimeFormatEpochMillis {
+		return json.Marshal(time.Duration(d).Milliseconds())
+	}
+	return json.Marshal(int64(d))
+}
+
+// Time is a timestamp written in the configured TimeFormat. Both formats
+// are read back, so stored timestamps survive a change of the format.
+type Time struct {
+	time.Time
+}
+
+// NewTime wraps a time.Time
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// Now returns the current time as a Time
+func Now() Time {
+	return NewTime(GetTimestamp())
+}
+
+// MarshalJSON implements json.Marshaler
+func (t Time) MarshalJSON() ([]byte, error) {
+	if timeFormat == TimeFormatEpochMillis {
+		// Unset timestamps would otherwise be a large negative number
+		if t.IsZero() {
+			return []byte("null"), nil
+		}
+		return strconv.AppendInt(nil, t.UnixMilli(), 10), nil
+	}
+	return t.UTC().MarshalJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = Time{}
+		return nil
+	}
+	var millis int64
+	if err := json.Unmarshal(data, &millis); err == nil {
+		*t = Time{Time: time.UnixMilli(millis)}
+		return nil
+	}
+	return t.Time.UnmarshalJSON(data)
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// NewValidationErrorResponse creates a 400 ErrorResponse listing the invalid fields
+func NewValidationErrorResponse(errs []FieldError) *ErrorResponse {
+	return &ErrorResponse{
+		StatusCode: http.StatusBadRequest,
+		Message:    "validation failed",
+		Errors:     errs,
+	}
+}
+
+// Validate checks the `validate` tags of a struct and returns an error for
+// every field breaking a rule. Fields are named after their JSON keys and
+// nested structs are checked too. Rules are separated by commas:
+//
+//	required   the field must not be empty or zero
+//	min=N      strings need N characters, slices N items and numbers a value of at least N
+//	max=N      the same as min, as an upper bound
+//	oneof=a b  the field must be one of the space separated values
+//
+// Rules other than required are skipped for empty fields.
+func Validate(v interface{}) []FieldError {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs []FieldError
+	validateStruct(value, "", &errs)
+	return errs
+}
+
+func validateStruct(value reflect.Value, prefix string, errs *[]FieldError) {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		fieldValue := value.Field(i)
+		if rules := field.Tag.Get("validate"); rules != "" {
+			if reason := checkRules(fieldValue, rules); reason != "" {
+				*errs = append(*errs, FieldError{Field: name, Reason: reason})
+				continue
+			}
+		}
+
+		if fieldValue.Kind() == reflect.Pointer && !fieldValue.IsNil() {
+			fieldValue = fieldValue.Elem()
+		}
+		if fieldValue.Kind() == reflect.Struct {
+			validateStruct(fieldValue, name, errs)
+		}
+	}
+}
+
+// jsonName returns the key a field is encoded under
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// checkRules returns the reason the value breaks the first failing rule, or
+// an empty string
+func checkRules(value reflect.Value, rules string) string {
+	empty := isEmpty(value)
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if name == "required" {
+			if empty {
+				return "is required"
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				panic(fmt.Sprintf("utils: invalid %s rule %q", name, rule))
+			}
+			size, unit := measure(value)
+			if name == "min" && size < limit {
+				return fmt.Sprintf("must be at least %s%s", param, unit)
+			}
+			if name == "max" && size > limit {
+				return fmt.Sprintf("must be at most %s%s", param, unit)
+			}
+		case "oneof":
+			options := strings.Fields(param)
+			if !contains(options, fmt.Sprint(value.Interface())) {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		default:
+			panic(fmt.Sprintf("utils: unknown validation rule %q", rule))
+		}
+	}
+	return ""
+}
+
+// isEmpty reports whether a value is zero, nil or has no elements
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// measure returns the size min and max compare against and the unit it's in
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	panic(fmt.Sprintf("utils: min and max don't apply to %s", value.Kind()))
+}
+
+func contains(options []string, value string) bool {
+	for _, option := range options {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
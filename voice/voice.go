@@ -0,0 +1,151 @@
+// Package voice turns uploaded audio into voice notes with ffmpeg: mono
+// Ogg/Opus with the duration and a waveform clients can draw.
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/config"
+)
+
+// sampleRate is the rate audio is decoded at to measure it, enough for a
+// waveform
+const sampleRate = 8000
+
+// ErrTooLong rejects audio longer than the maximum duration
+var ErrTooLong = errors.New("voice message is too long")
+
+// Note describes a transcoded voice note
+type Note struct {
+	Duration time.Duration
+	// Waveform holds the peak level of equal slices of the audio, from 0 to
+	// 100 relative to the loudest slice
+	Waveform []int
+}
+
+// Transcoder converts uploads to voice notes, a bounded number at a time
+type Transcoder struct {
+	ffmpeg      string
+	bitrate     int
+	maxDuration time.Duration
+	bars        int
+	timeout     time.Duration
+	slots       chan struct{}
+}
+
+// New returns a Transcoder, or nil when voice messages are disabled
+func New(cfg config.VoiceConfig, ffmpeg string) *Transcoder {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Transcoder{
+		ffmpeg:      ffmpeg,
+		bitrate:     cfg.Bitrate,
+		maxDuration: cfg.MaxDuration,
+		bars:        max(cfg.WaveformBars, 1),
+		timeout:     cfg.Timeout,
+		slots:       make(chan struct{}, max(cfg.Workers, 1)),
+	}
+}
+
+// Transcode writes the audio of the file at in to out as mono Ogg/Opus and
+// measures it. out is removed when the audio can't be used.
+func (t *Transcoder) Transcode(ctx context.Context, in, out string) (*Note, error) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	// Anything past the maximum is cut, a second of slack tells a note
+	// that is too long from one that fits exactly
+	limit := strconv.FormatFloat((t.maxDuration + time.Second).Seconds(), 'f', -1, 64)
+	cmd := exec.CommandContext(ctx, t.ffmpeg,
+		"-nostdin", "-y", "-loglevel", "error",
+		"-i", in,
+		"-t", limit,
+		"-vn", "-ac", "1",
+		"-c:a", "libopus", "-b:a", strconv.Itoa(t.bitrate), "-application", "voip",
+		"-f", "ogg", out,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(out)
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	samples, err := t.decode(ctx, out)
+	if err != nil {
+		_ = os.Remove(out)
+		return nil, err
+	}
+	note := &Note{
+		Duration: time.Duration(len(samples)) * time.Second / sampleRate,
+		Waveform: waveform(samples, t.bars),
+	}
+	if note.Duration == 0 {
+		_ = os.Remove(out)
+		return nil, errors.New("no audio")
+	}
+	if note.Duration > t.maxDuration {
+		_ = os.Remove(out)
+		return nil, ErrTooLong
+	}
+	return note, nil
+}
+
+// decode returns the samples of an audio file as mono 16-bit PCM
+func (t *Transcoder) decode(ctx context.Context, path string) ([]int16, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.ffmpeg,
+		"-nostdin", "-loglevel", "error",
+		"-i", path,
+		"-ac", "1", "-ar", strconv.Itoa(sampleRate),
+		"-f", "s16le", "-",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	samples := make([]int16, stdout.Len()/2)
+	if err := binary.Read(&stdout, binary.LittleEndian, samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// waveform splits the samples into bars slices and returns the peak of
+// each, scaled so the loudest is 100. Audio shorter than bars samples gets
+// one bar per sample.
+func waveform(samples []int16, bars int) []int {
+	bars = min(bars, len(samples))
+	peaks := make([]int, bars)
+	loudest := 0
+	for i := range peaks {
+		for _, sample := range samples[i*len(samples)/bars : (i+1)*len(samples)/bars] {
+			level := int(sample)
+			if level < 0 {
+				level = -level
+			}
+			peaks[i] = max(peaks[i], level)
+		}
+		loudest = max(loudest, peaks[i])
+	}
+
+	if loudest > 0 {
+		for i := range peaks {
+			peaks[i] = peaks[i] * 100 / loudest
+		}
+	}
+	return peaks
+}
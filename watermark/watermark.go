@@ -0,0 +1,139 @@
+// Package watermark burns a logo, the session ID and the wall-clock time
+// into recorded video with ffmpeg.
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pion-webrtc-microservice/config"
+)
+
+// Mark is the watermark of one recording
+type Mark struct {
+	// Text is drawn in the bottom left corner followed by the time the
+	// frame was recorded
+	Text string
+	// Logo is an image drawn in the top right corner, empty for none
+	Logo string
+	// Start is when the recording started, the clock is derived from it
+	Start time.Time
+}
+
+// Burner re-encodes video files with a watermark, a bounded number at a time
+type Burner struct {
+	ffmpeg  string
+	logo    string
+	logoDir string
+	font    string
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// New returns a Burner, or nil when watermarking is disabled
+func New(cfg config.WatermarkConfig, ffmpeg string) *Burner {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Burner{
+		ffmpeg:  ffmpeg,
+		logo:    cfg.Logo,
+		logoDir: cfg.LogoDir,
+		font:    cfg.Font,
+		timeout: cfg.Timeout,
+		slots:   make(chan struct{}, max(cfg.Workers, 1)),
+	}
+}
+
+// LogoFor returns the logo of a tenant, <logo dir>/<tenant>.png when it
+// exists, or the default logo
+func (b *Burner) LogoFor(tenant string) string {
+	if tenant != "" && b.logoDir != "" {
+		path := filepath.Join(b.logoDir, filepath.Base(tenant)+".png")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return b.logo
+}
+
+// encoders maps the video containers written by the recorder to the
+// encoder arguments they are re-encoded with
+var encoders = map[string][]string{
+	".ivf":  {"-c:v", "libvpx", "-crf", "10", "-b:v", "4M"},
+	".h264": {"-c:v", "libx264", "-preset", "veryfast", "-crf", "20"},
+}
+
+// Supports reports whether the video file at path can be watermarked
+func Supports(path string) bool {
+	_, ok := encoders[filepath.Ext(path)]
+	return ok
+}
+
+// Burn replaces the video file at path with a watermarked copy. The original
+// is kept when ffmpeg fails.
+func (b *Burner) Burn(ctx context.Context, path string, mark Mark) error {
+	ext := filepath.Ext(path)
+	encoder, ok := encoders[ext]
+	if !ok {
+		return fmt.Errorf("unsupported container %s", ext)
+	}
+
+	b.slots <- struct{}{}
+	defer func() { <-b.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	// The text goes through a file so it needs no filtergraph escaping
+	textFile, err := os.CreateTemp("", "watermark-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(textFile.Name())
+	text := expansionEscape(mark.Text) + " %{pts:localtime:" + strconv.FormatInt(mark.Start.Unix(), 10) + "}"
+	if _, err := textFile.WriteString(text); err != nil {
+		textFile.Close()
+		return err
+	}
+	if err := textFile.Close(); err != nil {
+		return err
+	}
+
+	drawtext := "drawtext=textfile=" + filterEscape(textFile.Name()) +
+		":x=10:y=h-th-10:fontsize=18:fontcolor=white:box=1:boxcolor=black@0.5:boxborderw=4"
+	if b.font != "" {
+		drawtext += ":fontfile=" + filterEscape(b.font)
+	}
+
+	args := []string{"-nostdin", "-y", "-loglevel", "error", "-i", path}
+	if mark.Logo != "" {
+		args = append(args, "-i", mark.Logo, "-filter_complex", "[0:v]"+drawtext+"[marked];[marked][1:v]overlay=W-w-10:10[out]", "-map", "[out]")
+	} else {
+		args = append(args, "-vf", drawtext)
+	}
+
+	tmp := strings.TrimSuffix(path, ext) + ".watermarked" + ext
+	args = append(append(args, encoder...), tmp)
+	if output, err := exec.CommandContext(ctx, b.ffmpeg, args...).CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(tmp, path)
+}
+
+// filterEscape quotes a value of an ffmpeg filter option
+func filterEscape(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// expansionEscape keeps drawtext from expanding sequences in literal text
+func expansionEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(text)
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"pion-webrtc-microservice/breaker"
+	"pion-webrtc-microservice/retry"
+	"pion-webrtc-microservice/utils"
+)
+
+// DeliveryJob is the retry job redelivering a webhook that failed
+const DeliveryJob = "webhook"
+
+// Event is the payload posted to the webhook URL
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp utils.Time  `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Notifier posts events to a configured URL. Deliveries go through a
+// circuit breaker, events that fail or arrive while it's open are queued for
+// a retry.
+type Notifier struct {
+	url     string
+	client  *http.Client
+	breaker *breaker.Breaker
+	retries *retry.Queue
+}
+
+// NewNotifier creates a Notifier, an empty url disables delivery
+func NewNotifier(url string, b *breaker.Breaker, retries *retry.Queue) *Notifier {
+	n := &Notifier{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		breaker: b,
+		retries: retries,
+	}
+	retries.Handle(DeliveryJob, func(_ string, body []byte) error {
+		return n.breaker.Do(func() error { return n.deliver(body) })
+	})
+	return n
+}
+
+// Send delivers an event in the background
+func (n *Notifier) Send(eventType string, data interface{}) {
+	if n == nil || n.url == "" {
+		return
+	}
+
+	event := Event{
+		Type:      eventType,
+		Timestamp: utils.Now(),
+		Data:      data,
+	}
+	go func() {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error encoding webhook %s: %v\n", event.Type, err)
+			return
+		}
+
+		if err := n.breaker.Do(func() error { return n.deliver(body) }); err != nil {
+			log.Printf("Error delivering webhook %s, queued for retry: %v\n", event.Type, err)
+			if err := n.retries.Enqueue(DeliveryJob, "", body); err != nil {
+				log.Printf("Error queueing webhook %s: %v\n", event.Type, err)
+			}
+		}
+	}()
+}
+
+func (n *Notifier) deliver(body []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package wsauth protects WebSocket upgrades from cross-site use: an origin
+// allow-list, and one-time tickets that let browsers authenticate an upgrade
+// without putting their token in the URL.
+package wsauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may open WebSockets
+type OriginPolicy struct {
+	allowed []string
+}
+
+// NewOriginPolicy creates an OriginPolicy from origins such as
+// "https://app.example.com", wildcards such as "https://*.example.com", or
+// "*" for any origin. Without origins only same-origin requests are allowed.
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	allowed := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		allowed = append(allowed, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+	return &OriginPolicy{allowed: allowed}
+}
+
+// Allowed reports whether a request may be upgraded. Requests without an
+// Origin header don't come from browsers and are always allowed.
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+
+	origin = strings.ToLower(parsed.Scheme + "://" + parsed.Host)
+	for _, pattern := range p.allowed {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches an origin against an exact origin or a pattern whose
+// "*." matches one or more subdomain labels, never the bare domain
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	prefix, suffix, ok := strings.Cut(pattern, "*.")
+	if !ok {
+		return false
+	}
+	rest, ok := strings.CutPrefix(origin, prefix)
+	if !ok {
+		return false
+	}
+	label, ok := strings.CutSuffix(rest, "."+suffix)
+	return ok && label != "" && !strings.ContainsAny(label, "/:@")
+}
@@ -0,0 +1,131 @@
+package wsauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes a ticket can be issued for, a ticket only opens its own socket
+const (
+	ScopeSignaling = "signaling"
+	ScopeChat      = "chat"
+)
+
+// Errors returned when redeeming a ticket
+var (
+	ErrInvalidTicket = errors.New("invalid websocket ticket")
+	ErrTicketUsed    = errors.New("websocket ticket was already used")
+	ErrTicketExpired = errors.New("websocket ticket expired")
+)
+
+// ticketClaims is the signed content of a ticket
+type ticketClaims struct {
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"`
+	Origin    string `json:"origin,omitempty"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Tickets issues and redeems signed one-time WebSocket tickets. A ticket is
+// bound to its user, its scope and the origin it was issued to, and can be
+// redeemed once before it expires.
+type Tickets struct {
+	key []byte
+	ttl time.Duration
+	// used holds the nonces of redeemed tickets until they expire
+	used map[string]time.Time
+	mu   sync.Mutex
+}
+
+// NewTickets creates Tickets signed with a key derived from secret
+func NewTickets(secret string, ttl time.Duration) *Tickets {
+	// A separate key keeps tickets and auth tokens from being confused
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("websocket-ticket"))
+
+	return &Tickets{
+		key:  mac.Sum(nil),
+		ttl:  ttl,
+		used: make(map[string]time.Time),
+	}
+}
+
+// Issue returns a ticket for a user and when it expires
+func (t *Tickets) Issue(subject, scope, origin string) (string, time.Time, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(t.ttl)
+	payload, err := json.Marshal(ticketClaims{
+		Subject:   subject,
+		Scope:     scope,
+		Origin:    origin,
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + t.sign(encoded), expiresAt, nil
+}
+
+// Redeem checks a ticket for a scope and origin, marks it as used and
+// returns its user
+func (t *Tickets) Redeem(ticket, scope, origin string) (string, error) {
+	encoded, signature, ok := strings.Cut(ticket, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return "", ErrInvalidTicket
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidTicket
+	}
+	var claims ticketClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", ErrInvalidTicket
+	}
+	if claims.Scope != scope || claims.Origin != origin {
+		return "", ErrInvalidTicket
+	}
+
+	now := time.Now()
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !now.Before(expiresAt) {
+		return "", ErrTicketExpired
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for nonce, expiry := range t.used {
+		if now.After(expiry) {
+			delete(t.used, nonce)
+		}
+	}
+	if _, used := t.used[claims.Nonce]; used {
+		return "", ErrTicketUsed
+	}
+	t.used[claims.Nonce] = expiresAt
+
+	return claims.Subject, nil
+}
+
+func (t *Tickets) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}